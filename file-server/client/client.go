@@ -0,0 +1,374 @@
+// Package client is a Go client for the file server, for tools that talk to
+// it without a browser. It logs in, keeps the access token fresh, and sends
+// and fetches files the way the web interface does: uploads in MD5 checked
+// chunks, several at a time, and downloads that resume where they stopped.
+//
+//	c := client.New("https://api.mydomain.com")
+//	if err := c.Login(ctx, "admin", password); err != nil {
+//		return err
+//	}
+//	err := c.UploadFile(ctx, "/", "photos", "IMG_0001.jpg")
+//
+// Requests that can be repeated safely are retried on network errors and on
+// 429 and 5xx answers.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"file-server/internal/auth"
+)
+
+const (
+	DefaultChunkSize   = 4 << 20
+	MaxChunkSize       = 5<<20 - 4<<10 // Servers without upload sessions take 5MB per request, form fields included
+	DefaultConcurrency = 4
+	DefaultRetries     = 3
+	DefaultRetryDelay  = time.Second
+
+	refreshCookie = "refresh_token"
+)
+
+// Client sends requests on behalf of one user or share. It is safe for use
+// from several goroutines once configured.
+type Client struct {
+	Server      string        // API address, e.g. https://api.mydomain.com
+	HttpClient  *http.Client  // Has no overall timeout by default, large downloads take as long as they take
+	ChunkSize   int64         // Bytes per upload chunk, at most MaxChunkSize, for servers that don't pick one
+	Concurrency int           // Chunks of an upload sent at the same time
+	Retries     int           // Further attempts after a transient failure
+	RetryDelay  time.Duration // Wait before the first retry, doubled for each one after
+
+	// OnTokenRefresh, if set, is called with the new access token whenever it
+	// is renewed, e.g. to persist it. It must not call the client.
+	OnTokenRefresh func(accessToken string)
+
+	mu           sync.Mutex // Guards the tokens
+	accessToken  string
+	refreshToken string
+}
+
+// StatusError is returned when the server answers with an error status.
+type StatusError struct {
+	StatusCode int
+	Message    string        // The server's plain text explanation, if any
+	RetryAfter time.Duration // From the Retry-After header of 429 and 503 answers
+}
+
+func (e *StatusError) Error() string {
+	status := fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message == "" {
+		return status
+	}
+	return status + ": " + e.Message
+}
+
+// New returns a client for the server with the default chunk size,
+// concurrency and retries. It has no credentials until Login, AuthShare or
+// SetTokens is called.
+func New(server string) *Client {
+	return &Client{
+		Server: strings.TrimRight(server, "/"),
+		HttpClient: &http.Client{
+			Transport: &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				ResponseHeaderTimeout: 5 * time.Minute,
+			},
+		},
+		ChunkSize:   DefaultChunkSize,
+		Concurrency: DefaultConcurrency,
+		Retries:     DefaultRetries,
+		RetryDelay:  DefaultRetryDelay,
+	}
+}
+
+// Tokens returns the current access and refresh token, so they can be stored
+// and given back with SetTokens later.
+func (c *Client) Tokens() (string, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.accessToken, c.refreshToken
+}
+
+// SetTokens sets the credentials of the client. An expired access token is
+// fine, it is renewed with the refresh token on first use.
+func (c *Client) SetTokens(accessToken string, refreshToken string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.accessToken = accessToken
+	c.refreshToken = refreshToken
+}
+
+// Login exchanges a username and password for the tokens of the account.
+func (c *Client) Login(ctx context.Context, username string, password string) error {
+	var tokens auth.TokenResponse
+	refreshToken, err := c.authenticate(ctx, "/login", auth.Credentials{Username: username, Password: password}, &tokens)
+	if err != nil {
+		return err
+	}
+	if refreshToken == "" {
+		return errors.New("server did not return a refresh token")
+	}
+	c.SetTokens(tokens.AccessToken, refreshToken)
+	return nil
+}
+
+// AuthShare exchanges a share link and its password for the share's tokens,
+// replacing the client's credentials. Returns the folder id of the share.
+func (c *Client) AuthShare(ctx context.Context, linkUrl string, otp string) (string, error) {
+	var tokens auth.SharingTokenResponse
+	refreshToken, err := c.authenticate(ctx, "/auth-share", auth.SharingCredentials{LinkUrl: linkUrl, OtpPassword: otp}, &tokens)
+	if err != nil {
+		return "", err
+	}
+	if refreshToken == "" {
+		return "", errors.New("server did not return a refresh token")
+	}
+	c.SetTokens(tokens.AccessToken, refreshToken)
+	return tokens.FolderId, nil
+}
+
+// authenticate posts credentials, decodes the tokens into v and returns the
+// refresh token set as a cookie. No cookie is sent, so the server always
+// answers with a new one.
+func (c *Client) authenticate(ctx context.Context, path string, credentials any, v any) (string, error) {
+	body, err := json.Marshal(credentials)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url(path, nil), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HttpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return "", fmt.Errorf("invalid response: %w", err)
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == refreshCookie {
+			return cookie.Value, nil
+		}
+	}
+	return "", nil
+}
+
+// Refresh renews the access token with the refresh token. Requests do this
+// on their own when the server refuses the access token.
+func (c *Client) Refresh(ctx context.Context) error {
+	accessToken, _ := c.Tokens()
+	_, err := c.refresh(ctx, accessToken)
+	return err
+}
+
+// refresh renews the access token, unless another request already did since
+// stale was read. Returns the token to use.
+func (c *Client) refresh(ctx context.Context, stale string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.accessToken != stale {
+		return c.accessToken, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url("/refresh", nil), nil)
+	if err != nil {
+		return "", err
+	}
+	req.AddCookie(&http.Cookie{Name: refreshCookie, Value: c.refreshToken})
+	resp, err := c.HttpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(resp)
+	}
+
+	var tokens auth.TokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return "", fmt.Errorf("invalid refresh response: %w", err)
+	}
+	c.accessToken = tokens.AccessToken
+	if c.OnTokenRefresh != nil {
+		c.OnTokenRefresh(tokens.AccessToken)
+	}
+	return tokens.AccessToken, nil
+}
+
+func (c *Client) url(path string, query url.Values) string {
+	if len(query) == 0 {
+		return c.Server + path
+	}
+	return c.Server + path + "?" + query.Encode()
+}
+
+// do sends the request built by newRequest, once more with a renewed access
+// token if the first attempt is refused. newRequest is called for each
+// attempt since a request body can only be read once.
+func (c *Client) do(ctx context.Context, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	accessToken, _ := c.Tokens()
+
+	resp, err := c.send(newRequest, accessToken)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	if accessToken, err = c.refresh(ctx, accessToken); err != nil {
+		return nil, err
+	}
+	return c.send(newRequest, accessToken)
+}
+
+func (c *Client) send(newRequest func() (*http.Request, error), accessToken string) (*http.Response, error) {
+	req, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+	_, refreshToken := c.Tokens()
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	// Downloads are authenticated with the refresh token, like in the browser
+	req.AddCookie(&http.Cookie{Name: refreshCookie, Value: refreshToken})
+	return c.HttpClient.Do(req)
+}
+
+// doRetry is do for requests that can be repeated safely: network errors and
+// 429 or 5xx answers are retried. Other answers are returned for the caller
+// to check.
+func (c *Client) doRetry(ctx context.Context, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := c.do(ctx, newRequest)
+		if err == nil {
+			if !retryableStatus(resp.StatusCode) || attempt >= c.Retries {
+				return resp, nil
+			}
+			err = responseError(resp)
+			resp.Body.Close()
+		} else if attempt >= c.Retries || !transient(ctx, err) {
+			return nil, err
+		}
+		if err := c.wait(ctx, attempt, err); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// wait sleeps before retry attempt+1, as long as the server asked for or
+// RetryDelay doubled for each attempt so far.
+func (c *Client) wait(ctx context.Context, attempt int, err error) error {
+	delay := c.RetryDelay << attempt
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		delay = statusErr.RetryAfter
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func retryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || (statusCode >= 500 && statusCode != http.StatusNotImplemented)
+}
+
+// transient tells whether an error is worth another attempt: the server
+// being busy or the connection failing, not a refusal or a local error.
+func transient(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return retryableStatus(statusErr.StatusCode)
+	}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// getJSON decodes the response of a GET into v.
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, v any) error {
+	resp, err := c.doRetry(ctx, func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, c.url(path, query), nil)
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// postJSON sends body as JSON and decodes the response into v, if not nil.
+// It is not retried, posts change things on the server.
+func (c *Client) postJSON(ctx context.Context, path string, body any, v any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url(path, nil), bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return responseError(resp)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// responseError turns an error response into a StatusError, the server
+// answers with a plain text message.
+func responseError(resp *http.Response) error {
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	statusErr := &StatusError{
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(message)),
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		statusErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return statusErr
+}
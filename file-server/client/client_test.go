@@ -0,0 +1,481 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"file-server/config"
+	"file-server/internal/app"
+	"file-server/internal/helpers"
+	"file-server/internal/job"
+)
+
+// --------------------------------------
+// 			 Helper Functions
+// --------------------------------------
+
+// newTestServer serves app.SetupServer over a mocked database. wrap, if not
+// nil, sits in front of the server's handler to inspect or fail requests.
+func newTestServer(t *testing.T, wrap func(http.Handler) http.Handler) (*httptest.Server, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+	}
+	mock.MatchExpectationsInOrder(false)
+
+	jm := job.NewJobManager(10 * time.Minute)
+	srv, _, err := app.SetupServer(jm, func() (*sql.DB, error) {
+		return db, nil
+	})
+	if err != nil {
+		t.Fatalf("Received unexpected error when setting up server: %v", err)
+	}
+
+	handler := srv.Handler
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+	ts := httptest.NewServer(handler)
+	t.Cleanup(func() {
+		ts.Close()
+		srv.Shutdown(context.Background())
+		jm.Wait(context.Background())
+		jm.Close()
+		db.Close()
+	})
+	return ts, mock
+}
+
+func newTestClient(ts *httptest.Server) *Client {
+	c := New(ts.URL)
+	c.ChunkSize = 1024
+	c.RetryDelay = time.Millisecond
+	return c
+}
+
+func expectUser(mock sqlmock.Sqlmock, username string, password string) {
+	salt, _ := helpers.GenerateRandomSalt()
+	mock.ExpectQuery(`SELECT username, email, salt, password_hash, folder, access\s+FROM users\s+WHERE username = \$1`).
+		WithArgs(username).
+		WillReturnRows(sqlmock.NewRows([]string{"username", "email", "salt", "password_hash", "folder", "access"}).
+			AddRow(username, "admin@mydomain.com", salt, helpers.HashPassword(password, salt), "/", "rw"))
+}
+
+func login(t *testing.T, c *Client, mock sqlmock.Sqlmock) {
+	expectUser(mock, "admin", "somePassword")
+	if err := c.Login(context.Background(), "admin", "somePassword"); err != nil {
+		t.Fatalf("Received unexpected error when logging in: %v", err)
+	}
+}
+
+func randomContent(t *testing.T, size int) []byte {
+	content := make([]byte, size)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatalf("Received unexpected error when creating content: %v", err)
+	}
+	return content
+}
+
+// waitForFile waits for an upload to be assembled, which happens after the
+// last chunk is answered.
+func waitForFile(t *testing.T, filePath string) []byte {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		content, err := os.ReadFile(filePath)
+		if err == nil {
+			return content
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("File %s wasn't assembled: %v", filePath, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func createShareFolder(t *testing.T) string {
+	cfg := config.LoadConfig()
+	folderId, _ := helpers.GenerateFolderId()
+	if err := os.MkdirAll(filepath.Join(cfg.SharingDir, folderId), os.ModePerm); err != nil {
+		t.Fatalf("Received unexpected error when creating folder: %v", err)
+	}
+	return folderId
+}
+
+// --------------------------------------
+// 		  Suite Setup - Cleanup
+// --------------------------------------
+func TestMain(m *testing.M) {
+	cfg := config.LoadConfig()
+	for _, dir := range []string{cfg.SharingDir, cfg.UploadDir, "secrets"} {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create directory %q: %v\n", dir, err)
+			os.Exit(1)
+		}
+	}
+
+	exitCode := m.Run()
+
+	for _, dir := range []string{cfg.SharingDir, cfg.UploadDir, "secrets"} {
+		if err := os.RemoveAll(dir); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to remove directory %q: %v\n", dir, err)
+		}
+	}
+
+	os.Exit(exitCode)
+}
+
+// --------------------------------------
+// 			 Client Tests
+// --------------------------------------
+func TestLogin(t *testing.T) {
+	ts, mock := newTestServer(t, nil)
+
+	t.Run("Login_Wrong_Password", func(t *testing.T) {
+		c := newTestClient(ts)
+		expectUser(mock, "admin", "somePassword")
+
+		err := c.Login(context.Background(), "admin", "wrongPassword")
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusForbidden {
+			t.Fatalf("Expected a 403 status error, got: %v", err)
+		}
+		if accessToken, refreshToken := c.Tokens(); accessToken != "" || refreshToken != "" {
+			t.Error("Expected no tokens after a failed login")
+		}
+	})
+
+	t.Run("Login_Success", func(t *testing.T) {
+		c := newTestClient(ts)
+		login(t, c, mock)
+
+		if accessToken, refreshToken := c.Tokens(); accessToken == "" || refreshToken == "" {
+			t.Error("Expected access and refresh token after login")
+		}
+	})
+}
+
+func TestTokenRefresh(t *testing.T) {
+	ts, mock := newTestServer(t, nil)
+	c := newTestClient(ts)
+	login(t, c, mock)
+	folderId := createShareFolder(t)
+
+	refreshed := ""
+	c.OnTokenRefresh = func(accessToken string) {
+		refreshed = accessToken
+	}
+	_, refreshToken := c.Tokens()
+	c.SetTokens("someExpiredToken", refreshToken)
+
+	if _, err := c.ShareFiles(context.Background(), folderId); err != nil {
+		t.Fatalf("Expected the request to succeed after a refresh, got: %v", err)
+	}
+	if accessToken, _ := c.Tokens(); refreshed == "" || accessToken != refreshed {
+		t.Errorf("Expected the renewed token %q to be used and reported, got %q", accessToken, refreshed)
+	}
+
+	t.Run("Refresh_Token_Invalid", func(t *testing.T) {
+		c.SetTokens("someExpiredToken", "someInvalidRefreshToken")
+
+		_, err := c.ShareFiles(context.Background(), folderId)
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected a 401 status error, got: %v", err)
+		}
+	})
+}
+
+func TestUpload(t *testing.T) {
+	cfg := config.LoadConfig()
+
+	t.Run("Upload_Invalid_File", func(t *testing.T) {
+		ts, _ := newTestServer(t, nil)
+		c := newTestClient(ts)
+
+		if err := c.Upload(context.Background(), "/", "", "someFile.txt", bytes.NewReader(nil), 0); !errors.Is(err, ErrInvalidFile) {
+			t.Errorf("Expected ErrInvalidFile for an empty file, got: %v", err)
+		}
+		if err := c.Upload(context.Background(), "/", "", "someFile.exe", bytes.NewReader([]byte("x")), 1); !errors.Is(err, ErrInvalidFile) {
+			t.Errorf("Expected ErrInvalidFile for an unsafe extension, got: %v", err)
+		}
+	})
+
+	t.Run("Upload_Library_In_Chunks", func(t *testing.T) {
+		var mu sync.Mutex
+		inFlight, maxInFlight, chunks := 0, 0, 0
+		ts, mock := newTestServer(t, func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/upload" {
+					next.ServeHTTP(w, r)
+					return
+				}
+				mu.Lock()
+				inFlight++
+				chunks++
+				maxInFlight = max(maxInFlight, inFlight)
+				mu.Unlock()
+				time.Sleep(10 * time.Millisecond)
+				next.ServeHTTP(w, r)
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+			})
+		})
+		t.Setenv("UPLOAD_CHUNK_SIZE", "1024")
+		c := newTestClient(ts)
+		c.ChunkSize = 4096 // The server's chunk size wins
+		c.Concurrency = 3
+		login(t, c, mock)
+		defer os.RemoveAll(filepath.Join(cfg.UploadDir, "someAlbum"))
+
+		content := randomContent(t, 10*1024+100)
+		if err := c.Upload(context.Background(), "/", "someAlbum", "somePhoto.jpg", bytes.NewReader(content), int64(len(content))); err != nil {
+			t.Fatalf("Received unexpected error when uploading: %v", err)
+		}
+
+		uploaded := waitForFile(t, filepath.Join(cfg.UploadDir, "someAlbum", "somePhoto.jpg"))
+		if !bytes.Equal(uploaded, content) {
+			t.Error("Expected the uploaded file to match the local content")
+		}
+		if chunks != 11 {
+			t.Errorf("Expected 11 chunks of 1024 bytes, got %d", chunks)
+		}
+		if maxInFlight < 2 || maxInFlight > 3 {
+			t.Errorf("Expected up to 3 chunks in flight, got %d", maxInFlight)
+		}
+	})
+
+	t.Run("Upload_Without_Upload_Sessions", func(t *testing.T) {
+		var mu sync.Mutex
+		chunks := 0
+		ts, mock := newTestServer(t, func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/upload-init" {
+					http.NotFound(w, r)
+					return
+				}
+				if r.URL.Path == "/upload" {
+					mu.Lock()
+					chunks++
+					mu.Unlock()
+				}
+				next.ServeHTTP(w, r)
+			})
+		})
+		c := newTestClient(ts)
+		login(t, c, mock)
+		defer os.Remove(filepath.Join(cfg.UploadDir, "someOlderServer.txt"))
+
+		content := randomContent(t, 2100)
+		if err := c.Upload(context.Background(), "/", "", "someOlderServer.txt", bytes.NewReader(content), int64(len(content))); err != nil {
+			t.Fatalf("Received unexpected error when uploading: %v", err)
+		}
+		if uploaded := waitForFile(t, filepath.Join(cfg.UploadDir, "someOlderServer.txt")); !bytes.Equal(uploaded, content) {
+			t.Error("Expected the uploaded file to match the local content")
+		}
+		if chunks != 3 {
+			t.Errorf("Expected 3 chunks of the client's 1024 bytes, got %d", chunks)
+		}
+	})
+
+	t.Run("Stream_In_One_Request", func(t *testing.T) {
+		var mu sync.Mutex
+		requests := []string{}
+		ts, mock := newTestServer(t, func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests = append(requests, r.Method+" "+r.URL.Path)
+				mu.Unlock()
+				next.ServeHTTP(w, r)
+			})
+		})
+		c := newTestClient(ts)
+		login(t, c, mock)
+		defer os.RemoveAll(filepath.Join(cfg.UploadDir, "someStreams"))
+
+		content := randomContent(t, 10*1024)
+		if err := c.Stream(context.Background(), "/", "someStreams", "someStream.mp4", bytes.NewReader(content), int64(len(content))); err != nil {
+			t.Fatalf("Received unexpected error when streaming: %v", err)
+		}
+		if uploaded, err := os.ReadFile(filepath.Join(cfg.UploadDir, "someStreams", "someStream.mp4")); err != nil || !bytes.Equal(uploaded, content) {
+			t.Errorf("Expected the file to be committed once the request returns: %v", err)
+		}
+		if strings.Join(requests, ",") != "POST /login,PUT /upload-stream" {
+			t.Errorf("Expected a single upload request, got %v", requests)
+		}
+
+		if err := c.Stream(context.Background(), "/", "", "someStream.exe", bytes.NewReader(content), int64(len(content))); !errors.Is(err, ErrInvalidFile) {
+			t.Errorf("Expected ErrInvalidFile for an unsafe extension, got: %v", err)
+		}
+	})
+
+	t.Run("Upload_Retries_Transient_Failures", func(t *testing.T) {
+		var mu sync.Mutex
+		failures := 0
+		ts, mock := newTestServer(t, func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				fail := r.URL.Path == "/upload" && failures < 2
+				if fail {
+					failures++
+				}
+				mu.Unlock()
+				if fail {
+					http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+					return
+				}
+				next.ServeHTTP(w, r)
+			})
+		})
+		c := newTestClient(ts)
+		c.Concurrency = 1
+		login(t, c, mock)
+		defer os.Remove(filepath.Join(cfg.UploadDir, "someRetried.txt"))
+
+		content := randomContent(t, 2048)
+		if err := c.Upload(context.Background(), "/", "", "someRetried.txt", bytes.NewReader(content), int64(len(content))); err != nil {
+			t.Fatalf("Expected the upload to survive transient failures, got: %v", err)
+		}
+		if uploaded := waitForFile(t, filepath.Join(cfg.UploadDir, "someRetried.txt")); !bytes.Equal(uploaded, content) {
+			t.Error("Expected the uploaded file to match the local content")
+		}
+	})
+
+	t.Run("Upload_Gives_Up_On_Refusal", func(t *testing.T) {
+		ts, mock := newTestServer(t, nil)
+		c := newTestClient(ts)
+		login(t, c, mock)
+
+		// Not a share folder, the server refuses every chunk
+		folderId, _ := helpers.GenerateFolderId()
+		err := c.Upload(context.Background(), folderId, "", "someFile.txt", bytes.NewReader([]byte("someContent")), 11)
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected a 404 status error, got: %v", err)
+		}
+	})
+}
+
+func TestShareRoundTrip(t *testing.T) {
+	cfg := config.LoadConfig()
+	ts, mock := newTestServer(t, nil)
+	admin := newTestClient(ts)
+	login(t, admin, mock)
+	ctx := context.Background()
+
+	mock.ExpectQuery(`INSERT INTO shares`).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+	mock.ExpectExec(`INSERT INTO sharing_users`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	share, err := admin.CreateShare(ctx, ShareDetails{
+		Access:         "rw",
+		FolderName:     "someFolderName",
+		OtpPass:        "someOtp",
+		ExpirationDate: expiresAt.Format(time.RFC3339),
+	})
+	if err != nil {
+		t.Fatalf("Received unexpected error when creating share: %v", err)
+	}
+
+	salt, _ := helpers.GenerateRandomSalt()
+	mock.ExpectQuery(`FROM sharing_users su`).
+		WithArgs(share.LinkUrl).
+		WillReturnRows(sqlmock.NewRows([]string{"link_url", "folder_id", "folder_name", "salt", "otp_hash", "access", "expires_at"}).
+			AddRow(share.LinkUrl, share.FolderId, "someFolderName", salt, helpers.HashPassword("someOtp", salt), "rw", expiresAt.Format(time.RFC3339)))
+
+	guest := newTestClient(ts)
+	folderId, err := guest.AuthShare(ctx, share.LinkUrl, "someOtp")
+	if err != nil {
+		t.Fatalf("Received unexpected error when opening share: %v", err)
+	}
+	if folderId != share.FolderId {
+		t.Fatalf("Expected folder id %s, got %s", share.FolderId, folderId)
+	}
+
+	content := randomContent(t, 4096+10)
+	if err := guest.Upload(ctx, folderId, "", "someVideo.mp4", bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatalf("Received unexpected error when uploading to share: %v", err)
+	}
+	waitForFile(t, filepath.Join(cfg.SharingDir, folderId, "someVideo.mp4"))
+
+	files, err := guest.ShareFiles(ctx, folderId)
+	if err != nil {
+		t.Fatalf("Received unexpected error when listing share: %v", err)
+	}
+	listed := false
+	for _, file := range files {
+		listed = listed || file.FileName+file.FileExtension == "someVideo.mp4"
+	}
+	if !listed {
+		t.Errorf("Expected someVideo.mp4 to be listed, got: %+v", files)
+	}
+
+	t.Run("Download", func(t *testing.T) {
+		var downloaded bytes.Buffer
+		if err := guest.Download(ctx, folderId, "someVideo.mp4", &downloaded); err != nil {
+			t.Fatalf("Received unexpected error when downloading: %v", err)
+		}
+		if !bytes.Equal(downloaded.Bytes(), content) {
+			t.Error("Expected the downloaded file to match the upload")
+		}
+	})
+
+	t.Run("Download_File_Resumes", func(t *testing.T) {
+		destPath := filepath.Join(t.TempDir(), "someVideo.mp4")
+		info, err := os.Stat(filepath.Join(cfg.SharingDir, folderId, "someVideo.mp4"))
+		if err != nil {
+			t.Fatalf("Received unexpected error when reading uploaded file: %v", err)
+		}
+
+		// Left by an interrupted download of the same version of the file,
+		// zeroed to tell whether the start is fetched again
+		if err := os.WriteFile(destPath+partSuffix, make([]byte, 1000), 0644); err != nil {
+			t.Fatalf("Received unexpected error when writing part file: %v", err)
+		}
+		modTime := info.ModTime().Truncate(time.Second)
+		os.Chtimes(destPath+partSuffix, modTime, modTime)
+
+		if err := guest.DownloadFile(ctx, folderId, "someVideo.mp4", destPath); err != nil {
+			t.Fatalf("Received unexpected error when downloading: %v", err)
+		}
+		downloaded, err := os.ReadFile(destPath)
+		if err != nil || !bytes.Equal(downloaded, append(make([]byte, 1000), content[1000:]...)) {
+			t.Errorf("Expected the download to resume after the part, got %d bytes: %v", len(downloaded), err)
+		}
+		if _, err := os.Stat(destPath + partSuffix); !os.IsNotExist(err) {
+			t.Error("Expected the part file to be renamed")
+		}
+	})
+
+	t.Run("Download_File_Stale_Part", func(t *testing.T) {
+		destPath := filepath.Join(t.TempDir(), "someVideo.mp4")
+		if err := os.WriteFile(destPath+partSuffix, []byte(strings.Repeat("x", 1000)), 0644); err != nil {
+			t.Fatalf("Received unexpected error when writing part file: %v", err)
+		}
+		stale := time.Now().Add(-48 * time.Hour)
+		os.Chtimes(destPath+partSuffix, stale, stale)
+
+		if err := guest.DownloadFile(ctx, folderId, "someVideo.mp4", destPath); err != nil {
+			t.Fatalf("Received unexpected error when downloading: %v", err)
+		}
+		if downloaded, _ := os.ReadFile(destPath); !bytes.Equal(downloaded, content) {
+			t.Error("Expected a part of another version to be downloaded again")
+		}
+	})
+}
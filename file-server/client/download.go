@@ -0,0 +1,109 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+const partSuffix = ".part"
+
+// Download writes a file of a share to w.
+func (c *Client) Download(ctx context.Context, folderId string, fileName string, w io.Writer) error {
+	resp, err := c.doRetry(ctx, func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, c.downloadUrl(folderId, fileName), nil)
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// DownloadFile saves a file of a share to destPath. The file is written as
+// `destPath.part` and renamed once complete. An interrupted transfer is
+// resumed from where it stopped, now or, through the `.part` file, by a
+// later call; unless the file changed on the server since.
+func (c *Client) DownloadFile(ctx context.Context, folderId string, fileName string, destPath string) error {
+	for attempt := 0; ; attempt++ {
+		err := c.downloadPart(ctx, folderId, fileName, destPath)
+		if err == nil || attempt >= c.Retries || !transient(ctx, err) {
+			return err
+		}
+		if err := c.wait(ctx, attempt, err); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) downloadPart(ctx context.Context, folderId string, fileName string, destPath string) error {
+	partPath := destPath + partSuffix
+
+	var offset int64
+	var partModTime time.Time
+	if info, err := os.Stat(partPath); err == nil {
+		offset = info.Size()
+		partModTime = info.ModTime()
+	}
+
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.downloadUrl(folderId, fileName), nil)
+		if err != nil {
+			return nil, err
+		}
+		if offset > 0 {
+			req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+			// The whole file is sent instead if it was modified
+			req.Header.Set("If-Range", partModTime.UTC().Format(http.TimeFormat))
+		}
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+	case http.StatusOK:
+		flags |= os.O_TRUNC
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Nothing left to fetch, the previous attempt got the whole file
+		return os.Rename(partPath, destPath)
+	default:
+		return responseError(resp)
+	}
+
+	part, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return err
+	}
+	_, copyErr := io.Copy(part, resp.Body)
+	if err := part.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+
+	// Stamped with the server's time, so a resume can tell if the file changed
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		os.Chtimes(partPath, modTime, modTime)
+	}
+	if copyErr != nil {
+		return fmt.Errorf("download interrupted: %w", copyErr)
+	}
+	return os.Rename(partPath, destPath)
+}
+
+func (c *Client) downloadUrl(folderId string, fileName string) string {
+	return c.url("/download", url.Values{"folder_id": {folderId}, "file": {fileName}})
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"context"
+	"net/url"
+
+	"file-server/internal/models"
+	"file-server/internal/sharing"
+)
+
+// Bodies of the share endpoints, the types the server itself uses.
+type (
+	ShareDetails  = sharing.SharingDetails
+	ShareResponse = sharing.SharingResponse
+	SharingFile   = sharing.SharingFileItem
+	Share         = models.Share
+)
+
+// CreateShare creates a share, optionally with files from the library. Needs
+// an admin login.
+func (c *Client) CreateShare(ctx context.Context, details ShareDetails) (*ShareResponse, error) {
+	var response ShareResponse
+	if err := c.postJSON(ctx, "/share", details, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Shares lists the shares that haven't expired or been revoked. Needs an
+// admin login.
+func (c *Client) Shares(ctx context.Context) ([]Share, error) {
+	var shares []Share
+	if err := c.getJSON(ctx, "/shares", nil, &shares); err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+// RevokeShare ends a share before it expires and removes its files.
+func (c *Client) RevokeShare(ctx context.Context, folderId string) error {
+	return c.postJSON(ctx, "/share-revoke", sharing.ShareRevokeDetails{FolderId: folderId}, nil)
+}
+
+// ShareFiles lists the files of a share.
+func (c *Client) ShareFiles(ctx context.Context, folderId string) ([]SharingFile, error) {
+	var response sharing.SharingFilesResponse
+	if err := c.getJSON(ctx, "/share-files", url.Values{"folder_id": {folderId}}, &response); err != nil {
+		return nil, err
+	}
+	return response.Files, nil
+}
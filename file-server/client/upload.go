@@ -0,0 +1,303 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"file-server/internal/helpers"
+	"file-server/internal/uploader"
+)
+
+// ErrInvalidFile is returned for files the server doesn't take: names it
+// refuses and empty files.
+var ErrInvalidFile = errors.New("invalid file")
+
+// Namespace of the upload ids, derived from the file so that uploading it
+// again resumes where the last attempt stopped.
+var uploadNamespace = uuid.MustParse("6f1c2a8e-3b0d-4e59-9a57-2d8c1f4b7e60")
+
+// UploadFile uploads a local file into folderId, "/" for the library or the
+// folder id of a share, under the sub folder dir ("" for the folder itself).
+func (c *Client) UploadFile(ctx context.Context, folderId string, dir string, localPath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return c.Upload(ctx, folderId, dir, filepath.Base(localPath), file, info.Size())
+}
+
+// Upload sends size bytes of content as fileName. Chunks the server already
+// has from an interrupted attempt are skipped, and so is the whole file when
+// the server can add it from content it already stores.
+func (c *Client) Upload(ctx context.Context, folderId string, dir string, fileName string, content io.ReaderAt, size int64) error {
+	extension := path.Ext(fileName)
+	name := strings.TrimSuffix(fileName, extension)
+	if err := helpers.ValidateFileName(name, extension); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+	if size == 0 {
+		return fmt.Errorf("%w: empty file", ErrInvalidFile)
+	}
+	dir = strings.Trim(dir, "/")
+
+	hasher := md5.New()
+	if _, err := io.Copy(hasher, io.NewSectionReader(content, 0, size)); err != nil {
+		return err
+	}
+	md5Hash := hex.EncodeToString(hasher.Sum(nil))
+
+	var check uploader.UploadCheckResponse
+	checkDetails := uploader.UploadCheckDetails{
+		FolderId:      folderId,
+		FileName:      name,
+		FileExtension: extension,
+		MD5Hash:       md5Hash,
+		Size:          size,
+		Path:          dir,
+	}
+	// The check needs the file index, without it the file is just uploaded
+	if err := c.postJSON(ctx, "/upload-check", checkDetails, &check); err == nil && check.Present {
+		return nil
+	}
+
+	u := chunkedUpload{
+		folderId:  folderId,
+		fileId:    uuid.NewSHA1(uploadNamespace, []byte(strings.Join([]string{folderId, path.Join(dir, fileName), md5Hash}, "\x00"))).String(),
+		fileName:  name,
+		extension: extension,
+		dir:       dir,
+		md5Hash:   md5Hash,
+		content:   content,
+		size:      size,
+		chunkSize: c.chunkSize(),
+	}
+
+	// The server picks the chunk size, servers without upload sessions take
+	// the client's
+	var session uploader.UploadInitResponse
+	initDetails := uploader.UploadInitDetails{
+		FolderId:      folderId,
+		UploadId:      u.fileId,
+		FileName:      name,
+		FileExtension: extension,
+		MD5Hash:       md5Hash,
+		Size:          size,
+		Path:          dir,
+	}
+	var statusErr *StatusError
+	if err := c.postJSON(ctx, "/upload-init", initDetails, &session); err == nil {
+		u.fileId = session.UploadId
+		u.chunkSize = session.ChunkSize
+	} else if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		return err
+	}
+	u.totalChunks = int((size + u.chunkSize - 1) / u.chunkSize)
+
+	received := map[int]bool{}
+	var status uploader.UploadStatusResponse
+	query := url.Values{"folder_id": {folderId}, "file_id": {u.fileId}}
+	if err := c.getJSON(ctx, "/upload-status", query, &status); err == nil && status.TotalChunks == u.totalChunks {
+		for _, index := range status.Received {
+			received[index] = true
+		}
+	}
+
+	concurrency := max(c.Concurrency, 1)
+	chunks := make(chan int)
+	errs := make(chan error, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range chunks {
+				if err := c.uploadChunk(ctx, u, index); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	var sendErr error
+	for index := 0; index < u.totalChunks && sendErr == nil; index++ {
+		if received[index] {
+			continue
+		}
+		select {
+		case chunks <- index:
+		case sendErr = <-errs:
+		case <-ctx.Done():
+			sendErr = ctx.Err()
+		}
+	}
+	close(chunks)
+	wg.Wait()
+	close(errs)
+
+	if sendErr != nil {
+		return sendErr
+	}
+	return <-errs
+}
+
+// StreamFile uploads a local file like UploadFile, in a single request. That
+// is faster on a local network but an interrupted upload starts over.
+func (c *Client) StreamFile(ctx context.Context, folderId string, dir string, localPath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return c.Stream(ctx, folderId, dir, filepath.Base(localPath), file, info.Size())
+}
+
+// Stream sends size bytes of content as fileName in the body of one request,
+// which the server checks against the MD5 of content.
+func (c *Client) Stream(ctx context.Context, folderId string, dir string, fileName string, content io.ReaderAt, size int64) error {
+	extension := path.Ext(fileName)
+	if err := helpers.ValidateFileName(strings.TrimSuffix(fileName, extension), extension); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+	if size == 0 {
+		return fmt.Errorf("%w: empty file", ErrInvalidFile)
+	}
+
+	hasher := md5.New()
+	if _, err := io.Copy(hasher, io.NewSectionReader(content, 0, size)); err != nil {
+		return err
+	}
+	contentMD5 := base64.StdEncoding.EncodeToString(hasher.Sum(nil))
+
+	query := url.Values{"folder_id": {folderId}, "file_name": {fileName}, "path": {strings.Trim(dir, "/")}}
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.url("/upload-stream", query), io.NewSectionReader(content, 0, size))
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = size
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Content-MD5", contentMD5)
+		// The body is only sent once the server accepted the token
+		req.Header.Set("Expect", "100-continue")
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return responseError(resp)
+	}
+	return nil
+}
+
+// chunkedUpload is what every chunk request of an upload repeats.
+type chunkedUpload struct {
+	folderId    string
+	fileId      string
+	fileName    string
+	extension   string
+	dir         string
+	md5Hash     string
+	content     io.ReaderAt
+	size        int64
+	chunkSize   int64
+	totalChunks int
+}
+
+func (c *Client) chunkSize() int64 {
+	if c.ChunkSize <= 0 {
+		return DefaultChunkSize
+	}
+	return min(c.ChunkSize, MaxChunkSize)
+}
+
+// uploadChunk sends one chunk, to /upload for the library and /share-file
+// for a share.
+func (c *Client) uploadChunk(ctx context.Context, u chunkedUpload, index int) error {
+	offset := int64(index) * u.chunkSize
+	chunk := make([]byte, min(u.chunkSize, u.size-offset))
+	if _, err := u.content.ReadAt(chunk, offset); err != nil && err != io.EOF {
+		return err
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	fields := [][2]string{
+		{"fileId", u.fileId},
+		{"fileName", u.fileName},
+		{"fileExtension", u.extension},
+		{"md5Hash", u.md5Hash},
+		{"chunkIndex", strconv.Itoa(index)},
+		{"totalChunks", strconv.Itoa(u.totalChunks)},
+		{"path", u.dir},
+	}
+	for _, field := range fields {
+		if err := form.WriteField(field[0], field[1]); err != nil {
+			return err
+		}
+	}
+	part, err := form.CreateFormFile("chunk", u.fileName+u.extension)
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(chunk); err != nil {
+		return err
+	}
+	if err := form.Close(); err != nil {
+		return err
+	}
+
+	uploadPath := "/upload"
+	if u.folderId != "/" {
+		uploadPath = "/share-file"
+	}
+	resp, err := c.doRetry(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url(uploadPath, nil), bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		if u.folderId != "/" {
+			req.Header.Set("Folder-Id", u.folderId)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return fmt.Errorf("chunk %d: %w", index, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("chunk %d: %w", index, responseError(resp))
+	}
+	return nil
+}
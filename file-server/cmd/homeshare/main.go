@@ -0,0 +1,394 @@
+// Command homeshare is a command line client for the file server: upload files
+// and folders, download from shares and manage shares.
+//
+//	homeshare login -server https://api.mydomain.com -user admin
+//	homeshare upload [-folder ID] [-path DIR] [-parallel N] [-stream] PATH...
+//	homeshare download -folder ID [-o DIR] [FILE...]
+//	homeshare ls [FOLDER_ID]
+//	homeshare share -name NAME [-access r|w|rw] [-expires 7d] [-otp CODE] [-strip] [-notify EMAIL,...] [LIBRARY_PATH...]
+//	homeshare revoke FOLDER_ID
+//	homeshare logout
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/term"
+
+	"file-server/client"
+)
+
+const usage = `Usage: homeshare <command> [flags] [args]
+
+Commands:
+  login     Sign in and store the session
+  upload    Upload files and folders to the library or a share
+  download  Download files of a share, resuming interrupted downloads
+  ls        List shares, or the files of a share
+  share     Create a share, optionally with files from the library
+  revoke    Revoke a share before it expires
+  logout    Forget the stored session
+
+Run 'homeshare <command> -h' for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	commands := map[string]func(context.Context, []string) error{
+		"login":    runLogin,
+		"upload":   runUpload,
+		"download": runDownload,
+		"ls":       runList,
+		"share":    runShare,
+		"revoke":   runRevoke,
+		"logout":   runLogout,
+	}
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err := command(ctx, os.Args[2:]); err != nil {
+		var statusErr *client.StatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusUnauthorized {
+			err = errors.New("session expired, run `homeshare login` again")
+		}
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "homeshare: %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// newClientFromSession returns a client with the stored tokens, storing the
+// access token again each time it is renewed.
+func newClientFromSession() (*client.Client, error) {
+	s, err := loadSession()
+	if err != nil {
+		return nil, err
+	}
+	c := client.New(s.Server)
+	c.SetTokens(s.AccessToken, s.RefreshToken)
+	c.OnTokenRefresh = func(accessToken string) {
+		s.AccessToken = accessToken
+		if err := s.save(); err != nil {
+			fmt.Fprintf(os.Stderr, "homeshare: saving session: %v\n", err)
+		}
+	}
+	return c, nil
+}
+
+func runLogin(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("login", flag.ContinueOnError)
+	server := flags.String("server", os.Getenv("HOMESHARE_SERVER"), "Server URL, e.g. https://api.mydomain.com")
+	username := flags.String("user", os.Getenv("HOMESHARE_USER"), "Username")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *server == "" || *username == "" {
+		return errors.New("-server and -user are required")
+	}
+
+	// HOMESHARE_PASSWORD lets scripts log in without a prompt
+	password := os.Getenv("HOMESHARE_PASSWORD")
+	if password == "" {
+		var err error
+		if password, err = readPassword(); err != nil {
+			return err
+		}
+	}
+
+	c := client.New(*server)
+	if err := c.Login(ctx, *username, password); err != nil {
+		return err
+	}
+	s := &session{Server: c.Server, Username: *username}
+	s.AccessToken, s.RefreshToken = c.Tokens()
+	if err := s.save(); err != nil {
+		return err
+	}
+	fmt.Printf("Logged in to %s as %s\n", s.Server, s.Username)
+	return nil
+}
+
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "Password: ")
+	defer fmt.Fprintln(os.Stderr)
+
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		password, err := term.ReadPassword(int(os.Stdin.Fd()))
+		return string(password), err
+	}
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		return "", err
+	}
+	return strings.TrimRight(password, "\r\n"), nil
+}
+
+func runLogout(ctx context.Context, args []string) error {
+	if err := removeSession(); err != nil {
+		return err
+	}
+	fmt.Println("Logged out")
+	return nil
+}
+
+func runUpload(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("upload", flag.ContinueOnError)
+	folderId := flags.String("folder", "/", "Folder to upload to, / for the library or a share's folder id")
+	remoteDir := flags.String("path", "", "Sub folder to upload into")
+	parallel := flags.Int("parallel", 4, "Chunks sent at the same time")
+	stream := flags.Bool("stream", false, "Send each file in a single request, faster on a local network but not resumable")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("nothing to upload")
+	}
+	if *parallel < 1 {
+		return errors.New("-parallel must be at least 1")
+	}
+
+	c, err := newClientFromSession()
+	if err != nil {
+		return err
+	}
+	c.Concurrency = *parallel
+	uploads, err := collectUploads(flags.Args(), *remoteDir)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, u := range uploads {
+		upload := c.UploadFile
+		if *stream {
+			upload = c.StreamFile
+		}
+		err := upload(ctx, *folderId, u.remoteDir, u.localPath)
+		switch {
+		case err == nil:
+			fmt.Printf("Uploaded %s\n", u.remotePath())
+		case errors.Is(err, client.ErrInvalidFile):
+			fmt.Fprintf(os.Stderr, "Skipped %s: %v\n", u.localPath, err)
+		default:
+			failed++
+			fmt.Fprintf(os.Stderr, "Failed %s: %v\n", u.localPath, err)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d uploads failed, run again to resume", failed, len(uploads))
+	}
+	return nil
+}
+
+func runDownload(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("download", flag.ContinueOnError)
+	folderId := flags.String("folder", "", "Folder id of the share")
+	outDir := flags.String("o", ".", "Directory to save into")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *folderId == "" {
+		return errors.New("-folder is required")
+	}
+
+	c, err := newClientFromSession()
+	if err != nil {
+		return err
+	}
+
+	// Without file names the whole share is downloaded
+	fileNames := flags.Args()
+	if len(fileNames) == 0 {
+		files, err := c.ShareFiles(ctx, *folderId)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			fileNames = append(fileNames, file.FileName+file.FileExtension)
+		}
+	}
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		return err
+	}
+
+	for _, fileName := range fileNames {
+		destPath := filepath.Join(*outDir, filepath.Base(fileName))
+		if err := c.DownloadFile(ctx, *folderId, fileName, destPath); err != nil {
+			var statusErr *client.StatusError
+			if errors.As(err, &statusErr) {
+				return fmt.Errorf("%s: %w", fileName, err)
+			}
+			return fmt.Errorf("%s: %w, run again to resume", fileName, err)
+		}
+		fmt.Printf("Downloaded %s\n", fileName)
+	}
+	return nil
+}
+
+func runList(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("ls", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	c, err := newClientFromSession()
+	if err != nil {
+		return err
+	}
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer out.Flush()
+
+	if flags.NArg() > 0 {
+		files, err := c.ShareFiles(ctx, flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "NAME\tSIZE")
+		for _, file := range files {
+			fmt.Fprintf(out, "%s%s\t%s\n", file.FileName, file.FileExtension, file.FileSize)
+		}
+		return nil
+	}
+
+	shares, err := c.Shares(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "FOLDER ID\tNAME\tEXPIRES")
+	for _, share := range shares {
+		fmt.Fprintf(out, "%s\t%s\t%s\n", share.FolderId, share.FolderName, share.ExpiresAt.Local().Format(time.DateTime))
+	}
+	return nil
+}
+
+func runShare(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("share", flag.ContinueOnError)
+	name := flags.String("name", "", "Name of the share")
+	access := flags.String("access", "r", "Access of the link: r, w or rw")
+	expires := flags.String("expires", "7d", "Expiry, a duration (90m, 36h, 7d) or an RFC3339 date")
+	otp := flags.String("otp", "", "Password of the link")
+	strip := flags.Bool("strip", false, "Remove EXIF, GPS and other metadata from images added to the share")
+	notify := flags.String("notify", "", "Comma separated emails to send the link and password to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *name == "" {
+		return errors.New("-name is required")
+	}
+	expiresAt, err := parseExpiry(*expires, time.Now())
+	if err != nil {
+		return err
+	}
+
+	c, err := newClientFromSession()
+	if err != nil {
+		return err
+	}
+	details := client.ShareDetails{
+		Access:         *access,
+		FolderName:     *name,
+		OtpPass:        *otp,
+		ExpirationDate: expiresAt.UTC().Format(time.RFC3339),
+		Files:          flags.Args(),
+		StripMetadata:  *strip,
+	}
+	for _, recipient := range strings.Split(*notify, ",") {
+		if recipient = strings.TrimSpace(recipient); recipient != "" {
+			details.Recipients = append(details.Recipients, recipient)
+		}
+	}
+	response, err := c.CreateShare(ctx, details)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Folder id: %s\n", response.FolderId)
+	fmt.Printf("Link:      %s\n", shareLink(c.Server, response.LinkUrl))
+	for _, attached := range response.Attached {
+		fmt.Printf("Attached:  %s\n", attached.Path)
+	}
+	for _, recipient := range response.Notified {
+		fmt.Printf("Emailed:   %s\n", recipient)
+	}
+	if response.NotifyError != "" {
+		fmt.Fprintf(os.Stderr, "homeshare: the link could not be emailed: %s\n", response.NotifyError)
+	}
+	return nil
+}
+
+// parseExpiry accepts a duration from now, with d for days, or a date.
+func parseExpiry(value string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		value = days + "h"
+		if duration, err := time.ParseDuration(value); err == nil {
+			return now.Add(24 * duration), nil
+		}
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(duration), nil
+	}
+	expiresAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiry %q", value)
+	}
+	return expiresAt, nil
+}
+
+// shareLink builds the link opened in the browser, the web interface is
+// served from the API's domain without the `api.` prefix.
+func shareLink(server string, linkUrl string) string {
+	base, err := url.Parse(server)
+	if err != nil {
+		return linkUrl
+	}
+	base.Host = strings.TrimPrefix(base.Host, "api.")
+	base.Path = "/sg-"
+	base.RawQuery = url.Values{"l": {linkUrl}}.Encode()
+	return base.String()
+}
+
+func runRevoke(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("revoke", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("expected the folder id of the share")
+	}
+
+	c, err := newClientFromSession()
+	if err != nil {
+		return err
+	}
+	if err := c.RevokeShare(ctx, flags.Arg(0)); err != nil {
+		return err
+	}
+	fmt.Printf("Revoked %s\n", flags.Arg(0))
+	return nil
+}
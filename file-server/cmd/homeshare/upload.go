@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// upload is a local file and where it goes in the folder.
+type upload struct {
+	localPath string
+	remoteDir string // Sub folder, "" for the folder itself
+	fileName  string
+}
+
+// collectUploads expands the given files and directories. Directories are
+// uploaded under their own name, keeping their structure.
+func collectUploads(paths []string, remoteDir string) ([]upload, error) {
+	uploads := []upload{}
+	for _, localPath := range paths {
+		info, err := os.Stat(localPath)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			uploads = append(uploads, newUpload(localPath, remoteDir, info))
+			continue
+		}
+
+		base := filepath.Dir(filepath.Clean(localPath))
+		err = filepath.WalkDir(localPath, func(filePath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				if strings.HasPrefix(entry.Name(), ".") && filePath != localPath {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(base, filepath.Dir(filePath))
+			if err != nil {
+				return err
+			}
+			uploads = append(uploads, newUpload(filePath, path.Join(remoteDir, filepath.ToSlash(rel)), info))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return uploads, nil
+}
+
+func newUpload(localPath string, remoteDir string, info fs.FileInfo) upload {
+	return upload{
+		localPath: localPath,
+		remoteDir: strings.Trim(remoteDir, "/"),
+		fileName:  info.Name(),
+	}
+}
+
+func (u upload) remotePath() string {
+	return path.Join(u.remoteDir, u.fileName)
+}
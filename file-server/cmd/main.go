@@ -2,19 +2,30 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
-
+	"os/signal"
+	"syscall"
 	"time"
 
 	"file-server/config"
 	"file-server/internal/app"
+	"file-server/internal/dedup"
+	"file-server/internal/files"
 	"file-server/internal/helpers"
+	"file-server/internal/index"
 	"file-server/internal/job"
+	"file-server/internal/repositories"
+	"file-server/internal/sharing"
+	"file-server/internal/uploader"
 )
 
 type AcmeJSON struct {
@@ -77,21 +88,58 @@ func main() {
 		log.Fatal("[FILE-SERVER] Error while creating sharing folder directory")
 	}
 
-	go func () {
-		for {
-			_ = helpers.CleanupExpiredFolders(cfg.SharingDir)
-			time.Sleep(30 * time.Minute)
-		}
-	}()
-	
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	job_timeout := 45 * time.Second
 	jm := job.NewJobManager(job_timeout)
 
-	server, err := app.SetupServer(jm, app.InitDatabase)
+	database, err := app.InitDatabase()
 	if err != nil {
 		log.Fatalf("[FILE-SERVER] Server setup failed: %v", err)
 	}
 
+	// Run through jm so shutdown waits for a pass under way before closing the database
+	jm.Go(func() {
+		for {
+			if err := sharing.CleanupExpiredShares(database, cfg.SharingDir, jm); err != nil {
+				log.Printf("[FILE-SERVER] Error while cleaning up expired shares: %v", err)
+			}
+			uploader.CollectStaleChunks(jm, helpers.StorageRoots(cfg), cfg.ChunkTTL)
+			files.PurgeExpiredTrash(helpers.StorageRoots(cfg), cfg.TrashRetention)
+			if removed, err := repositories.DeleteWebhookDeliveriesBefore(database, time.Now().Add(-cfg.WebhookLogRetention)); err != nil {
+				log.Printf("[FILE-SERVER] Error while pruning webhook deliveries: %v", err)
+			} else if removed > 0 {
+				log.Printf("[FILE-SERVER] Pruned %d webhook deliveries", removed)
+			}
+			if removed, freed, err := dedup.CollectGarbage(); err != nil {
+				log.Printf("[FILE-SERVER] Error while collecting unreferenced blobs: %v", err)
+			} else if removed > 0 {
+				log.Printf("[FILE-SERVER] Removed %d unreferenced blobs, freed %d bytes", removed, freed)
+			}
+			if err := index.Rescan(ctx, database, helpers.StorageRoots(cfg)); err != nil {
+				log.Printf("[FILE-SERVER] Error while rescanning file index: %v", err)
+			}
+			select {
+			case <-time.After(30 * time.Minute):
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+
+	server, stopServices, err := app.SetupServer(jm, func() (*sql.DB, error) {
+		return database, nil
+	})
+	if err != nil {
+		log.Fatalf("[FILE-SERVER] Server setup failed: %v", err)
+	}
+
+	// Finish or discard uploads interrupted by the previous shutdown, once the assembly hooks are set
+	jm.Go(func() {
+		uploader.RecoverUploads(jm, helpers.StorageRoots(cfg), cfg.ChunkTTL)
+	})
+
 	server.Addr = ":443"
 
 	cert, err := loadCertificate(cfg.Domain)
@@ -105,5 +153,39 @@ func main() {
 
 	server.TLSConfig = tlsConfig
 
-	log.Fatal(server.ListenAndServeTLS("","",))
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServeTLS("","",)
+	}()
+
+	select {
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("[FILE-SERVER] Server stopped unexpectedly: %v", err)
+		}
+	case <-ctx.Done():
+	}
+
+	log.Printf("[FILE-SERVER] Shutting down, waiting up to %s for in-flight work", cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	// Stop accepting connections and drain in-flight requests (chunk uploads, downloads)
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("[FILE-SERVER] Error while draining requests: %v", err)
+	}
+	// Last upload reports go through jm, so they are started before waiting on it
+	stopServices()
+
+	// Wait for background assemblies and zip builds started by the drained requests
+	if err := jm.Wait(shutdownCtx); err != nil {
+		log.Printf("[FILE-SERVER] Background jobs did not finish before deadline: %v", err)
+	}
+
+	if err := database.Close(); err != nil {
+		log.Printf("[FILE-SERVER] Error while closing database: %v", err)
+	}
+	jm.Close()
+
+	log.Printf("[FILE-SERVER] Shutdown complete")
 }
@@ -3,6 +3,7 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
 	"time"
 )
 
@@ -15,6 +16,16 @@ type DBConfig struct {
 	SSLMode  string
 }
 
+type SMTPConfig struct {
+	Host         string // Notifications are off without one
+	Port         string
+	Username     string // Authenticates with PLAIN when set
+	Password     string
+	From         string
+	StartTLS     bool   // Refuse to send unless the server upgrades the connection
+	TemplatesDir string // Overrides of the built in templates, see internal/notify
+}
+
 type User struct {
 	Username string
 	Email    string
@@ -36,6 +47,27 @@ type Config struct {
 	UploadDir    string
 	SharingDir   string
 	ChunksDir    string
+	ShutdownTimeout time.Duration
+	ChunkTTL     time.Duration
+	TrashRetention time.Duration
+	Dedup        bool // Store identical uploads once, see internal/dedup
+	IngestRules  string // Where uploads to the library are filed, see internal/ingest
+	UploadMaxInFlight int    // Chunks a user can be sending at once, 0 for no limit
+	UploadBandwidth   int64  // Bytes per second a user can upload, 0 for no limit
+	UploadBandwidthGlobal int64 // Bytes per second uploaded by everyone together, 0 for no limit
+	UploadBandwidthShare  int64 // Bytes per second uploaded to a share link, 0 for no limit
+	DownloadBandwidth       int64 // Bytes per second a user can download, 0 for no limit
+	DownloadBandwidthGlobal int64 // Bytes per second downloaded by everyone together, 0 for no limit
+	DownloadBandwidthShare  int64 // Bytes per second downloaded from a share link, 0 for no limit
+	UploadChunkSize   int64  // Bytes per chunk handed out by /upload-init
+	UploadChunkSizes  string // Per user chunk sizes as `user=bytes,...`, overriding UploadChunkSize
+	UploadMaxFileSize int64  // Largest file /upload-init accepts, 0 for no limit
+	WebhookMaxAttempts  int           // Deliveries tried this many times before giving up
+	WebhookRetryDelay   time.Duration // Wait after the first failed delivery, doubled after each one
+	WebhookLogRetention time.Duration // How long deliveries are kept in the log
+	NotifyUploadDelay   time.Duration // Uploads to a share within this long are reported in one email
+	NotifyExpiryWarning time.Duration // How long before a share expires the admin is warned, 0 for never
+	SMTP         SMTPConfig
 	DB           DBConfig
 	Secrets      Secrets
 	User         User
@@ -50,12 +82,117 @@ func LoadConfig() *Config {
 	if err != nil {
 		log.Fatalf("[FILE-SERVER] Invalid REFRESH_TOKEN_EXP value: %v", err)
 	}
+	shutdownTimeout, err := time.ParseDuration(getEnv("SHUTDOWN_TIMEOUT", "30s"))
+	if err != nil {
+		log.Fatalf("[FILE-SERVER] Invalid SHUTDOWN_TIMEOUT value: %v", err)
+	}
+	chunkTTL, err := time.ParseDuration(getEnv("CHUNK_TTL", "24h"))
+	if err != nil {
+		log.Fatalf("[FILE-SERVER] Invalid CHUNK_TTL value: %v", err)
+	}
+	trashRetention, err := time.ParseDuration(getEnv("TRASH_RETENTION", "720h"))
+	if err != nil {
+		log.Fatalf("[FILE-SERVER] Invalid TRASH_RETENTION value: %v", err)
+	}
+	dedup, err := strconv.ParseBool(getEnv("DEDUP_ENABLED", "false"))
+	if err != nil {
+		log.Fatalf("[FILE-SERVER] Invalid DEDUP_ENABLED value: %v", err)
+	}
+	uploadMaxInFlight, err := strconv.Atoi(getEnv("UPLOAD_MAX_INFLIGHT", "8"))
+	if err != nil {
+		log.Fatalf("[FILE-SERVER] Invalid UPLOAD_MAX_INFLIGHT value: %v", err)
+	}
+	uploadBandwidth, err := strconv.ParseInt(getEnv("UPLOAD_BANDWIDTH", "0"), 10, 64)
+	if err != nil {
+		log.Fatalf("[FILE-SERVER] Invalid UPLOAD_BANDWIDTH value: %v", err)
+	}
+	uploadBandwidthGlobal, err := strconv.ParseInt(getEnv("UPLOAD_BANDWIDTH_GLOBAL", "0"), 10, 64)
+	if err != nil {
+		log.Fatalf("[FILE-SERVER] Invalid UPLOAD_BANDWIDTH_GLOBAL value: %v", err)
+	}
+	uploadBandwidthShare, err := strconv.ParseInt(getEnv("UPLOAD_BANDWIDTH_SHARE", "0"), 10, 64)
+	if err != nil {
+		log.Fatalf("[FILE-SERVER] Invalid UPLOAD_BANDWIDTH_SHARE value: %v", err)
+	}
+	downloadBandwidth, err := strconv.ParseInt(getEnv("DOWNLOAD_BANDWIDTH", "0"), 10, 64)
+	if err != nil {
+		log.Fatalf("[FILE-SERVER] Invalid DOWNLOAD_BANDWIDTH value: %v", err)
+	}
+	downloadBandwidthGlobal, err := strconv.ParseInt(getEnv("DOWNLOAD_BANDWIDTH_GLOBAL", "0"), 10, 64)
+	if err != nil {
+		log.Fatalf("[FILE-SERVER] Invalid DOWNLOAD_BANDWIDTH_GLOBAL value: %v", err)
+	}
+	downloadBandwidthShare, err := strconv.ParseInt(getEnv("DOWNLOAD_BANDWIDTH_SHARE", "0"), 10, 64)
+	if err != nil {
+		log.Fatalf("[FILE-SERVER] Invalid DOWNLOAD_BANDWIDTH_SHARE value: %v", err)
+	}
+	uploadChunkSize, err := strconv.ParseInt(getEnv("UPLOAD_CHUNK_SIZE", "5242880"), 10, 64)
+	if err != nil || uploadChunkSize <= 0 {
+		log.Fatalf("[FILE-SERVER] Invalid UPLOAD_CHUNK_SIZE value: %v", err)
+	}
+	uploadMaxFileSize, err := strconv.ParseInt(getEnv("UPLOAD_MAX_FILE_SIZE", "0"), 10, 64)
+	if err != nil {
+		log.Fatalf("[FILE-SERVER] Invalid UPLOAD_MAX_FILE_SIZE value: %v", err)
+	}
+	webhookMaxAttempts, err := strconv.Atoi(getEnv("WEBHOOK_MAX_ATTEMPTS", "5"))
+	if err != nil || webhookMaxAttempts <= 0 {
+		log.Fatalf("[FILE-SERVER] Invalid WEBHOOK_MAX_ATTEMPTS value: %v", err)
+	}
+	webhookRetryDelay, err := time.ParseDuration(getEnv("WEBHOOK_RETRY_DELAY", "10s"))
+	if err != nil {
+		log.Fatalf("[FILE-SERVER] Invalid WEBHOOK_RETRY_DELAY value: %v", err)
+	}
+	webhookLogRetention, err := time.ParseDuration(getEnv("WEBHOOK_LOG_RETENTION", "720h"))
+	if err != nil {
+		log.Fatalf("[FILE-SERVER] Invalid WEBHOOK_LOG_RETENTION value: %v", err)
+	}
+	notifyUploadDelay, err := time.ParseDuration(getEnv("NOTIFY_UPLOAD_DELAY", "5m"))
+	if err != nil {
+		log.Fatalf("[FILE-SERVER] Invalid NOTIFY_UPLOAD_DELAY value: %v", err)
+	}
+	notifyExpiryWarning, err := time.ParseDuration(getEnv("NOTIFY_EXPIRY_WARNING", "24h"))
+	if err != nil {
+		log.Fatalf("[FILE-SERVER] Invalid NOTIFY_EXPIRY_WARNING value: %v", err)
+	}
+	smtpStartTLS, err := strconv.ParseBool(getEnv("SMTP_STARTTLS", "true"))
+	if err != nil {
+		log.Fatalf("[FILE-SERVER] Invalid SMTP_STARTTLS value: %v", err)
+	}
 	return &Config{
 		Domain:		  getEnv("DOMAIN", "mydomain.com"),
 		DomainOrigin: getEnv("DOMAIN_ORIGIN", "https://mydomain.com"),
 		UploadDir:    getEnv("UPLOAD_DIR", "uploads"),
 		SharingDir:   getEnv("SHARING_DIR", "temp"),
 		ChunksDir:    getEnv("CHUNKS_DIR", "chunks"),
+		ShutdownTimeout: shutdownTimeout,
+		ChunkTTL:     chunkTTL,
+		TrashRetention: trashRetention,
+		Dedup:        dedup,
+		IngestRules:  getEnv("INGEST_RULES", ""),
+		UploadMaxInFlight: uploadMaxInFlight,
+		UploadBandwidth:   uploadBandwidth,
+		UploadBandwidthGlobal: uploadBandwidthGlobal,
+		UploadBandwidthShare:  uploadBandwidthShare,
+		DownloadBandwidth:       downloadBandwidth,
+		DownloadBandwidthGlobal: downloadBandwidthGlobal,
+		DownloadBandwidthShare:  downloadBandwidthShare,
+		UploadChunkSize:   uploadChunkSize,
+		UploadChunkSizes:  getEnv("UPLOAD_CHUNK_SIZES", ""),
+		UploadMaxFileSize: uploadMaxFileSize,
+		WebhookMaxAttempts:  webhookMaxAttempts,
+		WebhookRetryDelay:   webhookRetryDelay,
+		WebhookLogRetention: webhookLogRetention,
+		NotifyUploadDelay:   notifyUploadDelay,
+		NotifyExpiryWarning: notifyExpiryWarning,
+		SMTP: SMTPConfig{
+			Host:         getEnv("SMTP_HOST", ""),
+			Port:         getEnv("SMTP_PORT", "587"),
+			Username:     getEnv("SMTP_USERNAME", ""),
+			Password:     getEnv("SMTP_PASSWORD", ""),
+			From:         getEnv("SMTP_FROM", ""),
+			StartTLS:     smtpStartTLS,
+			TemplatesDir: getEnv("SMTP_TEMPLATES_DIR", ""),
+		},
 		DB: DBConfig{
 			Host:     getEnv("DB_HOST", "postgres"),
 			Port:     getEnv("DB_PORT", "5432"),
@@ -15,3 +15,14 @@ require (
 )
 
 require github.com/DATA-DOG/go-sqlmock v1.5.2
+
+require golang.org/x/image v0.23.0
+
+require (
+	golang.org/x/net v0.38.0
+	golang.org/x/term v0.30.0
+)
+
+require golang.org/x/time v0.11.0
+
+require golang.org/x/sys v0.31.0 // indirect
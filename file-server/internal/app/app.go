@@ -5,17 +5,29 @@ import (
 	"database/sql"
 	"file-server/config"
 	"file-server/internal/auth"
+	"file-server/internal/dav"
 	"file-server/internal/db"
+	"file-server/internal/dedup"
 	"file-server/internal/downloader"
+	"file-server/internal/events"
+	"file-server/internal/files"
+	"file-server/internal/index"
+	"file-server/internal/ingest"
 	"file-server/internal/job"
+	"file-server/internal/notify"
 	"file-server/internal/sharing"
+	"file-server/internal/throttle"
 	"file-server/internal/uploader"
+	"file-server/internal/webhooks"
 	"file-server/internal/repositories"
 	"file-server/internal/helpers"
 	"fmt"
+	"log"
 	"net/http"
+	"path/filepath"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/rs/cors"
 	"github.com/google/uuid"
 )
@@ -33,38 +45,152 @@ func InitDatabase() (*sql.DB, error) {
 	if err := repositories.InitializeUserTable(db); err != nil {
 		return nil, err
 	}
+	if err := repositories.InitializeAppPasswordTable(db); err != nil {
+		return nil, err
+	}
+	if err := repositories.InitializeApiTokenTable(db); err != nil {
+		return nil, err
+	}
+	if err := repositories.InitializeShareTable(db); err != nil {
+		return nil, err
+	}
 	if err := repositories.InitializeSharingUserTable(db); err != nil {
 		return nil, err
 	}
+	if err := repositories.InitializeFileIndexTable(db); err != nil {
+		return nil, err
+	}
+	if err := repositories.InitializeWebhookTable(db); err != nil {
+		return nil, err
+	}
+	if err := repositories.InitializeWebhookDeliveryTable(db); err != nil {
+		return nil, err
+	}
 	if _, err := repositories.CreateAdminUser(db, user.Username, user.Email, user.Password); err != nil {
 		return nil, err
 	}
 	return db, nil
 }
 
-func SetupServer(jm *job.JobManager, dbCallback DatabaseCallback) (*http.Server, error) {
+// SetupServer builds the server and starts the background services behind
+// it. The returned stop reports the uploads still waiting and ends webhook
+// deliveries, it must be called once the server is shut down and before
+// waiting on jm, as both start their last jobs through it.
+func SetupServer(jm *job.JobManager, dbCallback DatabaseCallback) (*http.Server, func(), error) {
 	cfg := config.LoadConfig()
 
+	rules, err := ingest.ParseRules(cfg.IngestRules)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	db, err := dbCallback()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	
-	go func(){
-		for {
-			err := repositories.DeleteExpiredUsers(db)
-			if err != nil {
-				fmt.Printf("Received unexpected error when deleting expired users")
+	stopSweeper := make(chan struct{})
+	if db != nil {
+		go func(){
+			ticker := time.NewTicker(5*time.Second)
+			defer ticker.Stop()
+			for {
+				err := repositories.DeleteExpiredUsers(db)
+				if err != nil {
+					fmt.Printf("Received unexpected error when deleting expired users")
+				}
+				select {
+				case <-ticker.C:
+				case <-stopSweeper:
+					return
+				}
+			}
+		}()
+	}
+
+	hooks := []uploader.AssemblyHook{}
+	if len(rules) > 0 {
+		// Filing runs first so dedup and the index see the final path
+		hooks = append(hooks, func(folderPath string, filePath string) (string, error) {
+			if filepath.Clean(folderPath) != filepath.Clean(cfg.UploadDir) {
+				return filePath, nil
 			}
-			time.Sleep(5*time.Second)
+			return ingest.Apply(rules, folderPath, filePath)
+		})
+	}
+	if db != nil {
+		hooks = append(hooks, sharing.StripMetadataHook(db))
+		files.SetTransferHook(sharing.StripTransferredHook(db))
+		if cfg.Dedup {
+			hooks = append(hooks, func(folderPath string, filePath string) (string, error) {
+				result, err := dedup.Deduplicate(filePath)
+				if result.Linked {
+					log.Printf("[FILE-SERVER] Deduplicated %s, reclaimed %d bytes", filePath, result.Reclaimed)
+				}
+				return filePath, err
+			})
 		}
-	}()
-	
+		hooks = append(hooks, func(folderPath string, filePath string) (string, error) {
+			return filePath, index.IndexFile(db, folderPath, filePath)
+		})
+	}
+	uploader.SetAssemblyHooks(hooks...)
+	uploader.SetUploadLimits(throttle.NewLimits(cfg.UploadMaxInFlight))
+
+	uploads, err := throttle.NewBandwidth(throttle.Rates{
+		Global: cfg.UploadBandwidthGlobal,
+		Share:  cfg.UploadBandwidthShare,
+		User:   cfg.UploadBandwidth,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	downloads, err := throttle.NewBandwidth(throttle.Rates{
+		Global: cfg.DownloadBandwidthGlobal,
+		Share:  cfg.DownloadBandwidthShare,
+		User:   cfg.DownloadBandwidth,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	uploader.SetUploadBandwidth(uploads)
+	downloader.SetDownloadBandwidth(downloads)
+
+	var dispatcher *webhooks.Dispatcher
+	if db != nil {
+		auth.SetApiTokenLookup(func(token string) (jwt.MapClaims, error) {
+			return auth.ApiTokenClaims(db, token)
+		})
+		dispatcher = webhooks.NewDispatcher(db, jm, cfg.WebhookMaxAttempts, cfg.WebhookRetryDelay)
+		dispatcher.Start()
+	} else {
+		auth.SetApiTokenLookup(nil)
+	}
+
+	var notifier *notify.Notifier
+	if db != nil && cfg.SMTP.Host != "" {
+		mailer, err := notify.NewMailer(cfg.SMTP)
+		if err != nil {
+			return nil, nil, err
+		}
+		templates, err := notify.LoadTemplates(cfg.SMTP.TemplatesDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		notifier = notify.NewNotifier(db, jm, mailer, templates, cfg.NotifyUploadDelay, cfg.NotifyExpiryWarning)
+		notifier.Start()
+	}
+	sharing.SetNotifier(notifier)
+
 
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{cfg.DomainOrigin, "http://localhost:3001"},
-		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodOptions},
-		AllowedHeaders:   []string{"Authorization", "Content-Type", "Set-Cookie", "Folder-Id"},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodOptions, http.MethodHead, http.MethodPatch, http.MethodDelete, http.MethodPut},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "Set-Cookie", "Folder-Id",
+			"Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata", "Upload-Checksum", "X-HTTP-Method-Override",
+			"Content-Digest", "Content-MD5"},
+		ExposedHeaders:   []string{"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Tus-Checksum-Algorithm",
+			"Upload-Length", "Upload-Offset", "Upload-Metadata", "Upload-Expires"},
 		AllowCredentials: true,
 	})
 
@@ -84,7 +210,24 @@ func SetupServer(jm *job.JobManager, dbCallback DatabaseCallback) (*http.Server,
 	})
 
 	mux.HandleFunc("/auth-share", func(w http.ResponseWriter, r *http.Request) {
-		auth.SharingGatewayHandler(w, r, db)
+		auth.SharingGatewayHandler(w, r, db, jm)
+	})
+
+	// Authenticated with Basic auth, WebDAV clients can't do the token flow
+	mux.HandleFunc(dav.Prefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		dav.WebDAVHandler(w, r, db, jm)
+	})
+
+	// Discovery is unauthenticated, tus clients send it without credentials
+	mux.HandleFunc(uploader.TusPrefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			uploader.TusOptionsHandler(w, r)
+			return
+		}
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				uploader.TusHandler(w, r, jm)
+			})(w, r)
 	})
 
 	// Authenticated endpoints
@@ -94,12 +237,48 @@ func SetupServer(jm *job.JobManager, dbCallback DatabaseCallback) (*http.Server,
 				uploader.UploadHandler(w, r, jm, cfg.UploadDir)
 			}))
 
+	mux.HandleFunc("/upload-init",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				uploader.UploadInitHandler(w, r)
+			}))
+
+	mux.HandleFunc("/upload-stream",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				uploader.UploadStreamHandler(w, r, jm)
+			}))
+
+	mux.HandleFunc("/upload-status",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				uploader.UploadStatusHandler(w, r)
+			}))
+
+	mux.HandleFunc("/upload-check",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				uploader.UploadCheckHandler(w, r, db, jm)
+			}))
+
 	mux.HandleFunc("/download",
 		auth.RefreshAuthMiddleware(
 			func(w http.ResponseWriter, r *http.Request) {
 				downloader.DownloadHandler(w, r, jm)
 			}))
 
+	// EventSource can't set headers, browsers authenticate with the refresh cookie as for downloads
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		middleware := auth.RefreshAuthMiddleware
+		if r.Header.Get("Authorization") != "" {
+			middleware = auth.AuthMiddleware
+		}
+		middleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				events.EventsHandler(w, r, jm)
+			})(w, r)
+	})
+
 	mux.HandleFunc("/download-available",
 		auth.AuthMiddleware(
 			func(w http.ResponseWriter, r *http.Request) {
@@ -114,7 +293,25 @@ func SetupServer(jm *job.JobManager, dbCallback DatabaseCallback) (*http.Server,
 					http.Error(w, fmt.Sprintf("Error while creating user: %v", err), http.StatusInternalServerError)
 					return
 				}
-				sharing.SharingHandler(w, r, db, salt, uuid.New().String())
+				sharing.SharingHandler(w, r, db, jm, salt, uuid.New().String())
+			}))
+
+	mux.HandleFunc("/share-expiry",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				sharing.UpdateSharingExpiryHandler(w, r, db)
+			}))
+
+	mux.HandleFunc("/shares",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				sharing.ListSharesHandler(w, r, db)
+			}))
+
+	mux.HandleFunc("/share-revoke",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				sharing.RevokeShareHandler(w, r, db)
 			}))
 
 	mux.HandleFunc("/share-file",
@@ -123,14 +320,173 @@ func SetupServer(jm *job.JobManager, dbCallback DatabaseCallback) (*http.Server,
 				sharing.AddSharingFilesHandler(w, r, jm)
 			}))
 
+	mux.HandleFunc("/share-attach",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				sharing.AttachFilesHandler(w, r, db, jm)
+			}))
+
 	mux.HandleFunc("/share-files",
 		auth.AuthMiddleware(
 			func(w http.ResponseWriter, r *http.Request) {
 				sharing.GetSharingFilesHandler(w, r)
 			}))
 
-	return &http.Server{
+	mux.HandleFunc("/share-gallery",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				sharing.GalleryHandler(w, r, db)
+			}))
+
+	// Thumbnails are loaded by <img> tags, which authenticate with the refresh cookie as for downloads
+	mux.HandleFunc("/thumbnail", func(w http.ResponseWriter, r *http.Request) {
+		middleware := auth.RefreshAuthMiddleware
+		if r.Header.Get("Authorization") != "" {
+			middleware = auth.AuthMiddleware
+		}
+		middleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				sharing.ThumbnailHandler(w, r)
+			})(w, r)
+	})
+
+	mux.HandleFunc("/app-passwords",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				auth.AppPasswordsHandler(w, r, db)
+			}))
+
+	mux.HandleFunc("/app-password-revoke",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				auth.AppPasswordRevokeHandler(w, r, db)
+			}))
+
+	mux.HandleFunc("/api-tokens",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				auth.ApiTokensHandler(w, r, db)
+			}))
+
+	mux.HandleFunc("/api-token-revoke",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				auth.ApiTokenRevokeHandler(w, r, db)
+			}))
+
+	mux.HandleFunc("/webhooks",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				webhooks.WebhooksHandler(w, r, db)
+			}))
+
+	mux.HandleFunc("/webhook-delete",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				webhooks.WebhookDeleteHandler(w, r, db)
+			}))
+
+	mux.HandleFunc("/webhook-deliveries",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				webhooks.WebhookDeliveriesHandler(w, r, db)
+			}))
+
+	mux.HandleFunc("/file-delete",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				files.DeleteFileHandler(w, r, jm)
+			}))
+
+	mux.HandleFunc("/trash",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				files.ListTrashHandler(w, r)
+			}))
+
+	mux.HandleFunc("/trash-restore",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				files.RestoreTrashHandler(w, r, jm)
+			}))
+
+	mux.HandleFunc("/file-rename",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				files.RenameFileHandler(w, r, jm)
+			}))
+
+	mux.HandleFunc("/file-move",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				files.MoveFileHandler(w, r, jm)
+			}))
+
+	mux.HandleFunc("/file-copy",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				files.CopyFileHandler(w, r, jm)
+			}))
+
+	mux.HandleFunc("/folder-create",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				files.CreateFolderHandler(w, r)
+			}))
+
+	mux.HandleFunc("/search",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				index.SearchHandler(w, r, db)
+			}))
+
+	// Admin endpoints
+	mux.HandleFunc("/admin/chunks",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				uploader.PendingChunksHandler(w, r)
+			}))
+
+	mux.HandleFunc("/admin/chunks-cleanup",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				uploader.CleanupChunksHandler(w, r, jm)
+			}))
+
+	mux.HandleFunc("/admin/bandwidth",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				throttle.BandwidthHandler(w, r, uploads, downloads)
+			}))
+
+	mux.HandleFunc("/admin/dedup",
+		auth.AuthMiddleware(
+			func(w http.ResponseWriter, r *http.Request) {
+				dedup.ReportHandler(w, r)
+			}))
+
+	server := &http.Server{
 		Addr:    ":443",
 		Handler: c.Handler(mux),
-	}, nil
+	}
+	// Stop touching the database once shutdown begins so it can be closed safely
+	server.RegisterOnShutdown(func() {
+		close(stopSweeper)
+	})
+	// Event streams never go idle, Shutdown would wait on them until its deadline
+	server.RegisterOnShutdown(jm.EndSubscriptions)
+
+	// Shutdown runs its hooks without waiting for them, these start jobs so they are the caller's to run
+	stop := func() {
+		if dispatcher != nil {
+			// Retries waiting for their turn are resumed on the next start
+			dispatcher.Stop()
+		}
+		if notifier != nil {
+			// Uploads waiting to be reported are reported right away
+			notifier.Stop()
+		}
+	}
+
+	return server, stop, nil
 }
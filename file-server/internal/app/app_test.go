@@ -18,10 +18,41 @@ var endpoints = []string{
 	"/refresh", // POST
 	"/logout", // POST
 	"/upload", // POST
+	"/upload-check", // POST
+	"/upload-init", // POST
+	"/upload-status", // GET
+	"/upload-stream", // PUT
+	"/tus/", // OPTIONS, POST, HEAD, PATCH, DELETE
 	"/download", // GET
+	"/events", // GET
 	"/share", // POST
+	"/share-expiry", // POST
+	"/shares", // GET
+	"/share-revoke", // POST
 	"/share-file", // POST
 	"/share-files", // GET
+	"/share-attach", // POST
+	"/share-gallery", // GET
+	"/thumbnail", // GET
+	"/app-passwords", // GET, POST
+	"/app-password-revoke", // POST
+	"/api-tokens", // GET, POST
+	"/api-token-revoke", // POST
+	"/webhooks", // GET, POST
+	"/webhook-delete", // POST
+	"/webhook-deliveries", // GET
+	"/file-delete", // POST
+	"/trash", // GET
+	"/trash-restore", // POST
+	"/file-rename", // POST
+	"/file-move", // POST
+	"/file-copy", // POST
+	"/folder-create", // POST
+	"/search", // GET
+	"/admin/chunks", // GET
+	"/admin/chunks-cleanup", // POST
+	"/admin/bandwidth", // GET, POST
+	"/admin/dedup", // GET
 }
 
 
@@ -34,7 +65,7 @@ func setupTestServer(t *testing.T) (*httptest.Server, string) {
 		return nil, nil
 	}
 
-	srv, err := SetupServer(jm, dummyInitDatabase)
+	srv, _, err := SetupServer(jm, dummyInitDatabase)
 	if err != nil {
 		t.Fatalf("failed to setup server: %v", err)
 	}
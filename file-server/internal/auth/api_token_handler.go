@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"file-server/internal/repositories"
+)
+
+type ApiTokenDetails struct {
+	Name      string `json:"name"`
+	FolderId  string `json:"folder_id"`            // "/" for the upload library, or a sharing folder
+	Access    string `json:"access"`               // "r", "w" or "rw"
+	ExpiresAt string `json:"expires_at,omitempty"` // RFC3339, never expires when empty
+}
+
+type ApiTokenRevokeDetails struct {
+	Id string `json:"id"`
+}
+
+type ApiTokenResponse struct {
+	Id        string     `json:"id"`
+	Name      string     `json:"name"`
+	FolderId  string     `json:"folder_id"`
+	Access    string     `json:"access"`
+	Token     string     `json:"token"` // Only ever returned here, it is stored hashed
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// ApiTokensHandler lists (GET) or creates (POST) the API tokens of the logged
+// in user. API tokens are sent as "Authorization: Bearer hs_..." by scripts
+// and sync clients, which can't go through the refresh cookie flow.
+func ApiTokensHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := accountUsername(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodGet {
+		apiTokens, err := repositories.ListApiTokens(db, username)
+		if err != nil {
+			http.Error(w, "Error while listing API tokens", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(apiTokens)
+		return
+	}
+
+	var details ApiTokenDetails
+	if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+		http.Error(w, "Unable to parse API token parameters", http.StatusBadRequest)
+		return
+	}
+	details.Name = strings.TrimSpace(details.Name)
+	if details.Name == "" || details.FolderId == "" {
+		http.Error(w, "Missing name or folder_id parameter", http.StatusBadRequest)
+		return
+	}
+	if details.Access != "r" && details.Access != "w" && details.Access != "rw" {
+		http.Error(w, "Invalid access, expected r, w or rw", http.StatusBadRequest)
+		return
+	}
+	// A token reaches no further than the account creating it
+	claims, _ := r.Context().Value(ClaimsContextKey).(jwt.MapClaims)
+	if canAccess, err := HasAccess(claims, details.FolderId, details.Access); err != nil || !canAccess {
+		http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+		return
+	}
+	if details.FolderId != "/" {
+		if share, err := repositories.GetShare(db, details.FolderId); err != nil || share.DeletedAt != nil {
+			http.Error(w, "Folder not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	var expiresAt *time.Time
+	if details.ExpiresAt != "" {
+		exp, err := time.Parse(time.RFC3339, details.ExpiresAt)
+		if err != nil {
+			http.Error(w, "Invalid expires_at, expected RFC3339", http.StatusBadRequest)
+			return
+		}
+		if !exp.After(time.Now()) {
+			http.Error(w, "Expiration date is in the past", http.StatusBadRequest)
+			return
+		}
+		expiresAt = &exp
+	}
+
+	secret, err := generateSecret(32)
+	if err != nil {
+		http.Error(w, "Error while generating API token", http.StatusInternalServerError)
+		return
+	}
+	token := ApiTokenPrefix + secret
+	apiToken, err := repositories.CreateApiToken(db, uuid.New().String(), username, details.Name, details.FolderId, details.Access, token, expiresAt)
+	if err != nil {
+		http.Error(w, "Error while creating API token", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ApiTokenResponse{
+		Id:        apiToken.Id,
+		Name:      apiToken.Name,
+		FolderId:  apiToken.FolderId,
+		Access:    apiToken.Access,
+		Token:     token,
+		ExpiresAt: apiToken.ExpiresAt,
+		CreatedAt: apiToken.CreatedAt,
+	})
+}
+
+func ApiTokenRevokeHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := accountUsername(w, r)
+	if !ok {
+		return
+	}
+
+	var details ApiTokenRevokeDetails
+	if err := json.NewDecoder(r.Body).Decode(&details); err != nil || details.Id == "" {
+		http.Error(w, "Missing id parameter", http.StatusBadRequest)
+		return
+	}
+	if err := repositories.DeleteApiToken(db, username, details.Id); err != nil {
+		http.Error(w, "API token not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"file-server/internal/repositories"
+)
+
+type AppPasswordDetails struct {
+	Name string `json:"name"`
+}
+
+type AppPasswordRevokeDetails struct {
+	Id string `json:"id"`
+}
+
+type AppPasswordResponse struct {
+	Id        string    `json:"id"`
+	Name      string    `json:"name"`
+	Password  string    `json:"password"` // Only ever returned here, it is stored hashed
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AppPasswordsHandler lists (GET) or creates (POST) the app passwords of the
+// logged in user. App passwords sign in WebDAV and other non browser clients
+// with the user's folder and access, and can be revoked one by one.
+func AppPasswordsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := accountUsername(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodGet {
+		appPasswords, err := repositories.ListAppPasswords(db, username)
+		if err != nil {
+			http.Error(w, "Error while listing app passwords", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(appPasswords)
+		return
+	}
+
+	var details AppPasswordDetails
+	if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+		http.Error(w, "Unable to parse app password parameters", http.StatusBadRequest)
+		return
+	}
+	details.Name = strings.TrimSpace(details.Name)
+	if details.Name == "" {
+		http.Error(w, "Missing name parameter", http.StatusBadRequest)
+		return
+	}
+
+	password, err := generateAppPassword()
+	if err != nil {
+		http.Error(w, "Error while generating app password", http.StatusInternalServerError)
+		return
+	}
+	appPassword, err := repositories.CreateAppPassword(db, uuid.New().String(), username, details.Name, password)
+	if err != nil {
+		http.Error(w, "Error while creating app password", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(AppPasswordResponse{
+		Id:        appPassword.Id,
+		Name:      appPassword.Name,
+		Password:  password,
+		CreatedAt: appPassword.CreatedAt,
+	})
+}
+
+func AppPasswordRevokeHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := accountUsername(w, r)
+	if !ok {
+		return
+	}
+
+	var details AppPasswordRevokeDetails
+	if err := json.NewDecoder(r.Body).Decode(&details); err != nil || details.Id == "" {
+		http.Error(w, "Missing id parameter", http.StatusBadRequest)
+		return
+	}
+	if err := repositories.DeleteAppPassword(db, username, details.Id); err != nil {
+		http.Error(w, "App password not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// accountUsername returns the user a login token was issued to, whatever
+// their folder and access. Sharing tokens belong to a share rather than an
+// account and are refused, as are API tokens so a leaked one can't be used to
+// mint more credentials.
+func accountUsername(w http.ResponseWriter, r *http.Request) (string, bool) {
+	claimsRaw := r.Context().Value(ClaimsContextKey)
+	claims, ok := claimsRaw.(jwt.MapClaims)
+	if !ok {
+		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		return "", false
+	}
+
+	username, _ := claims["user_id"].(string)
+	kind, _ := claims["kind"].(string)
+	_, isApiToken := claims["token_id"]
+	if username == "" || kind != TokenKindAccount || isApiToken {
+		http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+		return "", false
+	}
+	return username, true
+}
+
+// generateAppPassword returns 160 random bits as 32 lowercase letters and digits.
+func generateAppPassword() (string, error) {
+	return generateSecret(20)
+}
+
+func generateSecret(size int) (string, error) {
+	secret := make([]byte, size)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)), nil
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"file-server/config"
+	"file-server/internal/job"
 	"fmt"
 	"net/http"
 	"time"
@@ -26,8 +27,16 @@ type TokenParameters struct {
 	ExpiryDuration time.Duration `json:"exp"`
 	FolderId       string        `json:"folder_id"`
 	Access         string        `json:"access"` // "r", "w", or "rw"
+	Kind           string        `json:"kind"`   // TokenKindAccount or TokenKindShare
 }
 
+// Who a login token was issued to. Share tokens carry the share's folder name
+// as user_id, which could match a username, so only the kind tells them apart.
+const (
+	TokenKindAccount = "account"
+	TokenKindShare   = "share"
+)
+
 type TokenResponse struct {
 	AccessToken string `json:"access_token"`
 }
@@ -56,12 +65,14 @@ func LoginHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		ExpiryDuration: cfg.Secrets.Jwt.AccessExpiryDuration,
 		FolderId:       "/",
 		Access:         "rw",
+		Kind:           TokenKindAccount,
 	}
 	refreshParams := &TokenParameters{
 		UserId:         creds.Username,
 		ExpiryDuration: cfg.Secrets.Jwt.RefreshExpiryDuration,
 		FolderId:       "/",
 		Access:         "rw",
+		Kind:           TokenKindAccount,
 	}
 
 	accessTokenString, refreshTokenString, err := GenerateTokens(accessParams, refreshParams)
@@ -119,11 +130,14 @@ func RefreshHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	kind, _ := claims["kind"].(string)
+
 	accessParams := &TokenParameters{
 		UserId:         userId,
 		ExpiryDuration: cfg.Secrets.Jwt.AccessExpiryDuration,
 		FolderId:       folderId,
 		Access:         access,
+		Kind:           kind,
 	}
 
 	accessTokenString, _, err := GenerateTokens(accessParams, accessParams)
@@ -157,7 +171,7 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func SharingGatewayHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func SharingGatewayHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, jm *job.JobManager) {
 
 	var creds SharingCredentials
 	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
@@ -190,12 +204,14 @@ func SharingGatewayHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		ExpiryDuration: 5 * time.Minute,
 		FolderId:       sharingUser.FolderId,
 		Access:         sharingUser.Access,
+		Kind:           TokenKindShare,
 	}
 	refreshParams := &TokenParameters{
 		UserId:         sharingUser.FolderName,
 		ExpiryDuration: expiryDuration,
 		FolderId:       sharingUser.FolderId,
 		Access:         sharingUser.Access,
+		Kind:           TokenKindShare,
 	}
 
 	accessTokenString, refreshTokenString, err := GenerateTokens(accessParams, refreshParams)
@@ -217,6 +233,8 @@ func SharingGatewayHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		})
 	}
 
+	jm.Publish(job.Event{Type: job.EventShareAccessed, FolderId: sharingUser.FolderId})
+
 	response := SharingTokenResponse{
 		AccessToken: accessTokenString,
 		FolderId:    sharingUser.FolderId,
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 
 	"file-server/config"
 
@@ -14,6 +15,36 @@ type contextKey string
 
 const ClaimsContextKey contextKey = "claims"
 
+// ApiTokenPrefix tells API tokens apart from JWTs in the Authorization header
+const ApiTokenPrefix = "hs_"
+
+// ApiTokenLookup resolves an API token to the claims of its scope.
+type ApiTokenLookup func(token string) (jwt.MapClaims, error)
+
+var (
+	apiTokenMu     sync.RWMutex
+	apiTokenLookup ApiTokenLookup
+)
+
+// SetApiTokenLookup lets AuthMiddleware accept API tokens. Without a lookup
+// (no database) they are refused.
+func SetApiTokenLookup(lookup ApiTokenLookup) {
+	apiTokenMu.Lock()
+	defer apiTokenMu.Unlock()
+	apiTokenLookup = lookup
+}
+
+func lookupApiToken(token string) (jwt.MapClaims, error) {
+	apiTokenMu.RLock()
+	lookup := apiTokenLookup
+	apiTokenMu.RUnlock()
+
+	if lookup == nil {
+		return nil, fmt.Errorf("api tokens are not enabled")
+	}
+	return lookup(token)
+}
+
 func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		cfg := config.LoadConfig()
@@ -30,6 +61,17 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		}
 		tokenStr := parts[1]
 
+		if strings.HasPrefix(tokenStr, ApiTokenPrefix) {
+			claims, err := lookupApiToken(tokenStr)
+			if err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			ctx := context.WithValue(r.Context(), ClaimsContextKey, claims)
+			next(w, r.WithContext(ctx))
+			return
+		}
+
 		token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
 			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
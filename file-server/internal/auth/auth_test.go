@@ -2,6 +2,7 @@ package auth
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -14,10 +15,12 @@ import (
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/golang-jwt/jwt"
+	jwtv5 "github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 
 	"file-server/config"
 	"file-server/internal/helpers"
+	"file-server/internal/job"
 )
 
 // Mock Database somehow
@@ -346,6 +349,7 @@ func TestRefreshHandler(t *testing.T) {
 			ExpiryDuration: cfg.Secrets.Jwt.RefreshExpiryDuration,
 			FolderId:       "/",
 			Access:         "rw",
+			Kind:           TokenKindAccount,
 		}
 		_, refreshToken, err := GenerateTokens(&refreshParams, &refreshParams)
 		if err != nil {
@@ -375,6 +379,10 @@ func TestRefreshHandler(t *testing.T) {
 		if respData.AccessToken == "" {
 			t.Error("access token not found in response body")
 		}
+		// The refreshed token is still an account's
+		if params, err := DecodeToken(respData.AccessToken, cfg.Secrets.Jwt.JwtSecret); err != nil || params.Kind != TokenKindAccount {
+			t.Errorf("Expected an account token, got %+v %v", params, err)
+		}
 
 		if err := validateToken(respData.AccessToken, "/", "rw"); err != nil {
 			t.Errorf("Unexpected error when validating access token: %v", err)
@@ -430,8 +438,10 @@ func TestSharingGatewayWrongArguments(t *testing.T) {
 	expiration := expirationDate.Format(time.RFC3339)
 	otpPass := "123456"
 	linkUrl := uuid.New().String()
-	expiryDuration := expirationDate.Sub(time.Now().UTC().Truncate(time.Second))
-	sharingFolderId := helpers.GenerateFolderName(expiryDuration, linkUrl)
+	sharingFolderId, err := helpers.GenerateFolderId()
+	if err != nil {
+		t.Fatalf("Received unexpected error when generating folder id: %v", err)
+	}
 	folderName := "someFolderName"
 	access := "rw"
 	salt, err := helpers.GenerateRandomSalt()
@@ -450,7 +460,7 @@ func TestSharingGatewayWrongArguments(t *testing.T) {
 		expectedResponse :=  "Forbidden: user not found" 
 		rows := sqlmock.NewRows([]string{"link_url", "folder_id", "folder_name", "salt", "otp_hash", "access", "expiration"})
 	
-		mock.ExpectQuery("SELECT link_url, folder_id, folder_name, salt, otp_hash, access, expiration FROM sharing_users WHERE link_url = \\$1").
+		mock.ExpectQuery("SELECT su.link_url, su.folder_id, su.folder_name, su.salt, su.otp_hash, su.access, s.expires_at FROM sharing_users su JOIN shares s ON s.folder_id = su.folder_id WHERE su.link_url = \\$1 AND s.deleted_at IS NULL").
 			WithArgs(wrongLinkUrl).
 			WillReturnRows(rows)
 
@@ -465,7 +475,7 @@ func TestSharingGatewayWrongArguments(t *testing.T) {
 		rr := httptest.NewRecorder()
 		req := httptest.NewRequest("POST", url, bytes.NewBuffer(body))
 
-		SharingGatewayHandler(rr, req, db)
+		SharingGatewayHandler(rr, req, db, job.NewJobManager(time.Minute))
 
 		if rr.Code != http.StatusForbidden {
 			t.Errorf("Expected status 403 Forbidden, got: %d", rr.Code)
@@ -483,7 +493,7 @@ func TestSharingGatewayWrongArguments(t *testing.T) {
 		rows := sqlmock.NewRows([]string{"link_url", "folder_id", "folder_name", "salt", "otp_hash", "access", "expiration"}).
 					AddRow(linkUrl, sharingFolderId, folderName, salt, wrongHash, access, expiration)
 	
-		mock.ExpectQuery("SELECT link_url, folder_id, folder_name, salt, otp_hash, access, expiration FROM sharing_users WHERE link_url = \\$1").
+		mock.ExpectQuery("SELECT su.link_url, su.folder_id, su.folder_name, su.salt, su.otp_hash, su.access, s.expires_at FROM sharing_users su JOIN shares s ON s.folder_id = su.folder_id WHERE su.link_url = \\$1 AND s.deleted_at IS NULL").
 			WithArgs(linkUrl).
 			WillReturnRows(rows)
 
@@ -498,7 +508,7 @@ func TestSharingGatewayWrongArguments(t *testing.T) {
 		rr := httptest.NewRecorder()
 		req := httptest.NewRequest("POST", url, bytes.NewBuffer(body))
 
-		SharingGatewayHandler(rr, req, db)
+		SharingGatewayHandler(rr, req, db, job.NewJobManager(time.Minute))
 
 		if rr.Code != http.StatusForbidden {
 			t.Errorf("Expected status 403 Forbidden, got: %d", rr.Code)
@@ -520,8 +530,10 @@ func TestSharingGatewayHandlerSucces(t *testing.T) {
 	expiration := expirationDate.Format(time.RFC3339)
 	otpPass := "123456"
 	linkUrl := uuid.New().String()
-	expiryDuration := expirationDate.Sub(time.Now().UTC().Truncate(time.Second))
-	sharingFolderId := helpers.GenerateFolderName(expiryDuration, linkUrl)
+	sharingFolderId, err := helpers.GenerateFolderId()
+	if err != nil {
+		t.Fatalf("Received unexpected error when generating folder id: %v", err)
+	}
 	folderName := "someFolderName"
 	access := "w"
 	salt, err := helpers.GenerateRandomSalt()
@@ -539,7 +551,7 @@ func TestSharingGatewayHandlerSucces(t *testing.T) {
 	rows := sqlmock.NewRows([]string{"link_url", "folder_id", "folder_name", "salt", "otp_hash", "access", "expiration"}).
 			AddRow(linkUrl, sharingFolderId, folderName, salt, hashedOtp, access, expiration)
 
-	mock.ExpectQuery("SELECT link_url, folder_id, folder_name, salt, otp_hash, access, expiration FROM sharing_users WHERE link_url = \\$1").
+	mock.ExpectQuery("SELECT su.link_url, su.folder_id, su.folder_name, su.salt, su.otp_hash, su.access, s.expires_at FROM sharing_users su JOIN shares s ON s.folder_id = su.folder_id WHERE su.link_url = \\$1 AND s.deleted_at IS NULL").
 		WithArgs(linkUrl).
 		WillReturnRows(rows)
 
@@ -554,7 +566,7 @@ func TestSharingGatewayHandlerSucces(t *testing.T) {
 	rr := httptest.NewRecorder()
 	req := httptest.NewRequest("POST", url, bytes.NewBuffer(body))
 
-	SharingGatewayHandler(rr, req, db)
+	SharingGatewayHandler(rr, req, db, job.NewJobManager(time.Minute))
 
 	if rr.Code != http.StatusOK {
 		t.Errorf("Expected status 200 OK, got: %d", rr.Code)
@@ -593,4 +605,370 @@ func TestSharingGatewayHandlerSucces(t *testing.T) {
 			t.Errorf("Unexpected error when validating refresh token: %v", err)
 		}
 	}
-}
\ No newline at end of file
+}
+func TestAppPasswords(t *testing.T) {
+	withClaims := func(req *http.Request, claims jwtv5.MapClaims) *http.Request {
+		return req.WithContext(context.WithValue(req.Context(), ClaimsContextKey, claims))
+	}
+	adminClaims := jwtv5.MapClaims{"user_id": "johndoe", "folder_id": "/", "access": "rw", "kind": TokenKindAccount}
+
+	t.Run("Create_And_Authenticate", func(t *testing.T) {
+		db, mock, err := initMockDb()
+		if err != nil {
+			t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+		}
+		defer db.Close()
+
+		mock.ExpectQuery("INSERT INTO app_passwords").
+			WithArgs(sqlmock.AnyArg(), "johndoe", "Laptop", sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+
+		body := bytes.NewBufferString(`{"name": " Laptop "}`)
+		req := withClaims(httptest.NewRequest(http.MethodPost, "/app-passwords", body), adminClaims)
+		rr := httptest.NewRecorder()
+
+		AppPasswordsHandler(rr, req, db)
+
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201 Created, got : %d", rr.Code)
+		}
+		var created AppPasswordResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+			t.Fatalf("error unmarshalling response body: %v", err)
+		}
+		if created.Id == "" || created.Name != "Laptop" || len(created.Password) != 32 {
+			t.Fatalf("Unexpected app password: %+v", created)
+		}
+
+		// The app password signs in, the account password still does too
+		salt, err := helpers.GenerateRandomSalt()
+		if err != nil {
+			t.Fatalf("Encountered unexpected error while generating salt: %v", err)
+		}
+		hash := helpers.HashPassword(created.Password, salt)
+		accountSalt, _ := helpers.GenerateRandomSalt()
+		accountHash := helpers.HashPassword("somepassword", accountSalt)
+
+		expectUser := func() {
+			mock.ExpectQuery("SELECT username, email, salt, password_hash, folder, access FROM users WHERE username = \\$1").
+				WithArgs("johndoe").
+				WillReturnRows(sqlmock.NewRows([]string{"username", "email", "salt", "password_hash", "folder", "access"}).
+					AddRow("johndoe", "johndoe@example.com", accountSalt, accountHash, "/", "rw"))
+		}
+		expectAppPasswords := func() {
+			mock.ExpectQuery("SELECT id, username, name, salt, password_hash, created_at FROM app_passwords").
+				WithArgs("johndoe").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "username", "name", "salt", "password_hash", "created_at"}).
+					AddRow(created.Id, "johndoe", "Laptop", salt, hash, time.Now()))
+		}
+
+		expectUser()
+		if _, err := AuthenticateAccount(db, "johndoe", "somepassword"); err != nil {
+			t.Errorf("Expected account password to authenticate, got: %v", err)
+		}
+		expectUser()
+		expectAppPasswords()
+		if _, err := AuthenticateAccount(db, "johndoe", created.Password); err != nil {
+			t.Errorf("Expected app password to authenticate, got: %v", err)
+		}
+		expectUser()
+		expectAppPasswords()
+		if _, err := AuthenticateAccount(db, "johndoe", "wrongpassword"); err == nil {
+			t.Error("Expected wrong password to be refused")
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Unmet sql expectations: %v", err)
+		}
+	})
+
+	t.Run("List_Hides_Hashes", func(t *testing.T) {
+		db, mock, err := initMockDb()
+		if err != nil {
+			t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+		}
+		defer db.Close()
+
+		mock.ExpectQuery("SELECT id, username, name, salt, password_hash, created_at FROM app_passwords").
+			WithArgs("johndoe").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "username", "name", "salt", "password_hash", "created_at"}).
+				AddRow("some-id", "johndoe", "Laptop", "somesalt", "somehash", time.Now()))
+
+		req := withClaims(httptest.NewRequest(http.MethodGet, "/app-passwords", nil), adminClaims)
+		rr := httptest.NewRecorder()
+
+		AppPasswordsHandler(rr, req, db)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 OK, got : %d", rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), "Laptop") || strings.Contains(rr.Body.String(), "somehash") || strings.Contains(rr.Body.String(), "somesalt") {
+			t.Errorf("Unexpected listing: %s", rr.Body.String())
+		}
+	})
+
+	t.Run("Sharing_Token_Forbidden", func(t *testing.T) {
+		db, _, err := initMockDb()
+		if err != nil {
+			t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+		}
+		defer db.Close()
+
+		// A share whose folder name is a username is still a share
+		for _, claims := range []jwtv5.MapClaims{
+			{"user_id": "link", "folder_id": "some-folder", "access": "rw"},
+			{"user_id": "johndoe", "folder_id": "some-folder", "access": "rw", "kind": TokenKindShare},
+		} {
+			req := withClaims(httptest.NewRequest(http.MethodGet, "/app-passwords", nil), claims)
+			rr := httptest.NewRecorder()
+
+			AppPasswordsHandler(rr, req, db)
+
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("Expected status 403 Forbidden for %v, got : %d", claims, rr.Code)
+			}
+		}
+	})
+
+	t.Run("Non_Admin_Account", func(t *testing.T) {
+		db, mock, err := initMockDb()
+		if err != nil {
+			t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+		}
+		defer db.Close()
+
+		mock.ExpectQuery("SELECT id, username, name, salt, password_hash, created_at FROM app_passwords").
+			WithArgs("janedoe").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "username", "name", "salt", "password_hash", "created_at"}))
+
+		claims := jwtv5.MapClaims{"user_id": "janedoe", "folder_id": "some-folder", "access": "r", "kind": TokenKindAccount}
+		req := withClaims(httptest.NewRequest(http.MethodGet, "/app-passwords", nil), claims)
+		rr := httptest.NewRecorder()
+
+		AppPasswordsHandler(rr, req, db)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("Expected status 200 OK, got : %d", rr.Code)
+		}
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		db, mock, err := initMockDb()
+		if err != nil {
+			t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+		}
+		defer db.Close()
+
+		mock.ExpectExec("DELETE FROM app_passwords WHERE id = \\$1 AND username = \\$2").
+			WithArgs("some-id", "johndoe").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("DELETE FROM app_passwords WHERE id = \\$1 AND username = \\$2").
+			WithArgs("other-id", "johndoe").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		revokes := []struct {
+			id       string
+			expected int
+		}{
+			{"some-id", http.StatusNoContent},
+			{"other-id", http.StatusNotFound},
+		}
+		for _, revoke := range revokes {
+			body := bytes.NewBufferString(fmt.Sprintf(`{"id": %q}`, revoke.id))
+			req := withClaims(httptest.NewRequest(http.MethodPost, "/app-password-revoke", body), adminClaims)
+			rr := httptest.NewRecorder()
+
+			AppPasswordRevokeHandler(rr, req, db)
+
+			if rr.Code != revoke.expected {
+				t.Errorf("Expected status %d for %s, got : %d", revoke.expected, revoke.id, rr.Code)
+			}
+		}
+	})
+}
+
+func TestApiTokens(t *testing.T) {
+	withClaims := func(req *http.Request, claims jwtv5.MapClaims) *http.Request {
+		return req.WithContext(context.WithValue(req.Context(), ClaimsContextKey, claims))
+	}
+	adminClaims := jwtv5.MapClaims{"user_id": "johndoe", "folder_id": "/", "access": "rw", "kind": TokenKindAccount}
+	tokenColumns := []string{"id", "username", "name", "folder", "access", "token_hash", "expires_at", "last_used_at", "created_at"}
+
+	t.Run("Create_Invalid_Scope", func(t *testing.T) {
+		db, mock, err := initMockDb()
+		if err != nil {
+			t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+		}
+		defer db.Close()
+
+		mock.ExpectQuery("SELECT folder_id, folder_name, created_at, expires_at, deleted_at, strip_metadata FROM shares WHERE folder_id = \\$1").
+			WithArgs("missing-folder").
+			WillReturnError(sql.ErrNoRows)
+
+		bodies := map[string]int{
+			`{"name": "Backup", "folder_id": "/", "access": "x"}`:                                        http.StatusBadRequest,
+			`{"name": "Backup", "access": "rw"}`:                                                         http.StatusBadRequest,
+			`{"name": "Backup", "folder_id": "/", "access": "rw", "expires_at": "2000-01-01T00:00:00Z"}`: http.StatusBadRequest,
+			`{"name": "Backup", "folder_id": "missing-folder", "access": "rw"}`:                          http.StatusNotFound,
+		}
+		for body, expected := range bodies {
+			req := withClaims(httptest.NewRequest(http.MethodPost, "/api-tokens", bytes.NewBufferString(body)), adminClaims)
+			rr := httptest.NewRecorder()
+
+			ApiTokensHandler(rr, req, db)
+
+			if rr.Code != expected {
+				t.Errorf("Expected status %d for %s, got : %d", expected, body, rr.Code)
+			}
+		}
+	})
+
+	t.Run("Create_Beyond_Own_Scope", func(t *testing.T) {
+		db, mock, err := initMockDb()
+		if err != nil {
+			t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+		}
+		defer db.Close()
+
+		mock.ExpectQuery("SELECT folder_id, folder_name, created_at, expires_at, deleted_at, strip_metadata FROM shares WHERE folder_id = \\$1").
+			WithArgs("some-folder").
+			WillReturnRows(sqlmock.NewRows([]string{"folder_id", "folder_name", "created_at", "expires_at", "deleted_at", "strip_metadata"}).
+				AddRow("some-folder", "Some Folder", time.Now(), time.Now().Add(time.Hour), nil, false))
+		mock.ExpectQuery("INSERT INTO api_tokens").
+			WithArgs(sqlmock.AnyArg(), "janedoe", "Backup", "some-folder", "r", sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+
+		claims := jwtv5.MapClaims{"user_id": "janedoe", "folder_id": "some-folder", "access": "r", "kind": TokenKindAccount}
+		bodies := map[string]int{
+			`{"name": "Backup", "folder_id": "/", "access": "r"}`:            http.StatusForbidden,
+			`{"name": "Backup", "folder_id": "other-folder", "access": "r"}`: http.StatusForbidden,
+			`{"name": "Backup", "folder_id": "some-folder", "access": "rw"}`: http.StatusForbidden,
+			`{"name": "Backup", "folder_id": "some-folder", "access": "r"}`:  http.StatusCreated,
+		}
+		for body, expected := range bodies {
+			req := withClaims(httptest.NewRequest(http.MethodPost, "/api-tokens", bytes.NewBufferString(body)), claims)
+			rr := httptest.NewRecorder()
+
+			ApiTokensHandler(rr, req, db)
+
+			if rr.Code != expected {
+				t.Errorf("Expected status %d for %s, got : %d", expected, body, rr.Code)
+			}
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Unmet sql expectations: %v", err)
+		}
+	})
+
+	t.Run("Create_And_Authenticate", func(t *testing.T) {
+		db, mock, err := initMockDb()
+		if err != nil {
+			t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+		}
+		defer db.Close()
+
+		expiresAt := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+		mock.ExpectQuery("INSERT INTO api_tokens").
+			WithArgs(sqlmock.AnyArg(), "johndoe", "Backup", "/", "r", sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+
+		body := bytes.NewBufferString(fmt.Sprintf(`{"name": "Backup", "folder_id": "/", "access": "r", "expires_at": %q}`, expiresAt.Format(time.RFC3339)))
+		req := withClaims(httptest.NewRequest(http.MethodPost, "/api-tokens", body), adminClaims)
+		rr := httptest.NewRecorder()
+
+		ApiTokensHandler(rr, req, db)
+
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201 Created, got : %d", rr.Code)
+		}
+		var created ApiTokenResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+			t.Fatalf("error unmarshalling response body: %v", err)
+		}
+		if !strings.HasPrefix(created.Token, ApiTokenPrefix) || created.ExpiresAt == nil || !created.ExpiresAt.Equal(expiresAt) {
+			t.Fatalf("Unexpected API token: %+v", created)
+		}
+
+		// The middleware accepts the token with its scope
+		SetApiTokenLookup(func(token string) (jwtv5.MapClaims, error) {
+			return ApiTokenClaims(db, token)
+		})
+		defer SetApiTokenLookup(nil)
+
+		mock.ExpectQuery("SELECT id, username, name, folder, access, token_hash, expires_at, last_used_at, created_at FROM api_tokens").
+			WithArgs(helpers.HashToken(created.Token)).
+			WillReturnRows(sqlmock.NewRows(tokenColumns).
+				AddRow(created.Id, "johndoe", "Backup", "/", "r", helpers.HashToken(created.Token), expiresAt, nil, time.Now()))
+		mock.ExpectExec("UPDATE api_tokens SET last_used_at = NOW\\(\\)").
+			WithArgs(created.Id).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		var claims jwtv5.MapClaims
+		handler := AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+			claims, _ = r.Context().Value(ClaimsContextKey).(jwtv5.MapClaims)
+			AppPasswordsHandler(w, r, db)
+		})
+		req = httptest.NewRequest(http.MethodGet, "/app-passwords", nil)
+		req.Header.Set("Authorization", "Bearer "+created.Token)
+		rr = httptest.NewRecorder()
+		handler(rr, req)
+
+		if claims["folder_id"] != "/" || claims["access"] != "r" || claims["token_id"] != created.Id {
+			t.Errorf("Unexpected claims: %v", claims)
+		}
+		// API tokens can't manage credentials
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 Forbidden, got : %d", rr.Code)
+		}
+
+		// Unknown or expired tokens are refused
+		mock.ExpectQuery("SELECT id, username, name, folder, access, token_hash, expires_at, last_used_at, created_at FROM api_tokens").
+			WithArgs(helpers.HashToken(ApiTokenPrefix + "unknown")).
+			WillReturnError(sql.ErrNoRows)
+		req = httptest.NewRequest(http.MethodGet, "/app-passwords", nil)
+		req.Header.Set("Authorization", "Bearer "+ApiTokenPrefix+"unknown")
+		rr = httptest.NewRecorder()
+		handler(rr, req)
+
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401 Unauthorized, got : %d", rr.Code)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Unmet sql expectations: %v", err)
+		}
+	})
+
+	t.Run("Disabled_Without_Lookup", func(t *testing.T) {
+		handler := AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		req := httptest.NewRequest(http.MethodGet, "/search", nil)
+		req.Header.Set("Authorization", "Bearer "+ApiTokenPrefix+"sometoken")
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401 Unauthorized, got : %d", rr.Code)
+		}
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		db, mock, err := initMockDb()
+		if err != nil {
+			t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+		}
+		defer db.Close()
+
+		mock.ExpectExec("DELETE FROM api_tokens WHERE id = \\$1 AND username = \\$2").
+			WithArgs("some-id", "johndoe").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		req := withClaims(httptest.NewRequest(http.MethodPost, "/api-token-revoke", bytes.NewBufferString(`{"id": "some-id"}`)), adminClaims)
+		rr := httptest.NewRecorder()
+
+		ApiTokenRevokeHandler(rr, req, db)
+
+		if rr.Code != http.StatusNoContent {
+			t.Errorf("Expected status 204 No Content, got : %d", rr.Code)
+		}
+	})
+}
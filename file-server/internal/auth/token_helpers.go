@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"log"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -21,6 +22,7 @@ func GenerateTokens(accessParams, refreshParams *TokenParameters) (string, strin
 		"user_id":   accessParams.UserId,
 		"folder_id": accessParams.FolderId,
 		"access":    accessParams.Access,
+		"kind":      accessParams.Kind,
 		"exp":       time.Now().Add(accessParams.ExpiryDuration).Unix(),
 	}
 	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
@@ -33,6 +35,7 @@ func GenerateTokens(accessParams, refreshParams *TokenParameters) (string, strin
 		"user_id":   refreshParams.UserId,
 		"folder_id": refreshParams.FolderId,
 		"access":    refreshParams.Access,
+		"kind":      refreshParams.Kind,
 		"exp":       time.Now().Add(refreshParams.ExpiryDuration).Unix(),
 	}
 	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
@@ -74,6 +77,10 @@ func DecodeToken(tokenStr string, secret string) (TokenParameters, error) {
 		params.Access = access
 	}
 
+	if kind, ok := claims["kind"].(string); ok {
+		params.Kind = kind
+	}
+
 	if exp, ok := claims["exp"].(float64); ok {
 		expTime := time.Unix(int64(exp), 0)
 		params.ExpiryDuration = time.Until(expTime)
@@ -96,6 +103,48 @@ func Authenticate(db *sql.DB, creds Credentials) (*models.User, error) {
 	return user, nil
 }
 
+// AuthenticateAccount checks a username with either the account password or
+// one of the user's app passwords, as non browser clients (WebDAV) send.
+func AuthenticateAccount(db *sql.DB, username string, password string) (*models.User, error) {
+	user, err := repositories.GetUserByUsername(db, username)
+	if err != nil {
+		return nil, err
+	}
+	if helpers.HashPassword(password, user.Salt) == user.PasswordHash {
+		return user, nil
+	}
+
+	appPasswords, err := repositories.ListAppPasswords(db, username)
+	if err != nil {
+		return nil, err
+	}
+	for _, appPassword := range appPasswords {
+		if helpers.HashPassword(password, appPassword.Salt) == appPassword.PasswordHash {
+			return user, nil
+		}
+	}
+	return nil, errors.New("invalid credentials")
+}
+
+// ApiTokenClaims returns the claims an API token grants, the same ones a
+// login token has, scoped to the token's folder and access.
+func ApiTokenClaims(db *sql.DB, token string) (jwt.MapClaims, error) {
+	apiToken, err := repositories.GetApiToken(db, token)
+	if err != nil {
+		return nil, err
+	}
+	if err := repositories.TouchApiToken(db, apiToken.Id); err != nil {
+		log.Printf("[FILE-SERVER] Error recording use of API token %s: %v", apiToken.Id, err)
+	}
+
+	return jwt.MapClaims{
+		"user_id":   apiToken.Username,
+		"folder_id": apiToken.FolderId,
+		"access":    apiToken.Access,
+		"token_id":  apiToken.Id,
+	}, nil
+}
+
 func AuthenticateSharing(db *sql.DB, creds SharingCredentials) (*models.SharingUser, error) {
 	sharingUser, err := repositories.GetSharingUser(db, creds.LinkUrl)
 	if err != nil {
@@ -136,3 +185,16 @@ func HasAccess(claims jwt.MapClaims, folderID, requiredAccess string) (bool, err
 
 	return false, nil
 }
+
+// ClientId returns whom the transfers of a request are counted against, for
+// rates and in-flight limits. Every visitor of a share link holds the same
+// share, and its user_id is only the folder name, so share tokens count
+// against the folder id instead.
+func ClientId(claims jwt.MapClaims) string {
+	if kind, _ := claims["kind"].(string); kind == TokenKindShare {
+		folderId, _ := claims["folder_id"].(string)
+		return folderId
+	}
+	userId, _ := claims["user_id"].(string)
+	return userId
+}
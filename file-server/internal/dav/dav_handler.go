@@ -0,0 +1,134 @@
+package dav
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/net/webdav"
+
+	"file-server/config"
+	"file-server/internal/auth"
+	"file-server/internal/files"
+	"file-server/internal/helpers"
+	"file-server/internal/job"
+	"file-server/internal/uploader"
+)
+
+// Prefix is where the WebDAV share is mounted, e.g. https://mydomain.com/dav/
+const Prefix = "/dav"
+
+const realm = `Basic realm="HomeShare", charset="UTF-8"`
+
+var (
+	lockSystemsMu sync.Mutex
+	lockSystems   = map[string]webdav.LockSystem{} // Per storage root, lock names are relative to it
+)
+
+// Methods that only need read access. Everything else changes the folder.
+var readMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	"PROPFIND":         true,
+}
+
+// Methods that change the files of the folder, rather than locks or properties
+var contentMethods = map[string]bool{
+	http.MethodPut:    true,
+	http.MethodDelete: true,
+	"MKCOL":           true,
+	"COPY":            true,
+	"MOVE":            true,
+}
+
+// WebDAVHandler serves a user's folder over WebDAV so it can be mounted as a
+// network drive. Clients sign in with Basic auth, using the account password
+// or an app password. Users with "/" get the upload library, other users the
+// sharing folder they are assigned to, with their access ("r", "w", "rw").
+func WebDAVHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, jm *job.JobManager) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", realm)
+		http.Error(w, "Missing credentials", http.StatusUnauthorized)
+		return
+	}
+	user, err := auth.AuthenticateAccount(db, username, password)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", realm)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	root, _, err := files.ResolveFolder(user.FolderId)
+	if err != nil {
+		http.Error(w, "Folder not found", http.StatusNotFound)
+		return
+	}
+
+	required := "w"
+	if readMethods[r.Method] {
+		required = "r"
+	}
+	claims := jwt.MapClaims{"folder_id": user.FolderId, "access": user.Access}
+	if canAccess, err := auth.HasAccess(claims, user.FolderId, required); err != nil || !canAccess {
+		http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+		return
+	}
+
+	// Uploads over WebDAV are held to the same size cap and rates as the others
+	cfg := config.LoadConfig()
+	var body *uploadBody
+	if r.Method == http.MethodPut {
+		if cfg.UploadMaxFileSize > 0 {
+			if r.ContentLength > cfg.UploadMaxFileSize {
+				http.Error(w, fmt.Sprintf("File exceeds the maximum size of %d bytes", cfg.UploadMaxFileSize), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, cfg.UploadMaxFileSize)
+		}
+		release, ok := uploader.AcquireUploadSlot(r, root, user.Username)
+		if !ok {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many uploads in flight, retry later", http.StatusTooManyRequests)
+			return
+		}
+		defer release()
+		body = &uploadBody{ReadCloser: r.Body}
+		r.Body = body
+	}
+
+	handler := &webdav.Handler{
+		Prefix:     Prefix,
+		FileSystem: fileSystem{root: root, jm: jm, body: body},
+		LockSystem: lockSystem(root),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				log.Printf("[FILE-SERVER] WebDAV %s %s failed for %s: %v", r.Method, r.URL.Path, username, err)
+			}
+		},
+	}
+	handler.ServeHTTP(w, r)
+
+	// Keep the zip of a sharing folder in line with its content
+	if contentMethods[r.Method] && root != cfg.UploadDir {
+		jm.Go(func() {
+			helpers.RefreshShareZip(root, jm)
+		})
+	}
+}
+
+func lockSystem(root string) webdav.LockSystem {
+	lockSystemsMu.Lock()
+	defer lockSystemsMu.Unlock()
+
+	ls, ok := lockSystems[root]
+	if !ok {
+		ls = webdav.NewMemLS()
+		lockSystems[root] = ls
+	}
+	return ls
+}
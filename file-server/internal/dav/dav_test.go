@@ -0,0 +1,266 @@
+package dav
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"file-server/config"
+	"file-server/internal/files"
+	"file-server/internal/helpers"
+	"file-server/internal/job"
+	"file-server/internal/uploader"
+)
+
+// --------------------------------------
+//
+//	Suite Setup - Cleanup
+//
+// --------------------------------------
+func TestMain(m *testing.M) {
+	cfg := config.LoadConfig()
+	if err := os.MkdirAll(cfg.SharingDir, os.ModePerm); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create sharing directory %q: %v\n", cfg.SharingDir, err)
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(cfg.UploadDir, os.ModePerm); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create upload directory %q: %v\n", cfg.UploadDir, err)
+		os.Exit(1)
+	}
+
+	exitCode := m.Run()
+
+	if err := os.RemoveAll(cfg.SharingDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to remove sharing directory %q: %v\n", cfg.SharingDir, err)
+	}
+	if err := os.RemoveAll(cfg.UploadDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to remove upload directory %q: %v\n", cfg.UploadDir, err)
+	}
+	if err := os.RemoveAll("secrets"); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to remove secrets directory %q: %v\n", "secrets", err)
+	}
+
+	os.Exit(exitCode)
+}
+
+// davServer serves WebDAV for a single user, authenticated with password
+func davServer(t *testing.T, folderId string, access string, password string) *httptest.Server {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+	}
+	salt, err := helpers.GenerateRandomSalt()
+	if err != nil {
+		t.Fatalf("Encountered unexpected error while generating salt: %v", err)
+	}
+	hash := helpers.HashPassword(password, salt)
+	mock.MatchExpectationsInOrder(false)
+	for i := 0; i < 20; i++ {
+		mock.ExpectQuery("SELECT username, email, salt, password_hash, folder, access FROM users WHERE username = \\$1").
+			WithArgs("johndoe").
+			WillReturnRows(sqlmock.NewRows([]string{"username", "email", "salt", "password_hash", "folder", "access"}).
+				AddRow("johndoe", "johndoe@example.com", salt, hash, folderId, access))
+	}
+
+	jm := job.NewJobManager(30 * time.Minute)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WebDAVHandler(w, r, db, jm)
+	}))
+	t.Cleanup(func() {
+		ts.Close()
+		jm.Close()
+		db.Close()
+	})
+	return ts
+}
+
+func davRequest(t *testing.T, ts *httptest.Server, method string, path string, password string, body string, headers map[string]string) *http.Response {
+	req, err := http.NewRequest(method, ts.URL+Prefix+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to create %s request: %v", method, err)
+	}
+	req.SetBasicAuth("johndoe", password)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to perform %s %s: %v", method, path, err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	return res
+}
+
+func TestWebDAV(t *testing.T) {
+	cfg := config.LoadConfig()
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		ts := davServer(t, "/", "rw", "somepassword")
+
+		res := davRequest(t, ts, "PROPFIND", "/", "wrongpassword", "", map[string]string{"Depth": "1"})
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected status 401 Unauthorized, got : %d", res.StatusCode)
+		}
+		if res.Header.Get("WWW-Authenticate") == "" {
+			t.Error("Expected a WWW-Authenticate challenge")
+		}
+	})
+
+	t.Run("Library_Read_Write", func(t *testing.T) {
+		ts := davServer(t, "/", "rw", "somepassword")
+		if err := os.MkdirAll(filepath.Join(cfg.UploadDir, files.TrashDir), os.ModePerm); err != nil {
+			t.Fatalf("failed to create trash: %v", err)
+		}
+
+		res := davRequest(t, ts, "MKCOL", "/Docs", "somepassword", "", nil)
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected MKCOL status 201, got : %d", res.StatusCode)
+		}
+		res = davRequest(t, ts, http.MethodPut, "/Docs/note.txt", "somepassword", "hello", nil)
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected PUT status 201, got : %d", res.StatusCode)
+		}
+
+		// A hardlinked copy keeps its content when the file is overwritten
+		notePath := filepath.Join(cfg.UploadDir, "Docs", "note.txt")
+		linkPath := filepath.Join(cfg.UploadDir, "linked.txt")
+		if err := os.Link(notePath, linkPath); err != nil {
+			t.Fatalf("failed to link file: %v", err)
+		}
+		res = davRequest(t, ts, http.MethodPut, "/Docs/note.txt", "somepassword", "hello again", nil)
+		if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected PUT overwrite to succeed, got : %d", res.StatusCode)
+		}
+		if content, _ := os.ReadFile(linkPath); string(content) != "hello" {
+			t.Errorf("Expected hardlinked file to be untouched, got %q", content)
+		}
+
+		res = davRequest(t, ts, http.MethodGet, "/Docs/note.txt", "somepassword", "", nil)
+		if content, _ := io.ReadAll(res.Body); res.StatusCode != http.StatusOK || string(content) != "hello again" {
+			t.Errorf("Expected GET to return the new content, got %d %q", res.StatusCode, content)
+		}
+
+		res = davRequest(t, ts, "PROPFIND", "/", "somepassword", "", map[string]string{"Depth": "1"})
+		listing, _ := io.ReadAll(res.Body)
+		if res.StatusCode != http.StatusMultiStatus {
+			t.Fatalf("Expected PROPFIND status 207, got : %d", res.StatusCode)
+		}
+		if !strings.Contains(string(listing), "/Docs/") {
+			t.Errorf("Expected listing to contain Docs, got %s", listing)
+		}
+		if strings.Contains(string(listing), files.TrashDir) {
+			t.Errorf("Expected listing to hide the trash, got %s", listing)
+		}
+
+		res = davRequest(t, ts, http.MethodGet, "/"+files.TrashDir+"/", "somepassword", "", nil)
+		if res.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected trash to be unreachable, got : %d", res.StatusCode)
+		}
+
+		res = davRequest(t, ts, "MOVE", "/Docs/note.txt", "somepassword", "", map[string]string{"Destination": ts.URL + Prefix + "/Docs/moved.txt"})
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected MOVE status 201, got : %d", res.StatusCode)
+		}
+
+		res = davRequest(t, ts, http.MethodDelete, "/Docs/moved.txt", "somepassword", "", nil)
+		if res.StatusCode != http.StatusNoContent {
+			t.Fatalf("Expected DELETE status 204, got : %d", res.StatusCode)
+		}
+		if _, err := os.Stat(filepath.Join(cfg.UploadDir, "Docs", "moved.txt")); !os.IsNotExist(err) {
+			t.Error("Expected deleted file to be gone")
+		}
+		trashed, err := files.ListTrash(cfg.UploadDir)
+		if err != nil || len(trashed) != 1 {
+			t.Errorf("Expected deleted file in the trash, got %v, %v", trashed, err)
+		}
+	})
+
+	t.Run("Uploads_Checked", func(t *testing.T) {
+		ts := davServer(t, "/", "rw", "somepassword")
+
+		var hooked []string
+		uploader.SetAssemblyHooks(func(folderPath string, filePath string) (string, error) {
+			hooked = append(hooked, filePath)
+			return filePath, nil
+		})
+		defer uploader.SetAssemblyHooks()
+
+		res := davRequest(t, ts, http.MethodPut, "/checked.txt", "somepassword", "hello", nil)
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected PUT status 201, got : %d", res.StatusCode)
+		}
+		if len(hooked) != 1 || hooked[0] != filepath.Join(cfg.UploadDir, "checked.txt") {
+			t.Errorf("Expected the file to go through the assembly hooks, got %v", hooked)
+		}
+
+		for _, name := range []string{"/script.exe", "/bad;name.txt"} {
+			res = davRequest(t, ts, http.MethodPut, name, "somepassword", "hello", nil)
+			if res.StatusCode < 400 {
+				t.Errorf("Expected PUT of %s to fail, got : %d", name, res.StatusCode)
+			}
+			if _, err := os.Stat(filepath.Join(cfg.UploadDir, name)); !os.IsNotExist(err) {
+				t.Errorf("Expected %s not to be written", name)
+			}
+		}
+		res = davRequest(t, ts, "MOVE", "/checked.txt", "somepassword", "", map[string]string{"Destination": ts.URL + Prefix + "/checked.exe"})
+		if res.StatusCode < 400 {
+			t.Errorf("Expected MOVE to an invalid name to fail, got : %d", res.StatusCode)
+		}
+
+		t.Setenv("UPLOAD_MAX_FILE_SIZE", "8")
+		res = davRequest(t, ts, http.MethodPut, "/big.txt", "somepassword", "more than eight bytes", nil)
+		if res.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected PUT status 413, got : %d", res.StatusCode)
+		}
+
+		// Without a length the body is cut off at the cap, the file must not be committed
+		req, err := http.NewRequest(http.MethodPut, ts.URL+Prefix+"/big.txt", io.MultiReader(strings.NewReader("more than eight bytes")))
+		if err != nil {
+			t.Fatalf("failed to create PUT request: %v", err)
+		}
+		req.SetBasicAuth("johndoe", "somepassword")
+		chunked, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to perform PUT: %v", err)
+		}
+		chunked.Body.Close()
+		if chunked.StatusCode < 400 {
+			t.Errorf("Expected PUT over the size cap to fail, got : %d", chunked.StatusCode)
+		}
+		if _, err := os.Stat(filepath.Join(cfg.UploadDir, "big.txt")); !os.IsNotExist(err) {
+			t.Error("Expected the cut off file not to be committed")
+		}
+	})
+
+	t.Run("Sharing_Folder_Read_Only", func(t *testing.T) {
+		folderId := "dav-share"
+		if err := os.MkdirAll(filepath.Join(cfg.SharingDir, folderId), os.ModePerm); err != nil {
+			t.Fatalf("failed to create sharing folder: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(cfg.SharingDir, folderId, "photo.jpg"), []byte("photo"), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		ts := davServer(t, folderId, "r", "somepassword")
+
+		res := davRequest(t, ts, http.MethodGet, "/photo.jpg", "somepassword", "", nil)
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("Expected GET status 200, got : %d", res.StatusCode)
+		}
+		res = davRequest(t, ts, http.MethodPut, "/other.jpg", "somepassword", "other", nil)
+		if res.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected PUT status 403, got : %d", res.StatusCode)
+		}
+		res = davRequest(t, ts, "LOCK", "/photo.jpg", "somepassword", "", nil)
+		if res.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected LOCK status 403, got : %d", res.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,255 @@
+package dav
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"golang.org/x/net/webdav"
+
+	"file-server/config"
+	"file-server/internal/dedup"
+	"file-server/internal/files"
+	"file-server/internal/helpers"
+	"file-server/internal/job"
+	"file-server/internal/media"
+	"file-server/internal/uploader"
+)
+
+const tempPrefix = ".dav-"
+
+// fileSystem serves a storage root over WebDAV. It keeps clients out of the
+// internal directories, sends deletes to the trash and never writes to a file
+// in place: stored files may be hardlinks shared with other paths (dedup,
+// attached share files), so writes go to a new file renamed over the old one.
+// Written files are committed like uploads, through the assembly hooks.
+type fileSystem struct {
+	root string
+	jm   *job.JobManager
+	body *uploadBody // Body of the request when it is a PUT
+}
+
+func (fsys fileSystem) resolve(name string) (string, error) {
+	if strings.Trim(path.Clean("/"+name), "/") == "" {
+		return fsys.root, nil
+	}
+	resolved, err := files.SafeJoin(fsys.root, name)
+	if err != nil {
+		return "", os.ErrNotExist
+	}
+	return resolved, nil
+}
+
+func (fsys fileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	dirPath, err := fsys.resolve(name)
+	if err != nil {
+		return err
+	}
+	if err := helpers.ValidateFolderName(filepath.Base(dirPath)); err != nil {
+		return err
+	}
+	return os.Mkdir(dirPath, perm)
+}
+
+func (fsys fileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	filePath, err := fsys.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		file, err := os.OpenFile(filePath, flag, perm)
+		if err != nil {
+			return nil, err
+		}
+		return &readFile{File: file, atRoot: filePath == fsys.root}, nil
+	}
+	if err := validateFileName(filePath); err != nil {
+		return nil, err
+	}
+	return fsys.openReplacement(filePath, flag, perm)
+}
+
+func (fsys fileSystem) RemoveAll(ctx context.Context, name string) error {
+	filePath, err := fsys.resolve(name)
+	if err != nil {
+		return err
+	}
+	if filePath == fsys.root {
+		return os.ErrInvalid
+	}
+
+	rel, err := filepath.Rel(fsys.root, filePath)
+	if err != nil {
+		return err
+	}
+	_, err = files.MoveToTrash(fsys.root, rel)
+	return err
+}
+
+func (fsys fileSystem) Rename(ctx context.Context, oldName string, newName string) error {
+	oldPath, err := fsys.resolve(oldName)
+	if err != nil {
+		return err
+	}
+	newPath, err := fsys.resolve(newName)
+	if err != nil {
+		return err
+	}
+	if oldPath == fsys.root || newPath == fsys.root {
+		return os.ErrInvalid
+	}
+
+	info, err := os.Stat(oldPath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		err = helpers.ValidateFolderName(filepath.Base(newPath))
+	} else {
+		err = validateFileName(newPath)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+func (fsys fileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	filePath, err := fsys.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(filePath)
+}
+
+// readFile hides the internal directories from listings of the root.
+type readFile struct {
+	*os.File
+	atRoot bool
+}
+
+func (f *readFile) Readdir(count int) ([]fs.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	visible := infos[:0]
+	for _, info := range infos {
+		if !hidden(info.Name(), f.atRoot) {
+			visible = append(visible, info)
+		}
+	}
+	return visible, err
+}
+
+// validateFileName checks the name of a file written over WebDAV against the
+// formats accepted for uploads.
+func validateFileName(filePath string) error {
+	fileName := filepath.Base(filePath)
+	extension := filepath.Ext(fileName)
+	return helpers.ValidateFileName(strings.TrimSuffix(fileName, extension), extension)
+}
+
+func hidden(name string, atRoot bool) bool {
+	cfg := config.LoadConfig()
+
+	if strings.HasPrefix(name, tempPrefix) {
+		return true
+	}
+	return atRoot && (name == files.TrashDir || name == cfg.ChunksDir || name == dedup.CasDir || name == media.ThumbnailDir)
+}
+
+// replacementFile is written under a temporary name next to its target and
+// committed over it on Close.
+type replacementFile struct {
+	*os.File
+	fsys   fileSystem
+	target string
+}
+
+func (fsys fileSystem) openReplacement(filePath string, flag int, perm os.FileMode) (webdav.File, error) {
+	info, statErr := os.Stat(filePath)
+	switch {
+	case statErr == nil && info.IsDir():
+		return nil, os.ErrInvalid
+	case statErr == nil && flag&os.O_EXCL != 0:
+		return nil, os.ErrExist
+	case os.IsNotExist(statErr) && flag&os.O_CREATE == 0:
+		return nil, statErr
+	case statErr != nil && !os.IsNotExist(statErr):
+		return nil, statErr
+	}
+	if statErr == nil {
+		perm = info.Mode().Perm()
+	}
+
+	tempPath := filepath.Join(filepath.Dir(filePath), tempPrefix+uuid.New().String())
+	temp, err := os.OpenFile(tempPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	// Partial updates start from the current content
+	if statErr == nil && flag&os.O_TRUNC == 0 {
+		if err := copyContent(temp, filePath); err != nil {
+			temp.Close()
+			os.Remove(tempPath)
+			return nil, err
+		}
+	}
+	return &replacementFile{File: temp, fsys: fsys, target: filePath}, nil
+}
+
+func copyContent(temp *os.File, filePath string) error {
+	source, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	if _, err := io.Copy(temp, source); err != nil {
+		return err
+	}
+	_, err = temp.Seek(0, io.SeekStart)
+	return err
+}
+
+// Close commits the file, unless the body it was written from was cut short
+// or went over the upload size cap.
+func (f *replacementFile) Close() error {
+	tempPath := f.File.Name()
+	err := f.File.Sync()
+	if closeErr := f.File.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && f.fsys.body != nil {
+		err = f.fsys.body.err
+	}
+	if err == nil {
+		_, err = uploader.ReplaceUpload(f.fsys.jm, f.fsys.root, f.target, tempPath)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return errors.Join(errors.New("error saving file"), err)
+	}
+	return nil
+}
+
+// uploadBody remembers why reading a PUT body failed. The webdav package
+// closes the file it was copied to either way.
+type uploadBody struct {
+	io.ReadCloser
+	err error
+}
+
+func (b *uploadBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
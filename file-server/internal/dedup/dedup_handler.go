@@ -0,0 +1,41 @@
+package dedup
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"file-server/internal/auth"
+)
+
+// ReportHandler returns how much space deduplication is saving.
+func ReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check if user is admin (ie has rw access to root)
+	claimsRaw := r.Context().Value(auth.ClaimsContextKey)
+	claims, ok := claimsRaw.(jwt.MapClaims)
+	if !ok {
+		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		return
+	}
+
+	canAccess, err := auth.HasAccess(claims, "/", "rw")
+	if err != nil || !canAccess {
+		http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+		return
+	}
+
+	report, err := BuildReport()
+	if err != nil {
+		http.Error(w, "Error while reading blob store", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
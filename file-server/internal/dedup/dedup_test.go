@@ -0,0 +1,167 @@
+package dedup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"file-server/config"
+	"file-server/internal/auth"
+)
+
+// --------------------------------------
+// 			 Helper Functions
+// --------------------------------------
+func writeFile(t *testing.T, path string, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		t.Fatalf("Received unexpected error when creating folder: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Received unexpected error when writing file: %v", err)
+	}
+}
+
+func sameFile(t *testing.T, a string, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		t.Fatalf("Received unexpected error when reading %s: %v", a, err)
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		t.Fatalf("Received unexpected error when reading %s: %v", b, err)
+	}
+	return os.SameFile(infoA, infoB)
+}
+
+// --------------------------------------
+// 		  Suite Setup - Cleanup
+// --------------------------------------
+func TestMain(m *testing.M) {
+	cfg := config.LoadConfig()
+	if err := os.MkdirAll(cfg.UploadDir, os.ModePerm); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create upload directory %q: %v\n", cfg.UploadDir, err)
+		os.Exit(1)
+	}
+	if err := os.MkdirAll("secrets", os.ModePerm); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create upload directory %q: %v\n", "secrets", err)
+		os.Exit(1)
+	}
+
+	exitCode := m.Run()
+
+	if err := os.RemoveAll(cfg.UploadDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to remove upload directory %q: %v\n", cfg.UploadDir, err)
+	}
+	if err := os.RemoveAll("secrets"); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to remove upload directory %q: %v\n", "secrets", err)
+	}
+
+	os.Exit(exitCode)
+}
+
+// --------------------------------------
+// 			   Store Tests
+// --------------------------------------
+func TestDeduplicateLifecycle(t *testing.T) {
+	cfg := config.LoadConfig()
+	defer os.RemoveAll(StoreDir())
+
+	first := filepath.Join(cfg.UploadDir, "photo.jpg")
+	second := filepath.Join(cfg.UploadDir, "Album", "photo (1).jpg")
+	other := filepath.Join(cfg.UploadDir, "other.jpg")
+	writeFile(t, first, "samePhotoContent")
+	writeFile(t, second, "samePhotoContent")
+	writeFile(t, other, "otherContent")
+	defer os.RemoveAll(filepath.Join(cfg.UploadDir, "Album"))
+
+	result, err := Deduplicate(first)
+	if err != nil || result.Linked {
+		t.Fatalf("Expected first copy to become the blob, got %+v %v", result, err)
+	}
+	result, err = Deduplicate(second)
+	if err != nil || !result.Linked || result.Reclaimed != int64(len("samePhotoContent")) {
+		t.Fatalf("Expected second copy to be linked to the blob, got %+v %v", result, err)
+	}
+	if !sameFile(t, first, second) {
+		t.Error("Expected identical uploads to share storage")
+	}
+	if _, err := Deduplicate(other); err != nil {
+		t.Fatalf("Received unexpected error when storing other file: %v", err)
+	}
+
+	// Deduplicating again is a no-op
+	if result, err := Deduplicate(second); err != nil || result.Linked {
+		t.Errorf("Expected already linked file to be left alone, got %+v %v", result, err)
+	}
+
+	report, err := BuildReport()
+	if err != nil {
+		t.Fatalf("Received unexpected error when building report: %v", err)
+	}
+	if report.Blobs != 2 || report.References != 3 || report.ReclaimedBytes != int64(len("samePhotoContent")) {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+
+	// Deleting one reference keeps the content for the other
+	os.Remove(first)
+	if removed, _, err := CollectGarbage(); err != nil || removed != 0 {
+		t.Errorf("Expected referenced blobs to be kept, removed %d %v", removed, err)
+	}
+	content, _ := os.ReadFile(second)
+	if string(content) != "samePhotoContent" {
+		t.Errorf("Expected remaining copy to keep its content, got %q", content)
+	}
+
+	// Deleting the last reference frees the blob
+	os.Remove(second)
+	removed, freed, err := CollectGarbage()
+	if err != nil || removed != 1 || freed != int64(len("samePhotoContent")) {
+		t.Errorf("Expected unreferenced blob to be collected, got %d %d %v", removed, freed, err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("Expected unrelated file to be kept, got %v", err)
+	}
+}
+
+func TestReportHandler(t *testing.T) {
+	createReq := func(folderId string) *http.Request {
+		claims := jwt.MapClaims{
+			"user_id":   "someRandomUser",
+			"folder_id": folderId,
+			"access":    "rw",
+			"exp":       time.Now().Add(30 * time.Minute).Unix(),
+		}
+		req := httptest.NewRequest(http.MethodGet, "/admin/dedup", nil)
+		return req.WithContext(context.WithValue(context.Background(), auth.ClaimsContextKey, claims))
+	}
+
+	t.Run("Report_Not_Admin", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		ReportHandler(rr, createReq("someFolderId"))
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 Forbidden, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Report_Success", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		ReportHandler(rr, createReq("/"))
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 OK, got: %d", rr.Code)
+		}
+		var report Report
+		if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+			t.Fatalf("Received unexpected error when parsing response body: %v", err)
+		}
+	})
+}
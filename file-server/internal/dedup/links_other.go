@@ -0,0 +1,9 @@
+//go:build !unix
+
+package dedup
+
+import "io/fs"
+
+func linkCount(info fs.FileInfo) (int, bool) {
+	return 0, false
+}
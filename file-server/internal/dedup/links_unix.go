@@ -0,0 +1,16 @@
+//go:build unix
+
+package dedup
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+func linkCount(info fs.FileInfo) (int, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int(stat.Nlink), true
+}
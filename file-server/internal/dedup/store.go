@@ -0,0 +1,192 @@
+package dedup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/google/uuid"
+
+	"file-server/config"
+)
+
+// CasDir holds one blob per distinct content under the upload directory,
+// named by its SHA-256. Stored files are hardlinks to these blobs, so the
+// reference count of a blob is its link count minus the blob itself.
+const CasDir = ".cas"
+
+type Result struct {
+	Hash      string `json:"hash"`
+	Linked    bool   `json:"linked"`    // The file now shares its content with an earlier upload
+	Reclaimed int64  `json:"reclaimed"` // Bytes saved by linking
+}
+
+type Report struct {
+	Enabled        bool  `json:"enabled"`
+	Blobs          int   `json:"blobs"`
+	References     int   `json:"references"`      // Stored paths pointing to a blob
+	StoredBytes    int64 `json:"stored_bytes"`    // Size of the blobs, what is actually on disk
+	ReclaimedBytes int64 `json:"reclaimed_bytes"` // Size the references would take without dedup
+	Unreferenced   int   `json:"unreferenced"`    // Blobs waiting for CollectGarbage
+}
+
+// StoreDir returns the blob store directory.
+func StoreDir() string {
+	cfg := config.LoadConfig()
+	return filepath.Join(cfg.UploadDir, CasDir)
+}
+
+func blobPath(hash string) string {
+	return filepath.Join(StoreDir(), hash[:2], hash)
+}
+
+// Deduplicate stores filePath in the blob store, or, if identical content is
+// already stored, replaces filePath with a link to it. Files on a different
+// filesystem than the store are left as they are.
+func Deduplicate(filePath string) (Result, error) {
+	hash, err := hashFile(filePath)
+	if err != nil {
+		return Result{}, err
+	}
+	result := Result{Hash: hash}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return result, err
+	}
+
+	blob := blobPath(hash)
+	if err := os.MkdirAll(filepath.Dir(blob), os.ModePerm); err != nil {
+		return result, err
+	}
+
+	for {
+		blobInfo, err := os.Stat(blob)
+		if os.IsNotExist(err) {
+			// First copy of this content, it becomes the blob
+			err := os.Link(filePath, blob)
+			if os.IsExist(err) {
+				continue
+			}
+			if errors.Is(err, syscall.EXDEV) {
+				return result, nil
+			}
+			return result, err
+		}
+		if err != nil {
+			return result, err
+		}
+
+		if os.SameFile(info, blobInfo) {
+			return result, nil
+		}
+		if blobInfo.Size() != info.Size() {
+			return result, fmt.Errorf("blob %s does not match size of %s", hash, filePath)
+		}
+		break
+	}
+
+	// Swap the file for a link to the blob, rename keeps the path valid throughout
+	tempPath := filepath.Join(filepath.Dir(filePath), ".dedup-"+uuid.New().String())
+	if err := os.Link(blob, tempPath); err != nil {
+		if errors.Is(err, syscall.EXDEV) {
+			return result, nil
+		}
+		return result, err
+	}
+	if err := os.Rename(tempPath, filePath); err != nil {
+		os.Remove(tempPath)
+		return result, err
+	}
+
+	result.Linked = true
+	result.Reclaimed = info.Size()
+	return result, nil
+}
+
+// BuildReport summarises the blob store and the space saved by it.
+func BuildReport() (Report, error) {
+	cfg := config.LoadConfig()
+	report := Report{Enabled: cfg.Dedup}
+
+	err := walkBlobs(func(path string, info fs.FileInfo, references int) error {
+		report.Blobs++
+		report.StoredBytes += info.Size()
+		report.References += references
+		if references == 0 {
+			report.Unreferenced++
+		} else {
+			report.ReclaimedBytes += int64(references-1) * info.Size()
+		}
+		return nil
+	})
+	return report, err
+}
+
+// CollectGarbage removes blobs no stored path links to anymore, which happens
+// once every copy has been deleted and purged from the trash. Returns the
+// number of blobs removed and the bytes freed.
+func CollectGarbage() (int, int64, error) {
+	removed := 0
+	var freed int64
+
+	err := walkBlobs(func(path string, info fs.FileInfo, references int) error {
+		if references > 0 {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			log.Printf("[FILE-SERVER] Error removing blob %s: %v", path, err)
+			return nil
+		}
+		removed++
+		freed += info.Size()
+		return nil
+	})
+	return removed, freed, err
+}
+
+// walkBlobs calls fn for every blob in the store with its reference count.
+func walkBlobs(fn func(path string, info fs.FileInfo, references int) error) error {
+	err := filepath.WalkDir(StoreDir(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		links, ok := linkCount(info)
+		if !ok {
+			return fmt.Errorf("link counts are not supported on this platform")
+		}
+		return fn(path, info, links-1)
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func hashFile(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -1,19 +1,37 @@
 package downloader
 
 import (
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"net/http"
+	"sync"
 	
 	"file-server/config"
 	"file-server/internal/auth"
+	"file-server/internal/files"
 	"file-server/internal/job"
+	"file-server/internal/throttle"
 
 	
 	"github.com/golang-jwt/jwt/v5"
 )
 
+var (
+	bandwidthMu       sync.RWMutex
+	downloadBandwidth *throttle.Bandwidth
+)
+
+// SetDownloadBandwidth sets the buckets downloads are served through, nil
+// for no limit.
+func SetDownloadBandwidth(bandwidth *throttle.Bandwidth) {
+	bandwidthMu.Lock()
+	defer bandwidthMu.Unlock()
+	downloadBandwidth = bandwidth
+}
+
 func DownloadHandler(w http.ResponseWriter, r *http.Request, jm *job.JobManager) {
 	cfg := config.LoadConfig()
 
@@ -48,7 +66,12 @@ func DownloadHandler(w http.ResponseWriter, r *http.Request, jm *job.JobManager)
 		return
 	}
 
-	filePath := filepath.Join(cfg.SharingDir, folderId, fileName)
+	// The trash and other internal directories live inside the share folder
+	filePath, err := files.SafeJoin(filepath.Join(cfg.SharingDir, folderId), fileName)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
 	f, err := os.Open(filePath)
 	if err != nil {
 		http.Error(w, "File not found", http.StatusNotFound)
@@ -65,7 +88,21 @@ func DownloadHandler(w http.ResponseWriter, r *http.Request, jm *job.JobManager)
 	w.Header().Set("Content-Disposition", "attachment; filename=\""+filepath.Base(fileName)+"\"")
 	w.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
 
-	http.ServeContent(w, r, fileName, fi.ModTime(), f)
+	// Range requests after the first, as players make, are the same download
+	if rangeHeader := r.Header.Get("Range"); rangeHeader == "" || strings.HasPrefix(rangeHeader, "bytes=0-") {
+		jm.Publish(job.Event{Type: job.EventFileDownloaded, FolderId: folderId, File: fileName})
+	}
+
+	bandwidthMu.RLock()
+	bandwidth := downloadBandwidth
+	bandwidthMu.RUnlock()
+
+	// Throttled on reads, ServeContent still seeks the file itself for ranges
+	var content io.ReadSeeker = f
+	if bandwidth != nil {
+		content = bandwidth.ReadSeeker(r.Context(), folderId, auth.ClientId(claims), f)
+	}
+	http.ServeContent(w, r, fileName, fi.ModTime(), content)
 }
 
 func DownloadAvailableHandler(w http.ResponseWriter, r *http.Request, jm *job.JobManager) {
@@ -108,7 +145,12 @@ func DownloadAvailableHandler(w http.ResponseWriter, r *http.Request, jm *job.Jo
 	}
 	jm.ReleaseJob(fileName)
 
-	filePath := filepath.Join(cfg.SharingDir, folderId, fileName)
+	// The trash and other internal directories live inside the share folder
+	filePath, err := files.SafeJoin(filepath.Join(cfg.SharingDir, folderId), fileName)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
 	f, err := os.Open(filePath)
 	if err != nil {
 		http.Error(w, "File not found", http.StatusNotFound)
@@ -17,6 +17,7 @@ import (
 
 	"file-server/internal/auth"
 	"file-server/internal/job"
+	"file-server/internal/throttle"
 	"file-server/config"
 )
 
@@ -247,4 +248,88 @@ func TestDownloaderSuccess(t *testing.T) {
 	if err := os.RemoveAll(folder); err != nil {
 		t.Fatalf("Received unexpected error when deleting folder: %v", err)
 	}
-}
\ No newline at end of file
+}
+func TestDownloaderThrottledRange(t *testing.T) {
+	cfg := config.LoadConfig()
+	folder := uuid.New().String()
+	folderPath := filepath.Join(cfg.SharingDir, folder)
+	if err := os.MkdirAll(folderPath, os.ModePerm); err != nil {
+		t.Fatalf("Received unexpected error when creating folder: %v", err)
+	}
+	defer os.RemoveAll(folderPath)
+
+	fileName := "someRandomFileName.txt"
+	content := []byte(strings.Repeat("0123456789", 1000))
+	if err := os.WriteFile(filepath.Join(folderPath, fileName), content, 0644); err != nil {
+		t.Fatalf("Received unexpected error when creating file: %v", err)
+	}
+
+	bandwidth, err := throttle.NewBandwidth(throttle.Rates{Share: 1 << 20})
+	if err != nil {
+		t.Fatalf("Received unexpected error when creating bandwidth: %v", err)
+	}
+	SetDownloadBandwidth(bandwidth)
+	defer SetDownloadBandwidth(nil)
+
+	claims := jwt.MapClaims{
+		"user_id":   folder,
+		"folder_id": folder,
+		"access":    "r",
+		"exp":       time.Now().Add(5 * time.Hour).Unix(),
+	}
+	ctx := context.WithValue(context.Background(), auth.ClaimsContextKey, claims)
+
+	queryParams := url.Values{}
+	queryParams.Add("folder_id", folder)
+	queryParams.Add("file", fileName)
+
+	req := httptest.NewRequest(http.MethodGet, "/download?"+queryParams.Encode(), nil)
+	req.Header.Set("Range", "bytes=5000-5009")
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	DownloadHandler(rr, req, job.NewJobManager(30*time.Minute))
+
+	if rr.Code != http.StatusPartialContent {
+		t.Fatalf("Expected status 206 Partial Content, got: %d", rr.Code)
+	}
+	if rr.Body.String() != string(content[5000:5010]) {
+		t.Errorf("Expected the requested range, got: %q", rr.Body.String())
+	}
+}
+
+func TestDownloaderInternalDirectories(t *testing.T) {
+	cfg := config.LoadConfig()
+	folder := uuid.New().String()
+	folderPath := filepath.Join(cfg.SharingDir, folder)
+	trashedPath := filepath.Join(folderPath, ".trash", "someTrashId", "someDeletedFile.txt")
+	if err := os.MkdirAll(filepath.Dir(trashedPath), os.ModePerm); err != nil {
+		t.Fatalf("Received unexpected error when creating folder: %v", err)
+	}
+	defer os.RemoveAll(folderPath)
+	if err := os.WriteFile(trashedPath, []byte("someDeletedContent"), 0644); err != nil {
+		t.Fatalf("Received unexpected error when creating file: %v", err)
+	}
+
+	claims := jwt.MapClaims{
+		"user_id":   folder,
+		"folder_id": folder,
+		"access":    "r",
+		"exp":       time.Now().Add(5 * time.Hour).Unix(),
+	}
+	ctx := context.WithValue(context.Background(), auth.ClaimsContextKey, claims)
+
+	for _, fileName := range []string{".trash/someTrashId/someDeletedFile.txt", "./.trash/someTrashId/someDeletedFile.txt", "../" + folder + "/.trash/someTrashId/someDeletedFile.txt"} {
+		queryParams := url.Values{}
+		queryParams.Add("folder_id", folder)
+		queryParams.Add("file", fileName)
+
+		req := httptest.NewRequest(http.MethodGet, "/download?"+queryParams.Encode(), nil).WithContext(ctx)
+		rr := httptest.NewRecorder()
+		DownloadHandler(rr, req, job.NewJobManager(30*time.Minute))
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 Not Found for %s, got: %d", fileName, rr.Code)
+		}
+	}
+}
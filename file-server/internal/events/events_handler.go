@@ -0,0 +1,100 @@
+// Package events streams what happens in a folder to the clients looking at
+// it, as Server-Sent Events, instead of having them poll.
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"file-server/internal/auth"
+	"file-server/internal/job"
+)
+
+// Comments are sent this often on a quiet stream, so that proxies keep it open.
+const heartbeatInterval = 15 * time.Second
+
+// EventsHandler streams the events published through jm for the folder_id of
+// the caller's token, every folder's for the admin. Events name the files of
+// the folder, so write-only callers such as file request visitors get none.
+// The stream ends when the token expires, EventSource reconnects by itself
+// and a fresh token is used.
+func EventsHandler(w http.ResponseWriter, r *http.Request, jm *job.JobManager) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claimsRaw := r.Context().Value(auth.ClaimsContextKey)
+	claims, ok := claimsRaw.(jwt.MapClaims)
+	if !ok {
+		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		return
+	}
+	folderId, ok := claims["folder_id"].(string)
+	if !ok || folderId == "" {
+		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		return
+	}
+	canAccess, err := auth.HasAccess(claims, folderId, "r")
+	if err != nil || !canAccess {
+		http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	events, unsubscribe := jm.Subscribe(folderId)
+	defer unsubscribe()
+
+	var expired <-chan time.Time
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		timer := time.NewTimer(time.Until(exp.Time))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no") // Nginx would hold events back otherwise
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("[FILE-SERVER] Error encoding event %d: %v", event.Id, err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Type, data); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-expired:
+			return
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,162 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"file-server/internal/auth"
+	"file-server/internal/job"
+)
+
+// --------------------------------------
+// 			 Helper Functions
+// --------------------------------------
+func startStream(t *testing.T, jm *job.JobManager, folderId string) (*bufio.Reader, func()) {
+	t.Helper()
+	claims := jwt.MapClaims{
+		"user_id":   "someRandomUser",
+		"folder_id": folderId,
+		"access":    "r",
+		"exp":       time.Now().Add(30 * time.Minute).Unix(),
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		EventsHandler(w, r.WithContext(context.WithValue(r.Context(), auth.ClaimsContextKey, claims)), jm)
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Received unexpected error when connecting: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	stream := bufio.NewReader(resp.Body)
+	if line, _ := stream.ReadString('\n'); line != ": connected\n" {
+		t.Fatalf("Expected the stream to open with a comment, got %q", line)
+	}
+	stream.ReadString('\n')
+
+	return stream, func() {
+		cancel()
+		resp.Body.Close()
+		ts.Close()
+	}
+}
+
+// readEvent reads the next event off stream, its name and data.
+func readEvent(t *testing.T, stream *bufio.Reader) (string, job.Event) {
+	t.Helper()
+	var name string
+	var event job.Event
+	for {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Received unexpected error when reading stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return name, event
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				t.Fatalf("Received unexpected error when parsing event: %v", err)
+			}
+		}
+	}
+}
+
+// --------------------------------------
+// 				 Tests
+// --------------------------------------
+func TestEventsHandler(t *testing.T) {
+	t.Run("Events_Method_Not_Allowed", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		EventsHandler(rr, httptest.NewRequest(http.MethodPost, "/events", nil), job.NewJobManager(time.Minute))
+
+		if rr.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Expected status 405 Method Not Allowed, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Events_Invalid_Claims", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		EventsHandler(rr, httptest.NewRequest(http.MethodGet, "/events", nil), job.NewJobManager(time.Minute))
+
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401 Unauthorized, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Events_Write_Only_Forbidden", func(t *testing.T) {
+		claims := jwt.MapClaims{
+			"user_id":   "someRandomUser",
+			"folder_id": "someShareId",
+			"access":    "w",
+			"exp":       time.Now().Add(30 * time.Minute).Unix(),
+		}
+		req := httptest.NewRequest(http.MethodGet, "/events", nil)
+		req = req.WithContext(context.WithValue(req.Context(), auth.ClaimsContextKey, claims))
+		rr := httptest.NewRecorder()
+		EventsHandler(rr, req, job.NewJobManager(time.Minute))
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 Forbidden, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Events_Scoped_By_Folder", func(t *testing.T) {
+		jm := job.NewJobManager(time.Minute)
+		defer jm.Close()
+		admin, stopAdmin := startStream(t, jm, "/")
+		defer stopAdmin()
+		share, stopShare := startStream(t, jm, "someShareId")
+		defer stopShare()
+
+		jm.Publish(job.Event{Type: job.EventFileAdded, FolderId: "someOtherShareId", File: "someOtherFile.txt"})
+		jm.Publish(job.Event{Type: job.EventZipReady, FolderId: "someShareId", File: "someShareId.zip"})
+
+		if name, event := readEvent(t, admin); name != job.EventFileAdded || event.File != "someOtherFile.txt" {
+			t.Errorf("Expected the admin to get every folder's events, got %s %+v", name, event)
+		}
+		if name, event := readEvent(t, admin); name != job.EventZipReady || event.FolderId != "someShareId" {
+			t.Errorf("Expected the admin to get every folder's events, got %s %+v", name, event)
+		}
+		if name, event := readEvent(t, share); name != job.EventZipReady || event.File != "someShareId.zip" {
+			t.Errorf("Expected the share to get its own events only, got %s %+v", name, event)
+		}
+	})
+
+	t.Run("Events_End_On_Shutdown", func(t *testing.T) {
+		jm := job.NewJobManager(time.Minute)
+		stream, stop := startStream(t, jm, "/")
+		defer stop()
+
+		jm.EndSubscriptions()
+		done := make(chan error, 1)
+		go func() {
+			_, err := stream.ReadString('\n')
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Error("Expected the stream to end")
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("Expected the stream to end once subscriptions are closed")
+		}
+	})
+}
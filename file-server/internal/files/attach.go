@@ -0,0 +1,129 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"file-server/config"
+)
+
+const (
+	AttachHardlink = "hardlink" // Same inode, no extra space used
+	AttachReflink  = "reflink"  // Copy-on-write clone (btrfs, xfs)
+	AttachCopy     = "copy"     // Plain byte copy
+)
+
+var ErrNotRegularFile = errors.New("only files can be attached")
+
+type AttachedFile struct {
+	Source   string `json:"source"`
+	Path     string `json:"path"`
+	Method   string `json:"method"`
+	Stripped bool   `json:"stripped,omitempty"` // Metadata was removed, the share holds its own copy
+}
+
+// ResolveLibraryFiles checks that every path names a regular file under the
+// upload directory and returns their absolute paths.
+func ResolveLibraryFiles(relPaths []string) ([]string, error) {
+	cfg := config.LoadConfig()
+
+	sourcePaths := make([]string, 0, len(relPaths))
+	for _, relPath := range relPaths {
+		sourcePath, err := SafeJoin(cfg.UploadDir, relPath)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(sourcePath)
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			return nil, fmt.Errorf("%w: %s", ErrNotRegularFile, relPath)
+		}
+		sourcePaths = append(sourcePaths, sourcePath)
+	}
+	return sourcePaths, nil
+}
+
+// AttachLibraryFiles adds files of the upload directory to the top level of a
+// sharing folder without going through an upload. Each file is hardlinked
+// when possible, reflinked when the filesystem supports it, and copied otherwise.
+func AttachLibraryFiles(shareRoot string, relPaths []string, mode ConflictMode) ([]AttachedFile, error) {
+	cfg := config.LoadConfig()
+
+	sourcePaths, err := ResolveLibraryFiles(relPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	attached := []AttachedFile{}
+	for _, sourcePath := range sourcePaths {
+		targetPath, err := resolveTarget(shareRoot, filepath.Join(shareRoot, filepath.Base(sourcePath)), mode)
+		if err != nil {
+			return attached, err
+		}
+
+		method, err := CloneFile(sourcePath, targetPath)
+		if err != nil {
+			return attached, err
+		}
+
+		source, _ := relativePath(cfg.UploadDir, sourcePath)
+		target, _ := relativePath(shareRoot, targetPath)
+		attached = append(attached, AttachedFile{Source: source, Path: target, Method: method})
+	}
+	syncDir(shareRoot)
+	return attached, nil
+}
+
+// CloneFile creates targetPath with the content of sourcePath, using the
+// cheapest method the filesystem allows. Never overwrites targetPath.
+func CloneFile(sourcePath string, targetPath string) (string, error) {
+	err := os.Link(sourcePath, targetPath)
+	if err == nil {
+		return AttachHardlink, nil
+	}
+	if os.IsExist(err) {
+		return "", ErrConflict
+	}
+
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return "", err
+	}
+	defer source.Close()
+
+	info, err := source.Stat()
+	if err != nil {
+		return "", err
+	}
+	target, err := os.OpenFile(targetPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		if os.IsExist(err) {
+			return "", ErrConflict
+		}
+		return "", err
+	}
+
+	method := AttachReflink
+	if err := reflink(target, source); err != nil {
+		method = AttachCopy
+		if _, err := io.Copy(target, source); err != nil {
+			target.Close()
+			os.Remove(targetPath)
+			return "", err
+		}
+	}
+	if err := target.Sync(); err != nil {
+		log.Printf("[FILE-SERVER] Error syncing attached file %s: %v", targetPath, err)
+	}
+	if err := target.Close(); err != nil {
+		os.Remove(targetPath)
+		return "", err
+	}
+	return method, nil
+}
@@ -0,0 +1,429 @@
+package files
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"file-server/config"
+	"file-server/internal/auth"
+	"file-server/internal/helpers"
+	"file-server/internal/job"
+)
+
+type DeleteFileDetails struct {
+	FolderId string `json:"folder_id"`
+	Path     string `json:"path"`
+}
+
+type RestoreTrashDetails struct {
+	FolderId string `json:"folder_id"`
+	Id       string `json:"id"`
+}
+
+type RestoreTrashResponse struct {
+	Path string `json:"path"`
+}
+
+type TrashResponse struct {
+	Items     []TrashItem `json:"items"`
+	Retention string      `json:"retention"`
+}
+
+// TransferHook runs after a file or folder is moved or copied into
+// folderPath, path being where it now is.
+type TransferHook func(folderPath string, path string)
+
+var (
+	transferHookMu sync.RWMutex
+	transferHook   TransferHook
+)
+
+// SetTransferHook replaces the hook run after moves and copies, nil for none.
+func SetTransferHook(hook TransferHook) {
+	transferHookMu.Lock()
+	defer transferHookMu.Unlock()
+	transferHook = hook
+}
+
+// authorizeFolder resolves folderId and checks the caller's claims grant
+// access to it. On failure the error response has already been written.
+func authorizeFolder(w http.ResponseWriter, r *http.Request, folderId string, access string) (string, bool) {
+	claimsRaw := r.Context().Value(auth.ClaimsContextKey)
+	claims, ok := claimsRaw.(jwt.MapClaims)
+	if !ok {
+		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		return "", false
+	}
+
+	root, accessKey, err := ResolveFolder(folderId)
+	if err != nil && err != ErrFolderNotFound {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	if err == ErrFolderNotFound {
+		accessKey = folderId
+	}
+
+	canAccess, err := auth.HasAccess(claims, accessKey, access)
+	if err != nil || !canAccess {
+		http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+		return "", false
+	}
+	if root == "" {
+		http.Error(w, "Folder not found", http.StatusNotFound)
+		return "", false
+	}
+	return root, true
+}
+
+// refreshFolder rebuilds derived state after the content of root changed.
+func refreshFolder(root string, jm *job.JobManager) {
+	cfg := config.LoadConfig()
+	if root != cfg.UploadDir {
+		jm.Go(func() {
+			helpers.RefreshShareZip(root, jm)
+		})
+	}
+}
+
+func DeleteFileHandler(w http.ResponseWriter, r *http.Request, jm *job.JobManager) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var details DeleteFileDetails
+	if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+		http.Error(w, "Unable to parse delete parameters", http.StatusBadRequest)
+		return
+	}
+
+	root, ok := authorizeFolder(w, r, details.FolderId, "rw")
+	if !ok {
+		return
+	}
+
+	targetPath, err := SafeJoin(root, details.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !jm.AcquireJob(targetPath) {
+		http.Error(w, "File currently processing", http.StatusConflict)
+		return
+	}
+	item, err := MoveToTrash(root, details.Path)
+	jm.ReleaseJob(targetPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error while deleting file", http.StatusInternalServerError)
+		return
+	}
+
+	refreshFolder(root, jm)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item)
+}
+
+func ListTrashHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	root, ok := authorizeFolder(w, r, r.URL.Query().Get("folder_id"), "rw")
+	if !ok {
+		return
+	}
+
+	items, err := ListTrash(root)
+	if err != nil {
+		http.Error(w, "Error while reading trash", http.StatusInternalServerError)
+		return
+	}
+
+	cfg := config.LoadConfig()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TrashResponse{
+		Items:     items,
+		Retention: cfg.TrashRetention.String(),
+	})
+}
+
+func RestoreTrashHandler(w http.ResponseWriter, r *http.Request, jm *job.JobManager) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var details RestoreTrashDetails
+	if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+		http.Error(w, "Unable to parse restore parameters", http.StatusBadRequest)
+		return
+	}
+
+	root, ok := authorizeFolder(w, r, details.FolderId, "rw")
+	if !ok {
+		return
+	}
+
+	restoredPath, err := RestoreFromTrash(root, details.Id)
+	if err != nil {
+		if err == ErrTrashItemNotFound {
+			http.Error(w, "Trash item not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error while restoring file", http.StatusInternalServerError)
+		return
+	}
+
+	refreshFolder(root, jm)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RestoreTrashResponse{Path: restoredPath})
+}
+
+type RenameFileDetails struct {
+	FolderId   string `json:"folder_id"`
+	Path       string `json:"path"`
+	NewName    string `json:"new_name"`
+	OnConflict string `json:"on_conflict"`
+}
+
+type TransferFileDetails struct {
+	FolderId     string `json:"folder_id"`
+	Path         string `json:"path"`
+	DestFolderId string `json:"dest_folder_id"`
+	DestPath     string `json:"dest_path"`
+	OnConflict   string `json:"on_conflict"`
+}
+
+type CreateFolderDetails struct {
+	FolderId string `json:"folder_id"`
+	Path     string `json:"path"`
+}
+
+type FileOperationResponse struct {
+	FolderId string `json:"folder_id"`
+	Path     string `json:"path"`
+}
+
+// lockPaths acquires a job for every path so that operations on the same files
+// (including deletes and zip rebuilds) don't interleave. All or nothing.
+func lockPaths(jm *job.JobManager, paths ...string) (func(), bool) {
+	acquired := []string{}
+	release := func() {
+		for _, path := range acquired {
+			jm.ReleaseJob(path)
+		}
+	}
+	for _, path := range paths {
+		if contains(acquired, path) {
+			continue
+		}
+		if !jm.AcquireJob(path) {
+			release()
+			return nil, false
+		}
+		acquired = append(acquired, path)
+	}
+	return release, true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// writeOperationError maps errors of the file operations to a response.
+func writeOperationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrConflict):
+		http.Error(w, "Destination already exists", http.StatusConflict)
+	case errors.Is(err, ErrInvalidName), errors.Is(err, ErrInvalidMove), errors.Is(err, ErrNotDirectory):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case os.IsNotExist(err):
+		http.Error(w, "File not found", http.StatusNotFound)
+	default:
+		log.Printf("[FILE-SERVER] Error during file operation: %v", err)
+		http.Error(w, "Error during file operation", http.StatusInternalServerError)
+	}
+}
+
+func RenameFileHandler(w http.ResponseWriter, r *http.Request, jm *job.JobManager) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var details RenameFileDetails
+	if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+		http.Error(w, "Unable to parse rename parameters", http.StatusBadRequest)
+		return
+	}
+	mode, err := ParseConflictMode(details.OnConflict)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	root, ok := authorizeFolder(w, r, details.FolderId, "rw")
+	if !ok {
+		return
+	}
+
+	sourcePath, err := SafeJoin(root, details.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	release, ok := lockPaths(jm, sourcePath, filepath.Join(filepath.Dir(sourcePath), details.NewName))
+	if !ok {
+		http.Error(w, "File currently processing", http.StatusConflict)
+		return
+	}
+	newPath, err := RenamePath(root, details.Path, details.NewName, mode)
+	release()
+	if err != nil {
+		writeOperationError(w, err)
+		return
+	}
+
+	refreshFolder(root, jm)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(FileOperationResponse{FolderId: details.FolderId, Path: newPath})
+}
+
+func MoveFileHandler(w http.ResponseWriter, r *http.Request, jm *job.JobManager) {
+	transferHandler(w, r, jm, false)
+}
+
+func CopyFileHandler(w http.ResponseWriter, r *http.Request, jm *job.JobManager) {
+	transferHandler(w, r, jm, true)
+}
+
+// transferHandler moves or copies a file or folder, possibly between folders.
+// Moving needs write access on both ends, copying only read access on the source.
+func transferHandler(w http.ResponseWriter, r *http.Request, jm *job.JobManager, keepSource bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var details TransferFileDetails
+	if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+		http.Error(w, "Unable to parse file parameters", http.StatusBadRequest)
+		return
+	}
+	mode, err := ParseConflictMode(details.OnConflict)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sourceAccess := "rw"
+	if keepSource {
+		sourceAccess = "r"
+	}
+	srcRoot, ok := authorizeFolder(w, r, details.FolderId, sourceAccess)
+	if !ok {
+		return
+	}
+	dstRoot, ok := authorizeFolder(w, r, details.DestFolderId, "w")
+	if !ok {
+		return
+	}
+
+	sourcePath, err := SafeJoin(srcRoot, details.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dstDir, err := ResolveDir(dstRoot, details.DestPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	release, ok := lockPaths(jm, sourcePath, filepath.Join(dstDir, filepath.Base(sourcePath)))
+	if !ok {
+		http.Error(w, "File currently processing", http.StatusConflict)
+		return
+	}
+	var newPath string
+	if keepSource {
+		newPath, err = CopyPath(srcRoot, details.Path, dstRoot, details.DestPath, mode)
+	} else {
+		newPath, err = MovePath(srcRoot, details.Path, dstRoot, details.DestPath, mode)
+	}
+	release()
+	if err != nil {
+		writeOperationError(w, err)
+		return
+	}
+
+	transferHookMu.RLock()
+	hook := transferHook
+	transferHookMu.RUnlock()
+	if hook != nil {
+		hook(dstRoot, filepath.Join(dstRoot, filepath.FromSlash(newPath)))
+	}
+
+	if !keepSource && srcRoot != dstRoot {
+		refreshFolder(srcRoot, jm)
+	}
+	refreshFolder(dstRoot, jm)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(FileOperationResponse{FolderId: details.DestFolderId, Path: newPath})
+}
+
+func CreateFolderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var details CreateFolderDetails
+	if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+		http.Error(w, "Unable to parse folder parameters", http.StatusBadRequest)
+		return
+	}
+
+	root, ok := authorizeFolder(w, r, details.FolderId, "w")
+	if !ok {
+		return
+	}
+
+	folderPath, err := CreateFolder(root, details.Path)
+	if err != nil {
+		if _, joinErr := SafeJoin(root, details.Path); joinErr != nil {
+			http.Error(w, joinErr.Error(), http.StatusBadRequest)
+			return
+		}
+		writeOperationError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(FileOperationResponse{FolderId: details.FolderId, Path: folderPath})
+}
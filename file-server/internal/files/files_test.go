@@ -0,0 +1,532 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"file-server/config"
+	"file-server/internal/auth"
+	"file-server/internal/job"
+)
+
+// --------------------------------------
+//
+//	Helper Functions
+//
+// --------------------------------------
+func createShareFolder(t *testing.T) (string, string) {
+	cfg := config.LoadConfig()
+	folderId := uuid.New().String()
+	folderPath := filepath.Join(cfg.SharingDir, folderId)
+	if err := os.MkdirAll(folderPath, os.ModePerm); err != nil {
+		t.Fatalf("Received unexpected error when creating folder: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(folderPath) })
+	return folderId, folderPath
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		t.Fatalf("Received unexpected error when creating folder: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Received unexpected error when writing file: %v", err)
+	}
+}
+
+func withClaims(req *http.Request, folderId string, access string) *http.Request {
+	claims := jwt.MapClaims{
+		"user_id":   "someRandomUser",
+		"folder_id": folderId,
+		"access":    access,
+		"exp":       time.Now().Add(5 * time.Hour).Unix(),
+	}
+	return req.WithContext(context.WithValue(context.Background(), auth.ClaimsContextKey, claims))
+}
+
+func jsonRequest(t *testing.T, method string, target string, body interface{}) *http.Request {
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("failed to marshal body: %v", err)
+	}
+	return httptest.NewRequest(method, target, bytes.NewBuffer(data))
+}
+
+// --------------------------------------
+//
+//	Suite Setup - Cleanup
+//
+// --------------------------------------
+func TestMain(m *testing.M) {
+	cfg := config.LoadConfig()
+	if err := os.MkdirAll(cfg.SharingDir, os.ModePerm); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create sharing directory %q: %v\n", cfg.SharingDir, err)
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(cfg.UploadDir, os.ModePerm); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create upload directory %q: %v\n", cfg.UploadDir, err)
+		os.Exit(1)
+	}
+	if err := os.MkdirAll("secrets", os.ModePerm); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create upload directory %q: %v\n", "secrets", err)
+		os.Exit(1)
+	}
+
+	exitCode := m.Run()
+
+	if err := os.RemoveAll(cfg.SharingDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to remove sharing directory %q: %v\n", cfg.SharingDir, err)
+	}
+	if err := os.RemoveAll(cfg.UploadDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to remove upload directory %q: %v\n", cfg.UploadDir, err)
+	}
+	if err := os.RemoveAll("secrets"); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to remove upload directory %q: %v\n", "secrets", err)
+	}
+
+	os.Exit(exitCode)
+}
+
+// --------------------------------------
+//
+//	Path Tests
+//
+// --------------------------------------
+func TestSafeJoin(t *testing.T) {
+	cfg := config.LoadConfig()
+	root := "someRoot"
+
+	valid := map[string]string{
+		"photo.jpg":            filepath.Join(root, "photo.jpg"),
+		"Photos/2024/a.jpg":    filepath.Join(root, "Photos", "2024", "a.jpg"),
+		"../../etc/passwd":     filepath.Join(root, "etc", "passwd"),
+		"/absolute/secret.txt": filepath.Join(root, "absolute", "secret.txt"),
+	}
+	for relPath, expected := range valid {
+		joined, err := SafeJoin(root, relPath)
+		if err != nil {
+			t.Errorf("Received unexpected error for %s: %v", relPath, err)
+		}
+		if joined != expected {
+			t.Errorf("Expected %s to resolve to %s, got %s", relPath, expected, joined)
+		}
+	}
+
+	for _, relPath := range []string{"", "/", "..", TrashDir + "/x", cfg.ChunksDir + "/x"} {
+		if _, err := SafeJoin(root, relPath); err == nil {
+			t.Errorf("Expected error for path %q, received nil", relPath)
+		}
+	}
+}
+
+func TestResolveFolder(t *testing.T) {
+	cfg := config.LoadConfig()
+	folderId, folderPath := createShareFolder(t)
+
+	root, accessKey, err := ResolveFolder("")
+	if err != nil || root != cfg.UploadDir || accessKey != "/" {
+		t.Errorf("Expected upload dir with root access, got %s %s %v", root, accessKey, err)
+	}
+
+	root, accessKey, err = ResolveFolder(folderId)
+	if err != nil || root != folderPath || accessKey != folderId {
+		t.Errorf("Expected share folder %s, got %s %s %v", folderPath, root, accessKey, err)
+	}
+
+	if _, _, err := ResolveFolder(uuid.New().String()); err != ErrFolderNotFound {
+		t.Errorf("Expected ErrFolderNotFound, got %v", err)
+	}
+	if _, _, err := ResolveFolder("../etc"); err == nil {
+		t.Error("Expected error for folder id with path separators")
+	}
+}
+
+// --------------------------------------
+//
+//	Trash Tests
+//
+// --------------------------------------
+func TestTrashLifecycle(t *testing.T) {
+	_, root := createShareFolder(t)
+	writeFile(t, filepath.Join(root, "album", "photo.jpg"), "photo content")
+
+	item, err := MoveToTrash(root, "album/photo.jpg")
+	if err != nil {
+		t.Fatalf("Received unexpected error when trashing file: %v", err)
+	}
+	if item.OriginalPath != "album/photo.jpg" || item.Size != int64(len("photo content")) {
+		t.Errorf("Unexpected trash item recorded: %+v", item)
+	}
+	if _, err := os.Stat(filepath.Join(root, "album", "photo.jpg")); !os.IsNotExist(err) {
+		t.Error("Expected file to be moved out of its folder")
+	}
+
+	items, err := ListTrash(root)
+	if err != nil || len(items) != 1 || items[0].Id != item.Id {
+		t.Fatalf("Expected trash to list the deleted item, got %+v %v", items, err)
+	}
+
+	// Someone uploads a new file under the same name in the meantime
+	writeFile(t, filepath.Join(root, "album", "photo.jpg"), "new content")
+
+	restoredPath, err := RestoreFromTrash(root, item.Id)
+	if err != nil {
+		t.Fatalf("Received unexpected error when restoring file: %v", err)
+	}
+	if restoredPath != "album/photo (1).jpg" {
+		t.Errorf("Expected restore to avoid overwriting, got %s", restoredPath)
+	}
+	content, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(restoredPath)))
+	if err != nil || string(content) != "photo content" {
+		t.Errorf("Expected restored file content, got %q %v", content, err)
+	}
+
+	items, _ = ListTrash(root)
+	if len(items) != 0 {
+		t.Errorf("Expected trash to be empty after restore, got %d items", len(items))
+	}
+	if _, err := RestoreFromTrash(root, item.Id); err != ErrTrashItemNotFound {
+		t.Errorf("Expected ErrTrashItemNotFound when restoring twice, got %v", err)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	_, root := createShareFolder(t)
+	writeFile(t, filepath.Join(root, "old.txt"), "old")
+	writeFile(t, filepath.Join(root, "recent.txt"), "recent")
+
+	oldItem, err := MoveToTrash(root, "old.txt")
+	if err != nil {
+		t.Fatalf("Received unexpected error when trashing file: %v", err)
+	}
+	if _, err := MoveToTrash(root, "recent.txt"); err != nil {
+		t.Fatalf("Received unexpected error when trashing file: %v", err)
+	}
+
+	// Backdate the deletion of the first item past the retention period
+	oldItem.DeletedAt = time.Now().Add(-48 * time.Hour)
+	data, _ := json.Marshal(oldItem)
+	if err := os.WriteFile(filepath.Join(root, TrashDir, oldItem.Id, trashInfoFile), data, 0644); err != nil {
+		t.Fatalf("Received unexpected error when updating trash info: %v", err)
+	}
+
+	purged, err := PurgeTrash(root, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("Received unexpected error when purging trash: %v", err)
+	}
+	if len(purged) != 1 || purged[0].Id != oldItem.Id {
+		t.Errorf("Expected only the old item to be purged, got %+v", purged)
+	}
+	items, _ := ListTrash(root)
+	if len(items) != 1 || items[0].OriginalPath != "recent.txt" {
+		t.Errorf("Expected recent item to stay in trash, got %+v", items)
+	}
+}
+
+func TestTrashHandlers(t *testing.T) {
+	folderId, root := createShareFolder(t)
+	writeFile(t, filepath.Join(root, "someFile.txt"), "content")
+	jm := job.NewJobManager(30 * time.Minute)
+	defer jm.Close()
+
+	t.Run("Delete_Forbidden_Other_Folder", func(t *testing.T) {
+		req := jsonRequest(t, http.MethodPost, "/file-delete", DeleteFileDetails{FolderId: folderId, Path: "someFile.txt"})
+		rr := httptest.NewRecorder()
+		DeleteFileHandler(rr, withClaims(req, uuid.New().String(), "rw"), jm)
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 Forbidden, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Delete_Forbidden_Read_Only", func(t *testing.T) {
+		req := jsonRequest(t, http.MethodPost, "/file-delete", DeleteFileDetails{FolderId: folderId, Path: "someFile.txt"})
+		rr := httptest.NewRecorder()
+		DeleteFileHandler(rr, withClaims(req, folderId, "r"), jm)
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 Forbidden, got: %d", rr.Code)
+		}
+	})
+
+	var item TrashItem
+	t.Run("Delete_Success", func(t *testing.T) {
+		req := jsonRequest(t, http.MethodPost, "/file-delete", DeleteFileDetails{FolderId: folderId, Path: "someFile.txt"})
+		rr := httptest.NewRecorder()
+		DeleteFileHandler(rr, withClaims(req, folderId, "rw"), jm)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 OK, got: %d %s", rr.Code, rr.Body.String())
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &item); err != nil {
+			t.Fatalf("Received unexpected error when parsing response body: %v", err)
+		}
+	})
+
+	t.Run("Delete_Missing_File", func(t *testing.T) {
+		req := jsonRequest(t, http.MethodPost, "/file-delete", DeleteFileDetails{FolderId: folderId, Path: "someFile.txt"})
+		rr := httptest.NewRecorder()
+		DeleteFileHandler(rr, withClaims(req, folderId, "rw"), jm)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 Not Found, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("List_Trash", func(t *testing.T) {
+		query := url.Values{}
+		query.Add("folder_id", folderId)
+		req := httptest.NewRequest(http.MethodGet, "/trash?"+query.Encode(), nil)
+		rr := httptest.NewRecorder()
+		ListTrashHandler(rr, withClaims(req, folderId, "rw"))
+
+		var response TrashResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Received unexpected error when parsing response body: %v", err)
+		}
+		if len(response.Items) != 1 || response.Items[0].Id != item.Id {
+			t.Errorf("Expected deleted item in trash listing, got %+v", response.Items)
+		}
+	})
+
+	t.Run("Restore_Success", func(t *testing.T) {
+		req := jsonRequest(t, http.MethodPost, "/trash-restore", RestoreTrashDetails{FolderId: folderId, Id: item.Id})
+		rr := httptest.NewRecorder()
+		RestoreTrashHandler(rr, withClaims(req, folderId, "rw"), jm)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 OK, got: %d %s", rr.Code, rr.Body.String())
+		}
+		if !strings.Contains(rr.Body.String(), "someFile.txt") {
+			t.Errorf("Expected restored path in response, got: %s", rr.Body.String())
+		}
+		if _, err := os.Stat(filepath.Join(root, "someFile.txt")); err != nil {
+			t.Errorf("Expected file to be restored, got: %v", err)
+		}
+	})
+
+	jm.Wait(context.Background())
+}
+
+// --------------------------------------
+// 		  File Operation Tests
+// --------------------------------------
+func TestRenamePath(t *testing.T) {
+	_, root := createShareFolder(t)
+	writeFile(t, filepath.Join(root, "a.txt"), "a")
+	writeFile(t, filepath.Join(root, "b.txt"), "b")
+
+	if _, err := RenamePath(root, "a.txt", "b.txt", ConflictFail); err != ErrConflict {
+		t.Errorf("Expected ErrConflict, got %v", err)
+	}
+
+	newPath, err := RenamePath(root, "a.txt", "b.txt", ConflictRename)
+	if err != nil || newPath != "b (1).txt" {
+		t.Errorf("Expected rename to b (1).txt, got %s %v", newPath, err)
+	}
+
+	newPath, err = RenamePath(root, "b (1).txt", "b.txt", ConflictOverwrite)
+	if err != nil || newPath != "b.txt" {
+		t.Fatalf("Expected overwrite of b.txt, got %s %v", newPath, err)
+	}
+	content, _ := os.ReadFile(filepath.Join(root, "b.txt"))
+	if string(content) != "a" {
+		t.Errorf("Expected b.txt to hold the renamed content, got %q", content)
+	}
+	items, _ := ListTrash(root)
+	if len(items) != 1 || items[0].OriginalPath != "b.txt" {
+		t.Errorf("Expected overwritten file in trash, got %+v", items)
+	}
+
+	for _, name := range []string{"../c.txt", ".hidden.txt", "noext", "script.exe", "a;b.txt"} {
+		if _, err := RenamePath(root, "b.txt", name, ConflictFail); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Expected ErrInvalidName for %q, got %v", name, err)
+		}
+	}
+}
+
+func TestMoveAndCopyPath(t *testing.T) {
+	_, srcRoot := createShareFolder(t)
+	_, dstRoot := createShareFolder(t)
+	writeFile(t, filepath.Join(srcRoot, "Album", "one.jpg"), "one")
+	writeFile(t, filepath.Join(srcRoot, "Album", "Nested", "two.jpg"), "two")
+
+	if _, err := CreateFolder(dstRoot, "Trips/2024"); err != nil {
+		t.Fatalf("Received unexpected error when creating folder: %v", err)
+	}
+
+	copied, err := CopyPath(srcRoot, "Album", dstRoot, "Trips/2024", ConflictFail)
+	if err != nil || copied != "Trips/2024/Album" {
+		t.Fatalf("Expected folder to be copied, got %s %v", copied, err)
+	}
+	content, err := os.ReadFile(filepath.Join(dstRoot, "Trips", "2024", "Album", "Nested", "two.jpg"))
+	if err != nil || string(content) != "two" {
+		t.Errorf("Expected nested file to be copied, got %q %v", content, err)
+	}
+
+	if _, err := MovePath(srcRoot, "Album", dstRoot, "Trips/2024", ConflictFail); err != ErrConflict {
+		t.Errorf("Expected ErrConflict when moving onto the copy, got %v", err)
+	}
+	moved, err := MovePath(srcRoot, "Album", dstRoot, "Trips/2024", ConflictRename)
+	if err != nil || moved != "Trips/2024/Album (1)" {
+		t.Fatalf("Expected folder to be moved under a new name, got %s %v", moved, err)
+	}
+	if _, err := os.Stat(filepath.Join(srcRoot, "Album")); !os.IsNotExist(err) {
+		t.Error("Expected source folder to be gone after move")
+	}
+
+	if _, err := MovePath(dstRoot, "Trips", dstRoot, "Trips/2024", ConflictFail); err != ErrInvalidMove {
+		t.Errorf("Expected ErrInvalidMove when moving a folder into itself, got %v", err)
+	}
+	if _, err := CreateFolder(dstRoot, "Trips/.hidden"); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Expected ErrInvalidName for hidden folder, got %v", err)
+	}
+}
+
+func TestFileOperationHandlers(t *testing.T) {
+	cfg := config.LoadConfig()
+	folderId, root := createShareFolder(t)
+	writeFile(t, filepath.Join(root, "photo.jpg"), "photo")
+	jm := job.NewJobManager(30 * time.Minute)
+	defer jm.Close()
+
+	t.Run("Rename_Success", func(t *testing.T) {
+		req := jsonRequest(t, http.MethodPost, "/file-rename", RenameFileDetails{FolderId: folderId, Path: "photo.jpg", NewName: "beach.jpg"})
+		rr := httptest.NewRecorder()
+		RenameFileHandler(rr, withClaims(req, folderId, "rw"), jm)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 OK, got: %d %s", rr.Code, rr.Body.String())
+		}
+		if _, err := os.Stat(filepath.Join(root, "beach.jpg")); err != nil {
+			t.Errorf("Expected renamed file to exist, got: %v", err)
+		}
+	})
+
+	t.Run("Rename_Invalid_Conflict_Mode", func(t *testing.T) {
+		req := jsonRequest(t, http.MethodPost, "/file-rename", RenameFileDetails{FolderId: folderId, Path: "beach.jpg", NewName: "sea.jpg", OnConflict: "merge"})
+		rr := httptest.NewRecorder()
+		RenameFileHandler(rr, withClaims(req, folderId, "rw"), jm)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 Bad Request, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Rename_Locked", func(t *testing.T) {
+		lockedPath := filepath.Join(root, "beach.jpg")
+		jm.AcquireJob(lockedPath)
+		defer jm.ReleaseJob(lockedPath)
+
+		req := jsonRequest(t, http.MethodPost, "/file-rename", RenameFileDetails{FolderId: folderId, Path: "beach.jpg", NewName: "sea.jpg"})
+		rr := httptest.NewRecorder()
+		RenameFileHandler(rr, withClaims(req, folderId, "rw"), jm)
+
+		if rr.Code != http.StatusConflict {
+			t.Errorf("Expected status 409 Conflict, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Copy_To_Library_Forbidden", func(t *testing.T) {
+		req := jsonRequest(t, http.MethodPost, "/file-copy", TransferFileDetails{FolderId: folderId, Path: "beach.jpg", DestFolderId: "/"})
+		rr := httptest.NewRecorder()
+		CopyFileHandler(rr, withClaims(req, folderId, "rw"), jm)
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 Forbidden, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Move_From_Library_As_Admin", func(t *testing.T) {
+		writeFile(t, filepath.Join(cfg.UploadDir, "library.jpg"), "library")
+		defer os.Remove(filepath.Join(cfg.UploadDir, "library.jpg"))
+
+		transferred := [][2]string{}
+		SetTransferHook(func(folderPath string, path string) {
+			transferred = append(transferred, [2]string{folderPath, path})
+		})
+		defer SetTransferHook(nil)
+
+		req := jsonRequest(t, http.MethodPost, "/file-move", TransferFileDetails{FolderId: "/", Path: "library.jpg", DestFolderId: folderId})
+		rr := httptest.NewRecorder()
+		MoveFileHandler(rr, withClaims(req, "/", "rw"), jm)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 OK, got: %d %s", rr.Code, rr.Body.String())
+		}
+		if _, err := os.Stat(filepath.Join(root, "library.jpg")); err != nil {
+			t.Errorf("Expected file to be moved into the share, got: %v", err)
+		}
+		if len(transferred) != 1 || transferred[0] != [2]string{root, filepath.Join(root, "library.jpg")} {
+			t.Errorf("Expected the transfer hook to see the moved file, got %v", transferred)
+		}
+	})
+
+	t.Run("Create_Folder", func(t *testing.T) {
+		req := jsonRequest(t, http.MethodPost, "/folder-create", CreateFolderDetails{FolderId: folderId, Path: "Holidays"})
+		rr := httptest.NewRecorder()
+		CreateFolderHandler(rr, withClaims(req, folderId, "rw"))
+
+		if rr.Code != http.StatusCreated {
+			t.Errorf("Expected status 201 Created, got: %d %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	jm.Wait(context.Background())
+}
+
+func TestCloneFile(t *testing.T) {
+	_, root := createShareFolder(t)
+	writeFile(t, filepath.Join(root, "source.txt"), "content")
+
+	method, err := CloneFile(filepath.Join(root, "source.txt"), filepath.Join(root, "clone.txt"))
+	if err != nil {
+		t.Fatalf("Received unexpected error when cloning file: %v", err)
+	}
+	if method != AttachHardlink && method != AttachReflink && method != AttachCopy {
+		t.Errorf("Unexpected clone method: %s", method)
+	}
+	content, _ := os.ReadFile(filepath.Join(root, "clone.txt"))
+	if string(content) != "content" {
+		t.Errorf("Expected cloned content, got %q", content)
+	}
+
+	if _, err := CloneFile(filepath.Join(root, "source.txt"), filepath.Join(root, "clone.txt")); err != ErrConflict {
+		t.Errorf("Expected ErrConflict when cloning onto an existing file, got %v", err)
+	}
+}
+
+func TestAttachLibraryFiles(t *testing.T) {
+	cfg := config.LoadConfig()
+	_, root := createShareFolder(t)
+	writeFile(t, filepath.Join(cfg.UploadDir, "A", "same.txt"), "a")
+	writeFile(t, filepath.Join(cfg.UploadDir, "B", "same.txt"), "b")
+	defer os.RemoveAll(filepath.Join(cfg.UploadDir, "A"))
+	defer os.RemoveAll(filepath.Join(cfg.UploadDir, "B"))
+
+	if _, err := AttachLibraryFiles(root, []string{"A"}, ConflictFail); !errors.Is(err, ErrNotRegularFile) {
+		t.Errorf("Expected ErrNotRegularFile when attaching a folder, got %v", err)
+	}
+
+	attached, err := AttachLibraryFiles(root, []string{"A/same.txt", "B/same.txt"}, ConflictRename)
+	if err != nil {
+		t.Fatalf("Received unexpected error when attaching files: %v", err)
+	}
+	if len(attached) != 2 || attached[0].Path != "same.txt" || attached[1].Path != "same (1).txt" {
+		t.Errorf("Expected both files attached under unique names, got %+v", attached)
+	}
+}
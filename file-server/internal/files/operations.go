@@ -0,0 +1,308 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/google/uuid"
+
+	"file-server/internal/helpers"
+)
+
+type ConflictMode string
+
+const (
+	ConflictFail      ConflictMode = "fail"      // Leave the destination alone and report the conflict
+	ConflictOverwrite ConflictMode = "overwrite" // Move the existing destination to the trash and replace it
+	ConflictRename    ConflictMode = "rename"    // Save as `name (1).ext` next to the existing destination
+)
+
+var (
+	ErrConflict     = errors.New("destination already exists")
+	ErrInvalidName  = errors.New("invalid name")
+	ErrInvalidMove  = errors.New("cannot move a folder into itself")
+	ErrNotDirectory = errors.New("destination is not a folder")
+)
+
+// ParseConflictMode validates the on_conflict value of a request, defaulting to fail.
+func ParseConflictMode(mode string) (ConflictMode, error) {
+	switch ConflictMode(mode) {
+	case "":
+		return ConflictFail, nil
+	case ConflictFail, ConflictOverwrite, ConflictRename:
+		return ConflictMode(mode), nil
+	}
+	return "", fmt.Errorf("invalid on_conflict value: %s", mode)
+}
+
+// ValidateName checks a new file or folder name with the same rules uploads go through.
+func ValidateName(name string, isDir bool) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("%w: %s", ErrInvalidName, name)
+	}
+
+	var err error
+	if isDir {
+		err = helpers.ValidateFolderName(name)
+	} else {
+		ext := filepath.Ext(name)
+		err = helpers.ValidateFileName(strings.TrimSuffix(name, ext), ext)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidName, err)
+	}
+	return nil
+}
+
+// ResolveDir is SafeJoin for folders, where an empty path is root itself.
+func ResolveDir(root string, relPath string) (string, error) {
+	if strings.Trim(filepath.ToSlash(relPath), "/.") == "" {
+		return root, nil
+	}
+	return SafeJoin(root, relPath)
+}
+
+// RenamePath renames the file or folder at relPath under root to newName,
+// keeping it in the same folder. Returns its new path relative to root.
+func RenamePath(root string, relPath string, newName string, mode ConflictMode) (string, error) {
+	sourcePath, err := SafeJoin(root, relPath)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Lstat(sourcePath)
+	if err != nil {
+		return "", err
+	}
+	if err := ValidateName(newName, info.IsDir()); err != nil {
+		return "", err
+	}
+
+	targetPath := filepath.Join(filepath.Dir(sourcePath), newName)
+	if targetPath != sourcePath {
+		if targetPath, err = resolveTarget(root, targetPath, mode); err != nil {
+			return "", err
+		}
+		if err := os.Rename(sourcePath, targetPath); err != nil {
+			return "", err
+		}
+		syncDir(filepath.Dir(targetPath))
+	}
+	return relativePath(root, targetPath)
+}
+
+// MovePath moves the file or folder at srcRel under srcRoot into the folder
+// dstDirRel under dstRoot. Returns its new path relative to dstRoot.
+func MovePath(srcRoot string, srcRel string, dstRoot string, dstDirRel string, mode ConflictMode) (string, error) {
+	sourcePath, targetPath, err := prepareTransfer(srcRoot, srcRel, dstRoot, dstDirRel)
+	if err != nil {
+		return "", err
+	}
+	if targetPath == sourcePath {
+		return relativePath(dstRoot, targetPath)
+	}
+	if targetPath, err = resolveTarget(dstRoot, targetPath, mode); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(sourcePath, targetPath); err != nil {
+		// Sharing folders may be mounted separately from the library
+		if !errors.Is(err, syscall.EXDEV) {
+			return "", err
+		}
+		if err := copyInto(sourcePath, targetPath); err != nil {
+			return "", err
+		}
+		if err := os.RemoveAll(sourcePath); err != nil {
+			return "", err
+		}
+	}
+	syncDir(filepath.Dir(targetPath))
+	return relativePath(dstRoot, targetPath)
+}
+
+// CopyPath copies the file or folder at srcRel under srcRoot into the folder
+// dstDirRel under dstRoot. Returns the path of the copy relative to dstRoot.
+func CopyPath(srcRoot string, srcRel string, dstRoot string, dstDirRel string, mode ConflictMode) (string, error) {
+	sourcePath, targetPath, err := prepareTransfer(srcRoot, srcRel, dstRoot, dstDirRel)
+	if err != nil {
+		return "", err
+	}
+	if targetPath == sourcePath && mode != ConflictRename {
+		return "", ErrConflict
+	}
+	if targetPath, err = resolveTarget(dstRoot, targetPath, mode); err != nil {
+		return "", err
+	}
+
+	if err := copyInto(sourcePath, targetPath); err != nil {
+		return "", err
+	}
+	syncDir(filepath.Dir(targetPath))
+	return relativePath(dstRoot, targetPath)
+}
+
+// CreateFolder creates the folder at relPath under root, along with any
+// missing parents. Returns its path relative to root.
+func CreateFolder(root string, relPath string) (string, error) {
+	folderPath, err := SafeJoin(root, relPath)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := relativePath(root, folderPath)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range strings.Split(rel, "/") {
+		if err := ValidateName(name, true); err != nil {
+			return "", err
+		}
+	}
+
+	if info, err := os.Stat(folderPath); err == nil {
+		if !info.IsDir() {
+			return "", ErrConflict
+		}
+		return rel, nil
+	}
+	if err := os.MkdirAll(folderPath, os.ModePerm); err != nil {
+		return "", err
+	}
+	syncDir(filepath.Dir(folderPath))
+	return rel, nil
+}
+
+// prepareTransfer resolves and checks the source and destination of a move or copy.
+func prepareTransfer(srcRoot string, srcRel string, dstRoot string, dstDirRel string) (string, string, error) {
+	sourcePath, err := SafeJoin(srcRoot, srcRel)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := os.Lstat(sourcePath); err != nil {
+		return "", "", err
+	}
+
+	dstDir, err := ResolveDir(dstRoot, dstDirRel)
+	if err != nil {
+		return "", "", err
+	}
+	info, err := os.Stat(dstDir)
+	if err != nil {
+		return "", "", err
+	}
+	if !info.IsDir() {
+		return "", "", ErrNotDirectory
+	}
+
+	if dstDir == sourcePath || strings.HasPrefix(dstDir, sourcePath+string(filepath.Separator)) {
+		return "", "", ErrInvalidMove
+	}
+	return sourcePath, filepath.Join(dstDir, filepath.Base(sourcePath)), nil
+}
+
+// resolveTarget applies the conflict mode when targetPath already exists and
+// returns the path the operation should write to.
+func resolveTarget(root string, targetPath string, mode ConflictMode) (string, error) {
+	if _, err := os.Lstat(targetPath); os.IsNotExist(err) {
+		return targetPath, nil
+	}
+
+	switch mode {
+	case ConflictRename:
+		return helpers.GetUniqueFileName(targetPath), nil
+	case ConflictOverwrite:
+		rel, err := relativePath(root, targetPath)
+		if err != nil {
+			return "", err
+		}
+		if _, err := MoveToTrash(root, rel); err != nil {
+			return "", err
+		}
+		return targetPath, nil
+	}
+	return "", ErrConflict
+}
+
+// copyInto copies sourcePath to a hidden name next to targetPath and renames
+// it into place once complete, so a failed copy never shows up half written.
+func copyInto(sourcePath string, targetPath string) error {
+	tempPath := filepath.Join(filepath.Dir(targetPath), ".copy-"+uuid.New().String())
+	if err := copyTree(sourcePath, tempPath); err != nil {
+		os.RemoveAll(tempPath)
+		return err
+	}
+	if err := os.Rename(tempPath, targetPath); err != nil {
+		os.RemoveAll(tempPath)
+		return err
+	}
+	return nil
+}
+
+func copyTree(sourcePath string, targetPath string) error {
+	info, err := os.Lstat(sourcePath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return copyFile(sourcePath, targetPath, info.Mode().Perm())
+	}
+
+	if err := os.Mkdir(targetPath, info.Mode().Perm()); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(sourcePath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := copyTree(filepath.Join(sourcePath, entry.Name()), filepath.Join(targetPath, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(sourcePath string, targetPath string, perm os.FileMode) error {
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	target, err := os.OpenFile(targetPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(target, source); err != nil {
+		target.Close()
+		return err
+	}
+	if err := target.Sync(); err != nil {
+		target.Close()
+		return err
+	}
+	return target.Close()
+}
+
+// syncDir flushes a directory entry change (create, rename) to disk.
+func syncDir(dirPath string) {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return
+	}
+	defer dir.Close()
+	_ = dir.Sync()
+}
+
+func relativePath(root string, path string) (string, error) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
@@ -0,0 +1,58 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"file-server/config"
+	"file-server/internal/dedup"
+	"file-server/internal/media"
+)
+
+const TrashDir = ".trash"
+
+var ErrFolderNotFound = errors.New("folder not found")
+
+// ResolveFolder maps a folder_id to the directory it names and the folder id
+// that claims are checked against. An empty id or "/" is the upload library,
+// which only root tokens can access; anything else is a sharing folder.
+func ResolveFolder(folderId string) (string, string, error) {
+	cfg := config.LoadConfig()
+
+	if folderId == "" || folderId == "/" {
+		return cfg.UploadDir, "/", nil
+	}
+	if strings.ContainsAny(folderId, `/\`) || folderId == "." || folderId == ".." {
+		return "", "", fmt.Errorf("invalid folder_id: %s", folderId)
+	}
+
+	root := filepath.Join(cfg.SharingDir, folderId)
+	info, err := os.Stat(root)
+	if err != nil || !info.IsDir() {
+		return "", "", ErrFolderNotFound
+	}
+	return root, folderId, nil
+}
+
+// SafeJoin joins a client supplied relative path onto root, rejecting paths
+// that escape it or point into internal directories (chunks, trash, blob
+// store, thumbnails).
+func SafeJoin(root string, relPath string) (string, error) {
+	cfg := config.LoadConfig()
+
+	cleaned := filepath.Clean("/" + filepath.ToSlash(relPath))
+	if cleaned == "/" {
+		return "", fmt.Errorf("path is required")
+	}
+	cleaned = strings.TrimPrefix(cleaned, "/")
+
+	topLevel := strings.SplitN(cleaned, "/", 2)[0]
+	if topLevel == TrashDir || topLevel == cfg.ChunksDir || topLevel == dedup.CasDir || topLevel == media.ThumbnailDir {
+		return "", fmt.Errorf("invalid path: %s", relPath)
+	}
+
+	return filepath.Join(root, filepath.FromSlash(cleaned)), nil
+}
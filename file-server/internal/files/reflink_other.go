@@ -0,0 +1,12 @@
+//go:build !linux
+
+package files
+
+import (
+	"errors"
+	"os"
+)
+
+func reflink(target *os.File, source *os.File) error {
+	return errors.ErrUnsupported
+}
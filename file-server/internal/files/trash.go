@@ -0,0 +1,221 @@
+package files
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+
+	"file-server/internal/helpers"
+)
+
+const (
+	trashInfoFile = "info.json" // Where the item came from and when it was deleted
+	trashDataName = "data"      // The deleted file or directory itself
+)
+
+var ErrTrashItemNotFound = errors.New("trash item not found")
+
+type TrashItem struct {
+	Id           string    `json:"id"`
+	OriginalPath string    `json:"original_path"`
+	DeletedAt    time.Time `json:"deleted_at"`
+	IsDir        bool      `json:"is_dir"`
+	Size         int64     `json:"size"`
+}
+
+// MoveToTrash moves relPath under root into `<root>/.trash/<id>/data`, recording
+// its original location alongside so that it can be restored.
+func MoveToTrash(root string, relPath string) (TrashItem, error) {
+	sourcePath, err := SafeJoin(root, relPath)
+	if err != nil {
+		return TrashItem{}, err
+	}
+	info, err := os.Lstat(sourcePath)
+	if err != nil {
+		return TrashItem{}, err
+	}
+	size, err := pathSize(sourcePath)
+	if err != nil {
+		return TrashItem{}, err
+	}
+
+	rel, err := filepath.Rel(root, sourcePath)
+	if err != nil {
+		return TrashItem{}, err
+	}
+	item := TrashItem{
+		Id:           uuid.New().String(),
+		OriginalPath: filepath.ToSlash(rel),
+		DeletedAt:    time.Now().UTC(),
+		IsDir:        info.IsDir(),
+		Size:         size,
+	}
+
+	itemDir := filepath.Join(root, TrashDir, item.Id)
+	if err := os.MkdirAll(itemDir, os.ModePerm); err != nil {
+		return TrashItem{}, err
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return TrashItem{}, err
+	}
+	if err := os.WriteFile(filepath.Join(itemDir, trashInfoFile), data, 0644); err != nil {
+		os.RemoveAll(itemDir)
+		return TrashItem{}, err
+	}
+
+	// Trash lives under the same root, so this is a rename and not a copy
+	if err := os.Rename(sourcePath, filepath.Join(itemDir, trashDataName)); err != nil {
+		os.RemoveAll(itemDir)
+		return TrashItem{}, err
+	}
+	return item, nil
+}
+
+// ListTrash returns the items in the trash of root, most recently deleted first.
+func ListTrash(root string) ([]TrashItem, error) {
+	entries, err := os.ReadDir(filepath.Join(root, TrashDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []TrashItem{}, nil
+		}
+		return nil, err
+	}
+
+	items := []TrashItem{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		item, err := readTrashItem(root, entry.Name())
+		if err != nil {
+			log.Printf("[FILE-SERVER] Skipping unreadable trash item %s: %v", entry.Name(), err)
+			continue
+		}
+		items = append(items, item)
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].DeletedAt.After(items[j].DeletedAt)
+	})
+	return items, nil
+}
+
+// RestoreFromTrash moves a trashed item back to its original path, or to a
+// `name (1).ext` variant if that path has been taken since. Returns the
+// path, relative to root, that the item was restored to.
+func RestoreFromTrash(root string, id string) (string, error) {
+	item, err := readTrashItem(root, id)
+	if err != nil {
+		return "", err
+	}
+
+	targetPath, err := SafeJoin(root, item.OriginalPath)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(targetPath), os.ModePerm); err != nil {
+		return "", err
+	}
+	targetPath = helpers.GetUniqueFileName(targetPath)
+
+	itemDir := filepath.Join(root, TrashDir, id)
+	if err := os.Rename(filepath.Join(itemDir, trashDataName), targetPath); err != nil {
+		return "", err
+	}
+	if err := os.RemoveAll(itemDir); err != nil {
+		log.Printf("[FILE-SERVER] Error removing trash item %s: %v", itemDir, err)
+	}
+
+	rel, err := filepath.Rel(root, targetPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// PurgeTrash permanently deletes items that have been in the trash of root
+// for longer than retention. Returns the purged items.
+func PurgeTrash(root string, retention time.Duration) ([]TrashItem, error) {
+	items, err := ListTrash(root)
+	if err != nil {
+		return nil, err
+	}
+
+	purged := []TrashItem{}
+	for _, item := range items {
+		if time.Since(item.DeletedAt) < retention {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(root, TrashDir, item.Id)); err != nil {
+			log.Printf("[FILE-SERVER] Error purging trash item %s: %v", item.Id, err)
+			continue
+		}
+		purged = append(purged, item)
+	}
+	return purged, nil
+}
+
+// PurgeExpiredTrash runs PurgeTrash over every root and logs what was freed.
+func PurgeExpiredTrash(roots []string, retention time.Duration) {
+	for _, root := range roots {
+		purged, err := PurgeTrash(root, retention)
+		if err != nil {
+			log.Printf("[FILE-SERVER] Error purging trash of %s: %v", root, err)
+			continue
+		}
+		var freed int64
+		for _, item := range purged {
+			freed += item.Size
+		}
+		if len(purged) > 0 {
+			log.Printf("[FILE-SERVER] Purged %d items from trash of %s, freed %d bytes", len(purged), root, freed)
+		}
+	}
+}
+
+func readTrashItem(root string, id string) (TrashItem, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return TrashItem{}, ErrTrashItemNotFound
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, TrashDir, id, trashInfoFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return TrashItem{}, ErrTrashItemNotFound
+		}
+		return TrashItem{}, err
+	}
+
+	var item TrashItem
+	if err := json.Unmarshal(data, &item); err != nil {
+		return TrashItem{}, fmt.Errorf("invalid trash info: %w", err)
+	}
+	return item, nil
+}
+
+func pathSize(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,99 @@
+package helpers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"file-server/config"
+)
+
+var (
+	fileNameRegex      = regexp.MustCompile(`^[a-zA-Z0-9._ -\(\)\-]+$`)
+	fileExtensionRegex = regexp.MustCompile(`^\.(jpe?g|png|pdf|docx|doc|xlsx|xls|pptx|ppt|txt|csv|rtf|odt|ods|odp|heic|webp|gif|bmp|tiff?|mp3|wav|m4a|aac|flac|ogg|mp4|m4v|mov|mkv|avi|flv|wmv|webm|zip|rar|7z|tar|gz|iso|epub|azw3|mobi|ics|vcf|psd|ai|svg|html|css|js|json|xml)$`)
+)
+
+// fileCategories groups the accepted extensions by kind of file.
+var fileCategories = map[string][]string{
+	"image":    {".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tif", ".tiff", ".heic", ".webp", ".svg", ".psd", ".ai"},
+	"video":    {".mp4", ".m4v", ".mov", ".mkv", ".avi", ".flv", ".wmv", ".webm"},
+	"audio":    {".mp3", ".wav", ".m4a", ".aac", ".flac", ".ogg"},
+	"document": {".pdf", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx", ".txt", ".csv", ".rtf", ".odt", ".ods", ".odp", ".epub", ".azw3", ".mobi"},
+	"archive":  {".zip", ".rar", ".7z", ".tar", ".gz", ".iso"},
+}
+
+// CategoryExtensions returns the extensions of a category (image, video,
+// audio, document or archive), false if there is no such category.
+func CategoryExtensions(category string) ([]string, bool) {
+	extensions, ok := fileCategories[category]
+	return extensions, ok
+}
+
+// FileCategory returns the category of an extension, "" when it has none.
+func FileCategory(extension string) string {
+	extension = strings.ToLower(extension)
+	for category, extensions := range fileCategories {
+		for _, candidate := range extensions {
+			if candidate == extension {
+				return category
+			}
+		}
+	}
+	return ""
+}
+
+// ValidateFileName checks a file name and extension against the formats accepted for uploads.
+func ValidateFileName(fileName string, fileExtension string) error {
+	if !fileNameRegex.MatchString(fileName) {
+		return fmt.Errorf("invalid file name format: %s", fileName)
+	}
+	if !fileExtensionRegex.MatchString(strings.ToLower(fileExtension)) {
+		return fmt.Errorf("invalid file extension: %s", fileExtension)
+	}
+	return nil
+}
+
+// ValidateFolderName checks a folder name against the characters accepted in file names.
+func ValidateFolderName(folderName string) error {
+	if !fileNameRegex.MatchString(folderName) || strings.HasPrefix(folderName, ".") {
+		return fmt.Errorf("invalid folder name format: %s", folderName)
+	}
+	return nil
+}
+
+// GetUniqueFileName returns path, or `name (1).ext`, `name (2).ext`... if it is already taken.
+func GetUniqueFileName(path string) string {
+	counter := 1
+	dir := filepath.Dir(path)
+	ext := filepath.Ext(path)
+	baseName := strings.TrimSuffix(filepath.Base(path), ext)
+
+	for {
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			return path
+		}
+
+		newName := fmt.Sprintf("%s (%d)%s", baseName, counter, ext)
+		path = filepath.Join(dir, newName)
+		counter++
+	}
+}
+
+// StorageRoots returns every folder files are stored under: the upload
+// directory and each sharing folder.
+func StorageRoots(cfg *config.Config) []string {
+	roots := []string{cfg.UploadDir}
+
+	entries, err := os.ReadDir(cfg.SharingDir)
+	if err != nil {
+		return roots
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			roots = append(roots, filepath.Join(cfg.SharingDir, entry.Name()))
+		}
+	}
+	return roots
+}
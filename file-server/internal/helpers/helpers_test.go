@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,7 +15,7 @@ import (
 	"github.com/google/uuid"
 )
 
-var TestFolder string = GenerateFolderName(0 * time.Second, uuid.New().String())
+var TestFolder string = uuid.New().String()
 
 func TestMain(m *testing.M) {
 	if err := os.MkdirAll(TestFolder, os.ModePerm); err != nil {
@@ -158,19 +159,23 @@ func TestZipFileCreation(t *testing.T) {
 }
 
 
-func TestZipFileCleanup(t *testing.T) {
-	currentDir, err := os.Getwd()
-    if err != nil {
-        t.Fatalf("Error getting current directory: %v", err)
-    }
-    
-	if err := CleanupExpiredFolders(currentDir); err != nil {
-		t.Errorf("Received unexpected error when cleaning expired folders: %v", err)
+func TestGenerateFolderId(t *testing.T) {
+	first, err := GenerateFolderId()
+	if err != nil {
+		t.Fatalf("Received unexpected error when generating folder id: %v", err)
+	}
+	second, err := GenerateFolderId()
+	if err != nil {
+		t.Fatalf("Received unexpected error when generating folder id: %v", err)
 	}
 
-	if _, err := os.Stat(TestFolder); err == nil {
-		t.Errorf("Folder exists, expected for it to be deleted")
-	} else if !os.IsNotExist(err) {
-		t.Errorf("Received unexpected error when checking folder: %v", err)
+	if len(first) != 32 {
+		t.Errorf("Expected folder id of length 32, received %d", len(first))
 	}
-}
\ No newline at end of file
+	if first == second {
+		t.Errorf("Expected unique folder ids, received %s twice", first)
+	}
+	if strings.ContainsAny(first, "_/.") {
+		t.Errorf("Expected opaque folder id, received %s", first)
+	}
+}
@@ -19,4 +19,11 @@ func GenerateRandomSalt() (string, error) {
 		return "", err
 	}
 	return hex.EncodeToString(salt), nil
-}
\ No newline at end of file
+}
+
+// HashToken hashes a generated API token. Tokens are long and random, so they
+// aren't salted, which lets them be looked up by their hash.
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
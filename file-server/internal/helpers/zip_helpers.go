@@ -4,8 +4,10 @@ import (
 	"os"
 	"fmt"
 	"io"
+	"log"
 	"path/filepath"
 	"archive/zip"
+	"strings"
 	
 	"file-server/internal/job"
 )
@@ -66,3 +68,28 @@ func AddFileToZip(zipWriter *zip.Writer, filePath string) error {
 
 	return nil
 }
+
+// RefreshShareZip rebuilds the `<folderId>.zip` archive of a sharing folder.
+func RefreshShareZip(folderPath string, jm *job.JobManager) {
+	entries, err := os.ReadDir(folderPath)
+	if err != nil {
+		log.Printf("[FILE-SERVER] Error while reading directory : %v", err)
+	}
+	files := make([]string, len(entries))
+	for index, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".zip") {
+			continue
+		}
+		files[index] = entry.Name()
+	}
+
+	folderId := filepath.Base(folderPath)
+	zipFileName := fmt.Sprintf("%s.zip", folderId)
+	err = CreateZip(folderPath, zipFileName, files, jm)
+	if err != nil {
+		log.Printf("[FILE-SERVER] Received error while creating zip file : %v", err)
+	} else {
+		log.Printf("[FILE-SERVER] Successfully created zip file : %s", zipFileName)
+		jm.Publish(job.Event{Type: job.EventZipReady, FolderId: folderId, File: zipFileName})
+	}
+}
@@ -0,0 +1,259 @@
+package index
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"file-server/config"
+	"file-server/internal/auth"
+	"file-server/internal/models"
+)
+
+// --------------------------------------
+// 			 Helper Functions
+// --------------------------------------
+var fileEntryColumns = []string{"folder_id", "path", "name", "extension", "size", "mod_time", "content_hash", "captured_at", "indexed_at"}
+
+func createShareFolder(t *testing.T) (string, string) {
+	cfg := config.LoadConfig()
+	folderId := uuid.New().String()
+	folderPath := filepath.Join(cfg.SharingDir, folderId)
+	if err := os.MkdirAll(folderPath, os.ModePerm); err != nil {
+		t.Fatalf("Received unexpected error when creating folder: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(folderPath) })
+	return folderId, folderPath
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		t.Fatalf("Received unexpected error when creating folder: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Received unexpected error when writing file: %v", err)
+	}
+}
+
+func withClaims(req *http.Request, folderId string, access string) *http.Request {
+	claims := jwt.MapClaims{
+		"user_id":   "someRandomUser",
+		"folder_id": folderId,
+		"access":    access,
+		"exp":       time.Now().Add(5 * time.Hour).Unix(),
+	}
+	return req.WithContext(context.WithValue(context.Background(), auth.ClaimsContextKey, claims))
+}
+
+// anyTime matches any time.Time argument
+type anyTime struct{}
+
+func (anyTime) Match(v driver.Value) bool {
+	_, ok := v.(time.Time)
+	return ok
+}
+
+// --------------------------------------
+// 		  Suite Setup - Cleanup
+// --------------------------------------
+func TestMain(m *testing.M) {
+	cfg := config.LoadConfig()
+	if err := os.MkdirAll(cfg.SharingDir, os.ModePerm); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create sharing directory %q: %v\n", cfg.SharingDir, err)
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(cfg.UploadDir, os.ModePerm); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create upload directory %q: %v\n", cfg.UploadDir, err)
+		os.Exit(1)
+	}
+
+	exitCode := m.Run()
+
+	if err := os.RemoveAll(cfg.SharingDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to remove sharing directory %q: %v\n", cfg.SharingDir, err)
+	}
+	if err := os.RemoveAll(cfg.UploadDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to remove upload directory %q: %v\n", cfg.UploadDir, err)
+	}
+	if err := os.RemoveAll("secrets"); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to remove secrets directory %q: %v\n", "secrets", err)
+	}
+
+	os.Exit(exitCode)
+}
+
+// --------------------------------------
+// 			  Indexer Tests
+// --------------------------------------
+func TestDescribeFile(t *testing.T) {
+	folderId, root := createShareFolder(t)
+	filePath := filepath.Join(root, "Album", "Photo.JPG")
+	writeFile(t, filePath, "someContent")
+
+	entry, err := describeFile(root, filePath, nil)
+	if err != nil {
+		t.Fatalf("Received unexpected error when describing file: %v", err)
+	}
+	if entry.FolderId != folderId || entry.Path != "Album/Photo.JPG" || entry.Extension != ".jpg" || entry.Size != 11 {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+	// md5 of "someContent"
+	if entry.ContentHash != "af386900f199dbcb126364e0e2da5124" {
+		t.Errorf("Expected md5 content hash, got %s", entry.ContentHash)
+	}
+
+	prev := entry
+	prev.ContentHash = "previousHash"
+	reused, err := describeFile(root, filePath, &prev)
+	if err != nil {
+		t.Fatalf("Received unexpected error when describing file: %v", err)
+	}
+	if reused.ContentHash != "previousHash" {
+		t.Errorf("Expected hash of unchanged file to be reused, got %s", reused.ContentHash)
+	}
+
+	writeFile(t, filePath, "changedContent")
+	changed, _ := describeFile(root, filePath, &prev)
+	if changed.ContentHash == "previousHash" {
+		t.Error("Expected changed file to be hashed again")
+	}
+}
+
+func TestRescan(t *testing.T) {
+	cfg := config.LoadConfig()
+	folderId, root := createShareFolder(t)
+	writeFile(t, filepath.Join(root, "kept.txt"), "kept")
+	writeFile(t, filepath.Join(root, "Nested", "new.txt"), "new")
+	writeFile(t, filepath.Join(root, folderId+".zip"), "zip")
+	writeFile(t, filepath.Join(root, ".trash", "x", "data"), "trashed")
+	writeFile(t, filepath.Join(root, cfg.ChunksDir, "y", "chunk_0"), "chunk")
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+	}
+	defer db.Close()
+
+	kept, _ := describeFile(root, filepath.Join(root, "kept.txt"), nil)
+
+	mock.ExpectQuery(`SELECT folder_id, path, name, extension, size, mod_time, content_hash, captured_at, indexed_at\s+FROM file_index\s+WHERE folder_id = \$1`).
+		WithArgs(folderId).
+		WillReturnRows(sqlmock.NewRows(fileEntryColumns).
+			AddRow(folderId, "kept.txt", "kept.txt", ".txt", kept.Size, kept.ModTime, "keptHash", nil, time.Now()))
+	mock.ExpectExec(`INSERT INTO file_index`).
+		WithArgs(folderId, "Nested/new.txt", "new.txt", ".txt", int64(3), anyTime{}, sqlmock.AnyArg(), sqlmock.AnyArg(), anyTime{}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO file_index`).
+		WithArgs(folderId, "kept.txt", "kept.txt", ".txt", kept.Size, anyTime{}, "keptHash", sqlmock.AnyArg(), anyTime{}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM file_index WHERE folder_id = \$1 AND indexed_at < \$2`).
+		WithArgs(folderId, anyTime{}).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`DELETE FROM file_index WHERE NOT \(folder_id = ANY\(\$1\)\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := Rescan(context.Background(), db, []string{root}); err != nil {
+		t.Fatalf("Received unexpected error when rescanning: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled database expectations: %v", err)
+	}
+}
+
+// --------------------------------------
+// 			  Search Tests
+// --------------------------------------
+func TestSearchScope(t *testing.T) {
+	folderId := uuid.New().String()
+	claims := func(folderId string, access string) jwt.MapClaims {
+		return jwt.MapClaims{"folder_id": folderId, "access": access}
+	}
+
+	if scope, err := searchScope(claims("/", "rw"), ""); err != nil || scope != nil {
+		t.Errorf("Expected root token to search everywhere, got %v %v", scope, err)
+	}
+	if scope, err := searchScope(claims(folderId, "r"), ""); err != nil || len(scope) != 1 || scope[0] != folderId {
+		t.Errorf("Expected share token to search its folder only, got %v %v", scope, err)
+	}
+	if _, err := searchScope(claims(folderId, "r"), "/"); err == nil {
+		t.Error("Expected share token to be denied the library")
+	}
+	if _, err := searchScope(claims(folderId, "w"), ""); err == nil {
+		t.Error("Expected write only token to be denied search")
+	}
+}
+
+func TestSearchHandler(t *testing.T) {
+	folderId := uuid.New().String()
+
+	t.Run("Search_Invalid_Parameters", func(t *testing.T) {
+		db, _, _ := sqlmock.New()
+		defer db.Close()
+
+		req := httptest.NewRequest(http.MethodGet, "/search?min_size=abc", nil)
+		rr := httptest.NewRecorder()
+		SearchHandler(rr, withClaims(req, "/", "rw"), db)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 Bad Request, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Search_Forbidden_Other_Folder", func(t *testing.T) {
+		db, _, _ := sqlmock.New()
+		defer db.Close()
+
+		req := httptest.NewRequest(http.MethodGet, "/search?folder_id="+uuid.New().String(), nil)
+		rr := httptest.NewRecorder()
+		SearchHandler(rr, withClaims(req, folderId, "r"), db)
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 Forbidden, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Search_Success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+		}
+		defer db.Close()
+
+		capturedAt := time.Date(2023, 7, 14, 16, 30, 5, 0, time.UTC)
+		mock.ExpectQuery(`FROM file_index\s+WHERE folder_id = ANY\(\$1\) AND strpos\(lower\(name\), lower\(\$2\)\) > 0 AND extension = ANY\(\$3\) AND size >= \$4 AND COALESCE\(captured_at, mod_time\) >= \$5 AND COALESCE\(captured_at, mod_time\) <= \$6 ORDER BY COALESCE\(captured_at, mod_time\) DESC, path LIMIT \$7 OFFSET \$8`).
+			WithArgs(sqlmock.AnyArg(), "beach", sqlmock.AnyArg(), int64(100), anyTime{}, anyTime{}, 10, 0).
+			WillReturnRows(sqlmock.NewRows(fileEntryColumns).
+				AddRow(folderId, "beach.jpg", "beach.jpg", ".jpg", int64(2048), time.Now(), "someHash", capturedAt, time.Now()))
+
+		req := httptest.NewRequest(http.MethodGet, "/search?q=beach&type=image&min_size=100&from=2023-01-01&to=2023-12-31&limit=10", nil)
+		rr := httptest.NewRecorder()
+		SearchHandler(rr, withClaims(req, folderId, "r"), db)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 OK, got: %d %s", rr.Code, rr.Body.String())
+		}
+		var response struct {
+			Files []models.FileEntry `json:"files"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Received unexpected error when parsing response body: %v", err)
+		}
+		if len(response.Files) != 1 || response.Files[0].CapturedAt == nil || !response.Files[0].CapturedAt.Equal(capturedAt) {
+			t.Errorf("Unexpected search results: %+v", response.Files)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Unfulfilled database expectations: %v", err)
+		}
+	})
+}
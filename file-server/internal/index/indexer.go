@@ -0,0 +1,193 @@
+package index
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"file-server/config"
+	"file-server/internal/media"
+	"file-server/internal/models"
+	"file-server/internal/repositories"
+)
+
+// FolderId returns the id files under root are indexed with: "/" for the
+// upload directory and the share id for sharing folders.
+func FolderId(root string) string {
+	cfg := config.LoadConfig()
+	if filepath.Clean(root) == filepath.Clean(cfg.UploadDir) {
+		return "/"
+	}
+	return filepath.Base(root)
+}
+
+// IndexFile records the metadata of filePath, a file stored under root.
+func IndexFile(db *sql.DB, root string, filePath string) error {
+	entry, err := describeFile(root, filePath, nil)
+	if err != nil {
+		return err
+	}
+	return repositories.UpsertFileEntry(db, entry)
+}
+
+// Rescan brings the index in line with the disk: new and changed files under
+// every root are indexed and entries of files or folders that are gone are
+// dropped. Unchanged files (same size and mtime) are not read again.
+func Rescan(ctx context.Context, db *sql.DB, roots []string) error {
+	folderIds := []string{}
+	for _, root := range roots {
+		if err := rescanRoot(ctx, db, root); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("[FILE-SERVER] Error rescanning %s: %v", root, err)
+		}
+		folderIds = append(folderIds, FolderId(root))
+	}
+	return repositories.DeleteFileEntriesNotIn(db, folderIds)
+}
+
+func rescanRoot(ctx context.Context, db *sql.DB, root string) error {
+	folderId := FolderId(root)
+	scanStart := time.Now().UTC()
+
+	existing, err := repositories.ListFileEntries(db, folderId)
+	if err != nil {
+		return err
+	}
+	previous := make(map[string]models.FileEntry, len(existing))
+	for _, entry := range existing {
+		previous[entry.Path] = entry
+	}
+
+	indexed := 0
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if path == root {
+			return nil
+		}
+		if skipEntry(root, path, d) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		var prev *models.FileEntry
+		if entry, ok := previous[filepath.ToSlash(rel)]; ok {
+			prev = &entry
+		}
+
+		entry, err := describeFile(root, path, prev)
+		if err != nil {
+			log.Printf("[FILE-SERVER] Error indexing %s: %v", path, err)
+			return nil
+		}
+		if err := repositories.UpsertFileEntry(db, entry); err != nil {
+			return err
+		}
+		indexed++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Anything the walk didn't touch no longer exists
+	if err := repositories.DeleteStaleFileEntries(db, folderId, scanStart); err != nil {
+		return err
+	}
+	log.Printf("[FILE-SERVER] Indexed %d files in %s", indexed, root)
+	return nil
+}
+
+// skipEntry leaves out hidden entries (trash, temp files), chunk directories
+// and the zip archive of a sharing folder.
+func skipEntry(root string, path string, d fs.DirEntry) bool {
+	cfg := config.LoadConfig()
+
+	name := d.Name()
+	if strings.HasPrefix(name, ".") {
+		return true
+	}
+	if filepath.Dir(path) != root {
+		return false
+	}
+	if d.IsDir() && name == cfg.ChunksDir {
+		return true
+	}
+	return !d.IsDir() && name == FolderId(root)+".zip"
+}
+
+// describeFile collects the index entry of filePath. The hash and capture
+// date of prev are reused when the file hasn't changed since.
+func describeFile(root string, filePath string, prev *models.FileEntry) (models.FileEntry, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return models.FileEntry{}, err
+	}
+	rel, err := filepath.Rel(root, filePath)
+	if err != nil {
+		return models.FileEntry{}, err
+	}
+
+	name := info.Name()
+	entry := models.FileEntry{
+		FolderId:  FolderId(root),
+		Path:      filepath.ToSlash(rel),
+		Name:      name,
+		Extension: strings.ToLower(filepath.Ext(name)),
+		Size:      info.Size(),
+		ModTime:   info.ModTime().UTC().Truncate(time.Microsecond), // Postgres precision
+		IndexedAt: time.Now().UTC(),
+	}
+
+	if prev != nil && prev.Size == entry.Size && prev.ModTime.Equal(entry.ModTime) && prev.ContentHash != "" {
+		entry.ContentHash = prev.ContentHash
+		entry.CapturedAt = prev.CapturedAt
+		return entry, nil
+	}
+
+	if entry.ContentHash, err = hashFile(filePath); err != nil {
+		return models.FileEntry{}, err
+	}
+	if capturedAt, err := media.CaptureTime(filePath); err == nil {
+		capturedAt = capturedAt.UTC()
+		entry.CapturedAt = &capturedAt
+	}
+	return entry, nil
+}
+
+func hashFile(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,175 @@
+package index
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"file-server/internal/auth"
+	"file-server/internal/helpers"
+	"file-server/internal/models"
+	"file-server/internal/repositories"
+)
+
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+)
+
+type SearchResponse struct {
+	Files  []models.FileEntry `json:"files"`
+	Limit  int                `json:"limit"`
+	Offset int                `json:"offset"`
+}
+
+// SearchHandler looks up indexed files by name, type, size and date. Results
+// are limited to the folders the caller's token can read.
+func SearchHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claimsRaw := r.Context().Value(auth.ClaimsContextKey)
+	claims, ok := claimsRaw.(jwt.MapClaims)
+	if !ok {
+		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		return
+	}
+
+	folderIds, err := searchScope(claims, r.URL.Query().Get("folder_id"))
+	if err != nil {
+		http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+		return
+	}
+
+	search, err := parseSearchQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	search.FolderIds = folderIds
+
+	entries, err := repositories.SearchFiles(db, search)
+	if err != nil {
+		http.Error(w, "Error while searching files", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SearchResponse{
+		Files:  entries,
+		Limit:  search.Limit,
+		Offset: search.Offset,
+	})
+}
+
+// searchScope returns the folders a search may look into, nil meaning all of
+// them. Root tokens can search everywhere, other tokens only their folder.
+func searchScope(claims jwt.MapClaims, folderId string) ([]string, error) {
+	if folderId != "" {
+		canAccess, err := auth.HasAccess(claims, folderId, "r")
+		if err != nil || !canAccess {
+			return nil, fmt.Errorf("no read access to %s", folderId)
+		}
+		return []string{folderId}, nil
+	}
+
+	if canAccess, _ := auth.HasAccess(claims, "/", "r"); canAccess {
+		return nil, nil
+	}
+
+	claimFolderId, _ := claims["folder_id"].(string)
+	canAccess, err := auth.HasAccess(claims, claimFolderId, "r")
+	if err != nil || !canAccess {
+		return nil, fmt.Errorf("no read access")
+	}
+	return []string{claimFolderId}, nil
+}
+
+func parseSearchQuery(r *http.Request) (repositories.FileSearchQuery, error) {
+	query := r.URL.Query()
+	search := repositories.FileSearchQuery{
+		Name:  strings.TrimSpace(query.Get("q")),
+		Limit: defaultSearchLimit,
+	}
+
+	if types := query.Get("type"); types != "" {
+		for _, fileType := range strings.Split(strings.ToLower(types), ",") {
+			fileType = strings.TrimSpace(fileType)
+			if extensions, ok := helpers.CategoryExtensions(fileType); ok {
+				search.Extensions = append(search.Extensions, extensions...)
+				continue
+			}
+			if fileType != "" {
+				search.Extensions = append(search.Extensions, "."+strings.TrimPrefix(fileType, "."))
+			}
+		}
+	}
+
+	var err error
+	if search.MinSize, err = parseInt(query.Get("min_size")); err != nil {
+		return search, fmt.Errorf("invalid min_size: %w", err)
+	}
+	if search.MaxSize, err = parseInt(query.Get("max_size")); err != nil {
+		return search, fmt.Errorf("invalid max_size: %w", err)
+	}
+	if search.From, err = parseDate(query.Get("from"), false); err != nil {
+		return search, fmt.Errorf("invalid from date: %w", err)
+	}
+	if search.To, err = parseDate(query.Get("to"), true); err != nil {
+		return search, fmt.Errorf("invalid to date: %w", err)
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value <= 0 {
+			return search, fmt.Errorf("invalid limit: %s", limit)
+		}
+		search.Limit = min(value, maxSearchLimit)
+	}
+	if offset := query.Get("offset"); offset != "" {
+		value, err := strconv.Atoi(offset)
+		if err != nil || value < 0 {
+			return search, fmt.Errorf("invalid offset: %s", offset)
+		}
+		search.Offset = value
+	}
+	return search, nil
+}
+
+func parseInt(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err == nil && parsed < 0 {
+		return 0, fmt.Errorf("negative value %d", parsed)
+	}
+	return parsed, err
+}
+
+// parseDate accepts RFC3339 timestamps or plain `2006-01-02` dates. A plain
+// date used as an upper bound covers the whole day.
+func parseDate(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed, nil
+	}
+	parsed, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		parsed = parsed.Add(24*time.Hour - time.Nanosecond)
+	}
+	return parsed, nil
+}
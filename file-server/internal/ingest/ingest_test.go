@@ -0,0 +1,198 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"file-server/config"
+)
+
+func TestMain(m *testing.M) {
+	cfg := config.LoadConfig()
+	if err := os.MkdirAll(cfg.UploadDir, os.ModePerm); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create upload directory %q: %v\n", cfg.UploadDir, err)
+		os.Exit(1)
+	}
+	if err := os.MkdirAll("secrets", os.ModePerm); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create upload directory %q: %v\n", "secrets", err)
+		os.Exit(1)
+	}
+
+	exitCode := m.Run()
+
+	if err := os.RemoveAll(cfg.UploadDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to remove upload directory %q: %v\n", cfg.UploadDir, err)
+	}
+	if err := os.RemoveAll("secrets"); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to remove upload directory %q: %v\n", "secrets", err)
+	}
+
+	os.Exit(exitCode)
+}
+
+// --------------------------------------
+//
+//	Helper Functions
+//
+// --------------------------------------
+
+// buildPhoto returns a minimal JPEG whose IFD0 holds the camera model and date.
+func buildPhoto(model string, dateTime string) []byte {
+	tags := []struct {
+		tag   uint16
+		value string
+	}{{0x0110, model}, {0x0132, dateTime}}
+
+	var tiff bytes.Buffer
+	tiff.WriteString("MM\x00*")
+	binary.Write(&tiff, binary.BigEndian, uint32(8))
+	binary.Write(&tiff, binary.BigEndian, uint16(len(tags)))
+	dataOffset := 8 + 2 + len(tags)*12 + 4
+	var data bytes.Buffer
+	for _, tag := range tags {
+		value := append([]byte(tag.value), 0)
+		binary.Write(&tiff, binary.BigEndian, tag.tag)
+		binary.Write(&tiff, binary.BigEndian, uint16(2))
+		binary.Write(&tiff, binary.BigEndian, uint32(len(value)))
+		binary.Write(&tiff, binary.BigEndian, uint32(dataOffset+data.Len()))
+		data.Write(value)
+	}
+	binary.Write(&tiff, binary.BigEndian, uint32(0))
+	tiff.Write(data.Bytes())
+
+	var jpeg bytes.Buffer
+	jpeg.Write([]byte{0xFF, 0xD8, 0xFF, 0xE1})
+	binary.Write(&jpeg, binary.BigEndian, uint16(tiff.Len()+8))
+	jpeg.WriteString("Exif\x00\x00")
+	jpeg.Write(tiff.Bytes())
+	jpeg.Write([]byte{0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9})
+	return jpeg.Bytes()
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Received unexpected error when writing file: %v", err)
+	}
+}
+
+// --------------------------------------
+//
+//	Rule Tests
+//
+// --------------------------------------
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules(" image=Photos/{yyyy}/{mm}/ ; .PDF=Documents ;*=Other/{camera}")
+	if err != nil {
+		t.Fatalf("Received unexpected error when parsing rules: %v", err)
+	}
+	expected := []Rule{{"image", "Photos/{yyyy}/{mm}"}, {".pdf", "Documents"}, {"*", "Other/{camera}"}}
+	if len(rules) != len(expected) {
+		t.Fatalf("Expected %d rules, got %v", len(expected), rules)
+	}
+	for i := range expected {
+		if rules[i] != expected[i] {
+			t.Errorf("Expected rule %v, got %v", expected[i], rules[i])
+		}
+	}
+
+	if rules, err := ParseRules(""); err != nil || len(rules) != 0 {
+		t.Errorf("Expected no rules for an empty spec, got %v %v", rules, err)
+	}
+
+	invalid := []string{
+		"Photos/{yyyy}",
+		"pictures=Photos",
+		"image=",
+		"image=Photos/{year}",
+		"image=../Photos",
+		"image=Photos/.hidden",
+	}
+	for _, spec := range invalid {
+		if _, err := ParseRules(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	root := t.TempDir()
+	rules, err := ParseRules("image=Photos/{yyyy}/{mm};.pdf=Documents/{yyyy}/{camera}")
+	if err != nil {
+		t.Fatalf("Received unexpected error when parsing rules: %v", err)
+	}
+
+	t.Run("Files_By_Capture_Date", func(t *testing.T) {
+		photoPath := filepath.Join(root, "photo.jpg")
+		writeFile(t, photoPath, buildPhoto("EOS R6", "2023:07:14 18:30:05"))
+
+		newPath, err := Apply(rules, root, photoPath)
+		if err != nil {
+			t.Fatalf("Received unexpected error when applying rules: %v", err)
+		}
+		if expected := filepath.Join(root, "Photos", "2023", "07", "photo.jpg"); newPath != expected {
+			t.Errorf("Expected %s, got %s", expected, newPath)
+		}
+		if _, err := os.Stat(photoPath); !os.IsNotExist(err) {
+			t.Errorf("Expected the original path to be gone")
+		}
+	})
+
+	t.Run("Renames_On_Collision", func(t *testing.T) {
+		photoPath := filepath.Join(root, "photo.jpg")
+		writeFile(t, photoPath, buildPhoto("EOS R6", "2023:07:01 08:00:00"))
+
+		newPath, err := Apply(rules, root, photoPath)
+		if err != nil {
+			t.Fatalf("Received unexpected error when applying rules: %v", err)
+		}
+		if expected := filepath.Join(root, "Photos", "2023", "07", "photo (1).jpg"); newPath != expected {
+			t.Errorf("Expected %s, got %s", expected, newPath)
+		}
+	})
+
+	t.Run("Falls_Back_To_Upload_Time", func(t *testing.T) {
+		pdfPath := filepath.Join(root, "report.pdf")
+		writeFile(t, pdfPath, []byte("%PDF-1.4"))
+		uploadedAt := time.Date(2021, 3, 5, 12, 0, 0, 0, time.Local)
+		if err := os.Chtimes(pdfPath, uploadedAt, uploadedAt); err != nil {
+			t.Fatalf("Received unexpected error when setting file times: %v", err)
+		}
+
+		newPath, err := Apply(rules, root, pdfPath)
+		if err != nil {
+			t.Fatalf("Received unexpected error when applying rules: %v", err)
+		}
+		if expected := filepath.Join(root, "Documents", "2021", unknownValue, "report.pdf"); newPath != expected {
+			t.Errorf("Expected %s, got %s", expected, newPath)
+		}
+	})
+
+	t.Run("Leaves_Unmatched_Files", func(t *testing.T) {
+		songPath := filepath.Join(root, "song.mp3")
+		writeFile(t, songPath, []byte("ID3"))
+
+		newPath, err := Apply(rules, root, songPath)
+		if err != nil || newPath != songPath {
+			t.Errorf("Expected %s to stay in place, got %s %v", songPath, newPath, err)
+		}
+	})
+}
+
+func TestFolderName(t *testing.T) {
+	tests := map[string]string{
+		"Canon EOS R6":      "Canon EOS R6",
+		"iPhone 15 Pro/Max": "iPhone 15 Pro_Max",
+		"..hidden":          "hidden",
+		"":                  unknownValue,
+	}
+	for input, expected := range tests {
+		if got := folderName(input); got != expected {
+			t.Errorf("Expected folder name %q for %q, got %q", expected, input, got)
+		}
+	}
+}
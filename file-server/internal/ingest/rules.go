@@ -0,0 +1,166 @@
+package ingest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"file-server/internal/files"
+	"file-server/internal/helpers"
+	"file-server/internal/media"
+)
+
+const unknownValue = "Unknown"
+
+var (
+	tokenRegex      = regexp.MustCompile(`\{[a-z]+\}`)
+	unsafeNameChars = regexp.MustCompile(`[^a-zA-Z0-9._ ()-]+`)
+)
+
+// Rule files uploads matching Match into the folder Template renders to.
+// Match is a category (image, video...), an extension (.pdf) or "*".
+type Rule struct {
+	Match    string
+	Template string
+}
+
+// ParseRules reads rules written as `image=Photos/{yyyy}/{mm};video=Videos/{yyyy}`.
+// Templates may use {yyyy}, {mm}, {dd}, {make} and {camera}. The first rule
+// matching a file wins.
+func ParseRules(spec string) ([]Rule, error) {
+	rules := []Rule{}
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		match, template, found := strings.Cut(part, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid ingest rule, expected match=template: %s", part)
+		}
+		rule := Rule{
+			Match:    strings.ToLower(strings.TrimSpace(match)),
+			Template: strings.Trim(strings.TrimSpace(template), "/"),
+		}
+
+		if _, ok := helpers.CategoryExtensions(rule.Match); !ok && rule.Match != "*" && !strings.HasPrefix(rule.Match, ".") {
+			return nil, fmt.Errorf("invalid ingest rule match: %s", match)
+		}
+		if err := validateTemplate(rule.Template); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// validateTemplate renders the template with placeholder values and checks
+// each folder of the result is a valid folder name.
+func validateTemplate(template string) error {
+	if template == "" {
+		return fmt.Errorf("empty ingest rule template")
+	}
+	for _, token := range tokenRegex.FindAllString(template, -1) {
+		if _, ok := tokenValue(token, media.Metadata{}); !ok {
+			return fmt.Errorf("unknown ingest template token %s", token)
+		}
+	}
+
+	rendered := render(template, media.Metadata{CapturedAt: time.Now()})
+	for _, name := range strings.Split(rendered, "/") {
+		if err := files.ValidateName(name, true); err != nil {
+			return fmt.Errorf("invalid ingest rule template %s: %w", template, err)
+		}
+	}
+	return nil
+}
+
+func (rule Rule) matches(extension string) bool {
+	extension = strings.ToLower(extension)
+	switch {
+	case rule.Match == "*":
+		return true
+	case strings.HasPrefix(rule.Match, "."):
+		return rule.Match == extension
+	}
+	return helpers.FileCategory(extension) == rule.Match
+}
+
+// Apply moves filePath, a file just stored in root, into the folder of the
+// first matching rule and returns its new path. Files are dated by their
+// capture date, or when it's unknown, by their upload time. Name collisions
+// get a `name (1).ext` style name. Files no rule matches stay where they are.
+func Apply(rules []Rule, root string, filePath string) (string, error) {
+	name := filepath.Base(filePath)
+	var rule *Rule
+	for i := range rules {
+		if rules[i].matches(filepath.Ext(name)) {
+			rule = &rules[i]
+			break
+		}
+	}
+	if rule == nil {
+		return filePath, nil
+	}
+
+	metadata, _ := media.ReadMetadata(filePath)
+	if metadata.CapturedAt.IsZero() {
+		// The file was just written, so its mtime is the upload time
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return filePath, err
+		}
+		metadata.CapturedAt = info.ModTime()
+	}
+
+	rel, err := filepath.Rel(root, filePath)
+	if err != nil {
+		return filePath, err
+	}
+	folder, err := files.CreateFolder(root, render(rule.Template, metadata))
+	if err != nil {
+		return filePath, err
+	}
+	newRel, err := files.MovePath(root, rel, root, folder, files.ConflictRename)
+	if err != nil {
+		return filePath, err
+	}
+	return filepath.Join(root, filepath.FromSlash(newRel)), nil
+}
+
+func render(template string, metadata media.Metadata) string {
+	return tokenRegex.ReplaceAllStringFunc(template, func(token string) string {
+		value, _ := tokenValue(token, metadata)
+		return value
+	})
+}
+
+func tokenValue(token string, metadata media.Metadata) (string, bool) {
+	date := metadata.CapturedAt
+	switch token {
+	case "{yyyy}":
+		return date.Format("2006"), true
+	case "{mm}":
+		return date.Format("01"), true
+	case "{dd}":
+		return date.Format("02"), true
+	case "{make}":
+		return folderName(metadata.CameraMake), true
+	case "{camera}":
+		return folderName(metadata.CameraModel), true
+	}
+	return "", false
+}
+
+// folderName turns a metadata value into something usable as a folder name.
+func folderName(value string) string {
+	value = strings.TrimLeft(strings.TrimSpace(unsafeNameChars.ReplaceAllString(value, "_")), ".")
+	if value == "" {
+		return unknownValue
+	}
+	return value
+}
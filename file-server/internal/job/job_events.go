@@ -0,0 +1,116 @@
+package job
+
+import (
+	"log"
+	"time"
+)
+
+// Events a JobManager publishes, see Event.
+const (
+	EventChunkReceived    = "chunk.received"
+	EventAssemblyStarted  = "assembly.started"
+	EventAssemblyFinished = "assembly.finished"
+	EventAssemblyFailed   = "assembly.failed"
+	EventZipReady         = "zip.ready"
+	EventShareCreated     = "share.created"
+	EventShareExpired     = "share.expired"
+	EventFileAdded        = "file.added"
+	EventUploadCompleted  = "upload.completed"
+	EventChecksumFailed   = "upload.checksum_failed" // The upload didn't match the MD5 or digest it came with
+	EventShareAccessed    = "share.accessed"
+	EventFileDownloaded   = "file.downloaded"
+)
+
+// Events a subscriber can fall behind by before it is disconnected.
+const subscriberBuffer = 64
+
+// Event is something that happened in a folder, pushed to the subscribers
+// of that folder as the jobs doing it complete.
+type Event struct {
+	Id       uint64    `json:"id"`
+	Type     string    `json:"type"`
+	FolderId string    `json:"folder_id"` // "/" for the library
+	UploadId string    `json:"upload_id,omitempty"`
+	File     string    `json:"file,omitempty"`     // Relative to the folder
+	Received int       `json:"received,omitempty"` // Chunks received so far
+	Total    int       `json:"total,omitempty"`    // Chunks in the upload
+	Error    string    `json:"error,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// Subscribe returns the events of folderId, or of every folder for "/", until
+// unsubscribe is called. The channel is closed when the subscription ends,
+// also when the subscriber falls too far behind or the server shuts down; a
+// client should then reconnect and refetch what it shows.
+func (jm *JobManager) Subscribe(folderId string) (events <-chan Event, unsubscribe func()) {
+	jm.subsMu.Lock()
+	defer jm.subsMu.Unlock()
+
+	ch := make(chan Event, subscriberBuffer)
+	if jm.subsClosed {
+		close(ch)
+		return ch, func() {}
+	}
+	jm.subscribers[ch] = folderId
+
+	return ch, func() {
+		jm.subsMu.Lock()
+		defer jm.subsMu.Unlock()
+		if _, ok := jm.subscribers[ch]; ok {
+			delete(jm.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Listen has fn called with every event published from then on, in order.
+// fn runs in the goroutine publishing with the manager locked, so it must
+// return quickly and must not publish itself.
+func (jm *JobManager) Listen(fn func(Event)) {
+	jm.subsMu.Lock()
+	defer jm.subsMu.Unlock()
+	jm.listeners = append(jm.listeners, fn)
+}
+
+// Publish hands event to its listeners and to the subscribers of its folder,
+// without waiting on any of them.
+func (jm *JobManager) Publish(event Event) {
+	jm.subsMu.Lock()
+	defer jm.subsMu.Unlock()
+
+	jm.lastEventId++
+	event.Id = jm.lastEventId
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	for _, fn := range jm.listeners {
+		fn(event)
+	}
+	for ch, folderId := range jm.subscribers {
+		if folderId != "/" && folderId != event.FolderId {
+			continue
+		}
+		select {
+		case ch <- event:
+		default:
+			// Dropping the event would leave the client showing stale state
+			log.Printf("[FILE-SERVER] Disconnecting subscriber of %s, too far behind", folderId)
+			delete(jm.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// EndSubscriptions closes every subscription and refuses new ones, so that
+// event streams don't hold up shutdown.
+func (jm *JobManager) EndSubscriptions() {
+	jm.subsMu.Lock()
+	defer jm.subsMu.Unlock()
+
+	jm.subsClosed = true
+	for ch := range jm.subscribers {
+		delete(jm.subscribers, ch)
+		close(ch)
+	}
+}
@@ -1,6 +1,7 @@
 package job
 
 import (
+	"context"
 	"sync"
 	"time"
 )
@@ -16,6 +17,14 @@ type JobManager struct {
 	mapMu     sync.RWMutex // To protect against race conditions in accessing the jobs map
 	timeout   time.Duration
 	closeChan chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup // Tracks background work started through Go
+
+	subsMu      sync.Mutex
+	subscribers map[chan Event]string // Folder id each subscriber gets the events of
+	listeners   []func(Event)
+	subsClosed  bool
+	lastEventId uint64
 }
 
 func NewJobManager(timeout time.Duration) *JobManager {
@@ -23,6 +32,7 @@ func NewJobManager(timeout time.Duration) *JobManager {
 		jobs:      make(map[string]*Job),
 		timeout:   timeout,
 		closeChan: make(chan struct{}),
+		subscribers: make(map[chan Event]string),
 	}
 	go jm.cleanupStaleJobs(1 * time.Minute)
 	return jm
@@ -56,6 +66,33 @@ func (jm *JobManager) ReleaseJob(jobId string) {
 	}
 }
 
+// Go runs fn in a goroutine tracked by the manager, so that shutdown can
+// wait for assemblies and zip builds instead of killing them mid-write.
+func (jm *JobManager) Go(fn func()) {
+	jm.wg.Add(1)
+	go func() {
+		defer jm.wg.Done()
+		fn()
+	}()
+}
+
+// Wait blocks until every goroutine started through Go has returned, or
+// until ctx is done, in which case the context error is returned.
+func (jm *JobManager) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		jm.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (jm *JobManager) cleanupStaleJobs(tckInterval time.Duration) {
 	ticker := time.NewTicker(tckInterval)
 	defer ticker.Stop()
@@ -77,7 +114,8 @@ func (jm *JobManager) cleanupStaleJobs(tckInterval time.Duration) {
 }
 
 func (jm *JobManager) Close() {
-	close(jm.closeChan)
+	jm.closeOnce.Do(func() { close(jm.closeChan) })
+	jm.EndSubscriptions()
 	jm.mapMu.Lock()
 	defer jm.mapMu.Unlock()
 	for id, _ := range jm.jobs {
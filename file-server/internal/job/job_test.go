@@ -1,6 +1,7 @@
 package job
 
 import (
+	"context"
 	"sync"
 	"testing"
 	"time"
@@ -99,4 +100,105 @@ func TestJobManager(t *testing.T) {
 		}
 		jm.mapMu.RUnlock()
 	})
+
+	t.Run("Test Wait For Background Jobs", func(t *testing.T) {
+		jm := NewJobManager(5 * time.Minute)
+		finished := false
+
+		jm.Go(func() {
+			time.Sleep(20 * time.Millisecond)
+			finished = true
+		})
+
+		if err := jm.Wait(context.Background()); err != nil {
+			t.Errorf("expected Wait to return nil, got %v", err)
+		}
+		if !finished {
+			t.Errorf("expected background job to have finished before Wait returned")
+		}
+		jm.Close()
+	})
+
+	t.Run("Test Wait Deadline", func(t *testing.T) {
+		jm := NewJobManager(5 * time.Minute)
+		release := make(chan struct{})
+
+		jm.Go(func() {
+			<-release
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		if err := jm.Wait(ctx); err != context.DeadlineExceeded {
+			t.Errorf("expected Wait to return %v, got %v", context.DeadlineExceeded, err)
+		}
+		close(release)
+		jm.Close()
+		jm.Close() // Closing twice must not panic
+	})
+}
+
+func TestEvents(t *testing.T) {
+	t.Run("Events_Scoped_By_Folder", func(t *testing.T) {
+		jm := NewJobManager(5 * time.Minute)
+		defer jm.Close()
+
+		all, unsubscribeAll := jm.Subscribe("/")
+		defer unsubscribeAll()
+		share, unsubscribeShare := jm.Subscribe("someShare")
+		defer unsubscribeShare()
+
+		jm.Publish(Event{Type: EventZipReady, FolderId: "someShare"})
+		jm.Publish(Event{Type: EventZipReady, FolderId: "someOtherShare"})
+
+		if event := <-all; event.FolderId != "someShare" || event.Id != 1 || event.Time.IsZero() {
+			t.Errorf("expected the first event with an id and time, got %+v", event)
+		}
+		if event := <-all; event.FolderId != "someOtherShare" || event.Id != 2 {
+			t.Errorf("expected the second event, got %+v", event)
+		}
+		if event := <-share; event.FolderId != "someShare" {
+			t.Errorf("expected the share's event, got %+v", event)
+		}
+		select {
+		case event := <-share:
+			t.Errorf("expected no event of another share, got %+v", event)
+		default:
+		}
+	})
+
+	t.Run("Slow_Subscriber_Disconnected", func(t *testing.T) {
+		jm := NewJobManager(5 * time.Minute)
+		defer jm.Close()
+
+		events, unsubscribe := jm.Subscribe("/")
+		for i := 0; i <= subscriberBuffer; i++ {
+			jm.Publish(Event{Type: EventFileAdded, FolderId: "/"})
+		}
+
+		received := 0
+		for range events {
+			received++
+		}
+		if received != subscriberBuffer {
+			t.Errorf("expected %d buffered events before the channel closed, got %d", subscriberBuffer, received)
+		}
+		unsubscribe() // Must not close the channel twice
+	})
+
+	t.Run("End_Subscriptions", func(t *testing.T) {
+		jm := NewJobManager(5 * time.Minute)
+
+		events, _ := jm.Subscribe("/")
+		jm.EndSubscriptions()
+		if _, ok := <-events; ok {
+			t.Error("expected the subscription to be closed")
+		}
+		late, _ := jm.Subscribe("/")
+		if _, ok := <-late; ok {
+			t.Error("expected no subscriptions after they ended")
+		}
+		jm.Close() // Ends them again
+	})
 }
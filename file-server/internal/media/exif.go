@@ -0,0 +1,267 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	tagMake               = 0x010F
+	tagModel              = 0x0110
+	tagOrientation        = 0x0112
+	tagDateTime           = 0x0132
+	tagExifIFD            = 0x8769
+	tagDateTimeOriginal   = 0x9003
+	tagDateTimeDigitized  = 0x9004
+	tagOffsetTimeOriginal = 0x9011
+
+	typeASCII = 2
+	typeShort = 3
+	typeLong  = 4
+
+	exifDateLayout = "2006:01:02 15:04:05"
+	maxIFDEntries  = 1024 // Guards against corrupt files claiming huge directories
+)
+
+var (
+	ErrNoCaptureTime = errors.New("no capture time found")
+	ErrNoMetadata    = errors.New("no metadata found")
+)
+
+// Metadata is what ingest and the index use from a photo or video.
+type Metadata struct {
+	CapturedAt  time.Time // Zero when unknown
+	CameraMake  string
+	CameraModel string
+}
+
+// CaptureTime reads the date a photo or video was taken from its metadata.
+// Times without a zone are returned as UTC.
+func CaptureTime(path string) (time.Time, error) {
+	metadata, err := ReadMetadata(path)
+	if err != nil || metadata.CapturedAt.IsZero() {
+		return time.Time{}, ErrNoCaptureTime
+	}
+	return metadata.CapturedAt, nil
+}
+
+// ReadCaptureTime is CaptureTime for an already opened file.
+func ReadCaptureTime(r io.ReadSeeker) (time.Time, error) {
+	metadata, err := ReadMetadataFrom(r)
+	if err != nil || metadata.CapturedAt.IsZero() {
+		return time.Time{}, ErrNoCaptureTime
+	}
+	return metadata.CapturedAt, nil
+}
+
+// ReadMetadata reads the capture date and camera of a file. EXIF in JPEG and
+// TIFF based files and QuickTime/MP4 metadata are supported.
+func ReadMetadata(path string) (Metadata, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer file.Close()
+
+	return ReadMetadataFrom(file)
+}
+
+// ReadMetadataFrom is ReadMetadata for an already opened file.
+func ReadMetadataFrom(r io.ReadSeeker) (Metadata, error) {
+	header := make([]byte, 8)
+	n, _ := io.ReadFull(r, header)
+	header = header[:n]
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return Metadata{}, err
+	}
+
+	var metadata Metadata
+	var err error
+	switch {
+	case len(header) >= 2 && header[0] == 0xFF && header[1] == 0xD8:
+		var payload []byte
+		if payload, err = readJPEGExif(r); err == nil {
+			metadata, err = readTIFFMetadata(bytes.NewReader(payload))
+		}
+	case len(header) >= 4 && (string(header[:4]) == "II*\x00" || string(header[:4]) == "MM\x00*"):
+		readerAt, ok := r.(io.ReaderAt)
+		if !ok {
+			return Metadata{}, ErrNoMetadata
+		}
+		metadata, err = readTIFFMetadata(readerAt)
+	case len(header) == 8 && isQuickTimeAtom(string(header[4:8])):
+		size, seekErr := r.Seek(0, io.SeekEnd)
+		readerAt, ok := r.(io.ReaderAt)
+		if seekErr != nil || !ok {
+			return Metadata{}, ErrNoMetadata
+		}
+		metadata, err = readQuickTimeMetadata(readerAt, size)
+	default:
+		return Metadata{}, ErrNoMetadata
+	}
+
+	if err != nil || (metadata.CapturedAt.IsZero() && metadata.CameraModel == "" && metadata.CameraMake == "") {
+		return Metadata{}, ErrNoMetadata
+	}
+	return metadata, nil
+}
+
+// readJPEGExif walks the JPEG segments up to the image data and returns the
+// TIFF structure embedded in the Exif APP1 segment.
+func readJPEGExif(r io.Reader) ([]byte, error) {
+	marker := make([]byte, 4)
+	if _, err := io.ReadFull(r, marker[:2]); err != nil {
+		return nil, ErrNoMetadata
+	}
+
+	for {
+		if _, err := io.ReadFull(r, marker); err != nil {
+			return nil, ErrNoMetadata
+		}
+		if marker[0] != 0xFF {
+			return nil, ErrNoMetadata
+		}
+		// Start of scan, the metadata segments are all before it
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return nil, ErrNoMetadata
+		}
+
+		length := int(binary.BigEndian.Uint16(marker[2:]))
+		if length < 2 {
+			return nil, ErrNoMetadata
+		}
+		segment := make([]byte, length-2)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return nil, ErrNoMetadata
+		}
+
+		if marker[1] == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:], nil
+		}
+	}
+}
+
+type tiffReader struct {
+	r     io.ReaderAt
+	order binary.ByteOrder
+}
+
+type ifdEntry struct {
+	tag      uint16
+	dataType uint16
+	count    uint32
+	value    []byte // The 4 byte value field, holds the offset when the data doesn't fit
+}
+
+func readTIFFMetadata(r io.ReaderAt) (Metadata, error) {
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return Metadata{}, ErrNoMetadata
+	}
+
+	tiff := tiffReader{r: r}
+	switch string(header[:2]) {
+	case "II":
+		tiff.order = binary.LittleEndian
+	case "MM":
+		tiff.order = binary.BigEndian
+	default:
+		return Metadata{}, ErrNoMetadata
+	}
+
+	ifd0, err := tiff.readIFD(int64(tiff.order.Uint32(header[4:])))
+	if err != nil {
+		return Metadata{}, ErrNoMetadata
+	}
+
+	var metadata Metadata
+	metadata.CameraMake, _ = tiff.readString(ifd0[tagMake])
+	metadata.CameraModel, _ = tiff.readString(ifd0[tagModel])
+
+	var dateTime, offset string
+	if entry, ok := ifd0[tagExifIFD]; ok && entry.dataType == typeLong {
+		if exifIFD, err := tiff.readIFD(int64(tiff.order.Uint32(entry.value))); err == nil {
+			for _, tag := range []uint16{tagDateTimeOriginal, tagDateTimeDigitized} {
+				if value, err := tiff.readString(exifIFD[tag]); err == nil && value != "" {
+					dateTime = value
+					break
+				}
+			}
+			offset, _ = tiff.readString(exifIFD[tagOffsetTimeOriginal])
+		}
+	}
+	if dateTime == "" {
+		dateTime, _ = tiff.readString(ifd0[tagDateTime])
+	}
+
+	metadata.CapturedAt, _ = parseExifDate(dateTime, offset)
+	return metadata, nil
+}
+
+func (t tiffReader) readIFD(offset int64) (map[uint16]ifdEntry, error) {
+	countBytes := make([]byte, 2)
+	if _, err := t.r.ReadAt(countBytes, offset); err != nil {
+		return nil, err
+	}
+	count := int(t.order.Uint16(countBytes))
+	if count > maxIFDEntries {
+		return nil, ErrNoMetadata
+	}
+
+	data := make([]byte, count*12)
+	if _, err := t.r.ReadAt(data, offset+2); err != nil {
+		return nil, err
+	}
+
+	entries := make(map[uint16]ifdEntry, count)
+	for i := 0; i < count; i++ {
+		raw := data[i*12 : (i+1)*12]
+		entry := ifdEntry{
+			tag:      t.order.Uint16(raw[0:]),
+			dataType: t.order.Uint16(raw[2:]),
+			count:    t.order.Uint32(raw[4:]),
+			value:    raw[8:12],
+		}
+		entries[entry.tag] = entry
+	}
+	return entries, nil
+}
+
+func (t tiffReader) readString(entry ifdEntry) (string, error) {
+	if entry.dataType != typeASCII || entry.count == 0 || entry.count > 64 {
+		return "", ErrNoMetadata
+	}
+
+	data := entry.value[:min(int(entry.count), 4)]
+	if entry.count > 4 {
+		data = make([]byte, entry.count)
+		if _, err := t.r.ReadAt(data, int64(t.order.Uint32(entry.value))); err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimSpace(strings.TrimRight(string(data), "\x00")), nil
+}
+
+// parseExifDate parses an EXIF `2006:01:02 15:04:05` date, with the zone from
+// an OffsetTime tag (`+02:00`) when there is one.
+func parseExifDate(value string, offset string) (time.Time, error) {
+	if value == "" || strings.HasPrefix(value, "0000") {
+		return time.Time{}, ErrNoCaptureTime
+	}
+
+	if offset != "" {
+		if parsed, err := time.Parse(exifDateLayout+"-07:00", value+offset); err == nil {
+			return parsed, nil
+		}
+	}
+	parsed, err := time.Parse(exifDateLayout, value)
+	if err != nil {
+		return time.Time{}, ErrNoCaptureTime
+	}
+	return parsed, nil
+}
@@ -0,0 +1,450 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// --------------------------------------
+// 			 Helper Functions
+// --------------------------------------
+
+type asciiTag struct {
+	tag   uint16
+	value string
+}
+
+// buildTIFF lays out a TIFF structure with ASCII tags in IFD0 and, when
+// exifTags is not empty, an Exif IFD that IFD0 points to.
+func buildTIFF(order binary.ByteOrder, ifd0Tags []asciiTag, exifTags []asciiTag) []byte {
+	var buf bytes.Buffer
+	if order == binary.LittleEndian {
+		buf.WriteString("II*\x00")
+	} else {
+		buf.WriteString("MM\x00*")
+	}
+	binary.Write(&buf, order, uint32(8))
+
+	ifdSize := func(count int) int { return 2 + count*12 + 4 }
+	ifd0Count := len(ifd0Tags)
+	if len(exifTags) > 0 {
+		ifd0Count++
+	}
+
+	// Data of IFD0 strings follows IFD0, then the Exif IFD and its data
+	dataOffset := 8 + ifdSize(ifd0Count)
+	var ifd0Data bytes.Buffer
+	writeIFD := func(tags []asciiTag, extra func(*bytes.Buffer), count int, data *bytes.Buffer, offset int) {
+		binary.Write(&buf, order, uint16(count))
+		for _, tag := range tags {
+			value := append([]byte(tag.value), 0)
+			binary.Write(&buf, order, tag.tag)
+			binary.Write(&buf, order, uint16(typeASCII))
+			binary.Write(&buf, order, uint32(len(value)))
+			if len(value) <= 4 {
+				padded := make([]byte, 4)
+				copy(padded, value)
+				buf.Write(padded)
+				continue
+			}
+			binary.Write(&buf, order, uint32(offset+data.Len()))
+			data.Write(value)
+		}
+		if extra != nil {
+			extra(&buf)
+		}
+		binary.Write(&buf, order, uint32(0))
+	}
+
+	// Exif IFD position depends on the size of IFD0 data, compute it first
+	ifd0DataLen := 0
+	for _, tag := range ifd0Tags {
+		if len(tag.value)+1 > 4 {
+			ifd0DataLen += len(tag.value) + 1
+		}
+	}
+	exifOffset := dataOffset + ifd0DataLen
+
+	var exifPointer func(*bytes.Buffer)
+	if len(exifTags) > 0 {
+		exifPointer = func(b *bytes.Buffer) {
+			binary.Write(b, order, uint16(tagExifIFD))
+			binary.Write(b, order, uint16(typeLong))
+			binary.Write(b, order, uint32(1))
+			binary.Write(b, order, uint32(exifOffset))
+		}
+	}
+	writeIFD(ifd0Tags, exifPointer, ifd0Count, &ifd0Data, dataOffset)
+	buf.Write(ifd0Data.Bytes())
+
+	if len(exifTags) > 0 {
+		var exifData bytes.Buffer
+		writeIFD(exifTags, nil, len(exifTags), &exifData, exifOffset+ifdSize(len(exifTags)))
+		buf.Write(exifData.Bytes())
+	}
+	return buf.Bytes()
+}
+
+// buildJPEG wraps a TIFF structure in a minimal JPEG with an Exif APP1 segment.
+func buildJPEG(tiff []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0xFF, 0xD8})
+
+	// An unrelated APP0 segment before the Exif one
+	buf.Write([]byte{0xFF, 0xE0, 0x00, 0x07})
+	buf.WriteString("JFIF\x00")
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	buf.Write([]byte{0xFF, 0xE1})
+	binary.Write(&buf, binary.BigEndian, uint16(len(payload)+2))
+	buf.Write(payload)
+
+	buf.Write([]byte{0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9})
+	return buf.Bytes()
+}
+
+// buildAtom wraps payload in a QuickTime atom of the given type.
+func buildAtom(kind string, payload ...[]byte) []byte {
+	var buf bytes.Buffer
+	size := 8
+	for _, part := range payload {
+		size += len(part)
+	}
+	binary.Write(&buf, binary.BigEndian, uint32(size))
+	buf.WriteString(kind)
+	for _, part := range payload {
+		buf.Write(part)
+	}
+	return buf.Bytes()
+}
+
+// buildMovie lays out a minimal MP4 with an `mvhd` creation time, `©mod`
+// user data and, when creationDate is set, a `com.apple.quicktime` key.
+func buildMovie(created uint32, model string, creationDate string) []byte {
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[4:8], created)
+
+	var userModel bytes.Buffer
+	binary.Write(&userModel, binary.BigEndian, uint16(len(model)))
+	binary.Write(&userModel, binary.BigEndian, uint16(0x55C4))
+	userModel.WriteString(model)
+
+	moov := [][]byte{buildAtom("mvhd", mvhd), buildAtom("udta", buildAtom("\xa9mod", userModel.Bytes()))}
+	if creationDate != "" {
+		key := buildAtom("mdta", []byte(keyCreationDate))
+		keys := buildAtom("keys", []byte{0, 0, 0, 0, 0, 0, 0, 1}, key)
+
+		value := append([]byte{0, 0, 0, 1, 0, 0, 0, 0}, creationDate...)
+		item := buildAtom("\x00\x00\x00\x01", buildAtom("data", value))
+		moov = append(moov, buildAtom("meta", buildAtom("hdlr", make([]byte, 25)), keys, buildAtom("ilst", item)))
+	}
+
+	ftyp := buildAtom("ftyp", []byte("qt  \x00\x00\x00\x00qt  "))
+	return append(append(ftyp, buildAtom("moov", moov...)...), buildAtom("mdat", make([]byte, 16))...)
+}
+
+// --------------------------------------
+//
+//	EXIF Tests
+//
+// --------------------------------------
+func TestReadCaptureTime(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected time.Time
+	}{
+		{
+			name: "JPEG_Date_Time_Original_With_Offset",
+			data: buildJPEG(buildTIFF(binary.BigEndian,
+				[]asciiTag{{tagDateTime, "2024:01:01 00:00:00"}},
+				[]asciiTag{{tagDateTimeOriginal, "2023:07:14 18:30:05"}, {tagOffsetTimeOriginal, "+02:00"}})),
+			expected: time.Date(2023, 7, 14, 16, 30, 5, 0, time.UTC),
+		},
+		{
+			name: "JPEG_Little_Endian_No_Offset",
+			data: buildJPEG(buildTIFF(binary.LittleEndian,
+				nil,
+				[]asciiTag{{tagDateTimeOriginal, "2021:12:24 09:00:00"}})),
+			expected: time.Date(2021, 12, 24, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "TIFF_Falls_Back_To_Date_Time",
+			data: buildTIFF(binary.LittleEndian,
+				[]asciiTag{{tagDateTime, "2020:02:29 12:00:00"}},
+				nil),
+			expected: time.Date(2020, 2, 29, 12, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captured, err := ReadCaptureTime(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("Received unexpected error when reading capture time: %v", err)
+			}
+			if !captured.Equal(tt.expected) {
+				t.Errorf("Expected capture time %s, got %s", tt.expected, captured)
+			}
+		})
+	}
+}
+
+func TestReadCaptureTimeMissing(t *testing.T) {
+	inputs := map[string][]byte{
+		"Empty":        {},
+		"Plain_Text":   []byte("just some text"),
+		"JPEG_No_Exif": {0xFF, 0xD8, 0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9},
+		"Truncated":    buildJPEG(buildTIFF(binary.BigEndian, nil, []asciiTag{{tagDateTimeOriginal, "2023:07:14 18:30:05"}}))[:30],
+		"Zeroed_Date":  buildJPEG(buildTIFF(binary.BigEndian, nil, []asciiTag{{tagDateTimeOriginal, "0000:00:00 00:00:00"}})),
+	}
+	for name, data := range inputs {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ReadCaptureTime(bytes.NewReader(data)); err != ErrNoCaptureTime {
+				t.Errorf("Expected ErrNoCaptureTime, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCaptureTimeFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	data := buildJPEG(buildTIFF(binary.BigEndian, nil, []asciiTag{{tagDateTimeOriginal, "2022:05:01 10:11:12"}}))
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Received unexpected error when writing file: %v", err)
+	}
+
+	captured, err := CaptureTime(path)
+	if err != nil {
+		t.Fatalf("Received unexpected error when reading capture time: %v", err)
+	}
+	if !captured.Equal(time.Date(2022, 5, 1, 10, 11, 12, 0, time.UTC)) {
+		t.Errorf("Unexpected capture time: %s", captured)
+	}
+}
+
+func TestReadMetadataCamera(t *testing.T) {
+	data := buildJPEG(buildTIFF(binary.BigEndian,
+		[]asciiTag{{tagMake, "Canon"}, {tagModel, "EOS R6"}},
+		[]asciiTag{{tagDateTimeOriginal, "2023:07:14 18:30:05"}}))
+
+	metadata, err := ReadMetadataFrom(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Received unexpected error when reading metadata: %v", err)
+	}
+	if metadata.CameraMake != "Canon" || metadata.CameraModel != "EOS R6" {
+		t.Errorf("Unexpected camera %q %q", metadata.CameraMake, metadata.CameraModel)
+	}
+}
+
+// --------------------------------------
+//
+//	QuickTime Tests
+//
+// --------------------------------------
+func TestReadQuickTimeMetadata(t *testing.T) {
+	// 2023-07-14 16:30:05 UTC in seconds since 1904
+	movieTime := uint32(time.Date(2023, 7, 14, 16, 30, 5, 0, time.UTC).Sub(quickTimeEpoch) / time.Second)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected time.Time
+	}{
+		{
+			name:     "Movie_Header_Time",
+			data:     buildMovie(movieTime, "Pixel 8", ""),
+			expected: time.Date(2023, 7, 14, 16, 30, 5, 0, time.UTC),
+		},
+		{
+			name:     "Creation_Date_Key_Preferred",
+			data:     buildMovie(movieTime, "Pixel 8", "2022-01-02T10:00:00+0200"),
+			expected: time.Date(2022, 1, 2, 8, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata, err := ReadMetadataFrom(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("Received unexpected error when reading metadata: %v", err)
+			}
+			if !metadata.CapturedAt.Equal(tt.expected) {
+				t.Errorf("Expected capture time %s, got %s", tt.expected, metadata.CapturedAt)
+			}
+			if metadata.CameraModel != "Pixel 8" {
+				t.Errorf("Expected camera model Pixel 8, got %q", metadata.CameraModel)
+			}
+		})
+	}
+
+	if _, err := ReadMetadataFrom(bytes.NewReader(buildMovie(0, "", ""))); err != ErrNoMetadata {
+		t.Errorf("Expected ErrNoMetadata for a movie without metadata, got %v", err)
+	}
+}
+
+// --------------------------------------
+//
+//	Metadata Stripping Tests
+//
+// --------------------------------------
+func pngChunk(kind string, data string) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(len(data)))
+	buf.WriteString(kind)
+	buf.WriteString(data)
+	buf.Write([]byte{1, 2, 3, 4}) // CRC, copied as is
+	return buf.Bytes()
+}
+
+func webpChunk(kind string, data string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(kind)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(data)))
+	buf.WriteString(data)
+	if len(data)%2 == 1 {
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+func buildWebP(chunks ...[]byte) []byte {
+	body := bytes.Join(chunks, nil)
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(body)+4))
+	buf.WriteString("WEBP")
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+func TestStripMetadata(t *testing.T) {
+	exif := buildTIFF(binary.BigEndian, []asciiTag{{tagModel, "EOS R6"}}, nil)
+	tests := []struct {
+		name     string
+		data     []byte
+		expected []byte
+	}{
+		{
+			name:     "JPEG",
+			data:     buildJPEG(exif),
+			expected: []byte("\xFF\xD8\xFF\xE0\x00\x07JFIF\x00\xFF\xDA\x00\x02\xFF\xD9"),
+		},
+		{
+			name: "JPEG_Orientation",
+			data: buildJPEG([]byte("II*\x00\x08\x00\x00\x00\x02\x00" +
+				"\x10\x01\x02\x00\x07\x00\x00\x00\x26\x00\x00\x00" +
+				"\x12\x01\x03\x00\x01\x00\x00\x00\x06\x00\x00\x00" +
+				"\x00\x00\x00\x00EOS R6\x00")),
+			expected: []byte("\xFF\xD8\xFF\xE0\x00\x07JFIF\x00" +
+				"\xFF\xE1\x00\x22Exif\x00\x00MM\x00*\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00\x00\x00\x00\x00" +
+				"\xFF\xDA\x00\x02\xFF\xD9"),
+		},
+		{
+			name: "PNG",
+			data: bytes.Join([][]byte{pngSignature, pngChunk("IHDR", "header"), pngChunk("tEXt", "Author\x00me"),
+				pngChunk("eXIf", string(exif)), pngChunk("IDAT", "pixels"), pngChunk("IEND", "")}, nil),
+			expected: bytes.Join([][]byte{pngSignature, pngChunk("IHDR", "header"), pngChunk("IDAT", "pixels"), pngChunk("IEND", "")}, nil),
+		},
+		{
+			name:     "WebP",
+			data:     buildWebP(webpChunk("VP8X", "\x0C\x00\x00\x00\x00\x00\x00\x00\x00\x00"), webpChunk("VP8 ", "pixels!"), webpChunk("EXIF", string(exif)), webpChunk("XMP ", "<x/>")),
+			expected: buildWebP(webpChunk("VP8X", "\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"), webpChunk("VP8 ", "pixels!")),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "image")
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatalf("Received unexpected error when writing file: %v", err)
+			}
+			linkPath := path + ".link"
+			if err := os.Link(path, linkPath); err != nil {
+				t.Fatalf("Received unexpected error when linking file: %v", err)
+			}
+
+			stripped, err := StripMetadata(path)
+			if err != nil || !stripped {
+				t.Fatalf("Expected metadata to be stripped, got %v %v", stripped, err)
+			}
+			content, _ := os.ReadFile(path)
+			if !bytes.Equal(content, tt.expected) {
+				t.Errorf("Unexpected stripped content:\n%x\nexpected\n%x", content, tt.expected)
+			}
+			if original, _ := os.ReadFile(linkPath); !bytes.Equal(original, tt.data) {
+				t.Errorf("Expected the hardlinked original to be left untouched")
+			}
+
+			// Nothing left to strip, the file isn't rewritten
+			if stripped, err := StripMetadata(path); err != nil || stripped {
+				t.Errorf("Expected nothing to strip the second time, got %v %v", stripped, err)
+			}
+		})
+	}
+
+	textPath := filepath.Join(t.TempDir(), "notes.txt")
+	os.WriteFile(textPath, []byte("some notes"), 0644)
+	if stripped, err := StripMetadata(textPath); err != nil || stripped {
+		t.Errorf("Expected unsupported files to be left alone, got %v %v", stripped, err)
+	}
+
+	// A chunk claiming more bytes than the RIFF holds
+	truncated := buildWebP(webpChunk("VP8 ", "pixels!"), webpChunk("EXIF", string(exif)))
+	binary.LittleEndian.PutUint32(truncated[32:36], 0xFFFFFFF0)
+	truncatedPath := filepath.Join(t.TempDir(), "truncated.webp")
+	os.WriteFile(truncatedPath, truncated, 0644)
+	if stripped, err := StripMetadata(truncatedPath); err != nil || stripped {
+		t.Errorf("Expected malformed WebP files to be left alone, got %v %v", stripped, err)
+	}
+	if content, _ := os.ReadFile(truncatedPath); !bytes.Equal(content, truncated) {
+		t.Errorf("Expected the malformed WebP file to be left untouched")
+	}
+}
+
+// --------------------------------------
+//
+//	Probe Tests
+//
+// --------------------------------------
+func TestProbe(t *testing.T) {
+	dir := t.TempDir()
+
+	var photo bytes.Buffer
+	png.Encode(&photo, image.NewGray(image.Rect(0, 0, 30, 20)))
+	photoPath := filepath.Join(dir, "photo.png")
+	os.WriteFile(photoPath, photo.Bytes(), 0644)
+
+	info, err := Probe(photoPath)
+	if err != nil || info.Width != 30 || info.Height != 20 {
+		t.Errorf("Expected a 30x20 image, got %+v %v", info, err)
+	}
+
+	// 5 seconds at a 1000 timescale, and a 1920x1080 track
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:16], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:20], 5000)
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[76:80], 1920<<16)
+	binary.BigEndian.PutUint32(tkhd[80:84], 1080<<16)
+	movie := append(buildAtom("ftyp", []byte("isom")), buildAtom("moov", buildAtom("mvhd", mvhd), buildAtom("trak", buildAtom("tkhd", tkhd)))...)
+	moviePath := filepath.Join(dir, "movie.mp4")
+	os.WriteFile(moviePath, movie, 0644)
+
+	info, err = Probe(moviePath)
+	if err != nil || info.Width != 1920 || info.Height != 1080 || info.Duration != 5*time.Second {
+		t.Errorf("Expected a 5s 1920x1080 movie, got %+v %v", info, err)
+	}
+
+	textPath := filepath.Join(dir, "notes.txt")
+	os.WriteFile(textPath, []byte("some notes"), 0644)
+	if _, err := Probe(textPath); err != ErrUnsupportedFormat {
+		t.Errorf("Expected ErrUnsupportedFormat, got %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package media
+
+import (
+	"bufio"
+	"encoding/binary"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"time"
+
+	_ "golang.org/x/image/webp"
+)
+
+// Info is what a gallery shows about a photo or video.
+type Info struct {
+	Width    int           `json:"width,omitempty"`
+	Height   int           `json:"height,omitempty"`
+	Duration time.Duration `json:"-"`
+}
+
+// Probe reads the dimensions of an image (JPEG, PNG, GIF, WebP), or the
+// dimensions and duration of a QuickTime or MP4 video, from its headers.
+func Probe(path string) (Info, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Info{}, err
+	}
+	defer file.Close()
+
+	header := make([]byte, 8)
+	if n, _ := io.ReadFull(file, header); n == 8 && isQuickTimeAtom(string(header[4:8])) {
+		info, err := file.Stat()
+		if err != nil {
+			return Info{}, err
+		}
+		return probeQuickTime(file, info.Size())
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return Info{}, err
+	}
+
+	config, _, err := image.DecodeConfig(bufio.NewReader(file))
+	if err != nil {
+		return Info{}, ErrUnsupportedFormat
+	}
+	return Info{Width: config.Width, Height: config.Height}, nil
+}
+
+// probeQuickTime reads the duration from the movie header and the size of
+// the first track that has one, usually the video track.
+func probeQuickTime(r io.ReaderAt, size int64) (Info, error) {
+	moov, found, err := findAtom(r, 0, size, "moov")
+	if err != nil || !found {
+		return Info{}, ErrUnsupportedFormat
+	}
+
+	var info Info
+	err = walkAtoms(r, moov.start, moov.end, func(a atom) error {
+		switch a.kind {
+		case "mvhd":
+			info.Duration = readMovieDuration(r, a)
+		case "trak":
+			if info.Width > 0 {
+				return nil
+			}
+			tkhd, found, err := findAtom(r, a.start, a.end, "tkhd")
+			if err == nil && found {
+				info.Width, info.Height = readTrackSize(r, tkhd)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Info{}, ErrUnsupportedFormat
+	}
+	return info, nil
+}
+
+func readMovieDuration(r io.ReaderAt, a atom) time.Duration {
+	data := readAtomData(r, a)
+
+	var timescale, duration uint64
+	switch {
+	case len(data) >= 32 && data[0] == 1:
+		timescale = uint64(binary.BigEndian.Uint32(data[20:24]))
+		duration = binary.BigEndian.Uint64(data[24:32])
+	case len(data) >= 20 && data[0] == 0:
+		timescale = uint64(binary.BigEndian.Uint32(data[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(data[16:20]))
+	}
+	if timescale == 0 {
+		return 0
+	}
+	return time.Duration(duration) * time.Second / time.Duration(timescale)
+}
+
+// readTrackSize reads the 16.16 fixed point width and height at the end of a
+// track header.
+func readTrackSize(r io.ReaderAt, a atom) (int, int) {
+	data := readAtomData(r, a)
+	if len(data) < 8 {
+		return 0, 0
+	}
+	end := len(data)
+	return int(binary.BigEndian.Uint32(data[end-8:end-4]) >> 16), int(binary.BigEndian.Uint32(data[end-4:]) >> 16)
+}
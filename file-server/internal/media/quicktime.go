@@ -0,0 +1,264 @@
+package media
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+	"time"
+)
+
+const maxQuickTimeItem = 64 * 1024 // Largest metadata atom read into memory
+
+// QuickTime and MP4 count seconds from 1904-01-01 UTC
+var quickTimeEpoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Metadata keys written by iPhones and most Android phones in `moov/meta`
+const (
+	keyCreationDate = "com.apple.quicktime.creationdate"
+	keyMake         = "com.apple.quicktime.make"
+	keyModel        = "com.apple.quicktime.model"
+)
+
+type atom struct {
+	kind  string
+	start int64 // First byte after the header
+	end   int64
+}
+
+// isQuickTimeAtom tells whether kind is one of the atoms a QuickTime or MP4
+// file starts with.
+func isQuickTimeAtom(kind string) bool {
+	switch kind {
+	case "ftyp", "moov", "mdat", "wide", "free", "skip":
+		return true
+	}
+	return false
+}
+
+// readQuickTimeMetadata reads the capture date and camera of a QuickTime or
+// MP4 file. The `com.apple.quicktime` keys are preferred as they carry the
+// local time zone, then the `©day` user data, then the movie creation time.
+func readQuickTimeMetadata(r io.ReaderAt, size int64) (Metadata, error) {
+	moov, found, err := findAtom(r, 0, size, "moov")
+	if err != nil || !found {
+		return Metadata{}, ErrNoMetadata
+	}
+
+	var metadata Metadata
+	var userDate string
+	var createdAt time.Time
+
+	err = walkAtoms(r, moov.start, moov.end, func(a atom) error {
+		switch a.kind {
+		case "mvhd":
+			createdAt = readMovieHeaderTime(r, a)
+		case "udta":
+			return walkAtoms(r, a.start, a.end, func(item atom) error {
+				switch item.kind {
+				case "\xa9day":
+					userDate = readUserDataString(r, item)
+				case "\xa9mak":
+					metadata.CameraMake = readUserDataString(r, item)
+				case "\xa9mod":
+					metadata.CameraModel = readUserDataString(r, item)
+				}
+				return nil
+			})
+		case "meta":
+			values := readMetadataKeys(r, a)
+			if value := values[keyMake]; value != "" {
+				metadata.CameraMake = value
+			}
+			if value := values[keyModel]; value != "" {
+				metadata.CameraModel = value
+			}
+			if value := values[keyCreationDate]; value != "" {
+				metadata.CapturedAt = parseQuickTimeDate(value)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Metadata{}, ErrNoMetadata
+	}
+
+	if metadata.CapturedAt.IsZero() {
+		metadata.CapturedAt = parseQuickTimeDate(userDate)
+	}
+	if metadata.CapturedAt.IsZero() {
+		metadata.CapturedAt = createdAt
+	}
+	return metadata, nil
+}
+
+// walkAtoms calls fn for each atom between start and end.
+func walkAtoms(r io.ReaderAt, start int64, end int64, fn func(atom) error) error {
+	header := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return err
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		kind := string(header[4:8])
+		headerSize := int64(8)
+
+		switch size {
+		case 0: // Extends to the end of its parent
+			size = end - offset
+		case 1: // 64 bit size follows the type
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if size < headerSize || offset+size > end {
+			return ErrNoMetadata
+		}
+
+		if err := fn(atom{kind: kind, start: offset + headerSize, end: offset + size}); err != nil {
+			return err
+		}
+		offset += size
+	}
+	return nil
+}
+
+func findAtom(r io.ReaderAt, start int64, end int64, kind string) (atom, bool, error) {
+	var found atom
+	ok := false
+	err := walkAtoms(r, start, end, func(a atom) error {
+		if !ok && a.kind == kind {
+			found = a
+			ok = true
+		}
+		return nil
+	})
+	return found, ok, err
+}
+
+func readAtomData(r io.ReaderAt, a atom) []byte {
+	if a.end-a.start > maxQuickTimeItem || a.end <= a.start {
+		return nil
+	}
+	data := make([]byte, a.end-a.start)
+	if _, err := r.ReadAt(data, a.start); err != nil {
+		return nil
+	}
+	return data
+}
+
+// readMovieHeaderTime reads the creation time of an `mvhd` atom.
+func readMovieHeaderTime(r io.ReaderAt, a atom) time.Time {
+	data := readAtomData(r, a)
+	if len(data) < 12 {
+		return time.Time{}
+	}
+
+	var seconds uint64
+	if data[0] == 1 {
+		seconds = binary.BigEndian.Uint64(data[4:12])
+	} else {
+		seconds = uint64(binary.BigEndian.Uint32(data[4:8]))
+	}
+	// Many encoders leave this at zero rather than leaving it out
+	if seconds == 0 {
+		return time.Time{}
+	}
+	return quickTimeEpoch.Add(time.Duration(seconds) * time.Second)
+}
+
+// readUserDataString reads a `©xyz` user data item: a 16 bit length and
+// language code followed by the text.
+func readUserDataString(r io.ReaderAt, a atom) string {
+	data := readAtomData(r, a)
+	if len(data) < 4 {
+		return ""
+	}
+	length := int(binary.BigEndian.Uint16(data[:2]))
+	if length > len(data)-4 {
+		length = len(data) - 4
+	}
+	return strings.TrimSpace(strings.TrimRight(string(data[4:4+length]), "\x00"))
+}
+
+// readMetadataKeys reads the `keys` and `ilst` pair of a `meta` atom into a
+// map of key name to text value.
+func readMetadataKeys(r io.ReaderAt, meta atom) map[string]string {
+	values := map[string]string{}
+
+	// MP4 style meta atoms carry a version and flags before their children
+	start := meta.start
+	if prefix := make([]byte, 4); meta.end-meta.start >= 4 {
+		if _, err := r.ReadAt(prefix, start); err == nil && binary.BigEndian.Uint32(prefix) == 0 {
+			start += 4
+		}
+	}
+
+	keys := []string{}
+	var ilst atom
+	hasItems := false
+	walkAtoms(r, start, meta.end, func(a atom) error {
+		switch a.kind {
+		case "keys":
+			keys = readKeyNames(r, a)
+		case "ilst":
+			ilst = a
+			hasItems = true
+		}
+		return nil
+	})
+	if !hasItems {
+		return values
+	}
+
+	walkAtoms(r, ilst.start, ilst.end, func(item atom) error {
+		index := int(binary.BigEndian.Uint32([]byte(item.kind)))
+		if index < 1 || index > len(keys) {
+			return nil
+		}
+		data, found, err := findAtom(r, item.start, item.end, "data")
+		if err != nil || !found {
+			return nil
+		}
+		// Type indicator and locale come before the value
+		value := readAtomData(r, data)
+		if len(value) < 8 || binary.BigEndian.Uint32(value[:4]) != 1 { // 1 is UTF-8 text
+			return nil
+		}
+		values[keys[index-1]] = strings.TrimSpace(string(value[8:]))
+		return nil
+	})
+	return values
+}
+
+func readKeyNames(r io.ReaderAt, a atom) []string {
+	data := readAtomData(r, a)
+	if len(data) < 8 {
+		return nil
+	}
+
+	count := int(binary.BigEndian.Uint32(data[4:8]))
+	keys := []string{}
+	offset := 8
+	for i := 0; i < count && offset+8 <= len(data); i++ {
+		size := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		if size < 8 || offset+size > len(data) {
+			break
+		}
+		keys = append(keys, string(data[offset+8:offset+size]))
+		offset += size
+	}
+	return keys
+}
+
+// parseQuickTimeDate parses the ISO 8601 dates found in QuickTime metadata,
+// e.g. `2023-07-14T18:30:05+0200`.
+func parseQuickTimeDate(value string) time.Time {
+	for _, layout := range []string{"2006-01-02T15:04:05-0700", time.RFC3339, "2006-01-02T15:04:05Z0700", "2006-01-02"} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed
+		}
+	}
+	return time.Time{}
+}
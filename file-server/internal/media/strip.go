@@ -0,0 +1,324 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported image format")
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// PNG chunks holding text, timestamps or EXIF. Everything else, including the
+// colour profile, is needed to display the image as it was.
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+// StripMetadata removes EXIF, XMP, IPTC and comments (GPS position, camera
+// serial, names...) from a JPEG, PNG or WebP file without re-encoding it.
+// The file is rewritten under a new inode, so hardlinks to the original, like
+// the library copy of a file attached to a share, are left untouched. Returns
+// false when there was nothing to remove or the format isn't supported.
+func StripMetadata(path string) (bool, error) {
+	source, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer source.Close()
+
+	info, err := source.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	tempPath := filepath.Join(filepath.Dir(path), ".strip-"+uuid.New().String())
+	temp, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tempPath)
+
+	writer := bufio.NewWriter(temp)
+	stripped, err := StripMetadataTo(writer, bufio.NewReader(source))
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if errors.Is(err, ErrUnsupportedFormat) || (err == nil && !stripped) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := os.Chtimes(tempPath, info.ModTime(), info.ModTime()); err != nil {
+		log.Printf("[FILE-SERVER] Error keeping modification time of %s: %v", path, err)
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// StripMetadataTo copies the image in r to w without its metadata. Returns
+// whether anything was removed, ErrUnsupportedFormat for other kinds of files.
+func StripMetadataTo(w io.Writer, r *bufio.Reader) (bool, error) {
+	header, _ := r.Peek(12)
+	switch {
+	case len(header) >= 2 && header[0] == 0xFF && header[1] == 0xD8:
+		return stripJPEG(w, r)
+	case bytes.HasPrefix(header, pngSignature):
+		return stripPNG(w, r)
+	case len(header) == 12 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WEBP":
+		return stripWebP(w, r)
+	}
+	return false, ErrUnsupportedFormat
+}
+
+// stripJPEG drops the APP1 (EXIF, XMP), APP13 (IPTC) and comment segments,
+// keeping only the EXIF Orientation. Once the scan starts the rest of the file
+// is image data and copied as is.
+func stripJPEG(w io.Writer, r io.Reader) (bool, error) {
+	marker := make([]byte, 4)
+	if _, err := io.ReadFull(r, marker[:2]); err != nil {
+		return false, err
+	}
+	if _, err := w.Write(marker[:2]); err != nil {
+		return false, err
+	}
+
+	stripped := false
+	for {
+		if _, err := io.ReadFull(r, marker[:2]); err != nil {
+			return false, err
+		}
+		if marker[0] != 0xFF {
+			return false, ErrUnsupportedFormat
+		}
+		// Markers without a length, the scan and everything after it
+		if marker[1] == 0xDA || marker[1] == 0xD9 || marker[1] == 0x01 || (marker[1] >= 0xD0 && marker[1] <= 0xD7) {
+			if _, err := w.Write(marker[:2]); err != nil {
+				return false, err
+			}
+			_, err := io.Copy(w, r)
+			return stripped, err
+		}
+
+		if _, err := io.ReadFull(r, marker[2:4]); err != nil {
+			return false, err
+		}
+		length := int64(binary.BigEndian.Uint16(marker[2:4]))
+		if length < 2 {
+			return false, ErrUnsupportedFormat
+		}
+
+		if marker[1] == 0xE1 {
+			segment := make([]byte, length-2)
+			if _, err := io.ReadFull(r, segment); err != nil {
+				return false, err
+			}
+			// Viewers rotate photos by their Orientation, so it is kept in an EXIF of its own
+			exif := orientationExif(segment)
+			if !bytes.Equal(exif, segment) {
+				stripped = true
+			}
+			if exif == nil {
+				continue
+			}
+			binary.BigEndian.PutUint16(marker[2:4], uint16(len(exif)+2))
+			if _, err := w.Write(marker); err != nil {
+				return false, err
+			}
+			if _, err := w.Write(exif); err != nil {
+				return false, err
+			}
+			continue
+		}
+		if marker[1] == 0xED || marker[1] == 0xFE {
+			if _, err := io.CopyN(io.Discard, r, length-2); err != nil {
+				return false, err
+			}
+			stripped = true
+			continue
+		}
+		if _, err := w.Write(marker); err != nil {
+			return false, err
+		}
+		if _, err := io.CopyN(w, r, length-2); err != nil {
+			return false, err
+		}
+	}
+}
+
+// orientationExif returns an Exif APP1 payload holding nothing but the
+// Orientation of the one in segment, nil when the photo is upright or segment
+// isn't EXIF.
+func orientationExif(segment []byte) []byte {
+	payload, ok := bytes.CutPrefix(segment, []byte("Exif\x00\x00"))
+	if !ok || len(payload) < 8 {
+		return nil
+	}
+	tiff := tiffReader{r: bytes.NewReader(payload)}
+	switch string(payload[:2]) {
+	case "II":
+		tiff.order = binary.LittleEndian
+	case "MM":
+		tiff.order = binary.BigEndian
+	default:
+		return nil
+	}
+	ifd0, err := tiff.readIFD(int64(tiff.order.Uint32(payload[4:8])))
+	if err != nil {
+		return nil
+	}
+	entry, ok := ifd0[tagOrientation]
+	if !ok || entry.dataType != typeShort || entry.count != 1 {
+		return nil
+	}
+	orientation := tiff.order.Uint16(entry.value)
+	if orientation < 2 || orientation > 8 {
+		return nil
+	}
+
+	// A big endian TIFF header, then IFD0 with the single tag and no next IFD
+	var exif bytes.Buffer
+	exif.WriteString("Exif\x00\x00MM\x00*")
+	binary.Write(&exif, binary.BigEndian, uint32(8))
+	binary.Write(&exif, binary.BigEndian, uint16(1))
+	binary.Write(&exif, binary.BigEndian, []uint16{tagOrientation, typeShort})
+	binary.Write(&exif, binary.BigEndian, uint32(1))
+	binary.Write(&exif, binary.BigEndian, []uint16{orientation, 0})
+	binary.Write(&exif, binary.BigEndian, uint32(0))
+	return exif.Bytes()
+}
+
+// stripPNG drops the text, time and EXIF chunks. Chunks are copied with their
+// CRC as they were, so nothing needs recomputing.
+func stripPNG(w io.Writer, r io.Reader) (bool, error) {
+	signature := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(r, signature); err != nil {
+		return false, err
+	}
+	if _, err := w.Write(signature); err != nil {
+		return false, err
+	}
+
+	stripped := false
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return false, err
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		kind := string(header[4:8])
+
+		// Data followed by a 4 byte CRC
+		if pngMetadataChunks[kind] {
+			if _, err := io.CopyN(io.Discard, r, length+4); err != nil {
+				return false, err
+			}
+			stripped = true
+			continue
+		}
+		if _, err := w.Write(header); err != nil {
+			return false, err
+		}
+		if _, err := io.CopyN(w, r, length+4); err != nil {
+			return false, err
+		}
+		if kind == "IEND" {
+			return stripped, nil
+		}
+	}
+}
+
+// stripWebP drops the EXIF and XMP chunks of a RIFF WebP file, clears their
+// flags in the VP8X header and fixes up the RIFF size.
+func stripWebP(w io.Writer, r io.Reader) (bool, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return false, err
+	}
+	riffSize := int64(binary.LittleEndian.Uint32(header[4:8]))
+
+	// The RIFF size comes first, so the chunks kept are spooled to a temp file before writing
+	spool, err := os.CreateTemp("", ".strip-webp-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	stripped := false
+	chunkHeader := make([]byte, 8)
+	flags := make([]byte, 1)
+	for remaining := riffSize - 4; remaining >= 8; {
+		if _, err := io.ReadFull(r, chunkHeader); err != nil {
+			return false, err
+		}
+		kind := string(chunkHeader[:4])
+		length := int64(binary.LittleEndian.Uint32(chunkHeader[4:8]))
+		padded := length + length%2
+		if padded > remaining-8 {
+			return false, ErrUnsupportedFormat // Chunk runs past the end of the RIFF
+		}
+		remaining -= 8 + padded
+
+		if kind == "EXIF" || kind == "XMP " {
+			if _, err := io.CopyN(io.Discard, r, padded); err != nil {
+				return false, err
+			}
+			stripped = true
+			continue
+		}
+
+		if _, err := spool.Write(chunkHeader); err != nil {
+			return false, err
+		}
+		if kind == "VP8X" && padded > 0 {
+			if _, err := io.ReadFull(r, flags); err != nil {
+				return false, err
+			}
+			flags[0] &^= 0x08 | 0x04 // EXIF and XMP present flags
+			if _, err := spool.Write(flags); err != nil {
+				return false, err
+			}
+			padded--
+		}
+		if _, err := io.CopyN(spool, r, padded); err != nil {
+			return false, err
+		}
+	}
+
+	size, err := spool.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false, err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	binary.LittleEndian.PutUint32(header[4:8], uint32(size+4))
+	if _, err := w.Write(header); err != nil {
+		return false, err
+	}
+	_, err = io.Copy(w, spool)
+	return stripped, err
+}
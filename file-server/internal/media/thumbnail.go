@@ -0,0 +1,111 @@
+package media
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+	"golang.org/x/image/draw"
+)
+
+// ThumbnailDir caches generated thumbnails at the top of each storage root.
+const ThumbnailDir = ".thumbs"
+
+const (
+	thumbnailQuality   = 80
+	maxThumbnailPixels = 50_000_000 // Decoded images take 4 bytes a pixel and more
+)
+
+var ErrImageTooLarge = errors.New("image too large for a thumbnail")
+
+// Thumbnail returns the path of a JPEG thumbnail of sourcePath, an image
+// stored under root, fitting in a size x size square. Thumbnails are generated
+// on first use and again whenever the image is newer than the cached one.
+func Thumbnail(root string, sourcePath string, size int) (string, error) {
+	sourceInfo, err := os.Stat(sourcePath)
+	if err != nil {
+		return "", err
+	}
+	relPath, err := filepath.Rel(root, sourcePath)
+	if err != nil {
+		return "", err
+	}
+
+	name := md5.Sum([]byte(filepath.ToSlash(relPath)))
+	thumbPath := filepath.Join(root, ThumbnailDir, fmt.Sprintf("%s-%d.jpg", hex.EncodeToString(name[:]), size))
+	if info, err := os.Stat(thumbPath); err == nil && !info.ModTime().Before(sourceInfo.ModTime()) {
+		return thumbPath, nil
+	}
+
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return "", err
+	}
+	defer source.Close()
+
+	// The header is trusted for nothing but refusing, a small file can claim a huge image
+	imgConfig, _, err := image.DecodeConfig(source)
+	if err != nil {
+		return "", ErrUnsupportedFormat
+	}
+	if int64(imgConfig.Width)*int64(imgConfig.Height) > maxThumbnailPixels {
+		return "", ErrImageTooLarge
+	}
+	if _, err := source.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	img, _, err := image.Decode(source)
+	if err != nil {
+		return "", ErrUnsupportedFormat
+	}
+
+	if err := os.MkdirAll(filepath.Dir(thumbPath), os.ModePerm); err != nil {
+		return "", err
+	}
+	tempPath := filepath.Join(filepath.Dir(thumbPath), ".tmp-"+uuid.New().String())
+	temp, err := os.Create(tempPath)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tempPath)
+
+	err = jpeg.Encode(temp, scaleToFit(img, size), &jpeg.Options{Quality: thumbnailQuality})
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(tempPath, thumbPath); err != nil {
+		return "", err
+	}
+	return thumbPath, nil
+}
+
+// scaleToFit shrinks img to fit in a size x size square, keeping its aspect
+// ratio. Smaller images are left as they are.
+func scaleToFit(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= size && height <= size {
+		return img
+	}
+
+	if width >= height {
+		height = max(1, height*size/width)
+		width = size
+	} else {
+		width = max(1, width*size/height)
+		height = size
+	}
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+	return scaled
+}
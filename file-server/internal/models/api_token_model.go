@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// ApiToken is a long-lived bearer token for scripts and sync clients, scoped
+// to a folder ("/" for the upload library) and an access ("r", "w", "rw").
+type ApiToken struct {
+	Id         string     `json:"id"`
+	Username   string     `json:"username"`
+	Name       string     `json:"name"`
+	FolderId   string     `json:"folder_id"`
+	Access     string     `json:"access"`
+	TokenHash  string     `json:"-"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// AppPassword is a password generated for a device or app (e.g. a WebDAV
+// mount), so the account password doesn't have to be stored on it.
+type AppPassword struct {
+	Id           string    `json:"id"`
+	Username     string    `json:"username"`
+	Name         string    `json:"name"`
+	Salt         string    `json:"-"`
+	PasswordHash string    `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+}
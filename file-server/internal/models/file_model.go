@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// FileEntry is the indexed metadata of a stored file. FolderId is "/" for the
+// upload directory and the share id for sharing folders, Path is relative to it.
+type FileEntry struct {
+	FolderId	string     `json:"folder_id"`
+	Path		string     `json:"path"`
+	Name		string     `json:"name"`
+	Extension	string     `json:"extension"`
+	Size		int64      `json:"size"`
+	ModTime		time.Time  `json:"mod_time"`
+	ContentHash	string     `json:"content_hash"` // MD5, same as the hash announced on upload
+	CapturedAt	*time.Time `json:"captured_at,omitempty"`
+	IndexedAt	time.Time  `json:"indexed_at"`
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type SharingUser struct {
 	LinkUrl			string `json:"link_url"`
 	FolderId		string `json:"folder_id"`
@@ -8,4 +10,14 @@ type SharingUser struct {
 	OtpHash			string `json:"otp_hash"`
 	Access			string `json:"access"`
 	Expiration		string `json:"expiration"`
-}
\ No newline at end of file
+}
+
+// Share holds the lifecycle of a sharing folder, the folder on disk is named after FolderId.
+type Share struct {
+	FolderId		string     `json:"folder_id"`
+	FolderName		string     `json:"folder_name"`
+	CreatedAt		time.Time  `json:"created_at"`
+	ExpiresAt		time.Time  `json:"expires_at"`
+	DeletedAt		*time.Time `json:"deleted_at,omitempty"`
+	StripMetadata	bool       `json:"strip_metadata"` // Remove EXIF, GPS and the like from images added to the share
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Statuses of a webhook delivery.
+const (
+	DeliveryPending   = "pending"   // Not sent yet, or failed and retried later
+	DeliveryDelivered = "delivered" // The receiver answered with a 2xx
+	DeliveryFailed    = "failed"    // Given up on after the last attempt
+)
+
+// Webhook is an URL events are posted to, signed with its secret. Only the
+// events listed are sent, all of them when the list is empty.
+type Webhook struct {
+	Id        string    `json:"id"`
+	Url       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is one event sent to a webhook, with how its attempts went.
+type WebhookDelivery struct {
+	Id            string     `json:"id"`
+	WebhookId     string     `json:"webhook_id"`
+	Event         string     `json:"event"`
+	Payload       string     `json:"payload"` // The body sent, as signed
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	ResponseCode  int        `json:"response_code,omitempty"` // Of the last attempt
+	Error         string     `json:"error,omitempty"`         // Of the last attempt
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,181 @@
+// Package notify emails share links to their recipients and keeps the admin
+// posted on uploads to file requests and on shares about to expire, through
+// an SMTP server.
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"file-server/config"
+)
+
+const (
+	sendTimeout   = 30 * time.Second
+	maxRecipients = 50
+)
+
+// Mailer sends plain text emails through an SMTP server.
+type Mailer struct {
+	host      string
+	port      string
+	username  string
+	password  string
+	from      *mail.Address
+	startTLS  bool
+	tlsConfig *tls.Config // Verifies the server against host when nil
+}
+
+func NewMailer(cfg config.SMTPConfig) (*Mailer, error) {
+	if cfg.Host == "" {
+		return nil, errors.New("missing SMTP host")
+	}
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP sender %q: %w", cfg.From, err)
+	}
+	return &Mailer{
+		host:     cfg.Host,
+		port:     cfg.Port,
+		username: cfg.Username,
+		password: cfg.Password,
+		from:     from,
+		startTLS: cfg.StartTLS,
+	}, nil
+}
+
+// ParseRecipients checks a list of email addresses, dropping duplicates.
+func ParseRecipients(addresses []string) ([]*mail.Address, error) {
+	if len(addresses) == 0 {
+		return nil, errors.New("no recipients")
+	}
+	if len(addresses) > maxRecipients {
+		return nil, fmt.Errorf("at most %d recipients are allowed", maxRecipients)
+	}
+
+	seen := map[string]bool{}
+	recipients := []*mail.Address{}
+	for _, address := range addresses {
+		recipient, err := mail.ParseAddress(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %q", address)
+		}
+		key := strings.ToLower(recipient.Address)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		recipients = append(recipients, recipient)
+	}
+	return recipients, nil
+}
+
+// Send emails subject and body to every address in to, in a single message.
+func (m *Mailer) Send(to []string, subject string, body string) error {
+	recipients, err := ParseRecipients(to)
+	if err != nil {
+		return err
+	}
+	message, err := m.message(recipients, subject, body)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(m.host, m.port), sendTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(sendTimeout))
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if m.startTLS {
+		// Refusing beats sending the credentials and the OTPs in the clear
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("SMTP server does not offer STARTTLS")
+		}
+		tlsConfig := m.tlsConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{ServerName: m.host}
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS: %w", err)
+		}
+	}
+	if m.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return fmt.Errorf("SMTP authentication: %w", err)
+		}
+	}
+
+	if err := c.Mail(m.from.Address); err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		if err := c.Rcpt(recipient.Address); err != nil {
+			return fmt.Errorf("recipient %s: %w", recipient.Address, err)
+		}
+	}
+	wc, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := wc.Write(message); err != nil {
+		wc.Close()
+		return err
+	}
+	if err := wc.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// message builds the headers and the quoted-printable body of an email.
+func (m *Mailer) message(recipients []*mail.Address, subject string, body string) ([]byte, error) {
+	to := make([]string, len(recipients))
+	for i, recipient := range recipients {
+		to[i] = recipient.String()
+	}
+	domain := m.from.Address[strings.LastIndex(m.from.Address, "@")+1:]
+
+	var msg bytes.Buffer
+	headers := [][2]string{
+		{"From", m.from.String()},
+		{"To", strings.Join(to, ", ")},
+		// Templates could render a subject over several lines, which would start new headers
+		{"Subject", mime.QEncoding.Encode("utf-8", strings.Join(strings.Fields(subject), " "))},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", "<" + uuid.New().String() + "@" + domain + ">"},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=utf-8"},
+		{"Content-Transfer-Encoding", "quoted-printable"},
+	}
+	for _, header := range headers {
+		fmt.Fprintf(&msg, "%s: %s\r\n", header[0], header[1])
+	}
+	msg.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&msg)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
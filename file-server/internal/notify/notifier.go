@@ -0,0 +1,202 @@
+package notify
+
+import (
+	"database/sql"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"file-server/config"
+	"file-server/internal/job"
+	"file-server/internal/repositories"
+)
+
+const expiryCheckInterval = 15 * time.Minute
+
+// Notifier emails share links on request, and the admin about uploads to
+// file requests, shares whose link lets visitors upload, and about shares
+// about to expire.
+type Notifier struct {
+	db            *sql.DB
+	jm            *job.JobManager
+	mailer        *Mailer
+	templates     *Templates
+	uploadDelay   time.Duration // Uploads within this long of the first are reported together
+	expiryWarning time.Duration // 0 for no warnings
+
+	mu      sync.Mutex
+	pending map[string][]string    // Files uploaded to each share, not reported yet
+	timers  map[string]*time.Timer // Reporting the pending files once the delay is up
+	stopped bool
+	stop    chan struct{}
+}
+
+func NewNotifier(db *sql.DB, jm *job.JobManager, mailer *Mailer, templates *Templates, uploadDelay time.Duration, expiryWarning time.Duration) *Notifier {
+	return &Notifier{
+		db:            db,
+		jm:            jm,
+		mailer:        mailer,
+		templates:     templates,
+		uploadDelay:   uploadDelay,
+		expiryWarning: expiryWarning,
+		pending:       map[string][]string{},
+		timers:        map[string]*time.Timer{},
+		stop:          make(chan struct{}),
+	}
+}
+
+// ShareLinkUrl returns the address of the web interface opening a share link.
+func ShareLinkUrl(linkUrl string) string {
+	cfg := config.LoadConfig()
+	return cfg.DomainOrigin + "/sg-?" + url.Values{"l": {linkUrl}}.Encode()
+}
+
+// Start listens for uploads to shares and checks for shares about to expire
+// until Stop.
+func (n *Notifier) Start() {
+	n.jm.Listen(n.queueUpload)
+	if n.expiryWarning > 0 {
+		go n.watchExpiry()
+	}
+}
+
+// Stop reports the uploads still waiting for their delay and ends the expiry
+// checks. The reports run through the JobManager, so shutdown waits for them.
+func (n *Notifier) Stop() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped {
+		return
+	}
+	n.stopped = true
+	close(n.stop)
+	for _, timer := range n.timers {
+		timer.Stop()
+	}
+	for folderId := range n.pending {
+		n.jm.Go(func() {
+			n.reportUploads(folderId)
+		})
+	}
+}
+
+// SendShareLink emails the link of a share to recipients.
+func (n *Notifier) SendShareLink(recipients []string, data ShareLinkData) error {
+	subject, body, err := n.templates.Render(TemplateShareLink, data)
+	if err != nil {
+		return err
+	}
+	return n.mailer.Send(recipients, subject, body)
+}
+
+// queueUpload holds an upload to a share until the delay since the first one
+// is up, so a batch of files is reported in one email.
+func (n *Notifier) queueUpload(event job.Event) {
+	if event.Type != job.EventUploadCompleted || event.FolderId == "/" {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped {
+		return
+	}
+
+	files, waiting := n.pending[event.FolderId]
+	n.pending[event.FolderId] = append(files, event.File)
+	if !waiting {
+		n.timers[event.FolderId] = time.AfterFunc(n.uploadDelay, func() {
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			// Stop reports the files itself, the JobManager may be closed by now
+			if n.stopped {
+				return
+			}
+			delete(n.timers, event.FolderId)
+			n.jm.Go(func() {
+				n.reportUploads(event.FolderId)
+			})
+		})
+	}
+}
+
+// reportUploads emails the admin the files uploaded to a share since the last
+// report, if the share is a file request.
+func (n *Notifier) reportUploads(folderId string) {
+	n.mu.Lock()
+	files := n.pending[folderId]
+	delete(n.pending, folderId)
+	n.mu.Unlock()
+	if len(files) == 0 {
+		return
+	}
+
+	// Without an upload link the files can only come from the admin
+	isRequest, err := repositories.HasUploadLink(n.db, folderId)
+	if err != nil {
+		log.Printf("[FILE-SERVER] Error reading links of share %s: %v", folderId, err)
+		return
+	}
+	if !isRequest {
+		return
+	}
+	share, err := repositories.GetShare(n.db, folderId)
+	if err != nil {
+		log.Printf("[FILE-SERVER] Error reading share %s: %v", folderId, err)
+		return
+	}
+
+	data := UploadsReceivedData{FolderId: folderId, FolderName: share.FolderName, Files: files}
+	if err := n.notifyAdmin(TemplateUploadsReceived, data); err != nil {
+		log.Printf("[FILE-SERVER] Error reporting uploads to share %s: %v", folderId, err)
+	}
+}
+
+// NotifyExpiringShares warns the admin about every share expiring within the
+// warning period, once per expiry.
+func (n *Notifier) NotifyExpiringShares() {
+	shares, err := repositories.ListSharesExpiringBefore(n.db, time.Now().Add(n.expiryWarning))
+	if err != nil {
+		log.Printf("[FILE-SERVER] Error listing expiring shares: %v", err)
+		return
+	}
+
+	for _, share := range shares {
+		data := ShareExpiringData{FolderId: share.FolderId, FolderName: share.FolderName, ExpiresAt: share.ExpiresAt}
+		if err := n.notifyAdmin(TemplateShareExpiring, data); err != nil {
+			// Tried again on the next check
+			log.Printf("[FILE-SERVER] Error warning about expiry of share %s: %v", share.FolderId, err)
+			continue
+		}
+		if err := repositories.MarkShareExpiryNotified(n.db, share.FolderId, share.ExpiresAt); err != nil {
+			log.Printf("[FILE-SERVER] Error recording expiry warning of share %s: %v", share.FolderId, err)
+		}
+	}
+}
+
+func (n *Notifier) watchExpiry() {
+	ticker := time.NewTicker(expiryCheckInterval)
+	defer ticker.Stop()
+	for {
+		n.NotifyExpiringShares()
+		select {
+		case <-ticker.C:
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+// notifyAdmin emails the admin, at the address stored with their user.
+func (n *Notifier) notifyAdmin(name string, data any) error {
+	cfg := config.LoadConfig()
+	admin, err := repositories.GetUserByUsername(n.db, cfg.User.Username)
+	if err != nil {
+		return err
+	}
+	subject, body, err := n.templates.Render(name, data)
+	if err != nil {
+		return err
+	}
+	return n.mailer.Send([]string{admin.Email}, subject, body)
+}
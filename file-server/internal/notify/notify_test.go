@@ -0,0 +1,568 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"file-server/config"
+	"file-server/internal/job"
+)
+
+// --------------------------------------
+// 			 Helper Functions
+// --------------------------------------
+
+// fakeMessage is an email as the fake server received it.
+type fakeMessage struct {
+	from    string
+	to      []string
+	data    string
+	secured bool // Sent after STARTTLS
+}
+
+// fakeSMTP is a local SMTP server keeping the messages it is sent. It offers
+// STARTTLS when tlsConfig is set and requires AUTH PLAIN when username is.
+type fakeSMTP struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	username  string
+	password  string
+	messages  chan fakeMessage
+}
+
+func newFakeSMTP(t *testing.T, tlsConfig *tls.Config, username string, password string) *fakeSMTP {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Received unexpected error when listening: %v", err)
+	}
+	s := &fakeSMTP{
+		listener:  listener,
+		tlsConfig: tlsConfig,
+		username:  username,
+		password:  password,
+		messages:  make(chan fakeMessage, 10),
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) port() string {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return port
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 fake ESMTP")
+
+	secured, authenticated := false, s.username == ""
+	message := fakeMessage{}
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			lines := []string{"fake"}
+			if s.tlsConfig != nil && !secured {
+				lines = append(lines, "STARTTLS")
+			}
+			if s.username != "" {
+				lines = append(lines, "AUTH PLAIN")
+			}
+			for i, l := range lines {
+				sep := "-"
+				if i == len(lines)-1 {
+					sep = " "
+				}
+				text.PrintfLine("250%s%s", sep, l)
+			}
+		case "STARTTLS":
+			text.PrintfLine("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, secured = tlsConn, true
+			text = textproto.NewConn(conn)
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			decoded, err := base64.StdEncoding.DecodeString(initial)
+			if mechanism != "PLAIN" || err != nil || string(decoded) != "\x00"+s.username+"\x00"+s.password {
+				text.PrintfLine("535 Authentication failed")
+				continue
+			}
+			authenticated = true
+			text.PrintfLine("235 Authenticated")
+		case "MAIL":
+			if !authenticated {
+				text.PrintfLine("530 Authentication required")
+				continue
+			}
+			message = fakeMessage{from: strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>"), secured: secured}
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			message.to = append(message.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := io.ReadAll(text.DotReader())
+			if err != nil {
+				return
+			}
+			message.data = string(data)
+			s.messages <- message
+			text.PrintfLine("250 OK")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("250 OK")
+		}
+	}
+}
+
+// waitMessage returns the next message received, failing after a while.
+func (s *fakeSMTP) waitMessage(t *testing.T) fakeMessage {
+	t.Helper()
+	select {
+	case message := <-s.messages:
+		return message
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected an email to be sent")
+		return fakeMessage{}
+	}
+}
+
+// decode returns the subject and the body of a message.
+func decode(t *testing.T, message fakeMessage) (*mail.Message, string, string) {
+	t.Helper()
+	parsed, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(message.data)))
+	if err != nil {
+		t.Fatalf("Received unexpected error when parsing email: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil {
+		t.Fatalf("Received unexpected error when decoding subject: %v", err)
+	}
+	body, err := io.ReadAll(quotedprintable.NewReader(parsed.Body))
+	if err != nil {
+		t.Fatalf("Received unexpected error when decoding body: %v", err)
+	}
+	return parsed, subject, string(body)
+}
+
+// selfSignedTLS returns a server config for 127.0.0.1 and a client config
+// trusting it.
+func selfSignedTLS(t *testing.T) (*tls.Config, *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Received unexpected error when generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Received unexpected error when creating certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Received unexpected error when parsing certificate: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	server := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	return server, &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+}
+
+func newMailer(t *testing.T, server *fakeSMTP, startTLS bool, username string, password string) *Mailer {
+	t.Helper()
+	mailer, err := NewMailer(config.SMTPConfig{
+		Host:     "127.0.0.1",
+		Port:     server.port(),
+		Username: username,
+		Password: password,
+		From:     "HomeShare <homeshare@example.com>",
+		StartTLS: startTLS,
+	})
+	if err != nil {
+		t.Fatalf("Received unexpected error when creating mailer: %v", err)
+	}
+	return mailer
+}
+
+func newNotifier(t *testing.T, mailer *Mailer, uploadDelay time.Duration, expiryWarning time.Duration) (sqlmock.Sqlmock, *job.JobManager, *Notifier) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Received unexpected error when creating mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	templates, err := LoadTemplates("")
+	if err != nil {
+		t.Fatalf("Received unexpected error when loading templates: %v", err)
+	}
+	jm := job.NewJobManager(time.Minute)
+	t.Cleanup(jm.Close)
+	return mock, jm, NewNotifier(db, jm, mailer, templates, uploadDelay, expiryWarning)
+}
+
+func expectAdmin(mock sqlmock.Sqlmock) {
+	cfg := config.LoadConfig()
+	mock.ExpectQuery(`SELECT username, email, salt, password_hash, folder, access\s+FROM users\s+WHERE username = \$1`).
+		WithArgs(cfg.User.Username).
+		WillReturnRows(sqlmock.NewRows([]string{"username", "email", "salt", "password_hash", "folder", "access"}).
+			AddRow(cfg.User.Username, "owner@example.com", "salt", "hash", "/", "rw"))
+}
+
+func waitJobs(t *testing.T, jm *job.JobManager) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := jm.Wait(ctx); err != nil {
+		t.Fatalf("Expected notifications to finish: %v", err)
+	}
+}
+
+// --------------------------------------
+// 		  Suite Setup - Cleanup
+// --------------------------------------
+func TestMain(m *testing.M) {
+	exitCode := m.Run()
+
+	// Created by config.LoadConfig
+	if err := os.RemoveAll("secrets"); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to remove secrets directory %q: %v\n", "secrets", err)
+	}
+
+	os.Exit(exitCode)
+}
+
+// --------------------------------------
+// 				Tests
+// --------------------------------------
+func TestMailer(t *testing.T) {
+	t.Run("Send", func(t *testing.T) {
+		server := newFakeSMTP(t, nil, "user", "secret")
+		mailer := newMailer(t, server, false, "user", "secret")
+
+		body := "Héllo,\n\nA line long enough to be wrapped by quoted-printable, " + strings.Repeat("word ", 30) + "\n.\nEnd\n"
+		if err := mailer.Send([]string{"Alice <alice@example.com>", "bob@example.com", "ALICE@example.com"}, "Files for\nyou ✓", body); err != nil {
+			t.Fatalf("Received unexpected error when sending: %v", err)
+		}
+
+		message := server.waitMessage(t)
+		if message.from != "homeshare@example.com" {
+			t.Errorf("Expected envelope sender homeshare@example.com, got %q", message.from)
+		}
+		if strings.Join(message.to, ",") != "alice@example.com,bob@example.com" {
+			t.Errorf("Expected each recipient once, got %v", message.to)
+		}
+		parsed, subject, gotBody := decode(t, message)
+		if subject != "Files for you ✓" {
+			t.Errorf("Expected subject on one line, got %q", subject)
+		}
+		if strings.ReplaceAll(gotBody, "\r\n", "\n") != body {
+			t.Errorf("Expected body %q, got %q", body, gotBody)
+		}
+		if to := parsed.Header.Get("To"); !strings.Contains(to, `"Alice" <alice@example.com>`) {
+			t.Errorf("Expected named recipient in To header, got %q", to)
+		}
+		if parsed.Header.Get("Message-Id") == "" || parsed.Header.Get("Date") == "" {
+			t.Error("Expected Message-ID and Date headers")
+		}
+	})
+
+	t.Run("StartTLS", func(t *testing.T) {
+		serverTLS, clientTLS := selfSignedTLS(t)
+		server := newFakeSMTP(t, serverTLS, "user", "secret")
+		mailer := newMailer(t, server, true, "user", "secret")
+		mailer.tlsConfig = clientTLS
+
+		if err := mailer.Send([]string{"alice@example.com"}, "Subject", "Body"); err != nil {
+			t.Fatalf("Received unexpected error when sending: %v", err)
+		}
+		if message := server.waitMessage(t); !message.secured {
+			t.Error("Expected the message to be sent over TLS")
+		}
+	})
+
+	t.Run("StartTLSNotOffered", func(t *testing.T) {
+		server := newFakeSMTP(t, nil, "", "")
+		mailer := newMailer(t, server, true, "", "")
+
+		if err := mailer.Send([]string{"alice@example.com"}, "Subject", "Body"); err == nil {
+			t.Error("Expected refusing to send without STARTTLS")
+		}
+		if len(server.messages) != 0 {
+			t.Error("Expected no message to be sent")
+		}
+	})
+
+	t.Run("WrongPassword", func(t *testing.T) {
+		server := newFakeSMTP(t, nil, "user", "secret")
+		mailer := newMailer(t, server, false, "user", "wrong")
+
+		if err := mailer.Send([]string{"alice@example.com"}, "Subject", "Body"); err == nil {
+			t.Error("Expected an authentication error")
+		}
+	})
+
+	t.Run("InvalidRecipients", func(t *testing.T) {
+		server := newFakeSMTP(t, nil, "", "")
+		mailer := newMailer(t, server, false, "", "")
+
+		for _, to := range [][]string{nil, {"not an email"}, {"alice@example.com\r\nBcc: eve@example.com"}} {
+			if err := mailer.Send(to, "Subject", "Body"); err == nil {
+				t.Errorf("Expected an error for recipients %q", to)
+			}
+		}
+	})
+
+	t.Run("InvalidSender", func(t *testing.T) {
+		if _, err := NewMailer(config.SMTPConfig{Host: "127.0.0.1", Port: "25", From: ""}); err == nil {
+			t.Error("Expected an error without a sender")
+		}
+	})
+}
+
+func TestTemplates(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		templates, err := LoadTemplates("")
+		if err != nil {
+			t.Fatalf("Received unexpected error when loading templates: %v", err)
+		}
+		expiresAt := time.Date(2030, 1, 2, 15, 4, 0, 0, time.UTC)
+		subject, body, err := templates.Render(TemplateShareLink, ShareLinkData{
+			FolderName: "Holidays",
+			Link:       "https://mydomain.com/sg-?l=someLink",
+			Otp:        "123456",
+			CanUpload:  true,
+			ExpiresAt:  expiresAt,
+		})
+		if err != nil {
+			t.Fatalf("Received unexpected error when rendering: %v", err)
+		}
+		if subject != "Holidays has been shared with you" {
+			t.Errorf("Unexpected subject %q", subject)
+		}
+		for _, want := range []string{"https://mydomain.com/sg-?l=someLink", "Password: 123456", "upload", "Wed, 02 Jan 2030 15:04 UTC"} {
+			if !strings.Contains(body, want) {
+				t.Errorf("Expected body to contain %q, got %q", want, body)
+			}
+		}
+
+		subject, _, err = templates.Render(TemplateUploadsReceived, UploadsReceivedData{FolderName: "Holidays", Files: []string{"a.jpg"}})
+		if err != nil || subject != "1 new file in Holidays" {
+			t.Errorf("Unexpected subject %q, error %v", subject, err)
+		}
+		if _, _, err := templates.Render("unknown", nil); err == nil {
+			t.Error("Expected an error for an unknown template")
+		}
+	})
+
+	t.Run("Overrides", func(t *testing.T) {
+		dir := t.TempDir()
+		override := "Subject: Heads up, {{.FolderName}} is ending\n\nBye {{.FolderId}}\n"
+		if err := os.WriteFile(filepath.Join(dir, TemplateShareExpiring+".tmpl"), []byte(override), 0644); err != nil {
+			t.Fatalf("Received unexpected error when writing template: %v", err)
+		}
+		templates, err := LoadTemplates(dir)
+		if err != nil {
+			t.Fatalf("Received unexpected error when loading templates: %v", err)
+		}
+
+		subject, body, err := templates.Render(TemplateShareExpiring, ShareExpiringData{FolderId: "someFolderId", FolderName: "Holidays"})
+		if err != nil {
+			t.Fatalf("Received unexpected error when rendering: %v", err)
+		}
+		if subject != "Heads up, Holidays is ending" || body != "Bye someFolderId\n" {
+			t.Errorf("Expected the override to be used, got %q and %q", subject, body)
+		}
+		// The others keep their default
+		if subject, _, _ := templates.Render(TemplateShareLink, ShareLinkData{FolderName: "Holidays"}); subject != "Holidays has been shared with you" {
+			t.Errorf("Expected default share link template, got subject %q", subject)
+		}
+	})
+
+	t.Run("InvalidOverride", func(t *testing.T) {
+		for _, override := range []string{"No subject line\n", "Subject: {{.Broken\n"} {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, TemplateShareLink+".tmpl"), []byte(override), 0644); err != nil {
+				t.Fatalf("Received unexpected error when writing template: %v", err)
+			}
+			if _, err := LoadTemplates(dir); err == nil {
+				t.Errorf("Expected an error for template %q", override)
+			}
+		}
+	})
+}
+
+func TestNotifier(t *testing.T) {
+	shareColumns := []string{"folder_id", "folder_name", "created_at", "expires_at", "deleted_at", "strip_metadata"}
+
+	t.Run("ShareLink", func(t *testing.T) {
+		server := newFakeSMTP(t, nil, "", "")
+		_, _, n := newNotifier(t, newMailer(t, server, false, "", ""), time.Minute, 0)
+
+		err := n.SendShareLink([]string{"alice@example.com"}, ShareLinkData{
+			FolderName: "Holidays",
+			Link:       ShareLinkUrl("someLink"),
+			Otp:        "123456",
+			ExpiresAt:  time.Now().Add(time.Hour),
+		})
+		if err != nil {
+			t.Fatalf("Received unexpected error when sending link: %v", err)
+		}
+		_, _, body := decode(t, server.waitMessage(t))
+		if !strings.Contains(body, config.LoadConfig().DomainOrigin+"/sg-?l=someLink") || !strings.Contains(body, "123456") {
+			t.Errorf("Expected link and password in body, got %q", body)
+		}
+	})
+
+	t.Run("UploadsBatched", func(t *testing.T) {
+		server := newFakeSMTP(t, nil, "", "")
+		mock, jm, n := newNotifier(t, newMailer(t, server, false, "", ""), 50*time.Millisecond, 0)
+
+		mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM sharing_users WHERE folder_id = \$1 AND access IN \('w', 'rw'\)\)`).
+			WithArgs("someFolderId").
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(`SELECT folder_id, folder_name, created_at, expires_at, deleted_at, strip_metadata\s+FROM shares\s+WHERE folder_id = \$1`).
+			WithArgs("someFolderId").
+			WillReturnRows(sqlmock.NewRows(shareColumns).AddRow("someFolderId", "Requests", time.Now(), time.Now().Add(time.Hour), nil, false))
+		expectAdmin(mock)
+
+		n.Start()
+		defer n.Stop()
+		jm.Publish(job.Event{Type: job.EventUploadCompleted, FolderId: "someFolderId", File: "a.jpg"})
+		jm.Publish(job.Event{Type: job.EventFileAdded, FolderId: "someFolderId", File: "a.jpg"})
+		jm.Publish(job.Event{Type: job.EventUploadCompleted, FolderId: "/", File: "library.jpg"})
+		jm.Publish(job.Event{Type: job.EventUploadCompleted, FolderId: "someFolderId", File: "docs/b.pdf"})
+
+		message := server.waitMessage(t)
+		if strings.Join(message.to, ",") != "owner@example.com" {
+			t.Errorf("Expected the admin to be emailed, got %v", message.to)
+		}
+		_, subject, body := decode(t, message)
+		if subject != "2 new files in Requests" {
+			t.Errorf("Expected one email for both uploads, got subject %q", subject)
+		}
+		if !strings.Contains(body, "a.jpg") || !strings.Contains(body, "docs/b.pdf") || strings.Contains(body, "library.jpg") {
+			t.Errorf("Expected the uploaded files of the share, got %q", body)
+		}
+
+		waitJobs(t, jm)
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Unfulfilled database expectations: %v", err)
+		}
+	})
+
+	t.Run("UploadsNotFileRequest", func(t *testing.T) {
+		server := newFakeSMTP(t, nil, "", "")
+		mock, jm, n := newNotifier(t, newMailer(t, server, false, "", ""), time.Hour, 0)
+
+		mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM sharing_users`).
+			WithArgs("someFolderId").
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+		// Stop reports without waiting for the delay
+		n.queueUpload(job.Event{Type: job.EventUploadCompleted, FolderId: "someFolderId", File: "a.jpg"})
+		n.Stop()
+		waitJobs(t, jm)
+		if timer, ok := n.timers["someFolderId"]; !ok || timer.Stop() {
+			t.Error("Expected the delay to be stopped, it would start a job after shutdown")
+		}
+
+		if len(server.messages) != 0 {
+			t.Error("Expected no email for a share without an upload link")
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Unfulfilled database expectations: %v", err)
+		}
+	})
+
+	t.Run("ExpiringShares", func(t *testing.T) {
+		server := newFakeSMTP(t, nil, "", "")
+		mock, _, n := newNotifier(t, newMailer(t, server, false, "", ""), time.Minute, 24*time.Hour)
+
+		expiresAt := time.Now().Add(3 * time.Hour).UTC().Truncate(time.Second)
+		mock.ExpectQuery(`FROM shares\s+WHERE deleted_at IS NULL AND expires_at > NOW\(\) AND expires_at <= \$1\s+AND expiry_notified_for IS DISTINCT FROM expires_at`).
+			WithArgs(sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(shareColumns).AddRow("someFolderId", "Holidays", time.Now(), expiresAt, nil, false))
+		expectAdmin(mock)
+		mock.ExpectExec(`UPDATE shares SET expiry_notified_for = \$2 WHERE folder_id = \$1`).
+			WithArgs("someFolderId", expiresAt).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		n.NotifyExpiringShares()
+
+		_, subject, body := decode(t, server.waitMessage(t))
+		if !strings.HasPrefix(subject, "Holidays expires on") || !strings.Contains(body, "someFolderId") {
+			t.Errorf("Unexpected expiry warning %q: %q", subject, body)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Unfulfilled database expectations: %v", err)
+		}
+	})
+
+	t.Run("ExpiringSharesSendFailed", func(t *testing.T) {
+		server := newFakeSMTP(t, nil, "", "")
+		mailer := newMailer(t, server, false, "", "")
+		server.listener.Close()
+		mock, _, n := newNotifier(t, mailer, time.Minute, 24*time.Hour)
+
+		mock.ExpectQuery(`FROM shares\s+WHERE deleted_at IS NULL AND expires_at > NOW\(\)`).
+			WithArgs(sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(shareColumns).AddRow("someFolderId", "Holidays", time.Now(), time.Now().Add(time.Hour), nil, false))
+		expectAdmin(mock)
+
+		// Not marked, so the next check tries again
+		n.NotifyExpiringShares()
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Unfulfilled database expectations: %v", err)
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package notify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Templates of the emails sent. Each renders to a `Subject: ...` line, a blank
+// line and the body, and can be overridden by a <name>.tmpl file in the
+// templates directory.
+const (
+	TemplateShareLink       = "share_link"       // Rendered with ShareLinkData
+	TemplateUploadsReceived = "uploads_received" // Rendered with UploadsReceivedData
+	TemplateShareExpiring   = "share_expiring"   // Rendered with ShareExpiringData
+)
+
+const dateLayout = "Mon, 02 Jan 2006 15:04 MST"
+
+var defaultTemplates = map[string]string{
+	TemplateShareLink: `Subject: {{.FolderName}} has been shared with you
+
+Hello,
+
+The folder "{{.FolderName}}" has been shared with you{{if .CanUpload}}, you can also upload files to it{{end}}.
+
+Open it at: {{.Link}}
+{{- if .Otp}}
+Password: {{.Otp}}
+{{- end}}
+
+The link expires on {{date .ExpiresAt}}.
+`,
+	TemplateUploadsReceived: `Subject: {{len .Files}} new file{{if ne (len .Files) 1}}s{{end}} in {{.FolderName}}
+
+Files were uploaded to the share "{{.FolderName}}" ({{.FolderId}}):
+{{range .Files}}
+  - {{.}}
+{{- end}}
+`,
+	TemplateShareExpiring: `Subject: {{.FolderName}} expires on {{date .ExpiresAt}}
+
+The share "{{.FolderName}}" ({{.FolderId}}) expires on {{date .ExpiresAt}}, its files will be deleted then.
+
+Extend its expiry to keep it.
+`,
+}
+
+type ShareLinkData struct {
+	FolderName string
+	Link       string
+	Otp        string
+	CanUpload  bool
+	ExpiresAt  time.Time
+}
+
+type UploadsReceivedData struct {
+	FolderId   string
+	FolderName string
+	Files      []string // Paths relative to the share, in the order they were uploaded
+}
+
+type ShareExpiringData struct {
+	FolderId   string
+	FolderName string
+	ExpiresAt  time.Time
+}
+
+type Templates struct {
+	templates map[string]*template.Template
+}
+
+// LoadTemplates parses the built in templates, replacing those with a file
+// in dir. An empty dir keeps them all.
+func LoadTemplates(dir string) (*Templates, error) {
+	funcs := template.FuncMap{
+		"date": func(t time.Time) string { return t.UTC().Format(dateLayout) },
+	}
+
+	t := &Templates{templates: map[string]*template.Template{}}
+	for name, text := range defaultTemplates {
+		if dir != "" {
+			override, err := os.ReadFile(filepath.Join(dir, name+".tmpl"))
+			switch {
+			case err == nil:
+				text = string(override)
+			case !errors.Is(err, os.ErrNotExist):
+				return nil, err
+			}
+		}
+		if !strings.HasPrefix(text, "Subject:") {
+			return nil, fmt.Errorf("template %s must start with a Subject: line", name)
+		}
+		tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %w", name, err)
+		}
+		t.templates[name] = tmpl
+	}
+	return t, nil
+}
+
+// Render executes the template name with data, split into subject and body.
+func (t *Templates) Render(name string, data any) (string, string, error) {
+	tmpl, ok := t.templates[name]
+	if !ok {
+		return "", "", fmt.Errorf("unknown template %s", name)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", "", err
+	}
+
+	subjectLine, body, _ := strings.Cut(out.String(), "\n")
+	subject, ok := strings.CutPrefix(subjectLine, "Subject:")
+	if !ok {
+		return "", "", fmt.Errorf("template %s did not render a Subject: line", name)
+	}
+	return strings.TrimSpace(subject), strings.TrimLeft(body, "\r\n"), nil
+}
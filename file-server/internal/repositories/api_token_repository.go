@@ -0,0 +1,128 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"file-server/internal/helpers"
+	"file-server/internal/models"
+)
+
+func InitializeApiTokenTable(db *sql.DB) error {
+	createTableQuery := `
+		CREATE TABLE IF NOT EXISTS api_tokens (
+			id TEXT PRIMARY KEY,
+			username TEXT NOT NULL REFERENCES users (username) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			folder TEXT NOT NULL,
+			access TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			expires_at TIMESTAMPTZ,
+			last_used_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`
+	if _, err := db.Exec(createTableQuery); err != nil {
+		return fmt.Errorf("error creating api_tokens table: %w", err)
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_api_tokens_username ON api_tokens (username)`); err != nil {
+		return fmt.Errorf("error creating api_tokens username index: %w", err)
+	}
+	return nil
+}
+
+func CreateApiToken(db *sql.DB, id string, username string, name string, folderId string, access string, token string, expiresAt *time.Time) (models.ApiToken, error) {
+	query := `
+		INSERT INTO api_tokens (id, username, name, folder, access, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING created_at
+	`
+	apiToken := models.ApiToken{
+		Id:        id,
+		Username:  username,
+		Name:      name,
+		FolderId:  folderId,
+		Access:    access,
+		TokenHash: helpers.HashToken(token),
+		ExpiresAt: expiresAt,
+	}
+	err := db.QueryRow(query, id, username, name, folderId, access, apiToken.TokenHash, expiresAt).Scan(&apiToken.CreatedAt)
+	if err != nil {
+		return models.ApiToken{}, err
+	}
+	return apiToken, nil
+}
+
+// GetApiToken returns the unexpired token matching the given plaintext token.
+func GetApiToken(db *sql.DB, token string) (*models.ApiToken, error) {
+	query := `
+		SELECT id, username, name, folder, access, token_hash, expires_at, last_used_at, created_at
+		FROM api_tokens
+		WHERE token_hash = $1 AND (expires_at IS NULL OR expires_at > NOW())
+	`
+	var apiToken models.ApiToken
+	err := db.QueryRow(query, helpers.HashToken(token)).Scan(&apiToken.Id, &apiToken.Username, &apiToken.Name, &apiToken.FolderId,
+		&apiToken.Access, &apiToken.TokenHash, &apiToken.ExpiresAt, &apiToken.LastUsedAt, &apiToken.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("api token not found")
+		}
+		return nil, err
+	}
+	return &apiToken, nil
+}
+
+func ListApiTokens(db *sql.DB, username string) ([]models.ApiToken, error) {
+	query := `
+		SELECT id, username, name, folder, access, token_hash, expires_at, last_used_at, created_at
+		FROM api_tokens
+		WHERE username = $1
+		ORDER BY created_at
+	`
+	rows, err := db.Query(query, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	apiTokens := []models.ApiToken{}
+	for rows.Next() {
+		var apiToken models.ApiToken
+		if err := rows.Scan(&apiToken.Id, &apiToken.Username, &apiToken.Name, &apiToken.FolderId,
+			&apiToken.Access, &apiToken.TokenHash, &apiToken.ExpiresAt, &apiToken.LastUsedAt, &apiToken.CreatedAt); err != nil {
+			return nil, err
+		}
+		apiTokens = append(apiTokens, apiToken)
+	}
+	return apiTokens, rows.Err()
+}
+
+// TouchApiToken records that a token was used. Updates are at most a minute
+// apart so busy clients don't write on every request.
+func TouchApiToken(db *sql.DB, id string) error {
+	query := `
+		UPDATE api_tokens
+		SET last_used_at = NOW()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+	`
+	_, err := db.Exec(query, id)
+	return err
+}
+
+func DeleteApiToken(db *sql.DB, username string, id string) error {
+	result, err := db.Exec(`DELETE FROM api_tokens WHERE id = $1 AND username = $2`, id, username)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("api token not found")
+	}
+	return nil
+}
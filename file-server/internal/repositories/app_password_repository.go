@@ -0,0 +1,95 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"file-server/internal/helpers"
+	"file-server/internal/models"
+)
+
+func InitializeAppPasswordTable(db *sql.DB) error {
+	createTableQuery := `
+		CREATE TABLE IF NOT EXISTS app_passwords (
+			id TEXT PRIMARY KEY,
+			username TEXT NOT NULL REFERENCES users (username) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			salt TEXT NOT NULL,
+			password_hash TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`
+	if _, err := db.Exec(createTableQuery); err != nil {
+		return fmt.Errorf("error creating app_passwords table: %w", err)
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_app_passwords_username ON app_passwords (username)`); err != nil {
+		return fmt.Errorf("error creating app_passwords username index: %w", err)
+	}
+	return nil
+}
+
+func CreateAppPassword(db *sql.DB, id string, username string, name string, password string) (models.AppPassword, error) {
+	salt, err := helpers.GenerateRandomSalt()
+	if err != nil {
+		return models.AppPassword{}, err
+	}
+
+	query := `
+		INSERT INTO app_passwords (id, username, name, salt, password_hash)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING created_at
+	`
+	appPassword := models.AppPassword{
+		Id:           id,
+		Username:     username,
+		Name:         name,
+		Salt:         salt,
+		PasswordHash: helpers.HashPassword(password, salt),
+	}
+	err = db.QueryRow(query, id, username, name, salt, appPassword.PasswordHash).Scan(&appPassword.CreatedAt)
+	if err != nil {
+		return models.AppPassword{}, err
+	}
+	return appPassword, nil
+}
+
+func ListAppPasswords(db *sql.DB, username string) ([]models.AppPassword, error) {
+	query := `
+		SELECT id, username, name, salt, password_hash, created_at
+		FROM app_passwords
+		WHERE username = $1
+		ORDER BY created_at
+	`
+	rows, err := db.Query(query, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	appPasswords := []models.AppPassword{}
+	for rows.Next() {
+		var appPassword models.AppPassword
+		if err := rows.Scan(&appPassword.Id, &appPassword.Username, &appPassword.Name, &appPassword.Salt, &appPassword.PasswordHash, &appPassword.CreatedAt); err != nil {
+			return nil, err
+		}
+		appPasswords = append(appPasswords, appPassword)
+	}
+	return appPasswords, rows.Err()
+}
+
+func DeleteAppPassword(db *sql.DB, username string, id string) error {
+	result, err := db.Exec(`DELETE FROM app_passwords WHERE id = $1 AND username = $2`, id, username)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("app password not found")
+	}
+	return nil
+}
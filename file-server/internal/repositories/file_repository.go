@@ -0,0 +1,170 @@
+package repositories
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"file-server/internal/models"
+)
+
+// FileSearchQuery filters SearchFiles. Zero values don't filter.
+type FileSearchQuery struct {
+	FolderIds  []string // Folders the caller can read, nil for every folder
+	Name       string   // Case insensitive substring of the file name
+	Extensions []string
+	MinSize    int64
+	MaxSize    int64
+	From       time.Time // Capture date, or modification date when there is none
+	To         time.Time
+	Limit      int
+	Offset     int
+}
+
+func InitializeFileIndexTable(db *sql.DB) error {
+	createTableQuery := `
+		CREATE TABLE IF NOT EXISTS file_index (
+			folder_id TEXT NOT NULL,
+			path TEXT NOT NULL,
+			name TEXT NOT NULL,
+			extension TEXT NOT NULL,
+			size BIGINT NOT NULL,
+			mod_time TIMESTAMPTZ NOT NULL,
+			content_hash TEXT NOT NULL,
+			captured_at TIMESTAMPTZ,
+			indexed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (folder_id, path)
+		)
+	`
+	_, err := db.Exec(createTableQuery)
+	if err != nil {
+		return fmt.Errorf("error creating file_index table: %w", err)
+	}
+	createHashIndexQuery := `
+		CREATE INDEX IF NOT EXISTS idx_file_index_content_hash ON file_index (content_hash, size);
+	`
+	_, err = db.Exec(createHashIndexQuery)
+	if err != nil {
+		return fmt.Errorf("error creating file_index hash index: %w", err)
+	}
+
+	return nil
+}
+
+func UpsertFileEntry(db *sql.DB, entry models.FileEntry) error {
+	query := `
+		INSERT INTO file_index (folder_id, path, name, extension, size, mod_time, content_hash, captured_at, indexed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (folder_id, path) DO UPDATE
+		SET name = EXCLUDED.name, extension = EXCLUDED.extension, size = EXCLUDED.size, mod_time = EXCLUDED.mod_time,
+			content_hash = EXCLUDED.content_hash, captured_at = EXCLUDED.captured_at, indexed_at = EXCLUDED.indexed_at
+	`
+	_, err := db.Exec(query, entry.FolderId, entry.Path, entry.Name, entry.Extension, entry.Size, entry.ModTime, entry.ContentHash, entry.CapturedAt, entry.IndexedAt)
+	return err
+}
+
+func ListFileEntries(db *sql.DB, folderId string) ([]models.FileEntry, error) {
+	query := `
+		SELECT folder_id, path, name, extension, size, mod_time, content_hash, captured_at, indexed_at
+		FROM file_index
+		WHERE folder_id = $1
+	`
+	rows, err := db.Query(query, folderId)
+	if err != nil {
+		return nil, err
+	}
+	return scanFileEntries(rows)
+}
+
+// DeleteStaleFileEntries removes the entries of a folder that a rescan started at `before` didn't see.
+func DeleteStaleFileEntries(db *sql.DB, folderId string, before time.Time) error {
+	query := `DELETE FROM file_index WHERE folder_id = $1 AND indexed_at < $2`
+	_, err := db.Exec(query, folderId, before)
+	return err
+}
+
+// DeleteFileEntriesNotIn removes the entries of folders that no longer exist.
+func DeleteFileEntriesNotIn(db *sql.DB, folderIds []string) error {
+	query := `DELETE FROM file_index WHERE NOT (folder_id = ANY($1))`
+	_, err := db.Exec(query, pq.Array(folderIds))
+	return err
+}
+
+func SearchFiles(db *sql.DB, search FileSearchQuery) ([]models.FileEntry, error) {
+	conditions := []string{}
+	args := []interface{}{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if search.FolderIds != nil {
+		addCondition("folder_id = ANY($%d)", pq.Array(search.FolderIds))
+	}
+	if search.Name != "" {
+		addCondition("strpos(lower(name), lower($%d)) > 0", search.Name)
+	}
+	if len(search.Extensions) > 0 {
+		addCondition("extension = ANY($%d)", pq.Array(search.Extensions))
+	}
+	if search.MinSize > 0 {
+		addCondition("size >= $%d", search.MinSize)
+	}
+	if search.MaxSize > 0 {
+		addCondition("size <= $%d", search.MaxSize)
+	}
+	if !search.From.IsZero() {
+		addCondition("COALESCE(captured_at, mod_time) >= $%d", search.From)
+	}
+	if !search.To.IsZero() {
+		addCondition("COALESCE(captured_at, mod_time) <= $%d", search.To)
+	}
+
+	query := `
+		SELECT folder_id, path, name, extension, size, mod_time, content_hash, captured_at, indexed_at
+		FROM file_index
+	`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, search.Limit, search.Offset)
+	query += fmt.Sprintf(" ORDER BY COALESCE(captured_at, mod_time) DESC, path LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanFileEntries(rows)
+}
+
+func scanFileEntries(rows *sql.Rows) ([]models.FileEntry, error) {
+	defer rows.Close()
+
+	entries := []models.FileEntry{}
+	for rows.Next() {
+		var entry models.FileEntry
+		if err := rows.Scan(&entry.FolderId, &entry.Path, &entry.Name, &entry.Extension, &entry.Size, &entry.ModTime, &entry.ContentHash, &entry.CapturedAt, &entry.IndexedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// FindFilesByHash returns the indexed files with the given content, oldest first.
+func FindFilesByHash(db *sql.DB, contentHash string, size int64) ([]models.FileEntry, error) {
+	query := `
+		SELECT folder_id, path, name, extension, size, mod_time, content_hash, captured_at, indexed_at
+		FROM file_index
+		WHERE content_hash = $1 AND size = $2
+		ORDER BY mod_time
+	`
+	rows, err := db.Query(query, contentHash, size)
+	if err != nil {
+		return nil, err
+	}
+	return scanFileEntries(rows)
+}
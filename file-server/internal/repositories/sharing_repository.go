@@ -4,11 +4,49 @@ import (
 	"fmt"
 	"errors"
 	"database/sql"
+	"time"
 
 	"file-server/internal/helpers"
 	"file-server/internal/models"
 )
 
+// ErrShareNotFound is returned for a share that doesn't exist or was deleted.
+var ErrShareNotFound = errors.New("share not found")
+
+func InitializeShareTable(db *sql.DB) error {
+	createTableQuery := `
+		CREATE TABLE IF NOT EXISTS shares (
+			folder_id TEXT PRIMARY KEY,
+			folder_name TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			expires_at TIMESTAMPTZ NOT NULL,
+			deleted_at TIMESTAMPTZ
+		)
+	`
+	_, err := db.Exec(createTableQuery)
+	if err != nil {
+		return fmt.Errorf("error creating shares table: %w", err)
+	}
+	createExpIndexQuery := `
+		CREATE INDEX IF NOT EXISTS idx_shares_expires_at ON shares (expires_at) WHERE deleted_at IS NULL;
+	`
+	_, err = db.Exec(createExpIndexQuery)
+	if err != nil {
+		return fmt.Errorf("error creating shares expiration index: %w", err)
+	}
+	_, err = db.Exec(`ALTER TABLE shares ADD COLUMN IF NOT EXISTS strip_metadata BOOLEAN NOT NULL DEFAULT FALSE`)
+	if err != nil {
+		return fmt.Errorf("error adding shares strip_metadata column: %w", err)
+	}
+	// The expiry the admin was last warned about, a new expiry warns again
+	_, err = db.Exec(`ALTER TABLE shares ADD COLUMN IF NOT EXISTS expiry_notified_for TIMESTAMPTZ`)
+	if err != nil {
+		return fmt.Errorf("error adding shares expiry_notified_for column: %w", err)
+	}
+
+	return nil
+}
+
 func InitializeSharingUserTable(db *sql.DB) error {
 	createTableQuery := `
 		CREATE TABLE IF NOT EXISTS sharing_users (
@@ -17,34 +55,178 @@ func InitializeSharingUserTable(db *sql.DB) error {
 			folder_name TEXT NOT NULL,
 			salt TEXT NOT NULL,
 			otp_hash TEXT NOT NULL,
-			access TEXT NOT NULL CHECK (access IN ('r', 'w', 'rw')),
-			expiration TIMESTAMPTZ NOT NULL
+			access TEXT NOT NULL CHECK (access IN ('r', 'w', 'rw'))
 		)
 	`
 	_, err := db.Exec(createTableQuery)
 	if err != nil {
 		return fmt.Errorf("error creating sharing_users table: %w", err)
 	}
-	createExpIndexQuery := `
-		CREATE INDEX IF NOT EXISTS idx_expiration ON sharing_users (expiration);
+
+	return migrateSharingUserExpiration(db)
+}
+
+// migrateSharingUserExpiration moves the expiration of shares created before the
+// shares table existed into it, then drops the old column.
+func migrateSharingUserExpiration(db *sql.DB) error {
+	var exists bool
+	columnQuery := `
+		SELECT EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_name = 'sharing_users' AND column_name = 'expiration'
+		)
 	`
-	_, err = db.Exec(createExpIndexQuery)
+	if err := db.QueryRow(columnQuery).Scan(&exists); err != nil {
+		return fmt.Errorf("error inspecting sharing_users table: %w", err)
+	}
+	if !exists {
+		return nil
+	}
+
+	backfillQuery := `
+		INSERT INTO shares (folder_id, folder_name, expires_at)
+		SELECT folder_id, folder_name, MAX(expiration) FROM sharing_users GROUP BY folder_id, folder_name
+		ON CONFLICT (folder_id) DO NOTHING
+	`
+	if _, err := db.Exec(backfillQuery); err != nil {
+		return fmt.Errorf("error migrating sharing_users expiration: %w", err)
+	}
+	if _, err := db.Exec(`ALTER TABLE sharing_users DROP COLUMN expiration`); err != nil {
+		return fmt.Errorf("error dropping sharing_users expiration: %w", err)
+	}
+	return nil
+}
+
+func CreateShare(db *sql.DB, folderId string, folderName string, expiresAt time.Time, stripMetadata bool) (models.Share, error) {
+	query := `
+		INSERT INTO shares (folder_id, folder_name, expires_at, strip_metadata)
+		VALUES ($1, $2, $3, $4)
+		RETURNING created_at
+	`
+	share := models.Share{
+		FolderId:      folderId,
+		FolderName:    folderName,
+		ExpiresAt:     expiresAt,
+		StripMetadata: stripMetadata,
+	}
+	if err := db.QueryRow(query, folderId, folderName, expiresAt, stripMetadata).Scan(&share.CreatedAt); err != nil {
+		return models.Share{}, err
+	}
+	return share, nil
+}
+
+func GetShare(db *sql.DB, folderId string) (*models.Share, error) {
+	query := `
+		SELECT folder_id, folder_name, created_at, expires_at, deleted_at, strip_metadata
+		FROM shares
+		WHERE folder_id = $1
+	`
+	var share models.Share
+	err := db.QueryRow(query, folderId).Scan(&share.FolderId, &share.FolderName, &share.CreatedAt, &share.ExpiresAt, &share.DeletedAt, &share.StripMetadata)
 	if err != nil {
-		return fmt.Errorf("error creating sharing_users expiration index: %w", err)
+		if err == sql.ErrNoRows {
+			return nil, ErrShareNotFound
+		}
+		return nil, err
+	}
+	return &share, nil
+}
+
+// ListActiveShares returns every share that hasn't been deleted, expired or not.
+func ListActiveShares(db *sql.DB) ([]models.Share, error) {
+	query := `
+		SELECT folder_id, folder_name, created_at, expires_at, deleted_at, strip_metadata
+		FROM shares
+		WHERE deleted_at IS NULL
+	`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []models.Share{}
+	for rows.Next() {
+		var share models.Share
+		if err := rows.Scan(&share.FolderId, &share.FolderName, &share.CreatedAt, &share.ExpiresAt, &share.DeletedAt, &share.StripMetadata); err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+func UpdateShareExpiry(db *sql.DB, folderId string, expiresAt time.Time) error {
+	query := `UPDATE shares SET expires_at = $2 WHERE folder_id = $1 AND deleted_at IS NULL`
+	result, err := db.Exec(query, folderId, expiresAt)
+	if err != nil {
+		return err
 	}
 
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrShareNotFound
+	}
 	return nil
 }
 
-func CreateSharingUser(db *sql.DB, linkUrl string, folderId string, folderName string, salt string, otpPass string, access string, expiration string) (models.SharingUser, error) {
+// ListSharesExpiringBefore returns the live shares expiring by before whose
+// admin hasn't been warned about that expiry yet.
+func ListSharesExpiringBefore(db *sql.DB, before time.Time) ([]models.Share, error) {
+	query := `
+		SELECT folder_id, folder_name, created_at, expires_at, deleted_at, strip_metadata
+		FROM shares
+		WHERE deleted_at IS NULL AND expires_at > NOW() AND expires_at <= $1
+			AND expiry_notified_for IS DISTINCT FROM expires_at
+	`
+	rows, err := db.Query(query, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []models.Share{}
+	for rows.Next() {
+		var share models.Share
+		if err := rows.Scan(&share.FolderId, &share.FolderName, &share.CreatedAt, &share.ExpiresAt, &share.DeletedAt, &share.StripMetadata); err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+func MarkShareExpiryNotified(db *sql.DB, folderId string, expiresAt time.Time) error {
+	query := `UPDATE shares SET expiry_notified_for = $2 WHERE folder_id = $1`
+	_, err := db.Exec(query, folderId, expiresAt)
+	return err
+}
+
+// DeleteShare removes the row of a share outright, for one whose creation
+// couldn't be completed.
+func DeleteShare(db *sql.DB, folderId string) error {
+	_, err := db.Exec(`DELETE FROM shares WHERE folder_id = $1`, folderId)
+	return err
+}
+
+func MarkShareDeleted(db *sql.DB, folderId string) error {
+	query := `UPDATE shares SET deleted_at = NOW() WHERE folder_id = $1 AND deleted_at IS NULL`
+	_, err := db.Exec(query, folderId)
+	return err
+}
+
+func CreateSharingUser(db *sql.DB, linkUrl string, folderId string, folderName string, salt string, otpPass string, access string) (models.SharingUser, error) {
 	var sharingUser models.SharingUser
 
 	createUserQuery := `
-		INSERT INTO sharing_users (link_url, folder_id, folder_name, salt, otp_hash, access, expiration)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO sharing_users (link_url, folder_id, folder_name, salt, otp_hash, access)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		ON CONFLICT (link_url) DO UPDATE 
 		SET link_url = EXCLUDED.link_url 
-		RETURNING link_url, folder_id, folder_name, salt, otp_hash, access, expiration;
+		RETURNING link_url, folder_id, folder_name, salt, otp_hash, access;
 	`
 
 	sharingUser.LinkUrl = linkUrl
@@ -53,9 +235,8 @@ func CreateSharingUser(db *sql.DB, linkUrl string, folderId string, folderName s
 	sharingUser.Salt = salt
 	sharingUser.OtpHash = helpers.HashPassword(otpPass, salt)
 	sharingUser.Access = access
-	sharingUser.Expiration = expiration
 
-	_, err := db.Exec(createUserQuery, sharingUser.LinkUrl, sharingUser.FolderId, sharingUser.FolderName, sharingUser.Salt, sharingUser.OtpHash, sharingUser.Access, sharingUser.Expiration)
+	_, err := db.Exec(createUserQuery, sharingUser.LinkUrl, sharingUser.FolderId, sharingUser.FolderName, sharingUser.Salt, sharingUser.OtpHash, sharingUser.Access)
 	if err != nil {
 		return models.SharingUser{}, err
 	}
@@ -64,9 +245,10 @@ func CreateSharingUser(db *sql.DB, linkUrl string, folderId string, folderName s
 
 func GetSharingUser(db *sql.DB, linkUrl string) (*models.SharingUser, error) {
 	query := `
-		SELECT link_url, folder_id, folder_name, salt, otp_hash, access, expiration
-		FROM sharing_users
-		WHERE link_url = $1
+		SELECT su.link_url, su.folder_id, su.folder_name, su.salt, su.otp_hash, su.access, s.expires_at
+		FROM sharing_users su
+		JOIN shares s ON s.folder_id = su.folder_id
+		WHERE su.link_url = $1 AND s.deleted_at IS NULL
 	`
 	row := db.QueryRow(query, linkUrl)
 	var user models.SharingUser
@@ -81,6 +263,17 @@ func GetSharingUser(db *sql.DB, linkUrl string) (*models.SharingUser, error) {
 	return &user, nil
 }
 
+// HasUploadLink reports whether a link of the share lets visitors upload,
+// making it a file request.
+func HasUploadLink(db *sql.DB, folderId string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM sharing_users WHERE folder_id = $1 AND access IN ('w', 'rw'))`
+	var exists bool
+	if err := db.QueryRow(query, folderId).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
 func DeleteSharingUser(db *sql.DB, linkUrl string) error {
 	query := `DELETE FROM sharing_users WHERE link_url = $1`
 	result, err := db.Exec(query, linkUrl)
@@ -100,19 +293,16 @@ func DeleteSharingUser(db *sql.DB, linkUrl string) error {
 }
 
 func DeleteExpiredUsers(db *sql.DB) error {
-	query := "DELETE FROM sharing_users WHERE expiration < NOW()"
+	query := `
+		DELETE FROM sharing_users su
+		USING shares s
+		WHERE su.folder_id = s.folder_id AND (s.expires_at < NOW() OR s.deleted_at IS NOT NULL)
+	`
 
 	_, err := db.Exec(query)
 	if err != nil {
 		return err
 	}
 
-	// go func() {
-	// 	rowsAffected, err := res.RowsAffected()
-	// 	if err != nil {
-	// 		fmt.Printf("Error retrieving affected rows: %v", err)
-	// 	}
-	// 	fmt.Printf("Deleted %d rows.\n", rowsAffected)
-	// }()
 	return nil
 }
@@ -0,0 +1,205 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"file-server/internal/models"
+)
+
+func InitializeWebhookTable(db *sql.DB) error {
+	createTableQuery := `
+		CREATE TABLE IF NOT EXISTS webhooks (
+			id TEXT PRIMARY KEY,
+			url TEXT NOT NULL,
+			events TEXT NOT NULL DEFAULT '',
+			secret TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`
+	if _, err := db.Exec(createTableQuery); err != nil {
+		return fmt.Errorf("error creating webhooks table: %w", err)
+	}
+	return nil
+}
+
+func InitializeWebhookDeliveryTable(db *sql.DB) error {
+	createTableQuery := `
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id TEXT PRIMARY KEY,
+			webhook_id TEXT NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+			event TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			response_code INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT '',
+			next_attempt_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`
+	if _, err := db.Exec(createTableQuery); err != nil {
+		return fmt.Errorf("error creating webhook_deliveries table: %w", err)
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries (webhook_id, created_at)`); err != nil {
+		return fmt.Errorf("error creating webhook_deliveries webhook index: %w", err)
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_status ON webhook_deliveries (status)`); err != nil {
+		return fmt.Errorf("error creating webhook_deliveries status index: %w", err)
+	}
+	return nil
+}
+
+func CreateWebhook(db *sql.DB, id string, url string, events []string, secret string) (models.Webhook, error) {
+	query := `
+		INSERT INTO webhooks (id, url, events, secret)
+		VALUES ($1, $2, $3, $4)
+		RETURNING created_at
+	`
+	webhook := models.Webhook{
+		Id:     id,
+		Url:    url,
+		Events: events,
+		Secret: secret,
+	}
+	err := db.QueryRow(query, id, url, strings.Join(events, ","), secret).Scan(&webhook.CreatedAt)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+	return webhook, nil
+}
+
+func ListWebhooks(db *sql.DB) ([]models.Webhook, error) {
+	query := `
+		SELECT id, url, events, secret, created_at
+		FROM webhooks
+		ORDER BY created_at
+	`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []models.Webhook{}
+	for rows.Next() {
+		var webhook models.Webhook
+		var events string
+		if err := rows.Scan(&webhook.Id, &webhook.Url, &events, &webhook.Secret, &webhook.CreatedAt); err != nil {
+			return nil, err
+		}
+		webhook.Events = []string{}
+		if events != "" {
+			webhook.Events = strings.Split(events, ",")
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+// DeleteWebhook removes a webhook along with its deliveries.
+func DeleteWebhook(db *sql.DB, id string) error {
+	result, err := db.Exec(`DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("webhook not found")
+	}
+	return nil
+}
+
+// CreateWebhookDelivery logs a delivery before its first attempt, so that it
+// is retried after a restart.
+func CreateWebhookDelivery(db *sql.DB, id string, webhookId string, event string, payload string) (models.WebhookDelivery, error) {
+	query := `
+		INSERT INTO webhook_deliveries (id, webhook_id, event, payload, status)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING created_at, updated_at
+	`
+	delivery := models.WebhookDelivery{
+		Id:        id,
+		WebhookId: webhookId,
+		Event:     event,
+		Payload:   payload,
+		Status:    models.DeliveryPending,
+	}
+	err := db.QueryRow(query, id, webhookId, event, payload, models.DeliveryPending).Scan(&delivery.CreatedAt, &delivery.UpdatedAt)
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	return delivery, nil
+}
+
+// UpdateWebhookDelivery records how the last attempt of a delivery went.
+func UpdateWebhookDelivery(db *sql.DB, delivery models.WebhookDelivery) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = $3, response_code = $4, error = $5, next_attempt_at = $6, updated_at = NOW()
+		WHERE id = $1
+	`
+	_, err := db.Exec(query, delivery.Id, delivery.Status, delivery.Attempts, delivery.ResponseCode, delivery.Error, delivery.NextAttemptAt)
+	return err
+}
+
+// ListWebhookDeliveries returns the latest deliveries of a webhook, newest first.
+func ListWebhookDeliveries(db *sql.DB, webhookId string, limit int) ([]models.WebhookDelivery, error) {
+	query := `
+		SELECT id, webhook_id, event, payload, status, attempts, response_code, error, next_attempt_at, created_at, updated_at
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`
+	return queryWebhookDeliveries(db, query, webhookId, limit)
+}
+
+// ListPendingWebhookDeliveries returns the deliveries still to be attempted,
+// oldest first.
+func ListPendingWebhookDeliveries(db *sql.DB) ([]models.WebhookDelivery, error) {
+	query := `
+		SELECT id, webhook_id, event, payload, status, attempts, response_code, error, next_attempt_at, created_at, updated_at
+		FROM webhook_deliveries
+		WHERE status = $1
+		ORDER BY created_at
+	`
+	return queryWebhookDeliveries(db, query, models.DeliveryPending)
+}
+
+// DeleteWebhookDeliveriesBefore prunes the delivery log, pending deliveries
+// are kept. Returns how many were removed.
+func DeleteWebhookDeliveriesBefore(db *sql.DB, before time.Time) (int64, error) {
+	result, err := db.Exec(`DELETE FROM webhook_deliveries WHERE created_at < $1 AND status <> $2`, before, models.DeliveryPending)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func queryWebhookDeliveries(db *sql.DB, query string, args ...any) ([]models.WebhookDelivery, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		if err := rows.Scan(&delivery.Id, &delivery.WebhookId, &delivery.Event, &delivery.Payload, &delivery.Status, &delivery.Attempts,
+			&delivery.ResponseCode, &delivery.Error, &delivery.NextAttemptAt, &delivery.CreatedAt, &delivery.UpdatedAt); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
@@ -0,0 +1,358 @@
+package sharing
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"file-server/internal/auth"
+	"file-server/internal/files"
+	"file-server/internal/helpers"
+	"file-server/internal/media"
+	"file-server/internal/models"
+	"file-server/internal/repositories"
+)
+
+const (
+	defaultGalleryLimit = 100
+	maxGalleryLimit     = 1000
+	defaultThumbSize    = 256
+)
+
+// Thumbnails come in a few sizes only, so the cache stays small
+var thumbnailSizes = map[int]bool{128: true, 256: true, 512: true, 1024: true}
+
+// Extensions the thumbnail generator can decode
+var thumbnailExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true}
+
+type GalleryItem struct {
+	Name         string     `json:"name"`
+	Type         string     `json:"type,omitempty"` // image, video, audio, document or archive
+	MimeType     string     `json:"mime_type"`
+	Size         int64      `json:"size"`
+	ModTime      time.Time  `json:"mod_time"` // Upload time
+	CapturedAt   *time.Time `json:"captured_at,omitempty"`
+	Width        int        `json:"width,omitempty"`
+	Height       int        `json:"height,omitempty"`
+	Duration     float64    `json:"duration,omitempty"` // Seconds
+	ThumbnailUrl string     `json:"thumbnail_url,omitempty"`
+	Checksum     string     `json:"checksum,omitempty"` // MD5, when the file is indexed
+}
+
+type GalleryResponse struct {
+	Items  []GalleryItem `json:"items"`
+	Total  int           `json:"total"`
+	Limit  int           `json:"limit"`
+	Offset int           `json:"offset"`
+}
+
+type galleryQuery struct {
+	types  map[string]bool
+	sortBy string
+	desc   bool
+	limit  int
+	offset int
+}
+
+// GalleryHandler lists the files of a sharing folder with what a gallery
+// needs to show them: type, dimensions or duration, capture date, thumbnail
+// and checksum. Supports filtering by type, sorting and paging.
+func GalleryHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	folderId := r.URL.Query().Get("folder_id")
+	if folderId == "" || folderId == "/" {
+		http.Error(w, "Missing folder_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	claimsRaw := r.Context().Value(auth.ClaimsContextKey)
+	claims, ok := claimsRaw.(jwt.MapClaims)
+	if !ok {
+		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		return
+	}
+
+	canAccess, err := auth.HasAccess(claims, folderId, "r")
+	if err != nil || !canAccess {
+		http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+		return
+	}
+
+	query, err := parseGalleryQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	folderPath, _, err := files.ResolveFolder(folderId)
+	if err != nil {
+		http.Error(w, "Folder not found", http.StatusNotFound)
+		return
+	}
+	entries, err := os.ReadDir(folderPath)
+	if err != nil {
+		http.Error(w, "Folder not found", http.StatusNotFound)
+		return
+	}
+
+	// The index has the checksums and capture dates, files it hasn't seen yet
+	// are listed without a checksum
+	indexed := map[string]models.FileEntry{}
+	if db != nil {
+		if fileEntries, err := repositories.ListFileEntries(db, folderId); err == nil {
+			for _, entry := range fileEntries {
+				indexed[entry.Path] = entry
+			}
+		}
+	}
+
+	items := []GalleryItem{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || name == folderId+".zip" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		extension := strings.ToLower(filepath.Ext(name))
+		item := GalleryItem{
+			Name:     name,
+			Type:     helpers.FileCategory(extension),
+			MimeType: mime.TypeByExtension(extension),
+			Size:     info.Size(),
+			ModTime:  info.ModTime().UTC(),
+		}
+		if len(query.types) > 0 && !query.types[item.Type] {
+			continue
+		}
+		if item.MimeType == "" {
+			item.MimeType = "application/octet-stream"
+		}
+
+		modTime := item.ModTime.Truncate(time.Microsecond)
+		if entry, ok := indexed[name]; ok && entry.Size == item.Size && entry.ModTime.Equal(modTime) {
+			item.Checksum = entry.ContentHash
+			item.CapturedAt = entry.CapturedAt
+		} else if metadata, err := media.ReadMetadata(filepath.Join(folderPath, name)); err == nil && !metadata.CapturedAt.IsZero() {
+			capturedAt := metadata.CapturedAt.UTC()
+			item.CapturedAt = &capturedAt
+		}
+		items = append(items, item)
+	}
+
+	sortGalleryItems(items, query.sortBy, query.desc)
+
+	total := len(items)
+	page := items[min(query.offset, total):min(query.offset+query.limit, total)]
+
+	// Only the page is probed, headers have to be read for each file
+	for i := range page {
+		if page[i].Type != "image" && page[i].Type != "video" {
+			continue
+		}
+		if info, err := media.Probe(filepath.Join(folderPath, page[i].Name)); err == nil {
+			page[i].Width = info.Width
+			page[i].Height = info.Height
+			page[i].Duration = info.Duration.Seconds()
+		}
+		if thumbnailExtensions[strings.ToLower(filepath.Ext(page[i].Name))] {
+			page[i].ThumbnailUrl = fmt.Sprintf("/thumbnail?folder_id=%s&file=%s", url.QueryEscape(folderId), url.QueryEscape(page[i].Name))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(GalleryResponse{
+		Items:  page,
+		Total:  total,
+		Limit:  query.limit,
+		Offset: query.offset,
+	})
+}
+
+func parseGalleryQuery(r *http.Request) (galleryQuery, error) {
+	values := r.URL.Query()
+	query := galleryQuery{
+		types:  map[string]bool{},
+		sortBy: "name",
+		limit:  defaultGalleryLimit,
+	}
+
+	if types := values.Get("type"); types != "" {
+		for _, fileType := range strings.Split(strings.ToLower(types), ",") {
+			fileType = strings.TrimSpace(fileType)
+			if _, ok := helpers.CategoryExtensions(fileType); !ok {
+				return query, fmt.Errorf("invalid type: %s", fileType)
+			}
+			query.types[fileType] = true
+		}
+	}
+
+	if sortBy := values.Get("sort"); sortBy != "" {
+		switch sortBy {
+		case "name", "size", "taken", "uploaded":
+			query.sortBy = sortBy
+		default:
+			return query, fmt.Errorf("invalid sort: %s", sortBy)
+		}
+	}
+	switch values.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.desc = true
+	default:
+		return query, fmt.Errorf("invalid order: %s", values.Get("order"))
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value <= 0 {
+			return query, fmt.Errorf("invalid limit: %s", limit)
+		}
+		query.limit = min(value, maxGalleryLimit)
+	}
+	if offset := values.Get("offset"); offset != "" {
+		value, err := strconv.Atoi(offset)
+		if err != nil || value < 0 {
+			return query, fmt.Errorf("invalid offset: %s", offset)
+		}
+		query.offset = value
+	}
+	return query, nil
+}
+
+// sortGalleryItems orders items by name, size, capture date or upload time.
+// Files without a capture date are placed by their upload time. Ties are
+// broken by name so pages stay stable.
+func sortGalleryItems(items []GalleryItem, sortBy string, desc bool) {
+	takenAt := func(item GalleryItem) time.Time {
+		if item.CapturedAt != nil {
+			return *item.CapturedAt
+		}
+		return item.ModTime
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if desc {
+			a, b = b, a
+		}
+		switch sortBy {
+		case "size":
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		case "taken":
+			if !takenAt(a).Equal(takenAt(b)) {
+				return takenAt(a).Before(takenAt(b))
+			}
+		case "uploaded":
+			if !a.ModTime.Equal(b.ModTime) {
+				return a.ModTime.Before(b.ModTime)
+			}
+		}
+		return a.Name < b.Name
+	})
+}
+
+// ThumbnailHandler serves a JPEG thumbnail of an image, generated on first
+// request and cached next to the folder's files.
+func ThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	folderId := r.URL.Query().Get("folder_id")
+	fileName := r.URL.Query().Get("file")
+	if folderId == "" || fileName == "" {
+		http.Error(w, "Missing folder_id or file parameter", http.StatusBadRequest)
+		return
+	}
+
+	size := defaultThumbSize
+	if value := r.URL.Query().Get("size"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || !thumbnailSizes[parsed] {
+			http.Error(w, "Invalid size, expected 128, 256, 512 or 1024", http.StatusBadRequest)
+			return
+		}
+		size = parsed
+	}
+
+	claimsRaw := r.Context().Value(auth.ClaimsContextKey)
+	claims, ok := claimsRaw.(jwt.MapClaims)
+	if !ok {
+		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		return
+	}
+
+	folderPath, accessKey, err := files.ResolveFolder(folderId)
+	if err != nil {
+		http.Error(w, "Folder not found", http.StatusNotFound)
+		return
+	}
+	canAccess, err := auth.HasAccess(claims, accessKey, "r")
+	if err != nil || !canAccess {
+		http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+		return
+	}
+
+	filePath, err := files.SafeJoin(folderPath, fileName)
+	if err != nil {
+		http.Error(w, "Invalid file parameter", http.StatusBadRequest)
+		return
+	}
+	if !thumbnailExtensions[strings.ToLower(filepath.Ext(fileName))] {
+		http.Error(w, "No thumbnail for this file type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	thumbPath, err := media.Thumbnail(folderPath, filePath, size)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, media.ErrImageTooLarge) {
+			http.Error(w, "Image too large for a thumbnail", http.StatusUnprocessableEntity)
+			return
+		}
+		http.Error(w, "Unable to create thumbnail", http.StatusUnprocessableEntity)
+		return
+	}
+
+	thumb, err := os.Open(thumbPath)
+	if err != nil {
+		http.Error(w, "Error reading thumbnail", http.StatusInternalServerError)
+		return
+	}
+	defer thumb.Close()
+	info, err := thumb.Stat()
+	if err != nil {
+		http.Error(w, "Error reading thumbnail", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(w, r, filepath.Base(thumbPath), info.ModTime(), thumb)
+}
@@ -0,0 +1,78 @@
+package sharing
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"file-server/internal/job"
+	"file-server/internal/repositories"
+)
+
+// Folders and rows younger than this are skipped during reconciliation, so a
+// share being created (row inserted, folder not yet on disk) isn't torn down.
+const reconcileGracePeriod = time.Minute
+
+// CleanupExpiredShares removes the folders of expired shares and marks them
+// deleted. The shares table is the source of truth: folders without a live
+// share are removed, and live shares whose folder is gone are marked deleted.
+// Expired shares are published through jm.
+func CleanupExpiredShares(db *sql.DB, sharingDir string, jm *job.JobManager) error {
+	shares, err := repositories.ListActiveShares(db)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	live := make(map[string]bool, len(shares))
+	for _, share := range shares {
+		folderPath := filepath.Join(sharingDir, share.FolderId)
+
+		if now.After(share.ExpiresAt) {
+			log.Printf("[FILE-SERVER] Removing expired share folder: %s", folderPath)
+			if err := os.RemoveAll(folderPath); err != nil {
+				log.Printf("[FILE-SERVER] Error removing folder '%s': %v", folderPath, err)
+				continue
+			}
+			if err := repositories.MarkShareDeleted(db, share.FolderId); err != nil {
+				log.Printf("[FILE-SERVER] Error marking share '%s' deleted: %v", share.FolderId, err)
+			}
+			jm.Publish(job.Event{Type: job.EventShareExpired, FolderId: share.FolderId})
+			continue
+		}
+
+		if _, err := os.Stat(folderPath); os.IsNotExist(err) && now.Sub(share.CreatedAt) > reconcileGracePeriod {
+			log.Printf("[FILE-SERVER] Share '%s' has no folder on disk, marking it deleted", share.FolderId)
+			if err := repositories.MarkShareDeleted(db, share.FolderId); err != nil {
+				log.Printf("[FILE-SERVER] Error marking share '%s' deleted: %v", share.FolderId, err)
+			}
+			continue
+		}
+
+		live[share.FolderId] = true
+	}
+
+	entries, err := os.ReadDir(sharingDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || live[entry.Name()] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || now.Sub(info.ModTime()) < reconcileGracePeriod {
+			continue
+		}
+
+		folderPath := filepath.Join(sharingDir, entry.Name())
+		log.Printf("[FILE-SERVER] Removing orphaned share folder: %s", folderPath)
+		if err := os.RemoveAll(folderPath); err != nil {
+			log.Printf("[FILE-SERVER] Error removing folder '%s': %v", folderPath, err)
+		}
+	}
+
+	return nil
+}
@@ -10,11 +10,16 @@ import (
 	"strings"
 	"time"
 	"fmt"
+	"errors"
+	"log"
+	"sync"
 
 	"file-server/config"
 	"file-server/internal/auth"
+	"file-server/internal/files"
 	"file-server/internal/helpers"
 	"file-server/internal/job"
+	"file-server/internal/notify"
 	"file-server/internal/uploader"
 	"file-server/internal/repositories"
 
@@ -26,6 +31,28 @@ type SharingDetails struct {
 	FolderName     string `json:"folder_name"`
 	OtpPass		   string `json:"otp"`
 	ExpirationDate string `json:"expiration_date"` 
+	Files          []string `json:"files"` // Paths under the upload directory to attach to the share
+	StripMetadata  bool     `json:"strip_metadata"` // Remove EXIF, GPS and the like from images added to the share
+	Recipients     []string `json:"recipients"` // Emailed the link and the OTP once the share is created
+}
+
+type SharingExpiryDetails struct {
+	FolderId       string `json:"folder_id"`
+	ExpirationDate string `json:"expiration_date"`
+}
+
+type ShareRevokeDetails struct {
+	FolderId string `json:"folder_id"`
+}
+
+type AttachFilesDetails struct {
+	FolderId   string   `json:"folder_id"`
+	Paths      []string `json:"paths"`
+	OnConflict string   `json:"on_conflict"`
+}
+
+type AttachFilesResponse struct {
+	Attached []files.AttachedFile `json:"attached"`
 }
 
 type SharingFileParameters struct {
@@ -35,6 +62,9 @@ type SharingFileParameters struct {
 type SharingResponse struct {
 	LinkUrl 	string `json:"link_url"`
 	FolderId	string `json:"folder_id"`
+	Attached	[]files.AttachedFile `json:"attached,omitempty"`
+	Notified	[]string `json:"notified,omitempty"` // Recipients the link was emailed to
+	NotifyError	string   `json:"notify_error,omitempty"` // Why it couldn't be, the share is created regardless
 }
 
 type SharingFileItem struct {
@@ -47,7 +77,20 @@ type SharingFilesResponse struct {
 	Files []SharingFileItem `json:"files"`
 }
 
-func SharingHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, salt string, linkUrl string) {
+var (
+	notifierMu sync.RWMutex
+	notifier   *notify.Notifier
+)
+
+// SetNotifier sets what emails share links to recipients, nil when email
+// isn't configured.
+func SetNotifier(n *notify.Notifier) {
+	notifierMu.Lock()
+	defer notifierMu.Unlock()
+	notifier = n
+}
+
+func SharingHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, jm *job.JobManager, salt string, linkUrl string) {
 	cfg := config.LoadConfig()
 
 	if r.Method != http.MethodPost {
@@ -88,17 +131,51 @@ func SharingHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, salt str
 		return
 	}
 
-	expiryDuration := exp.Sub(now)
-	
-	sharingFolderId := helpers.GenerateFolderName(expiryDuration, linkUrl) // Will be the name under which folder is saved under
+	// Check the library files before creating anything, so a bad path doesn't leave an empty share behind
+	if _, err := files.ResolveLibraryFiles(sharingDetails.Files); err != nil {
+		writeAttachError(w, err)
+		return
+	}
+
+	notifierMu.RLock()
+	shareNotifier := notifier
+	notifierMu.RUnlock()
+	if len(sharingDetails.Recipients) > 0 {
+		if shareNotifier == nil {
+			http.Error(w, "Email notifications are not configured", http.StatusBadRequest)
+			return
+		}
+		if _, err := notify.ParseRecipients(sharingDetails.Recipients); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	sharingFolderId, err := helpers.GenerateFolderId() // Will be the name under which folder is saved under
+	if err != nil {
+		http.Error(w, "Error while creating folder", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := repositories.CreateShare(db, sharingFolderId, sharingDetails.FolderName, exp, sharingDetails.StripMetadata); err != nil {
+		http.Error(w, fmt.Sprintf("Error while creating share: %v", err), http.StatusInternalServerError)
+		return
+	}
+
 	finalSharingFolder := filepath.Join(cfg.SharingDir, sharingFolderId)
 	if err := os.MkdirAll(finalSharingFolder, os.ModePerm); err != nil {
+		_ = repositories.DeleteShare(db, sharingFolderId)
 		http.Error(w, "Error while creating folder", http.StatusInternalServerError)
 		return
 	}
 
-	_, err = repositories.CreateSharingUser(db, linkUrl, sharingFolderId, sharingDetails.FolderName, salt, sharingDetails.OtpPass, sharingDetails.Access, sharingDetails.ExpirationDate)
+	_, err = repositories.CreateSharingUser(db, linkUrl, sharingFolderId, sharingDetails.FolderName, salt, sharingDetails.OtpPass, sharingDetails.Access)
 	if err != nil {
+		// A share without a link could never be opened, don't leave it behind
+		if err := repositories.DeleteShare(db, sharingFolderId); err != nil {
+			log.Printf("[FILE-SERVER] Error removing share %s after failed creation: %v", sharingFolderId, err)
+		}
+		os.RemoveAll(finalSharingFolder)
 		http.Error(w, fmt.Sprintf("Error while creating user: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -106,12 +183,280 @@ func SharingHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, salt str
 	var sharingResponse SharingResponse
 	sharingResponse.LinkUrl = linkUrl
 	sharingResponse.FolderId = sharingFolderId
+
+	if len(sharingDetails.Files) > 0 {
+		attached, err := files.AttachLibraryFiles(finalSharingFolder, sharingDetails.Files, files.ConflictRename)
+		if err != nil {
+			// The caller never gets the link, so the share is removed with whatever was attached
+			if err := repositories.DeleteSharingUser(db, linkUrl); err != nil {
+				log.Printf("[FILE-SERVER] Error removing link of share %s after failed creation: %v", sharingFolderId, err)
+			}
+			if err := repositories.DeleteShare(db, sharingFolderId); err != nil {
+				log.Printf("[FILE-SERVER] Error removing share %s after failed creation: %v", sharingFolderId, err)
+			}
+			os.RemoveAll(finalSharingFolder)
+			writeAttachError(w, err)
+			return
+		}
+		if sharingDetails.StripMetadata {
+			stripAttachedFiles(finalSharingFolder, attached)
+		}
+		sharingResponse.Attached = attached
+		publishAttached(jm, sharingFolderId, attached)
+		jm.Go(func() {
+			helpers.RefreshShareZip(finalSharingFolder, jm)
+		})
+	}
+	jm.Publish(job.Event{Type: job.EventShareCreated, FolderId: sharingFolderId})
+
+	if len(sharingDetails.Recipients) > 0 {
+		err := shareNotifier.SendShareLink(sharingDetails.Recipients, notify.ShareLinkData{
+			FolderName: sharingDetails.FolderName,
+			Link:       notify.ShareLinkUrl(linkUrl),
+			Otp:        sharingDetails.OtpPass,
+			CanUpload:  strings.Contains(sharingDetails.Access, "w"),
+			ExpiresAt:  exp,
+		})
+		if err != nil {
+			// The admin still has the link to pass on by hand
+			log.Printf("[FILE-SERVER] Error emailing link of share %s: %v", sharingFolderId, err)
+			sharingResponse.NotifyError = err.Error()
+		} else {
+			sharingResponse.Notified = sharingDetails.Recipients
+		}
+	}
 	if err := json.NewEncoder(w).Encode(&sharingResponse); err != nil {
 		http.Error(w, "Error while generating response", http.StatusInternalServerError)
 		return
 	}
 }
 
+func UpdateSharingExpiryHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check if user is admin (ie has rw access to root)
+	claimsRaw := r.Context().Value(auth.ClaimsContextKey)
+	claims, ok := claimsRaw.(jwt.MapClaims)
+	if !ok {
+		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		return
+	}
+
+	canAccess, err := auth.HasAccess(claims, "/", "rw")
+	if err != nil || !canAccess {
+		http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+		return
+	}
+
+	var expiryDetails SharingExpiryDetails
+	if err := json.NewDecoder(r.Body).Decode(&expiryDetails); err != nil {
+		http.Error(w, "Unable to parse sharing parameters", http.StatusBadRequest)
+		return
+	}
+	if expiryDetails.FolderId == "" {
+		http.Error(w, "Missing folder_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	exp, err := time.Parse(time.RFC3339, expiryDetails.ExpirationDate)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error parsing time: %v", err), http.StatusBadRequest)
+		return
+	}
+	if time.Now().UTC().After(exp) {
+		http.Error(w, "Expiration date is in the past", http.StatusBadRequest)
+		return
+	}
+
+	if err := repositories.UpdateShareExpiry(db, expiryDetails.FolderId, exp); err != nil {
+		if errors.Is(err, repositories.ErrShareNotFound) || errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Share not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Error while updating share: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	share, err := repositories.GetShare(db, expiryDetails.FolderId)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while reading share: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(share)
+}
+
+// ListSharesHandler lists the shares that haven't been revoked or cleaned up.
+func ListSharesHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check if user is admin (ie has rw access to root)
+	claimsRaw := r.Context().Value(auth.ClaimsContextKey)
+	claims, ok := claimsRaw.(jwt.MapClaims)
+	if !ok {
+		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		return
+	}
+
+	canAccess, err := auth.HasAccess(claims, "/", "rw")
+	if err != nil || !canAccess {
+		http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+		return
+	}
+
+	shares, err := repositories.ListActiveShares(db)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while listing shares: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(shares)
+}
+
+// RevokeShareHandler ends a share before it expires. Its link stops working
+// right away and its folder is removed.
+func RevokeShareHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	cfg := config.LoadConfig()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check if user is admin (ie has rw access to root)
+	claimsRaw := r.Context().Value(auth.ClaimsContextKey)
+	claims, ok := claimsRaw.(jwt.MapClaims)
+	if !ok {
+		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		return
+	}
+
+	canAccess, err := auth.HasAccess(claims, "/", "rw")
+	if err != nil || !canAccess {
+		http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+		return
+	}
+
+	var revokeDetails ShareRevokeDetails
+	if err := json.NewDecoder(r.Body).Decode(&revokeDetails); err != nil {
+		http.Error(w, "Unable to parse sharing parameters", http.StatusBadRequest)
+		return
+	}
+	if revokeDetails.FolderId == "" || strings.ContainsAny(revokeDetails.FolderId, `/\`) || strings.HasPrefix(revokeDetails.FolderId, ".") {
+		http.Error(w, "Missing folder_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	share, err := repositories.GetShare(db, revokeDetails.FolderId)
+	if err != nil || share.DeletedAt != nil {
+		http.Error(w, "Share not found", http.StatusNotFound)
+		return
+	}
+
+	// Marked first, the link is what has to stop working if the removal fails
+	if err := repositories.MarkShareDeleted(db, revokeDetails.FolderId); err != nil {
+		http.Error(w, fmt.Sprintf("Error while revoking share: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := os.RemoveAll(filepath.Join(cfg.SharingDir, revokeDetails.FolderId)); err != nil {
+		// The expiry sweep removes folders without a live share
+		log.Printf("[FILE-SERVER] Error removing folder of revoked share '%s': %v", revokeDetails.FolderId, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AttachFilesHandler adds files that are already in the upload directory to an
+// existing share, instead of having them downloaded and uploaded again.
+func AttachFilesHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, jm *job.JobManager) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Reading from the library requires admin (ie rw access to root)
+	claimsRaw := r.Context().Value(auth.ClaimsContextKey)
+	claims, ok := claimsRaw.(jwt.MapClaims)
+	if !ok {
+		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		return
+	}
+
+	canAccess, err := auth.HasAccess(claims, "/", "rw")
+	if err != nil || !canAccess {
+		http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+		return
+	}
+
+	var attachDetails AttachFilesDetails
+	if err := json.NewDecoder(r.Body).Decode(&attachDetails); err != nil {
+		http.Error(w, "Unable to parse attach parameters", http.StatusBadRequest)
+		return
+	}
+	if len(attachDetails.Paths) == 0 {
+		http.Error(w, "Missing paths parameter", http.StatusBadRequest)
+		return
+	}
+	mode, err := files.ParseConflictMode(attachDetails.OnConflict)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	shareRoot, _, err := files.ResolveFolder(attachDetails.FolderId)
+	if err != nil || attachDetails.FolderId == "" || attachDetails.FolderId == "/" {
+		http.Error(w, "Folder not found", http.StatusNotFound)
+		return
+	}
+
+	attached, err := files.AttachLibraryFiles(shareRoot, attachDetails.Paths, mode)
+	if len(attached) > 0 {
+		if share, shareErr := repositories.GetShare(db, attachDetails.FolderId); shareErr == nil && share.StripMetadata {
+			stripAttachedFiles(shareRoot, attached)
+		}
+		publishAttached(jm, attachDetails.FolderId, attached)
+		jm.Go(func() {
+			helpers.RefreshShareZip(shareRoot, jm)
+		})
+	}
+	if err != nil {
+		writeAttachError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AttachFilesResponse{Attached: attached})
+}
+
+// publishAttached tells the subscribers of a share about the files attached
+// to it.
+func publishAttached(jm *job.JobManager, folderId string, attached []files.AttachedFile) {
+	for _, file := range attached {
+		jm.Publish(job.Event{Type: job.EventFileAdded, FolderId: folderId, File: file.Path})
+	}
+}
+
+func writeAttachError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, files.ErrConflict):
+		http.Error(w, "File already exists in share", http.StatusConflict)
+	case os.IsNotExist(err):
+		http.Error(w, "File not found", http.StatusNotFound)
+	case errors.Is(err, files.ErrNotRegularFile):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, fmt.Sprintf("Error while attaching files: %v", err), http.StatusBadRequest)
+	}
+}
+
 func AddSharingFilesHandler(w http.ResponseWriter, r *http.Request, jm *job.JobManager) {
 
 	if r.Method != http.MethodPost {
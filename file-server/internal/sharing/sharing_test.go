@@ -4,11 +4,17 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"crypto/md5"
 	"crypto/rand"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
@@ -238,7 +244,10 @@ func TestSharingAuth(t *testing.T) {
 		}
 		defer db.Close()
 
-		SharingHandler(rr, req, db, "someSalt", "someLink")
+		jm := job.NewJobManager(30 * time.Minute)
+		defer jm.Close()
+
+		SharingHandler(rr, req, db, jm, "someSalt", "someLink")
 
 		if rr.Code != http.StatusForbidden {
 			t.Errorf("expected status 403 Forbidden, got: %d", rr.Code)
@@ -263,7 +272,10 @@ func TestSharingAuth(t *testing.T) {
 		}
 		defer db.Close()
 
-		SharingHandler(rr, req, db, "someSalt", "someLink")
+		jm := job.NewJobManager(30 * time.Minute)
+		defer jm.Close()
+
+		SharingHandler(rr, req, db, jm, "someSalt", "someLink")
 
 		if rr.Code != http.StatusForbidden {
 			t.Errorf("expected status 403 Forbidden, got: %d", rr.Code)
@@ -287,7 +299,10 @@ func TestSharingAuth(t *testing.T) {
 		}
 		defer db.Close()
 
-		SharingHandler(rr, req, db, "someSalt", "someLink")
+		jm := job.NewJobManager(30 * time.Minute)
+		defer jm.Close()
+
+		SharingHandler(rr, req, db, jm, "someSalt", "someLink")
 
 		if rr.Code == http.StatusForbidden {
 			t.Errorf("didn't expect status 403 Forbidden, got: %d", rr.Code)
@@ -306,8 +321,6 @@ func TestCreateSharingSuccess(t *testing.T) {
 	expiration := expirationDate.Format(time.RFC3339)
 	otpPass := "123456"
 	linkUrl := uuid.New().String()
-	expiryDuration := expirationDate.Sub(time.Now().UTC().Truncate(time.Second))
-	folderId := helpers.GenerateFolderName(expiryDuration, linkUrl)
 	folderName := "someFolderName"
 	access := "rw"
 	salt, err := helpers.GenerateRandomSalt()
@@ -327,13 +340,20 @@ func TestCreateSharingSuccess(t *testing.T) {
 	}
 	defer db.Close()
 	
-	mock.ExpectExec(`INSERT INTO sharing_users \(link_url, folder_id, folder_name, salt, otp_hash, access, expiration\)[\s\n]*VALUES[\s\n]*\(\$1, \$2, \$3, \$4, \$5, \$6, \$7\)[\s\n]*ON CONFLICT[\s\n]*\(link_url\)[\s\n]*DO UPDATE[\s\n]*SET link_url = EXCLUDED.link_url[\s\n]*RETURNING link_url, folder_id, folder_name, salt, otp_hash, access, expiration`).
+	mock.ExpectQuery(`INSERT INTO shares \(folder_id, folder_name, expires_at, strip_metadata\)[\s\n]*VALUES[\s\n]*\(\$1, \$2, \$3, \$4\)[\s\n]*RETURNING created_at`).
+		WithArgs(sqlmock.AnyArg(), folderName, expirationDate, false).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+
+	mock.ExpectExec(`INSERT INTO sharing_users \(link_url, folder_id, folder_name, salt, otp_hash, access\)[\s\n]*VALUES[\s\n]*\(\$1, \$2, \$3, \$4, \$5, \$6\)[\s\n]*ON CONFLICT[\s\n]*\(link_url\)[\s\n]*DO UPDATE[\s\n]*SET link_url = EXCLUDED.link_url[\s\n]*RETURNING link_url, folder_id, folder_name, salt, otp_hash, access`).
 		WithArgs(
-			linkUrl, folderId, folderName, salt, hashedOtp, access, expiration,
+			linkUrl, sqlmock.AnyArg(), folderName, salt, hashedOtp, access,
 		).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
-	SharingHandler(rr, req, db, salt, linkUrl)
+	jm := job.NewJobManager(30 * time.Minute)
+	defer jm.Close()
+
+	SharingHandler(rr, req, db, jm, salt, linkUrl)
 
 	if rr.Code != http.StatusOK {
 		t.Errorf("expected status 200 OK, got: %d", rr.Code)
@@ -347,8 +367,11 @@ func TestCreateSharingSuccess(t *testing.T) {
 	if sharingResponse.LinkUrl != linkUrl {
 		t.Errorf("Expected %s link url, got : %s", linkUrl, sharingResponse.LinkUrl)
 	}
-	if sharingResponse.FolderId != folderId {
-		t.Errorf("Expected %s folder id, got : %s", linkUrl, sharingResponse.FolderId)
+	if sharingResponse.FolderId == "" || strings.Contains(sharingResponse.FolderId, linkUrl) {
+		t.Errorf("Expected an opaque folder id, got : %s", sharingResponse.FolderId)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled database expectations: %v", err)
 	}
 
 	// Check if the folder was created
@@ -363,6 +386,182 @@ func TestCreateSharingSuccess(t *testing.T) {
 
 }
 
+// A share whose link couldn't be created is removed again
+func TestCreateSharingUserFailure(t *testing.T) {
+	expiration := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+	req, err := createSharingReq("/", "someFolderName", "rw", expiration.Format(time.RFC3339), "123456")
+	if err != nil {
+		t.Fatalf("Received unexpected error when creating request: %v", err)
+	}
+
+	db, mock, err := initMockDb()
+	if err != nil {
+		t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+	}
+	defer db.Close()
+
+	var folderId string
+	mock.ExpectQuery(`INSERT INTO shares`).
+		WithArgs(sqlmock.AnyArg(), "someFolderName", expiration, false).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+	mock.ExpectExec(`INSERT INTO sharing_users`).
+		WillReturnError(fmt.Errorf("connection reset"))
+	mock.ExpectExec(`DELETE FROM shares WHERE folder_id = \$1`).
+		WithArgs(folderIdArg{&folderId}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	jm := job.NewJobManager(30 * time.Minute)
+	defer jm.Close()
+
+	rr := httptest.NewRecorder()
+	SharingHandler(rr, req, db, jm, "someSalt", uuid.New().String())
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500 Internal Server Error, got: %d", rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled database expectations: %v", err)
+	}
+	if folderId == "" {
+		t.Fatal("Expected the share to be deleted")
+	}
+	if _, err := os.Stat(filepath.Join(config.LoadConfig().SharingDir, folderId)); !os.IsNotExist(err) {
+		t.Errorf("Expected the folder of the share to be removed, got: %v", err)
+	}
+}
+
+// A share whose files couldn't be attached is removed again, link included
+func TestCreateSharingAttachFailure(t *testing.T) {
+	cfg := config.LoadConfig()
+	fileName := uuid.New().String() + ".txt"
+	libraryPath := filepath.Join(cfg.UploadDir, fileName)
+	if err := os.WriteFile(libraryPath, []byte("someContent"), 0644); err != nil {
+		t.Fatalf("Received unexpected error when creating library file: %v", err)
+	}
+	defer os.Remove(libraryPath)
+
+	expiration := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+	req, err := createSharingReq("/", "someFolderName", "rw", expiration.Format(time.RFC3339), "123456")
+	if err != nil {
+		t.Fatalf("Received unexpected error when creating request: %v", err)
+	}
+	body, err := json.Marshal(SharingDetails{
+		FolderName:     "someFolderName",
+		OtpPass:        "123456",
+		Access:         "rw",
+		ExpirationDate: expiration.Format(time.RFC3339),
+		Files:          []string{fileName},
+	})
+	if err != nil {
+		t.Fatalf("Received unexpected error when marshalling body: %v", err)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/share", bytes.NewBuffer(body)).WithContext(req.Context())
+
+	db, mock, err := initMockDb()
+	if err != nil {
+		t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+	}
+	defer db.Close()
+
+	var folderId string
+	linkUrl := uuid.New().String()
+	mock.ExpectQuery(`INSERT INTO shares`).
+		WithArgs(folderIdArg{&folderId}, "someFolderName", expiration, false).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+	// The library file goes away once the share exists, so attaching it fails
+	mock.ExpectExec(`INSERT INTO sharing_users`).
+		WithArgs(removingArg{linkUrl, libraryPath}, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`DELETE FROM sharing_users WHERE link_url = \$1`).
+		WithArgs(linkUrl).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM shares WHERE folder_id = \$1`).
+		WithArgs(folderIdArg{&folderId}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	jm := job.NewJobManager(30 * time.Minute)
+	defer jm.Close()
+
+	rr := httptest.NewRecorder()
+	SharingHandler(rr, req, db, jm, "someSalt", linkUrl)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 Not Found, got: %d", rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled database expectations: %v", err)
+	}
+	if folderId == "" {
+		t.Fatal("Expected the share to be deleted")
+	}
+	if _, err := os.Stat(filepath.Join(cfg.SharingDir, folderId)); !os.IsNotExist(err) {
+		t.Errorf("Expected the folder of the share to be removed, got: %v", err)
+	}
+}
+
+// removingArg matches value, removing path once it has.
+type removingArg struct {
+	value string
+	path  string
+}
+
+func (a removingArg) Match(v driver.Value) bool {
+	if v != a.value {
+		return false
+	}
+	os.Remove(a.path)
+	return true
+}
+
+// folderIdArg matches any folder id, keeping it for the test to check.
+type folderIdArg struct {
+	folderId *string
+}
+
+func (a folderIdArg) Match(v driver.Value) bool {
+	folderId, ok := v.(string)
+	*a.folderId = folderId
+	return ok && folderId != ""
+}
+
+// Recipients are refused before anything is created when email isn't configured
+func TestCreateSharingRecipientsWithoutEmail(t *testing.T) {
+	expiration := time.Now().Add(48 * time.Hour).UTC().Format(time.RFC3339)
+	req, err := createSharingReq("/", "someFolderName", "rw", expiration, "123456")
+	if err != nil {
+		t.Fatalf("Received unexpected error when creating request: %v", err)
+	}
+	body, err := json.Marshal(SharingDetails{
+		FolderName:     "someFolderName",
+		Access:         "r",
+		ExpirationDate: expiration,
+		Recipients:     []string{"someone@example.com"},
+	})
+	if err != nil {
+		t.Fatalf("Received unexpected error when marshalling body: %v", err)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/share", bytes.NewBuffer(body)).WithContext(req.Context())
+
+	db, mock, err := initMockDb()
+	if err != nil {
+		t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+	}
+	defer db.Close()
+	jm := job.NewJobManager(30 * time.Minute)
+	defer jm.Close()
+
+	SetNotifier(nil)
+	rr := httptest.NewRecorder()
+	SharingHandler(rr, req, db, jm, "someSalt", uuid.New().String())
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 Bad Request, got: %d", rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected database calls: %v", err)
+	}
+}
+
 // // Add Sharing Files Tests
 func TestAddSharingFilesAuth(t *testing.T) {
 	t.Run("Test_Add_Sharing_Auth_Wrong_Folder_Access", func(t *testing.T) {
@@ -511,10 +710,10 @@ func TestAddSharingFilesSuccess(t *testing.T) {
 	fileExt := ".txt"
 	byteSize := 3 * 1024 * 1024
 	jm := job.NewJobManager(30 * time.Minute)
-	linkUrl := uuid.New().String()
-	expirationDate := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
-	expiryDuration := expirationDate.Sub(time.Now().UTC().Truncate(time.Second))
-	sharingFolderId := helpers.GenerateFolderName(expiryDuration, linkUrl)
+	sharingFolderId, err := helpers.GenerateFolderId()
+	if err != nil {
+		t.Fatalf("Received unexpected error when generating folder id: %v", err)
+	}
 
 	// Create Folder
 	finalSharingFolder := filepath.Join(cfg.SharingDir, sharingFolderId)
@@ -727,10 +926,10 @@ func TestGetSharingFilesSuccess(t *testing.T) {
 	fileName := "someFileName"
 	fileExt := ".txt"
 	byteSize := 3 * 1024 * 1024
-	linkUrl := uuid.New().String()
-	expirationDate := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
-	expiryDuration := expirationDate.Sub(time.Now().UTC().Truncate(time.Second))
-	sharingFolderId := helpers.GenerateFolderName(expiryDuration, linkUrl)
+	sharingFolderId, err := helpers.GenerateFolderId()
+	if err != nil {
+		t.Fatalf("Received unexpected error when generating folder id: %v", err)
+	}
 
 	// Create Folder
 	finalSharingFolder := filepath.Join(cfg.SharingDir, sharingFolderId)
@@ -802,3 +1001,703 @@ func TestGetSharingFilesSuccess(t *testing.T) {
 		t.Errorf("`%s` doesn't exist inside the sharing folder", fileName+fileExt)
 	}
 }
+
+// Share Lifecycle Tests
+func TestCleanupExpiredShares(t *testing.T) {
+	cfg := config.LoadConfig()
+
+	db, mock, err := initMockDb()
+	if err != nil {
+		t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+	}
+	defer db.Close()
+
+	expiredId, _ := helpers.GenerateFolderId()
+	liveId, _ := helpers.GenerateFolderId()
+	missingId, _ := helpers.GenerateFolderId()
+	orphanId, _ := helpers.GenerateFolderId()
+
+	old := time.Now().Add(-time.Hour)
+	for _, folderId := range []string{expiredId, liveId, orphanId} {
+		folderPath := filepath.Join(cfg.SharingDir, folderId)
+		if err := os.MkdirAll(folderPath, os.ModePerm); err != nil {
+			t.Fatalf("Received unexpected error when creating folder: %v", err)
+		}
+		if err := os.Chtimes(folderPath, old, old); err != nil {
+			t.Fatalf("Received unexpected error when aging folder: %v", err)
+		}
+	}
+	defer os.RemoveAll(filepath.Join(cfg.SharingDir, liveId))
+
+	rows := sqlmock.NewRows([]string{"folder_id", "folder_name", "created_at", "expires_at", "deleted_at", "strip_metadata"}).
+		AddRow(expiredId, "expired", old, time.Now().Add(-time.Minute), nil, false).
+		AddRow(liveId, "live", old, time.Now().Add(time.Hour), nil, false).
+		AddRow(missingId, "missing", old, time.Now().Add(time.Hour), nil, false)
+	mock.ExpectQuery(`SELECT folder_id, folder_name, created_at, expires_at, deleted_at, strip_metadata FROM shares WHERE deleted_at IS NULL`).
+		WillReturnRows(rows)
+	mock.ExpectExec(`UPDATE shares SET deleted_at = NOW\(\) WHERE folder_id = \$1 AND deleted_at IS NULL`).
+		WithArgs(expiredId).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE shares SET deleted_at = NOW\(\) WHERE folder_id = \$1 AND deleted_at IS NULL`).
+		WithArgs(missingId).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	jm := job.NewJobManager(30 * time.Minute)
+	defer jm.Close()
+	events, unsubscribe := jm.Subscribe("/")
+	defer unsubscribe()
+
+	if err := CleanupExpiredShares(db, cfg.SharingDir, jm); err != nil {
+		t.Fatalf("Received unexpected error when cleaning up shares: %v", err)
+	}
+
+	select {
+	case event := <-events:
+		if event.Type != job.EventShareExpired || event.FolderId != expiredId {
+			t.Errorf("Expected the expired share to be published, got %+v", event)
+		}
+	default:
+		t.Error("Expected the expired share to be published")
+	}
+
+	if _, err := os.Stat(filepath.Join(cfg.SharingDir, expiredId)); !os.IsNotExist(err) {
+		t.Error("Expected expired share folder to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(cfg.SharingDir, orphanId)); !os.IsNotExist(err) {
+		t.Error("Expected orphaned share folder to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(cfg.SharingDir, liveId)); err != nil {
+		t.Errorf("Expected live share folder to be kept, got: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled database expectations: %v", err)
+	}
+}
+
+func TestUpdateSharingExpiry(t *testing.T) {
+	folderId, _ := helpers.GenerateFolderId()
+	newExpiration := time.Now().Add(72 * time.Hour).UTC().Truncate(time.Second)
+
+	createReq := func(claimFolderId string, expiration string) *http.Request {
+		claims := jwt.MapClaims{
+			"user_id":   "someRandomUser",
+			"folder_id": claimFolderId,
+			"access":    "rw",
+			"exp":       time.Now().Add(30 * time.Minute).Unix(),
+		}
+		body, _ := json.Marshal(SharingExpiryDetails{FolderId: folderId, ExpirationDate: expiration})
+		req := httptest.NewRequest(http.MethodPost, "/share-expiry", bytes.NewBuffer(body))
+		return req.WithContext(context.WithValue(context.Background(), auth.ClaimsContextKey, claims))
+	}
+
+	t.Run("Update_Expiry_Not_Admin", func(t *testing.T) {
+		db, _, err := initMockDb()
+		if err != nil {
+			t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+		}
+		defer db.Close()
+
+		rr := httptest.NewRecorder()
+		UpdateSharingExpiryHandler(rr, createReq(folderId, newExpiration.Format(time.RFC3339)), db)
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 Forbidden, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Update_Expiry_In_The_Past", func(t *testing.T) {
+		db, _, err := initMockDb()
+		if err != nil {
+			t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+		}
+		defer db.Close()
+
+		rr := httptest.NewRecorder()
+		UpdateSharingExpiryHandler(rr, createReq("/", time.Now().Add(-time.Hour).Format(time.RFC3339)), db)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 Bad Request, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Update_Expiry_Success", func(t *testing.T) {
+		db, mock, err := initMockDb()
+		if err != nil {
+			t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+		}
+		defer db.Close()
+
+		mock.ExpectExec(`UPDATE shares SET expires_at = \$2 WHERE folder_id = \$1 AND deleted_at IS NULL`).
+			WithArgs(folderId, newExpiration).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT folder_id, folder_name, created_at, expires_at, deleted_at, strip_metadata FROM shares WHERE folder_id = \$1`).
+			WithArgs(folderId).
+			WillReturnRows(sqlmock.NewRows([]string{"folder_id", "folder_name", "created_at", "expires_at", "deleted_at", "strip_metadata"}).
+				AddRow(folderId, "someFolderName", time.Now(), newExpiration, nil, false))
+
+		rr := httptest.NewRecorder()
+		UpdateSharingExpiryHandler(rr, createReq("/", newExpiration.Format(time.RFC3339)), db)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("Expected status 200 OK, got: %d", rr.Code)
+		}
+		var share struct {
+			ExpiresAt time.Time `json:"expires_at"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &share); err != nil {
+			t.Fatalf("Received unexpected error when parsing response body: %v", err)
+		}
+		if !share.ExpiresAt.Equal(newExpiration) {
+			t.Errorf("Expected expiry %s, got: %s", newExpiration, share.ExpiresAt)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Unfulfilled database expectations: %v", err)
+		}
+	})
+
+	t.Run("Update_Expiry_Not_Found", func(t *testing.T) {
+		db, mock, err := initMockDb()
+		if err != nil {
+			t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+		}
+		defer db.Close()
+
+		mock.ExpectExec(`UPDATE shares SET expires_at = \$2 WHERE folder_id = \$1 AND deleted_at IS NULL`).
+			WithArgs(folderId, newExpiration).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		rr := httptest.NewRecorder()
+		UpdateSharingExpiryHandler(rr, createReq("/", newExpiration.Format(time.RFC3339)), db)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 Not Found, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Update_Expiry_Database_Error", func(t *testing.T) {
+		db, mock, err := initMockDb()
+		if err != nil {
+			t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+		}
+		defer db.Close()
+
+		mock.ExpectExec(`UPDATE shares SET expires_at = \$2 WHERE folder_id = \$1 AND deleted_at IS NULL`).
+			WithArgs(folderId, newExpiration).
+			WillReturnError(fmt.Errorf("connection reset"))
+
+		rr := httptest.NewRecorder()
+		UpdateSharingExpiryHandler(rr, createReq("/", newExpiration.Format(time.RFC3339)), db)
+
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("Expected status 500 Internal Server Error, got: %d", rr.Code)
+		}
+	})
+}
+
+func TestAttachFiles(t *testing.T) {
+	cfg := config.LoadConfig()
+	folderId, _ := helpers.GenerateFolderId()
+	folderPath := filepath.Join(cfg.SharingDir, folderId)
+	if err := os.MkdirAll(folderPath, os.ModePerm); err != nil {
+		t.Fatalf("Received unexpected error when creating folder: %v", err)
+	}
+	defer os.RemoveAll(folderPath)
+
+	libraryFile := filepath.Join(cfg.UploadDir, "Albums", "someImage.jpg")
+	if err := os.MkdirAll(filepath.Dir(libraryFile), os.ModePerm); err != nil {
+		t.Fatalf("Received unexpected error when creating folder: %v", err)
+	}
+	if err := os.WriteFile(libraryFile, []byte("someImageContent"), 0644); err != nil {
+		t.Fatalf("Received unexpected error when writing file: %v", err)
+	}
+	defer os.RemoveAll(filepath.Join(cfg.UploadDir, "Albums"))
+
+	jm := job.NewJobManager(30 * time.Minute)
+	defer jm.Close()
+
+	db, mock, err := initMockDb()
+	if err != nil {
+		t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+	}
+	defer db.Close()
+	expectShare := func(stripMetadata bool) {
+		mock.ExpectQuery(`SELECT folder_id, folder_name, created_at, expires_at, deleted_at, strip_metadata FROM shares WHERE folder_id = \$1`).
+			WithArgs(folderId).
+			WillReturnRows(sqlmock.NewRows([]string{"folder_id", "folder_name", "created_at", "expires_at", "deleted_at", "strip_metadata"}).
+				AddRow(folderId, "someFolderName", time.Now(), time.Now().Add(time.Hour), nil, stripMetadata))
+	}
+
+	createReq := func(claimFolderId string, paths []string) *http.Request {
+		claims := jwt.MapClaims{
+			"user_id":   "someRandomUser",
+			"folder_id": claimFolderId,
+			"access":    "rw",
+			"exp":       time.Now().Add(30 * time.Minute).Unix(),
+		}
+		body, _ := json.Marshal(AttachFilesDetails{FolderId: folderId, Paths: paths})
+		req := httptest.NewRequest(http.MethodPost, "/share-attach", bytes.NewBuffer(body))
+		return req.WithContext(context.WithValue(context.Background(), auth.ClaimsContextKey, claims))
+	}
+
+	t.Run("Attach_Not_Admin", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		AttachFilesHandler(rr, createReq(folderId, []string{"Albums/someImage.jpg"}), db, jm)
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 Forbidden, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Attach_Missing_File", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		AttachFilesHandler(rr, createReq("/", []string{"Albums/missing.jpg"}), db, jm)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 Not Found, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Attach_Success", func(t *testing.T) {
+		expectShare(false)
+
+		rr := httptest.NewRecorder()
+		AttachFilesHandler(rr, createReq("/", []string{"Albums/someImage.jpg"}), db, jm)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 OK, got: %d %s", rr.Code, rr.Body.String())
+		}
+		var response AttachFilesResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Received unexpected error when parsing response body: %v", err)
+		}
+		if len(response.Attached) != 1 || response.Attached[0].Path != "someImage.jpg" {
+			t.Errorf("Expected file attached at the top of the share, got: %+v", response.Attached)
+		}
+		content, err := os.ReadFile(filepath.Join(folderPath, "someImage.jpg"))
+		if err != nil || string(content) != "someImageContent" {
+			t.Errorf("Expected attached file content, got %q %v", content, err)
+		}
+	})
+
+	t.Run("Attach_Conflict", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		AttachFilesHandler(rr, createReq("/", []string{"Albums/someImage.jpg"}), db, jm)
+
+		if rr.Code != http.StatusConflict {
+			t.Errorf("Expected status 409 Conflict, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Attach_Strip_Metadata", func(t *testing.T) {
+		// A JPEG with an Exif segment, the camera and position would be in there
+		photo := []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x0C}
+		photo = append(photo, []byte("Exif\x00\x00GPS!")...)
+		photo = append(photo, 0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9)
+		photoPath := filepath.Join(cfg.UploadDir, "Albums", "somePhoto.jpg")
+		if err := os.WriteFile(photoPath, photo, 0644); err != nil {
+			t.Fatalf("Received unexpected error when writing file: %v", err)
+		}
+		expectShare(true)
+
+		rr := httptest.NewRecorder()
+		AttachFilesHandler(rr, createReq("/", []string{"Albums/somePhoto.jpg"}), db, jm)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 OK, got: %d %s", rr.Code, rr.Body.String())
+		}
+		var response AttachFilesResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Received unexpected error when parsing response body: %v", err)
+		}
+		if len(response.Attached) != 1 || !response.Attached[0].Stripped {
+			t.Errorf("Expected the attached photo to be stripped, got: %+v", response.Attached)
+		}
+
+		shared, err := os.ReadFile(filepath.Join(folderPath, "somePhoto.jpg"))
+		if err != nil || !bytes.Equal(shared, []byte{0xFF, 0xD8, 0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9}) {
+			t.Errorf("Expected the shared photo without its Exif segment, got %x %v", shared, err)
+		}
+		original, err := os.ReadFile(photoPath)
+		if err != nil || !bytes.Equal(original, photo) {
+			t.Errorf("Expected the library photo to be left untouched, got %x %v", original, err)
+		}
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled database expectations: %v", err)
+	}
+
+	jm.Wait(context.Background())
+	if _, err := os.Stat(filepath.Join(folderPath, folderId+".zip")); err != nil {
+		t.Errorf("Expected share zip to be rebuilt, got: %v", err)
+	}
+}
+
+func TestStripTransferredHook(t *testing.T) {
+	cfg := config.LoadConfig()
+	folderId, _ := helpers.GenerateFolderId()
+	folderPath := filepath.Join(cfg.SharingDir, folderId)
+	defer os.RemoveAll(folderPath)
+
+	// A JPEG with an Exif segment, copied along with its folder
+	photo := []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x0C}
+	photo = append(photo, []byte("Exif\x00\x00GPS!")...)
+	photo = append(photo, 0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9)
+	photoPath := filepath.Join(folderPath, "Holidays", "somePhoto.jpg")
+	if err := os.MkdirAll(filepath.Dir(photoPath), os.ModePerm); err != nil {
+		t.Fatalf("Received unexpected error when creating folder: %v", err)
+	}
+
+	db, mock, err := initMockDb()
+	if err != nil {
+		t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+	}
+	defer db.Close()
+	hook := StripTransferredHook(db)
+
+	for _, stripMetadata := range []bool{false, true} {
+		if err := os.WriteFile(photoPath, photo, 0644); err != nil {
+			t.Fatalf("Received unexpected error when writing file: %v", err)
+		}
+		mock.ExpectQuery(`SELECT folder_id, folder_name, created_at, expires_at, deleted_at, strip_metadata FROM shares WHERE folder_id = \$1`).
+			WithArgs(folderId).
+			WillReturnRows(sqlmock.NewRows([]string{"folder_id", "folder_name", "created_at", "expires_at", "deleted_at", "strip_metadata"}).
+				AddRow(folderId, "someFolderName", time.Now(), time.Now().Add(time.Hour), nil, stripMetadata))
+
+		hook(folderPath, filepath.Dir(photoPath))
+
+		content, err := os.ReadFile(photoPath)
+		if err != nil {
+			t.Fatalf("Received unexpected error when reading file: %v", err)
+		}
+		if stripped := !bytes.Equal(content, photo); stripped != stripMetadata {
+			t.Errorf("Expected the photo to be stripped only in a share stripping metadata, strip_metadata: %t, stripped: %t", stripMetadata, stripped)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled database expectations: %v", err)
+	}
+}
+
+func TestGallery(t *testing.T) {
+	cfg := config.LoadConfig()
+	folderId, _ := helpers.GenerateFolderId()
+	folderPath := filepath.Join(cfg.SharingDir, folderId)
+	if err := os.MkdirAll(filepath.Join(folderPath, ".thumbs"), os.ModePerm); err != nil {
+		t.Fatalf("Received unexpected error when creating folder: %v", err)
+	}
+	defer os.RemoveAll(folderPath)
+
+	var photo bytes.Buffer
+	if err := png.Encode(&photo, image.NewRGBA(image.Rect(0, 0, 400, 200))); err != nil {
+		t.Fatalf("Received unexpected error when encoding image: %v", err)
+	}
+	contents := map[string][]byte{
+		"landscape.png":   photo.Bytes(),
+		"notes.txt":       []byte("some notes"),
+		"song.mp3":        []byte("ID3 some longer audio content"),
+		folderId + ".zip": []byte("zip"),
+	}
+	for name, content := range contents {
+		if err := os.WriteFile(filepath.Join(folderPath, name), content, 0644); err != nil {
+			t.Fatalf("Received unexpected error when writing file: %v", err)
+		}
+	}
+	photoInfo, _ := os.Stat(filepath.Join(folderPath, "landscape.png"))
+
+	createReq := func(target string, claimFolderId string) *http.Request {
+		claims := jwt.MapClaims{
+			"user_id":   "someRandomUser",
+			"folder_id": claimFolderId,
+			"access":    "r",
+			"exp":       time.Now().Add(30 * time.Minute).Unix(),
+		}
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		return req.WithContext(context.WithValue(context.Background(), auth.ClaimsContextKey, claims))
+	}
+	listGallery := func(t *testing.T, db *sql.DB, query string) GalleryResponse {
+		rr := httptest.NewRecorder()
+		GalleryHandler(rr, createReq("/share-gallery?folder_id="+folderId+query, folderId), db)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 OK, got: %d %s", rr.Code, rr.Body.String())
+		}
+		var response GalleryResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Received unexpected error when parsing response body: %v", err)
+		}
+		return response
+	}
+
+	t.Run("Gallery_Not_Allowed", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		GalleryHandler(rr, createReq("/share-gallery?folder_id="+folderId, "someOtherFolder"), nil)
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 Forbidden, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Gallery_Invalid_Sort", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		GalleryHandler(rr, createReq("/share-gallery?folder_id="+folderId+"&sort=colour", folderId), nil)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 Bad Request, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Gallery_Sorted_And_Paged", func(t *testing.T) {
+		response := listGallery(t, nil, "&sort=size&order=desc&limit=2")
+		if response.Total != 3 || len(response.Items) != 2 {
+			t.Fatalf("Expected 2 of 3 items, got %d of %d", len(response.Items), response.Total)
+		}
+		if response.Items[0].Name != "landscape.png" || response.Items[1].Name != "song.mp3" {
+			t.Errorf("Expected items sorted by size, got %s, %s", response.Items[0].Name, response.Items[1].Name)
+		}
+
+		response = listGallery(t, nil, "&sort=size&order=desc&limit=2&offset=2")
+		if len(response.Items) != 1 || response.Items[0].Name != "notes.txt" {
+			t.Errorf("Expected the last item on the second page, got %+v", response.Items)
+		}
+	})
+
+	t.Run("Gallery_Image_Details", func(t *testing.T) {
+		db, mock, err := initMockDb()
+		if err != nil {
+			t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+		}
+		defer db.Close()
+		mock.ExpectQuery(`FROM file_index\s+WHERE folder_id = \$1`).
+			WithArgs(folderId).
+			WillReturnRows(sqlmock.NewRows([]string{"folder_id", "path", "name", "extension", "size", "mod_time", "content_hash", "captured_at", "indexed_at"}).
+				AddRow(folderId, "landscape.png", "landscape.png", ".png", photoInfo.Size(), photoInfo.ModTime().UTC().Truncate(time.Microsecond), "someChecksum", nil, time.Now()))
+
+		response := listGallery(t, db, "&type=image")
+		if len(response.Items) != 1 {
+			t.Fatalf("Expected only the image, got %+v", response.Items)
+		}
+		item := response.Items[0]
+		if item.Width != 400 || item.Height != 200 || item.MimeType != "image/png" || item.Checksum != "someChecksum" {
+			t.Errorf("Unexpected image details: %+v", item)
+		}
+		if item.ThumbnailUrl != "/thumbnail?folder_id="+folderId+"&file=landscape.png" {
+			t.Errorf("Unexpected thumbnail url: %s", item.ThumbnailUrl)
+		}
+	})
+
+	t.Run("Thumbnail", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		ThumbnailHandler(rr, createReq("/thumbnail?folder_id="+folderId+"&file=landscape.png&size=128", folderId))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 OK, got: %d %s", rr.Code, rr.Body.String())
+		}
+		thumb, err := jpeg.DecodeConfig(rr.Body)
+		if err != nil {
+			t.Fatalf("Received unexpected error when decoding thumbnail: %v", err)
+		}
+		if thumb.Width != 128 || thumb.Height != 64 {
+			t.Errorf("Expected a 128x64 thumbnail, got %dx%d", thumb.Width, thumb.Height)
+		}
+	})
+
+	t.Run("Thumbnail_Invalid_Requests", func(t *testing.T) {
+		invalid := map[string]int{
+			"&file=landscape.png&size=100": http.StatusBadRequest,
+			"&file=notes.txt":              http.StatusUnsupportedMediaType,
+			"&file=../../secrets/JWT.png":  http.StatusNotFound,
+			"&file=.thumbs/landscape.png":  http.StatusBadRequest,
+			"&file=missing.png":            http.StatusNotFound,
+		}
+		for query, expected := range invalid {
+			rr := httptest.NewRecorder()
+			ThumbnailHandler(rr, createReq("/thumbnail?folder_id="+folderId+query, folderId))
+			if rr.Code != expected {
+				t.Errorf("Expected status %d for %s, got: %d", expected, query, rr.Code)
+			}
+		}
+	})
+
+	t.Run("Thumbnail_Too_Large", func(t *testing.T) {
+		// A 1x1 PNG whose header claims 20000x20000 pixels
+		var huge bytes.Buffer
+		if err := png.Encode(&huge, image.NewRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+			t.Fatalf("Received unexpected error when encoding image: %v", err)
+		}
+		data := huge.Bytes()
+		binary.BigEndian.PutUint32(data[16:20], 20000)
+		binary.BigEndian.PutUint32(data[20:24], 20000)
+		binary.BigEndian.PutUint32(data[29:33], crc32.ChecksumIEEE(data[12:29]))
+		if err := os.WriteFile(filepath.Join(folderPath, "huge.png"), data, 0644); err != nil {
+			t.Fatalf("Received unexpected error when writing file: %v", err)
+		}
+
+		rr := httptest.NewRecorder()
+		ThumbnailHandler(rr, createReq("/thumbnail?folder_id="+folderId+"&file=huge.png", folderId))
+		if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "too large") {
+			t.Errorf("Expected status 422 Unprocessable Entity for a too large image, got: %d %s", rr.Code, rr.Body.String())
+		}
+	})
+}
+
+func TestListShares(t *testing.T) {
+	createReq := func(claimFolderId string) *http.Request {
+		claims := jwt.MapClaims{
+			"user_id":   "someRandomUser",
+			"folder_id": claimFolderId,
+			"access":    "rw",
+			"exp":       time.Now().Add(30 * time.Minute).Unix(),
+		}
+		req := httptest.NewRequest(http.MethodGet, "/shares", nil)
+		return req.WithContext(context.WithValue(context.Background(), auth.ClaimsContextKey, claims))
+	}
+
+	t.Run("List_Shares_Not_Admin", func(t *testing.T) {
+		db, _, err := initMockDb()
+		if err != nil {
+			t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+		}
+		defer db.Close()
+
+		rr := httptest.NewRecorder()
+		ListSharesHandler(rr, createReq(uuid.New().String()), db)
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 Forbidden, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("List_Shares_Success", func(t *testing.T) {
+		db, mock, err := initMockDb()
+		if err != nil {
+			t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+		}
+		defer db.Close()
+
+		folderId, _ := helpers.GenerateFolderId()
+		mock.ExpectQuery(`SELECT folder_id, folder_name, created_at, expires_at, deleted_at, strip_metadata\s+FROM shares\s+WHERE deleted_at IS NULL`).
+			WillReturnRows(sqlmock.NewRows([]string{"folder_id", "folder_name", "created_at", "expires_at", "deleted_at", "strip_metadata"}).
+				AddRow(folderId, "someFolderName", time.Now(), time.Now().Add(time.Hour), nil, false))
+
+		rr := httptest.NewRecorder()
+		ListSharesHandler(rr, createReq("/"), db)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 OK, got: %d", rr.Code)
+		}
+		var shares []struct {
+			FolderId string `json:"folder_id"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &shares); err != nil {
+			t.Fatalf("Received unexpected error when parsing response body: %v", err)
+		}
+		if len(shares) != 1 || shares[0].FolderId != folderId {
+			t.Errorf("Expected share %s to be listed, got: %+v", folderId, shares)
+		}
+	})
+}
+
+func TestRevokeShare(t *testing.T) {
+	cfg := config.LoadConfig()
+
+	createReq := func(claimFolderId string, folderId string) *http.Request {
+		claims := jwt.MapClaims{
+			"user_id":   "someRandomUser",
+			"folder_id": claimFolderId,
+			"access":    "rw",
+			"exp":       time.Now().Add(30 * time.Minute).Unix(),
+		}
+		body, _ := json.Marshal(ShareRevokeDetails{FolderId: folderId})
+		req := httptest.NewRequest(http.MethodPost, "/share-revoke", bytes.NewBuffer(body))
+		return req.WithContext(context.WithValue(context.Background(), auth.ClaimsContextKey, claims))
+	}
+	expectShare := func(mock sqlmock.Sqlmock, folderId string) {
+		mock.ExpectQuery(`SELECT folder_id, folder_name, created_at, expires_at, deleted_at, strip_metadata FROM shares WHERE folder_id = \$1`).
+			WithArgs(folderId).
+			WillReturnRows(sqlmock.NewRows([]string{"folder_id", "folder_name", "created_at", "expires_at", "deleted_at", "strip_metadata"}).
+				AddRow(folderId, "someFolderName", time.Now(), time.Now().Add(time.Hour), nil, false))
+	}
+
+	t.Run("Revoke_Share_Not_Admin", func(t *testing.T) {
+		db, _, err := initMockDb()
+		if err != nil {
+			t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+		}
+		defer db.Close()
+
+		folderId, _ := helpers.GenerateFolderId()
+		rr := httptest.NewRecorder()
+		RevokeShareHandler(rr, createReq(folderId, folderId), db)
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 Forbidden, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Revoke_Share_Invalid_Folder", func(t *testing.T) {
+		db, _, err := initMockDb()
+		if err != nil {
+			t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+		}
+		defer db.Close()
+
+		rr := httptest.NewRecorder()
+		RevokeShareHandler(rr, createReq("/", "../uploads"), db)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 Bad Request, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Revoke_Share_Not_Found", func(t *testing.T) {
+		db, mock, err := initMockDb()
+		if err != nil {
+			t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+		}
+		defer db.Close()
+
+		folderId, _ := helpers.GenerateFolderId()
+		mock.ExpectQuery(`SELECT folder_id, folder_name, created_at, expires_at, deleted_at, strip_metadata FROM shares WHERE folder_id = \$1`).
+			WithArgs(folderId).
+			WillReturnError(sql.ErrNoRows)
+
+		rr := httptest.NewRecorder()
+		RevokeShareHandler(rr, createReq("/", folderId), db)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 Not Found, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Revoke_Share_Success", func(t *testing.T) {
+		db, mock, err := initMockDb()
+		if err != nil {
+			t.Fatalf("Received unexpected error when initializing mock db: %v", err)
+		}
+		defer db.Close()
+
+		folderId, _ := helpers.GenerateFolderId()
+		folderPath := filepath.Join(cfg.SharingDir, folderId)
+		if err := os.MkdirAll(folderPath, os.ModePerm); err != nil {
+			t.Fatalf("Received unexpected error when creating folder: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(folderPath, "someFile.txt"), []byte("someContent"), 0644); err != nil {
+			t.Fatalf("Received unexpected error when writing file: %v", err)
+		}
+
+		expectShare(mock, folderId)
+		mock.ExpectExec(`UPDATE shares SET deleted_at = NOW\(\) WHERE folder_id = \$1`).
+			WithArgs(folderId).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		rr := httptest.NewRecorder()
+		RevokeShareHandler(rr, createReq("/", folderId), db)
+
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204 No Content, got: %d %s", rr.Code, rr.Body.String())
+		}
+		if _, err := os.Stat(folderPath); !os.IsNotExist(err) {
+			t.Errorf("Expected folder %s to be removed", folderPath)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Unfulfilled database expectations: %v", err)
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package sharing
+
+import (
+	"database/sql"
+	"io/fs"
+	"log"
+	"path/filepath"
+
+	"file-server/config"
+	"file-server/internal/files"
+	"file-server/internal/media"
+	"file-server/internal/repositories"
+	"file-server/internal/uploader"
+)
+
+// StripMetadataHook removes the metadata of images uploaded to shares created
+// with strip_metadata, before anyone can download them.
+func StripMetadataHook(db *sql.DB) uploader.AssemblyHook {
+	return func(folderPath string, filePath string) (string, error) {
+		if !stripsMetadata(db, folderPath) {
+			return filePath, nil
+		}
+		if _, err := media.StripMetadata(filePath); err != nil {
+			return filePath, err
+		}
+		return filePath, nil
+	}
+}
+
+// StripTransferredHook removes the metadata of images moved or copied into
+// shares created with strip_metadata, whole folders included. Copies of
+// library files get their own stripped file, as attached files do.
+func StripTransferredHook(db *sql.DB) files.TransferHook {
+	return func(folderPath string, path string) {
+		if !stripsMetadata(db, folderPath) {
+			return
+		}
+		err := filepath.WalkDir(path, func(filePath string, entry fs.DirEntry, err error) error {
+			if err != nil || !entry.Type().IsRegular() {
+				return err
+			}
+			if _, err := media.StripMetadata(filePath); err != nil {
+				log.Printf("[FILE-SERVER] Error stripping metadata from %s: %v", filePath, err)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("[FILE-SERVER] Error stripping metadata under %s: %v", path, err)
+		}
+	}
+}
+
+// stripsMetadata tells whether folderPath is a share created with strip_metadata.
+func stripsMetadata(db *sql.DB, folderPath string) bool {
+	cfg := config.LoadConfig()
+	if filepath.Clean(folderPath) == filepath.Clean(cfg.UploadDir) {
+		return false
+	}
+	share, err := repositories.GetShare(db, filepath.Base(folderPath))
+	return err == nil && share.StripMetadata
+}
+
+// stripAttachedFiles removes the metadata of files just attached to a share.
+// Attached files are usually hardlinks, stripping gives the share its own copy
+// and leaves the library original as it was.
+func stripAttachedFiles(shareRoot string, attached []files.AttachedFile) {
+	for i := range attached {
+		filePath := filepath.Join(shareRoot, filepath.FromSlash(attached[i].Path))
+		stripped, err := media.StripMetadata(filePath)
+		if err != nil {
+			log.Printf("[FILE-SERVER] Error stripping metadata from %s: %v", filePath, err)
+			continue
+		}
+		attached[i].Stripped = stripped
+	}
+}
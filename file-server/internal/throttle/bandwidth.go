@@ -0,0 +1,258 @@
+package throttle
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Buckets idle for this long are dropped, they would be full again anyway.
+const idleBucket = time.Minute
+
+// Rates are bytes per second, 0 for no limit. Share and User apply to each
+// share link and user on its own, unless Shares or Users name another rate
+// for it.
+type Rates struct {
+	Global int64            `json:"global"`
+	Share  int64            `json:"share"`
+	User   int64            `json:"user"`
+	Shares map[string]int64 `json:"shares,omitempty"` // By folder id
+	Users  map[string]int64 `json:"users,omitempty"`  // By user id
+}
+
+func (r Rates) validate() error {
+	rates := []int64{r.Global, r.Share, r.User}
+	for _, rate := range r.Shares {
+		rates = append(rates, rate)
+	}
+	for _, rate := range r.Users {
+		rates = append(rates, rate)
+	}
+	for _, rate := range rates {
+		if rate < 0 {
+			return fmt.Errorf("invalid rate %d, must be 0 or more bytes per second", rate)
+		}
+	}
+	return nil
+}
+
+// unlimited tells whether no rate is set at all.
+func (r Rates) unlimited() bool {
+	if r.Global > 0 || r.Share > 0 || r.User > 0 {
+		return false
+	}
+	for _, rate := range r.Shares {
+		if rate > 0 {
+			return false
+		}
+	}
+	for _, rate := range r.Users {
+		if rate > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (r Rates) share(share string) int64 {
+	if rate, ok := r.Shares[share]; ok {
+		return rate
+	}
+	return r.Share
+}
+
+func (r Rates) user(user string) int64 {
+	if rate, ok := r.Users[user]; ok {
+		return rate
+	}
+	return r.User
+}
+
+// Bandwidth holds the token buckets of one direction, uploads or downloads.
+// A transfer takes its bytes from the global bucket and from those of its
+// share link and user, so it goes as fast as the slowest of them allows.
+type Bandwidth struct {
+	mu     sync.Mutex
+	rates  Rates
+	global *bucket
+	shares map[string]*bucket
+	users  map[string]*bucket
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+func NewBandwidth(rates Rates) (*Bandwidth, error) {
+	if err := rates.validate(); err != nil {
+		return nil, err
+	}
+	return &Bandwidth{
+		rates:  rates,
+		global: newBucket(rates.Global),
+		shares: make(map[string]*bucket),
+		users:  make(map[string]*bucket),
+	}, nil
+}
+
+// Rates returns the rates in force.
+func (b *Bandwidth) Rates() Rates {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rates
+}
+
+// SetRates changes the rates, transfers under way included.
+func (b *Bandwidth) SetRates(rates Rates) error {
+	if err := rates.validate(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rates = rates
+	b.global.setRate(rates.Global)
+	for share, bucket := range b.shares {
+		bucket.setRate(rates.share(share))
+	}
+	for user, bucket := range b.users {
+		bucket.setRate(rates.user(user))
+	}
+	return nil
+}
+
+// Reader returns body slowed down to the rates of share ("" for none) and
+// user. Reads wait for the bytes they returned, so a client sending faster
+// is held back by TCP. Rate changes apply to transfers under way, except
+// those started while no rate was set at all.
+func (b *Bandwidth) Reader(ctx context.Context, share string, user string, body io.ReadCloser) io.ReadCloser {
+	buckets := b.buckets(share, user)
+	if buckets == nil {
+		return body
+	}
+	return &reader{Reader: &throttled{ctx: ctx, r: body, buckets: buckets, bandwidth: b}, Closer: body}
+}
+
+// ReadSeeker is Reader for content served with http.ServeContent. Seeks are
+// passed through untouched, so range requests keep working.
+func (b *Bandwidth) ReadSeeker(ctx context.Context, share string, user string, content io.ReadSeeker) io.ReadSeeker {
+	buckets := b.buckets(share, user)
+	if buckets == nil {
+		return content
+	}
+	return &readSeeker{Reader: &throttled{ctx: ctx, r: content, buckets: buckets, bandwidth: b}, Seeker: content}
+}
+
+// buckets returns the buckets a transfer takes its bytes from, nil without
+// any rate set, and forgets those idle for a while.
+func (b *Bandwidth) buckets(share string, user string) []*bucket {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.rates.unlimited() {
+		return nil
+	}
+
+	now := time.Now()
+	for key, bucket := range b.shares {
+		if now.Sub(bucket.lastUsed) > idleBucket {
+			delete(b.shares, key)
+		}
+	}
+	for key, bucket := range b.users {
+		if now.Sub(bucket.lastUsed) > idleBucket {
+			delete(b.users, key)
+		}
+	}
+
+	buckets := []*bucket{b.global}
+	if share != "" {
+		if _, ok := b.shares[share]; !ok {
+			b.shares[share] = newBucket(b.rates.share(share))
+		}
+		buckets = append(buckets, b.shares[share])
+	}
+	if _, ok := b.users[user]; !ok {
+		b.users[user] = newBucket(b.rates.user(user))
+	}
+	buckets = append(buckets, b.users[user])
+
+	// Unlimited buckets are kept too, a later rate change applies to them
+	for _, bucket := range buckets {
+		bucket.lastUsed = now
+	}
+	return buckets
+}
+
+// newBucket returns a full bucket, a second's worth goes through at once.
+func newBucket(bytesPerSecond int64) *bucket {
+	limiter := rate.NewLimiter(rate.Inf, 0)
+	if bytesPerSecond > 0 {
+		limiter = rate.NewLimiter(rate.Limit(bytesPerSecond), int(bytesPerSecond))
+	}
+	return &bucket{limiter: limiter, lastUsed: time.Now()}
+}
+
+// setRate changes the rate of the bucket, with a burst of a second's worth.
+func (b *bucket) setRate(bytesPerSecond int64) {
+	if bytesPerSecond <= 0 {
+		b.limiter.SetLimit(rate.Inf)
+		return
+	}
+	b.limiter.SetBurst(int(bytesPerSecond))
+	b.limiter.SetLimit(rate.Limit(bytesPerSecond))
+}
+
+// throttled reads no more than the smallest burst at once and waits on every
+// bucket for all the bytes it read.
+type throttled struct {
+	ctx       context.Context
+	r         io.Reader
+	buckets   []*bucket
+	bandwidth *Bandwidth
+}
+
+func (t *throttled) Read(p []byte) (int, error) {
+	// Rates may change between reads, a bucket gone unlimited has no burst
+	t.bandwidth.mu.Lock()
+	limiters := make([]*rate.Limiter, 0, len(t.buckets))
+	for _, bucket := range t.buckets {
+		if bucket.limiter.Limit() != rate.Inf {
+			limiters = append(limiters, bucket.limiter)
+			p = p[:min(len(p), bucket.limiter.Burst())]
+		}
+		bucket.lastUsed = time.Now()
+	}
+	t.bandwidth.mu.Unlock()
+
+	n, err := t.r.Read(p)
+	for _, limiter := range limiters {
+		// A burst lowered since the read is charged in several waits
+		for charged := 0; charged < n; {
+			step := min(n-charged, limiter.Burst())
+			if step <= 0 {
+				break
+			}
+			if waitErr := limiter.WaitN(t.ctx, step); waitErr != nil {
+				return n, waitErr
+			}
+			charged += step
+		}
+	}
+	return n, err
+}
+
+type reader struct {
+	io.Reader
+	io.Closer
+}
+
+type readSeeker struct {
+	io.Reader
+	io.Seeker
+}
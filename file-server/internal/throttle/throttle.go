@@ -0,0 +1,45 @@
+// Package throttle caps what clients can have going at once: requests in
+// flight per user, and bytes per second through token buckets shared by all
+// requests of the whole server, of a share link or of a user.
+package throttle
+
+import (
+	"sync"
+)
+
+type Limits struct {
+	mu          sync.Mutex
+	maxInFlight int // 0 for no limit
+	inFlight    map[string]int
+}
+
+// NewLimits allows each user maxInFlight requests at once, zero for no limit.
+func NewLimits(maxInFlight int) *Limits {
+	return &Limits{
+		maxInFlight: maxInFlight,
+		inFlight:    make(map[string]int),
+	}
+}
+
+// Acquire takes one of the user's in-flight slots. ok is false when all of
+// them are in use, otherwise release must be called once the request is done.
+func (l *Limits) Acquire(user string) (release func(), ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxInFlight > 0 && l.inFlight[user] >= l.maxInFlight {
+		return nil, false
+	}
+	l.inFlight[user]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if l.inFlight[user]--; l.inFlight[user] == 0 {
+				delete(l.inFlight, user)
+			}
+		})
+	}, true
+}
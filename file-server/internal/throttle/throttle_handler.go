@@ -0,0 +1,73 @@
+package throttle
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"file-server/internal/auth"
+)
+
+type BandwidthRates struct {
+	Upload   Rates `json:"upload"`
+	Download Rates `json:"download"`
+}
+
+type BandwidthUpdate struct {
+	Upload   *Rates `json:"upload,omitempty"`   // Left as is when missing
+	Download *Rates `json:"download,omitempty"` // Left as is when missing
+}
+
+// BandwidthHandler returns the upload and download rates on GET and changes
+// them on POST, transfers under way included.
+func BandwidthHandler(w http.ResponseWriter, r *http.Request, uploads *Bandwidth, downloads *Bandwidth) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check if user is admin (ie has rw access to root)
+	claimsRaw := r.Context().Value(auth.ClaimsContextKey)
+	claims, ok := claimsRaw.(jwt.MapClaims)
+	if !ok {
+		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		return
+	}
+
+	canAccess, err := auth.HasAccess(claims, "/", "rw")
+	if err != nil || !canAccess {
+		http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		var update BandwidthUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		// Both are checked first so that a bad request changes nothing
+		for _, rates := range []*Rates{update.Upload, update.Download} {
+			if rates == nil {
+				continue
+			}
+			if err := rates.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if update.Upload != nil {
+			uploads.SetRates(*update.Upload)
+		}
+		if update.Download != nil {
+			downloads.SetRates(*update.Download)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BandwidthRates{
+		Upload:   uploads.Rates(),
+		Download: downloads.Rates(),
+	})
+}
@@ -0,0 +1,262 @@
+package throttle
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"file-server/internal/auth"
+)
+
+func TestAcquire(t *testing.T) {
+	t.Run("Acquire_Up_To_Max_In_Flight", func(t *testing.T) {
+		limits := NewLimits(2)
+
+		first, ok := limits.Acquire("someUser")
+		if !ok {
+			t.Fatal("Expected the first slot to be acquired")
+		}
+		if _, ok := limits.Acquire("someUser"); !ok {
+			t.Fatal("Expected the second slot to be acquired")
+		}
+		if _, ok := limits.Acquire("someUser"); ok {
+			t.Fatal("Expected a third request to be refused")
+		}
+		if _, ok := limits.Acquire("someOtherUser"); !ok {
+			t.Error("Expected other users to have their own slots")
+		}
+
+		first()
+		first() // Releasing twice must not free a second slot
+		if _, ok := limits.Acquire("someUser"); !ok {
+			t.Error("Expected a slot to be acquired after release")
+		}
+		if _, ok := limits.Acquire("someUser"); ok {
+			t.Error("Expected a double release to free a single slot")
+		}
+	})
+
+	t.Run("Acquire_Without_Limit", func(t *testing.T) {
+		limits := NewLimits(0)
+		for i := 0; i < 100; i++ {
+			if _, ok := limits.Acquire("someUser"); !ok {
+				t.Fatalf("Expected no in-flight limit, refused after %d", i)
+			}
+		}
+	})
+}
+
+func TestBandwidth(t *testing.T) {
+	const bandwidth = 20 << 10
+
+	readAll := func(t *testing.T, body io.Reader, size int64) {
+		t.Helper()
+		if n, err := io.Copy(io.Discard, body); err != nil || n != size {
+			t.Fatalf("Expected %d bytes to be read, got %d: %v", size, n, err)
+		}
+	}
+
+	t.Run("Reader_Without_Rates", func(t *testing.T) {
+		b, _ := NewBandwidth(Rates{})
+		body := io.NopCloser(bytes.NewReader(nil))
+		if b.Reader(context.Background(), "someShare", "someUser", body) != body {
+			t.Error("Expected the body to be left as is")
+		}
+	})
+
+	t.Run("Invalid_Rates", func(t *testing.T) {
+		if _, err := NewBandwidth(Rates{User: -1}); err == nil {
+			t.Error("Expected a negative rate to be refused")
+		}
+		b, _ := NewBandwidth(Rates{})
+		if err := b.SetRates(Rates{Users: map[string]int64{"someUser": -1}}); err == nil {
+			t.Error("Expected a negative rate to be refused")
+		}
+	})
+
+	t.Run("Global_Rate_Shared_By_Users", func(t *testing.T) {
+		b, _ := NewBandwidth(Rates{Global: bandwidth})
+
+		// A second worth goes through at once, the next half second is paced
+		start := time.Now()
+		for _, user := range []string{"someUser", "someOtherUser"} {
+			body := b.Reader(context.Background(), "", user, io.NopCloser(bytes.NewReader(make([]byte, bandwidth*3/4))))
+			readAll(t, body, bandwidth*3/4)
+		}
+		if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+			t.Errorf("Expected reads to be held to %d bytes per second, took %s", bandwidth, elapsed)
+		}
+	})
+
+	t.Run("Share_Rate_Shared_By_Requests", func(t *testing.T) {
+		b, _ := NewBandwidth(Rates{Share: bandwidth})
+
+		start := time.Now()
+		var wg sync.WaitGroup
+		for _, user := range []string{"someUser", "someOtherUser"} {
+			wg.Add(1)
+			go func(user string) {
+				defer wg.Done()
+				body := b.Reader(context.Background(), "someShare", user, io.NopCloser(bytes.NewReader(make([]byte, bandwidth*3/4))))
+				readAll(t, body, bandwidth*3/4)
+			}(user)
+		}
+		wg.Wait()
+		if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+			t.Errorf("Expected reads to be held to %d bytes per second, took %s", bandwidth, elapsed)
+		}
+
+		// Another share has a bucket of its own
+		start = time.Now()
+		readAll(t, b.Reader(context.Background(), "someOtherShare", "someUser", io.NopCloser(bytes.NewReader(make([]byte, bandwidth/2)))), bandwidth/2)
+		if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+			t.Errorf("Expected another share not to be held back, took %s", elapsed)
+		}
+	})
+
+	t.Run("Rates_Changed_Under_Way", func(t *testing.T) {
+		b, _ := NewBandwidth(Rates{User: 1 << 10})
+		body := b.Reader(context.Background(), "", "someUser", io.NopCloser(bytes.NewReader(make([]byte, bandwidth))))
+
+		if err := b.SetRates(Rates{User: 1 << 10, Users: map[string]int64{"someUser": 0}}); err != nil {
+			t.Fatalf("Received unexpected error when setting rates: %v", err)
+		}
+		start := time.Now()
+		readAll(t, body, bandwidth)
+		if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+			t.Errorf("Expected the user to be unlimited, took %s", elapsed)
+		}
+		if rates := b.Rates(); rates.Users["someUser"] != 0 || rates.User != 1<<10 {
+			t.Errorf("Expected the new rates to be returned, got %+v", rates)
+		}
+	})
+
+	t.Run("Rate_Lowered_During_Read", func(t *testing.T) {
+		b, _ := NewBandwidth(Rates{User: bandwidth})
+		content := &lowering{Reader: bytes.NewReader(make([]byte, bandwidth)), lower: func() {
+			b.SetRates(Rates{User: bandwidth / 2})
+		}}
+
+		// Half of the read fits the lowered burst, the other half waits for it
+		start := time.Now()
+		if n, err := b.Reader(context.Background(), "", "someUser", io.NopCloser(content)).Read(make([]byte, bandwidth)); err != nil || n != bandwidth {
+			t.Fatalf("Expected %d bytes to be read at once, got %d: %v", bandwidth, n, err)
+		}
+		if elapsed := time.Since(start); elapsed < 800*time.Millisecond {
+			t.Errorf("Expected every byte read to be charged, took %s", elapsed)
+		}
+	})
+
+	t.Run("ReadSeeker_Range", func(t *testing.T) {
+		b, _ := NewBandwidth(Rates{User: bandwidth})
+		content := []byte(strings.Repeat("0123456789", 100))
+		rs := b.ReadSeeker(context.Background(), "someShare", "someUser", bytes.NewReader(content))
+
+		if _, err := rs.Seek(500, io.SeekStart); err != nil {
+			t.Fatalf("Received unexpected error when seeking: %v", err)
+		}
+		part := make([]byte, 10)
+		if _, err := io.ReadFull(rs, part); err != nil || !bytes.Equal(part, content[500:510]) {
+			t.Errorf("Expected the bytes after the seek, got %q: %v", part, err)
+		}
+	})
+
+	t.Run("Reader_Cancelled", func(t *testing.T) {
+		b, _ := NewBandwidth(Rates{User: 1 << 10})
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		body := b.Reader(ctx, "", "someUser", io.NopCloser(bytes.NewReader(make([]byte, 4<<10))))
+		if _, err := io.Copy(io.Discard, body); err == nil {
+			t.Error("Expected reading to stop once the request is cancelled")
+		}
+	})
+}
+
+// lowering calls lower on its first read, once the read is under way.
+type lowering struct {
+	io.Reader
+	lower func()
+	once  sync.Once
+}
+
+func (l *lowering) Read(p []byte) (int, error) {
+	l.once.Do(l.lower)
+	return l.Reader.Read(p)
+}
+
+func TestBandwidthHandler(t *testing.T) {
+	createReq := func(method string, folderId string, body string) *http.Request {
+		claims := jwt.MapClaims{
+			"user_id":   "someRandomUser",
+			"folder_id": folderId,
+			"access":    "rw",
+			"exp":       time.Now().Add(30 * time.Minute).Unix(),
+		}
+		req := httptest.NewRequest(method, "/admin/bandwidth", strings.NewReader(body))
+		return req.WithContext(context.WithValue(context.Background(), auth.ClaimsContextKey, claims))
+	}
+	uploads, _ := NewBandwidth(Rates{User: 1 << 20})
+	downloads, _ := NewBandwidth(Rates{})
+
+	t.Run("Bandwidth_Not_Admin", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		BandwidthHandler(rr, createReq(http.MethodGet, "someFolderId", ""), uploads, downloads)
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 Forbidden, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Bandwidth_Get", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		BandwidthHandler(rr, createReq(http.MethodGet, "/", ""), uploads, downloads)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 OK, got: %d", rr.Code)
+		}
+		var rates BandwidthRates
+		if err := json.Unmarshal(rr.Body.Bytes(), &rates); err != nil {
+			t.Fatalf("Received unexpected error when parsing response body: %v", err)
+		}
+		if rates.Upload.User != 1<<20 || rates.Download.User != 0 {
+			t.Errorf("Expected the configured rates, got %+v", rates)
+		}
+	})
+
+	t.Run("Bandwidth_Invalid_Rate", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		BandwidthHandler(rr, createReq(http.MethodPost, "/", `{"upload":{"user":1024},"download":{"global":-1}}`), uploads, downloads)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 Bad Request, got: %d", rr.Code)
+		}
+		if uploads.Rates().User != 1<<20 {
+			t.Error("Expected a refused update to change nothing")
+		}
+	})
+
+	t.Run("Bandwidth_Update", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		BandwidthHandler(rr, createReq(http.MethodPost, "/", `{"download":{"global":2048,"shares":{"someShare":512}}}`), uploads, downloads)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 OK, got: %d", rr.Code)
+		}
+		if uploads.Rates().User != 1<<20 {
+			t.Error("Expected upload rates to be left as is")
+		}
+		if rates := downloads.Rates(); rates.Global != 2048 || rates.Shares["someShare"] != 512 {
+			t.Errorf("Expected download rates to be updated, got %+v", rates)
+		}
+	})
+}
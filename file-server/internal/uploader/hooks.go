@@ -0,0 +1,39 @@
+package uploader
+
+import (
+	"log"
+	"sync"
+)
+
+// AssemblyHook runs after an upload has been assembled and committed to
+// folderPath. Hooks may move the file, in which case they return its new path
+// so that the hooks after them see it.
+type AssemblyHook func(folderPath string, filePath string) (string, error)
+
+var (
+	hooksMu       sync.RWMutex
+	assemblyHooks []AssemblyHook
+)
+
+// SetAssemblyHooks replaces the hooks run after assembly. They run in the order given.
+func SetAssemblyHooks(hooks ...AssemblyHook) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	assemblyHooks = hooks
+}
+
+func runAssemblyHooks(folderPath string, filePath string) string {
+	hooksMu.RLock()
+	hooks := assemblyHooks
+	hooksMu.RUnlock()
+
+	for _, hook := range hooks {
+		newPath, err := hook(folderPath, filePath)
+		if err != nil {
+			log.Printf("[FILE-SERVER] Error running post assembly hook on %s: %v", filePath, err)
+			continue
+		}
+		filePath = newPath
+	}
+	return filePath
+}
@@ -0,0 +1,227 @@
+package uploader
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"file-server/config"
+	"file-server/internal/auth"
+	"file-server/internal/helpers"
+	"file-server/internal/job"
+)
+
+type ChunkSetInfo struct {
+	FileId         string    `json:"file_id"`
+	Folder         string    `json:"folder"`
+	FileName       string    `json:"file_name,omitempty"`
+	ReceivedChunks int       `json:"received_chunks"`
+	TotalChunks    int       `json:"total_chunks,omitempty"`
+	Size           int64     `json:"size"`
+	LastActivity   time.Time `json:"last_activity"`
+	Stale          bool      `json:"stale"`
+}
+
+type PendingChunksResponse struct {
+	ChunkSets    []ChunkSetInfo `json:"chunk_sets"`
+	PendingBytes int64          `json:"pending_bytes"`
+	StaleBytes   int64          `json:"stale_bytes"`
+	TTL          string         `json:"ttl"`
+}
+
+type JanitorReport struct {
+	Removed        []ChunkSetInfo `json:"removed"`
+	ReclaimedBytes int64          `json:"reclaimed_bytes"`
+}
+
+// ListChunkSets describes every in-progress upload found under the chunk
+// directories of roots. Sets idle for longer than ttl are marked stale.
+func ListChunkSets(roots []string, ttl time.Duration) []ChunkSetInfo {
+	cfg := config.LoadConfig()
+
+	chunkSets := []ChunkSetInfo{}
+	for _, root := range roots {
+		chunksRoot := filepath.Join(root, cfg.ChunksDir)
+		entries, err := os.ReadDir(chunksRoot)
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			info, err := describeChunkSet(root, entry.Name(), ttl)
+			if err != nil {
+				log.Printf("[FILE-SERVER] Error reading chunk directory %s: %v", entry.Name(), err)
+				continue
+			}
+			chunkSets = append(chunkSets, info)
+		}
+	}
+	return chunkSets
+}
+
+func describeChunkSet(root string, fileId string, ttl time.Duration) (ChunkSetInfo, error) {
+	cfg := config.LoadConfig()
+	chunksDir := filepath.Join(root, cfg.ChunksDir, fileId)
+
+	latest, err := lastActivity(chunksDir)
+	if err != nil {
+		return ChunkSetInfo{}, err
+	}
+	size, err := dirSize(chunksDir)
+	if err != nil {
+		return ChunkSetInfo{}, err
+	}
+	received, err := countChunkFiles(chunksDir)
+	if err != nil {
+		return ChunkSetInfo{}, err
+	}
+
+	info := ChunkSetInfo{
+		FileId:         fileId,
+		Folder:         filepath.Base(root),
+		ReceivedChunks: received,
+		Size:           size,
+		LastActivity:   latest,
+		Stale:          time.Since(latest) >= ttl,
+	}
+	if meta, err := readChunkMeta(chunksDir); err == nil {
+		info.FileName = meta.FileName + meta.FileExtension
+		info.TotalChunks = meta.TotalChunks
+	}
+	return info, nil
+}
+
+// CollectStaleChunks removes chunk directories with no activity for ttl,
+// skipping uploads that are currently being assembled.
+func CollectStaleChunks(jm *job.JobManager, roots []string, ttl time.Duration) JanitorReport {
+	cfg := config.LoadConfig()
+
+	report := JanitorReport{Removed: []ChunkSetInfo{}}
+	for _, root := range roots {
+		chunksRoot := filepath.Join(root, cfg.ChunksDir)
+		entries, err := os.ReadDir(chunksRoot)
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			fileId := entry.Name()
+			if !jm.AcquireJob(fileId) {
+				continue
+			}
+
+			info, err := describeChunkSet(root, fileId, ttl)
+			if err == nil && info.Stale {
+				if err = os.RemoveAll(filepath.Join(chunksRoot, fileId)); err == nil {
+					report.Removed = append(report.Removed, info)
+					report.ReclaimedBytes += info.Size
+				}
+			}
+			jm.ReleaseJob(fileId)
+
+			if err != nil {
+				log.Printf("[FILE-SERVER] Error collecting chunk directory %s: %v", fileId, err)
+			}
+		}
+	}
+
+	if len(report.Removed) > 0 {
+		log.Printf("[FILE-SERVER] Chunk janitor removed %d abandoned uploads, reclaimed %d bytes", len(report.Removed), report.ReclaimedBytes)
+	}
+	return report
+}
+
+func dirSize(dirPath string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dirPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+func PendingChunksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check if user is admin (ie has rw access to root)
+	claimsRaw := r.Context().Value(auth.ClaimsContextKey)
+	claims, ok := claimsRaw.(jwt.MapClaims)
+	if !ok {
+		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		return
+	}
+
+	canAccess, err := auth.HasAccess(claims, "/", "rw")
+	if err != nil || !canAccess {
+		http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+		return
+	}
+
+	cfg := config.LoadConfig()
+
+	response := PendingChunksResponse{
+		ChunkSets: ListChunkSets(helpers.StorageRoots(cfg), cfg.ChunkTTL),
+		TTL:       cfg.ChunkTTL.String(),
+	}
+	for _, chunkSet := range response.ChunkSets {
+		response.PendingBytes += chunkSet.Size
+		if chunkSet.Stale {
+			response.StaleBytes += chunkSet.Size
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func CleanupChunksHandler(w http.ResponseWriter, r *http.Request, jm *job.JobManager) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check if user is admin (ie has rw access to root)
+	claimsRaw := r.Context().Value(auth.ClaimsContextKey)
+	claims, ok := claimsRaw.(jwt.MapClaims)
+	if !ok {
+		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		return
+	}
+
+	canAccess, err := auth.HasAccess(claims, "/", "rw")
+	if err != nil || !canAccess {
+		http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+		return
+	}
+
+	cfg := config.LoadConfig()
+
+	report := CollectStaleChunks(jm, helpers.StorageRoots(cfg), cfg.ChunkTTL)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,185 @@
+package uploader
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"file-server/config"
+	"file-server/internal/helpers"
+	"file-server/internal/job"
+)
+
+// RecoverUploads scans the chunk directories left behind by a previous run.
+// Uploads whose chunks all arrived are assembled, verified temp files are
+// committed, and incomplete uploads idle for longer than ttl are removed.
+// Recovered files go through the assembly hooks like any other upload, so
+// they must be set first.
+func RecoverUploads(jm *job.JobManager, roots []string, ttl time.Duration) {
+	cfg := config.LoadConfig()
+
+	for _, root := range roots {
+		chunksRoot := filepath.Join(root, cfg.ChunksDir)
+		entries, err := os.ReadDir(chunksRoot)
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			fileId := entry.Name()
+			if !jm.AcquireJob(fileId) {
+				continue // Upload is being assembled right now
+			}
+			recovered, err := recoverChunkSet(root, fileId, ttl)
+			jm.ReleaseJob(fileId)
+
+			if err != nil {
+				log.Printf("[FILE-SERVER] Error recovering upload %s in %s: %v", fileId, root, err)
+				continue
+			}
+			if recovered != "" {
+				recovered = runAssemblyHooks(root, recovered)
+				log.Printf("[FILE-SERVER] Recovered interrupted upload %s", recovered)
+				if root != cfg.UploadDir {
+					helpers.RefreshShareZip(root, jm)
+				}
+			}
+		}
+	}
+}
+
+// recoverChunkSet handles a single `<root>/<ChunksDir>/<fileId>` directory and
+// returns the path of the file it committed, if any.
+func recoverChunkSet(root string, fileId string, ttl time.Duration) (string, error) {
+	cfg := config.LoadConfig()
+	chunksDir := filepath.Join(root, cfg.ChunksDir, fileId)
+
+	if err := removeTempFiles(chunksDir); err != nil {
+		return "", err
+	}
+
+	// A tus upload whose last bytes arrived before the crash is committed
+	if upload, err := readTusUpload(chunksDir); err == nil {
+		if offset, _, err := tusProgress(chunksDir); err == nil && offset == upload.Length {
+			return commitTusData(root, chunksDir, upload)
+		}
+		return "", removeIfStale(chunksDir, ttl)
+	}
+
+	meta, err := readChunkMeta(chunksDir)
+	if err != nil {
+		// Without metadata the upload can't be assembled, only expired
+		return "", removeIfStale(chunksDir, ttl)
+	}
+
+	tempFilePath := filepath.Join(chunksDir, assemblyTempFile)
+	if _, err := os.Stat(tempFilePath); err == nil {
+		if err := verifyFile(tempFilePath, meta.MD5Hash); err == nil {
+			dirPath, err := targetDir(root, meta.Path)
+			if err != nil {
+				return "", err
+			}
+			finalFilePath, err := CommitFile(tempFilePath, filepath.Join(dirPath, meta.FileName+meta.FileExtension))
+			if err != nil {
+				return "", err
+			}
+			return finalFilePath, os.RemoveAll(chunksDir)
+		}
+		// Temp file was cut short by the crash, the chunks are still there to retry
+		if err := os.Remove(tempFilePath); err != nil {
+			return "", err
+		}
+	}
+
+	if hasAllChunks(chunksDir, meta.TotalChunks) {
+		return assembleChunks(meta, root, CommitFile)
+	}
+
+	return "", removeIfStale(chunksDir, ttl)
+}
+
+func readChunkMeta(chunksDir string) (ChunkMeta, error) {
+	data, err := os.ReadFile(filepath.Join(chunksDir, chunkMetaFile))
+	if err != nil {
+		return ChunkMeta{}, err
+	}
+	var meta ChunkMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return ChunkMeta{}, err
+	}
+	if meta.TotalChunks <= 0 {
+		return ChunkMeta{}, fmt.Errorf("invalid chunk count %d", meta.TotalChunks)
+	}
+	return meta, nil
+}
+
+func hasAllChunks(chunksDir string, totalChunks int) bool {
+	for i := 0; i < totalChunks; i++ {
+		if _, err := os.Stat(filepath.Join(chunksDir, fmt.Sprintf("chunk_%d", i))); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// lastActivity returns the most recent modification time of a chunk directory
+// or any file in it.
+func lastActivity(chunksDir string) (time.Time, error) {
+	info, err := os.Stat(chunksDir)
+	if err != nil {
+		return time.Time{}, err
+	}
+	latest := info.ModTime()
+
+	entries, err := os.ReadDir(chunksDir)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, entry := range entries {
+		entryInfo, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if entryInfo.ModTime().After(latest) {
+			latest = entryInfo.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func removeIfStale(chunksDir string, ttl time.Duration) error {
+	latest, err := lastActivity(chunksDir)
+	if err != nil {
+		return err
+	}
+	if time.Since(latest) < ttl {
+		return nil
+	}
+
+	log.Printf("[FILE-SERVER] Removing abandoned chunk directory %s", chunksDir)
+	return os.RemoveAll(chunksDir)
+}
+
+// removeTempFiles deletes half-written `*.tmp` files (e.g. metadata) from a chunk directory.
+func removeTempFiles(chunksDir string) error {
+	entries, err := os.ReadDir(chunksDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		if err := os.Remove(filepath.Join(chunksDir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,544 @@
+package uploader
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"file-server/config"
+	"file-server/internal/auth"
+	"file-server/internal/files"
+	"file-server/internal/helpers"
+	"file-server/internal/job"
+)
+
+// TusPrefix is where the tus 1.0 endpoint is served. Uploads are created with
+// a POST on it and live at TusPrefix/<upload id> for the library and
+// TusPrefix/<folder id>/<upload id> for shares.
+const TusPrefix = "/tus"
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,checksum,expiration"
+	tusChecksums  = "md5,sha1,sha256"
+
+	tusInfoFile = "tus.json" // Upload details, kept apart from chunk uploads' meta.json
+	tusDataFile = "tus.bin"  // Bytes received so far
+
+	statusChecksumMismatch = 460 // Defined by the tus checksum extension
+)
+
+// tusUpload is what a tus upload was created with. Its offset is the size of
+// its data file.
+type tusUpload struct {
+	Id            string `json:"id"`
+	FolderId      string `json:"folder_id"`
+	FileName      string `json:"file_name"`
+	FileExtension string `json:"file_extension"`
+	Path          string `json:"path,omitempty"`     // Sub folder to commit the file to, as with uploads
+	MD5Hash       string `json:"md5_hash,omitempty"` // Checked once the upload completes, when the client gave one
+	Length        int64  `json:"length"`
+	Metadata      string `json:"metadata,omitempty"` // Upload-Metadata as sent, returned on HEAD
+}
+
+// TusOptionsHandler answers tus discovery requests, which clients send
+// without credentials.
+func TusOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := config.LoadConfig()
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Checksum-Algorithm", tusChecksums)
+	if cfg.UploadMaxFileSize > 0 {
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(cfg.UploadMaxFileSize, 10))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TusHandler serves the tus 1.0 core protocol with the creation, termination,
+// checksum and expiration extensions. Uploads are staged in the chunk
+// directory of their folder and committed like chunked uploads once all
+// bytes have arrived.
+//
+// Upload-Metadata keys: filename (or name) is required, folder_id ("/" by
+// default), path and md5 (hex) are optional.
+func TusHandler(w http.ResponseWriter, r *http.Request, jm *job.JobManager) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	method := r.Method
+	if override := r.Header.Get("X-HTTP-Method-Override"); override != "" && method == http.MethodPost {
+		method = override
+	}
+	if method == http.MethodOptions {
+		TusOptionsHandler(w, r)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	claimsRaw := r.Context().Value(auth.ClaimsContextKey)
+	claims, ok := claimsRaw.(jwt.MapClaims)
+	if !ok {
+		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		return
+	}
+
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, TusPrefix), "/"), "/")
+	if segments[0] == "" {
+		if method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		createTusUpload(w, r, claims)
+		return
+	}
+
+	folderId, uploadId := "/", segments[0]
+	switch len(segments) {
+	case 1:
+	case 2:
+		folderId, uploadId = segments[0], segments[1]
+	default:
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	if _, err := uuid.Parse(uploadId); err != nil {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+
+	folderPath, accessKey, err := files.ResolveFolder(folderId)
+	if err != nil {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	canAccess, err := auth.HasAccess(claims, accessKey, "w")
+	if err != nil || !canAccess {
+		http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+		return
+	}
+
+	cfg := config.LoadConfig()
+	uploadDir := filepath.Join(folderPath, cfg.ChunksDir, uploadId)
+	upload, err := readTusUpload(uploadDir)
+	if err != nil {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+
+	switch method {
+	case http.MethodHead:
+		headTusUpload(w, uploadDir, upload)
+	case http.MethodPatch:
+		patchTusUpload(w, r, jm, folderPath, uploadDir, upload, auth.ClientId(claims))
+	case http.MethodDelete:
+		if !jm.AcquireJob(upload.Id) {
+			http.Error(w, "Upload currently processing", http.StatusLocked)
+			return
+		}
+		defer jm.ReleaseJob(upload.Id)
+		if err := os.RemoveAll(uploadDir); err != nil {
+			http.Error(w, "Error removing upload", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// createTusUpload handles the creation extension: a POST announcing the
+// length and metadata of a new upload.
+func createTusUpload(w http.ResponseWriter, r *http.Request, claims jwt.MapClaims) {
+	cfg := config.LoadConfig()
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		http.Error(w, "Upload-Length must be a positive number of bytes", http.StatusBadRequest)
+		return
+	}
+	if cfg.UploadMaxFileSize > 0 && length > cfg.UploadMaxFileSize {
+		http.Error(w, fmt.Sprintf("File exceeds the maximum size of %d bytes", cfg.UploadMaxFileSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fileName := metadata["filename"]
+	if fileName == "" {
+		fileName = metadata["name"]
+	}
+	extension := path.Ext(fileName)
+	upload := tusUpload{
+		Id:            uuid.New().String(),
+		FolderId:      metadata["folder_id"],
+		FileName:      strings.TrimSuffix(fileName, extension),
+		FileExtension: extension,
+		MD5Hash:       strings.ToLower(strings.TrimSpace(metadata["md5"])),
+		Length:        length,
+		Metadata:      r.Header.Get("Upload-Metadata"),
+	}
+	if upload.FolderId == "" {
+		upload.FolderId = "/"
+	}
+	if err := helpers.ValidateFileName(upload.FileName, upload.FileExtension); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if upload.Path, err = cleanUploadPath(metadata["path"]); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if upload.MD5Hash != "" && len(upload.MD5Hash) != 32 {
+		http.Error(w, "Invalid md5 metadata", http.StatusBadRequest)
+		return
+	}
+
+	folderPath, accessKey, err := files.ResolveFolder(upload.FolderId)
+	if err != nil {
+		http.Error(w, "Folder not found", http.StatusNotFound)
+		return
+	}
+	canAccess, err := auth.HasAccess(claims, accessKey, "w")
+	if err != nil || !canAccess {
+		http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+		return
+	}
+
+	uploadDir := filepath.Join(folderPath, cfg.ChunksDir, upload.Id)
+	if err := os.MkdirAll(uploadDir, os.ModePerm); err != nil {
+		http.Error(w, "Error creating directory", http.StatusInternalServerError)
+		return
+	}
+	if err := writeTusUpload(uploadDir, upload); err != nil {
+		os.RemoveAll(uploadDir)
+		http.Error(w, "Error saving upload metadata", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[FILE-SERVER] Started tus upload %s of %s%s", upload.Id, upload.FileName, upload.FileExtension)
+
+	location := TusPrefix + "/" + upload.Id
+	if upload.FolderId != "/" {
+		location = TusPrefix + "/" + upload.FolderId + "/" + upload.Id
+	}
+	w.Header().Set("Location", location)
+	w.Header().Set("Upload-Expires", time.Now().Add(cfg.ChunkTTL).UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func headTusUpload(w http.ResponseWriter, uploadDir string, upload tusUpload) {
+	offset, expires, err := tusProgress(uploadDir)
+	if err != nil {
+		http.Error(w, "Error reading upload", http.StatusInternalServerError)
+		return
+	}
+	if time.Now().After(expires) {
+		http.Error(w, "Upload expired", http.StatusGone)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", expires.UTC().Format(http.TimeFormat))
+	if upload.Metadata != "" {
+		w.Header().Set("Upload-Metadata", upload.Metadata)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// patchTusUpload appends the request body at Upload-Offset. With an
+// Upload-Checksum the bytes are kept only if they match it. The request that
+// completes the upload hands it over to be committed.
+func patchTusUpload(w http.ResponseWriter, r *http.Request, jm *job.JobManager, folderPath string, uploadDir string, upload tusUpload, clientId string) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	requestOffset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || requestOffset < 0 {
+		http.Error(w, "Invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	var checksum hash.Hash
+	var expectedSum []byte
+	if header := r.Header.Get("Upload-Checksum"); header != "" {
+		algorithm, encoded, _ := strings.Cut(header, " ")
+		if checksum = newTusChecksum(algorithm); checksum == nil {
+			http.Error(w, "Unsupported checksum algorithm", http.StatusBadRequest)
+			return
+		}
+		if expectedSum, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			http.Error(w, "Invalid Upload-Checksum", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Held until the response, or by the commit when this request completes the upload
+	if !jm.AcquireJob(upload.Id) {
+		http.Error(w, "Upload currently processing", http.StatusLocked)
+		return
+	}
+	completing := false
+	defer func() {
+		if !completing {
+			jm.ReleaseJob(upload.Id)
+		}
+	}()
+
+	offset, expires, err := tusProgress(uploadDir)
+	if err != nil {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	if time.Now().After(expires) {
+		http.Error(w, "Upload expired", http.StatusGone)
+		return
+	}
+	if requestOffset != offset {
+		http.Error(w, fmt.Sprintf("Upload-Offset %d does not match the upload offset %d", requestOffset, offset), http.StatusConflict)
+		return
+	}
+	if offset == upload.Length {
+		http.Error(w, "Upload already complete", http.StatusConflict)
+		return
+	}
+
+	release, ok := AcquireUploadSlot(r, folderPath, clientId)
+	if !ok {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Too many uploads in flight, retry later", http.StatusTooManyRequests)
+		return
+	}
+	defer release()
+
+	offset, err = appendTusData(uploadDir, offset, http.MaxBytesReader(w, r.Body, upload.Length-offset), checksum, expectedSum)
+	if errors.Is(err, errChecksumMismatch) {
+		http.Error(w, "Checksum mismatch", statusChecksumMismatch)
+		return
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, "Request exceeds Upload-Length", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		log.Printf("[FILE-SERVER] Error receiving tus upload %s: %v", upload.Id, err)
+		// Whatever was received in full is kept, the client resumes from the new offset
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		http.Error(w, "Error saving upload", http.StatusInternalServerError)
+		return
+	}
+
+	if offset == upload.Length {
+		completing = true
+		jm.Go(func() {
+			defer jm.ReleaseJob(upload.Id)
+			event := job.Event{
+				FolderId: folderIdOf(folderPath),
+				UploadId: upload.Id,
+				File:     path.Join(upload.Path, upload.FileName+upload.FileExtension),
+			}
+			event.Type = job.EventAssemblyStarted
+			jm.Publish(event)
+
+			if finalFilePath, err := commitTusUpload(jm, folderPath, uploadDir, upload); err != nil {
+				log.Printf("[FILE-SERVER] Error assembling file %s: %v", upload.Id, err)
+				publishAssemblyFailed(jm, event, err)
+			} else {
+				log.Printf("[FILE-SERVER] Successfully assembled file %s", finalFilePath)
+				if relPath, err := filepath.Rel(folderPath, finalFilePath); err == nil {
+					event.File = filepath.ToSlash(relPath)
+				}
+				event.Type = job.EventAssemblyFinished
+				jm.Publish(event)
+				publishUploaded(jm, folderPath, upload.Id, finalFilePath)
+			}
+
+			cfg := config.LoadConfig()
+			if folderPath != cfg.UploadDir {
+				helpers.RefreshShareZip(folderPath, jm)
+			}
+		})
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Expires", time.Now().Add(config.LoadConfig().ChunkTTL).UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// appendTusData writes body at offset and returns the new offset. When a
+// checksum is given the data is dropped again unless it matches.
+func appendTusData(uploadDir string, offset int64, body io.Reader, checksum hash.Hash, expectedSum []byte) (int64, error) {
+	dataFile, err := os.OpenFile(filepath.Join(uploadDir, tusDataFile), os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return offset, err
+	}
+	defer dataFile.Close()
+
+	if _, err := dataFile.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+	writer := io.Writer(dataFile)
+	if checksum != nil {
+		writer = io.MultiWriter(dataFile, checksum)
+	}
+
+	written, copyErr := io.Copy(writer, body)
+	if copyErr == nil && checksum != nil && string(checksum.Sum(nil)) != string(expectedSum) {
+		copyErr = errChecksumMismatch
+	}
+	var maxBytesErr *http.MaxBytesError
+	if copyErr != nil && (checksum != nil || errors.As(copyErr, &maxBytesErr)) {
+		// Data that can't be verified or runs past the upload length is dropped
+		written = 0
+	}
+	if err := dataFile.Truncate(offset + written); err != nil {
+		return offset, err
+	}
+	if err := dataFile.Sync(); err != nil {
+		return offset, err
+	}
+	return offset + written, copyErr
+}
+
+// commitTusUpload commits a complete upload through the same path as an
+// assembled chunk upload. The upload directory is removed either way, data
+// that fails verification can't be resumed.
+func commitTusUpload(jm *job.JobManager, folderPath string, uploadDir string, upload tusUpload) (string, error) {
+	defer func() {
+		if err := os.RemoveAll(uploadDir); err != nil {
+			log.Printf("[FILE-SERVER] Error deleting upload directory %s: %v", uploadDir, err)
+		}
+	}()
+
+	dataPath := filepath.Join(uploadDir, tusDataFile)
+	if upload.MD5Hash != "" {
+		if err := verifyFile(dataPath, upload.MD5Hash); err != nil {
+			return "", err
+		}
+	}
+	return commitUpload(jm, folderPath, upload.Path, upload.FileName+upload.FileExtension, dataPath)
+}
+
+// commitTusData is commitTusUpload for recovery, which runs before uploads
+// are taken and leaves the hooks to RecoverUploads.
+func commitTusData(folderPath string, uploadDir string, upload tusUpload) (string, error) {
+	defer os.RemoveAll(uploadDir)
+
+	dataPath := filepath.Join(uploadDir, tusDataFile)
+	if upload.MD5Hash != "" {
+		if err := verifyFile(dataPath, upload.MD5Hash); err != nil {
+			return "", err
+		}
+	}
+	dirPath, err := targetDir(folderPath, upload.Path)
+	if err != nil {
+		return "", err
+	}
+	return CommitFile(dataPath, filepath.Join(dirPath, upload.FileName+upload.FileExtension))
+}
+
+// tusProgress returns the offset of an upload and when it expires, ChunkTTL
+// after its last activity.
+func tusProgress(uploadDir string) (int64, time.Time, error) {
+	latest, err := lastActivity(uploadDir)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	expires := latest.Add(config.LoadConfig().ChunkTTL)
+
+	info, err := os.Stat(filepath.Join(uploadDir, tusDataFile))
+	if os.IsNotExist(err) {
+		return 0, expires, nil
+	}
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return info.Size(), expires, nil
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma separated keys,
+// each followed by a space and its base64 encoded value when it has one.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, fmt.Errorf("invalid Upload-Metadata")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Upload-Metadata value for %s", key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+func newTusChecksum(algorithm string) hash.Hash {
+	switch algorithm {
+	case "md5":
+		return md5.New()
+	case "sha1":
+		return sha1.New()
+	case "sha256":
+		return sha256.New()
+	}
+	return nil
+}
+
+func readTusUpload(uploadDir string) (tusUpload, error) {
+	data, err := os.ReadFile(filepath.Join(uploadDir, tusInfoFile))
+	if err != nil {
+		return tusUpload{}, err
+	}
+	var upload tusUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return tusUpload{}, err
+	}
+	return upload, nil
+}
+
+func writeTusUpload(uploadDir string, upload tusUpload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	infoPath := filepath.Join(uploadDir, tusInfoFile)
+	tempPath := infoPath + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, infoPath)
+}
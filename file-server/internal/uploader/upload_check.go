@@ -0,0 +1,178 @@
+package uploader
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"file-server/config"
+	"file-server/internal/auth"
+	"file-server/internal/files"
+	"file-server/internal/helpers"
+	"file-server/internal/job"
+	"file-server/internal/models"
+	"file-server/internal/repositories"
+)
+
+type UploadCheckDetails struct {
+	FolderId      string `json:"folder_id"`
+	FileName      string `json:"file_name"`
+	FileExtension string `json:"file_extension"`
+	MD5Hash       string `json:"md5_hash"`
+	Size          int64  `json:"size"`
+	Path          string `json:"path,omitempty"` // Sub folder to add the file to, as with uploads
+}
+
+type UploadCheckResponse struct {
+	Present bool   `json:"present"`          // The file was added from existing content, skip the upload
+	Path    string `json:"path,omitempty"`   // Where it was added, relative to the folder
+	Method  string `json:"method,omitempty"` // hardlink, reflink or copy
+}
+
+// UploadCheckHandler lets a client skip uploading a file the server already
+// has. When a readable indexed file has the same MD5 and size, its content is
+// linked into the destination folder as if the upload had completed.
+func UploadCheckHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, jm *job.JobManager) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claimsRaw := r.Context().Value(auth.ClaimsContextKey)
+	claims, ok := claimsRaw.(jwt.MapClaims)
+	if !ok {
+		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		return
+	}
+
+	var details UploadCheckDetails
+	if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+		http.Error(w, "Unable to parse upload check parameters", http.StatusBadRequest)
+		return
+	}
+	if err := helpers.ValidateFileName(details.FileName, details.FileExtension); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	uploadPath, err := cleanUploadPath(details.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	details.MD5Hash = strings.ToLower(strings.TrimSpace(details.MD5Hash))
+	if len(details.MD5Hash) != 32 || details.Size < 0 {
+		http.Error(w, "Invalid md5_hash or size", http.StatusBadRequest)
+		return
+	}
+
+	folderPath, accessKey, err := files.ResolveFolder(details.FolderId)
+	if err != nil {
+		http.Error(w, "Folder not found", http.StatusNotFound)
+		return
+	}
+	canAccess, err := auth.HasAccess(claims, accessKey, "w")
+	if err != nil || !canAccess {
+		http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+		return
+	}
+
+	candidates, err := repositories.FindFilesByHash(db, details.MD5Hash, details.Size)
+	if err != nil {
+		http.Error(w, "Error while looking up file", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	// Only content the caller could read counts, otherwise the check would reveal
+	// which files exist in folders they have no access to
+	sourcePath, found := pickExistingFile(claims, candidates)
+	if !found {
+		json.NewEncoder(w).Encode(UploadCheckResponse{Present: false})
+		return
+	}
+
+	dirPath, err := targetDir(folderPath, uploadPath)
+	if err != nil {
+		http.Error(w, "Error creating folder", http.StatusInternalServerError)
+		return
+	}
+	namePath := filepath.Join(dirPath, details.FileName+details.FileExtension)
+	if !jm.AcquireJob(namePath) {
+		http.Error(w, "File currently processing", http.StatusConflict)
+		return
+	}
+	finalFilePath, method, err := linkExistingFile(sourcePath, namePath)
+	jm.ReleaseJob(namePath)
+	if err != nil {
+		log.Printf("[FILE-SERVER] Error linking existing content %s: %v", sourcePath, err)
+		json.NewEncoder(w).Encode(UploadCheckResponse{Present: false})
+		return
+	}
+	log.Printf("[FILE-SERVER] Added %s from existing content %s", finalFilePath, sourcePath)
+
+	jm.Go(func() {
+		runAssemblyHooks(folderPath, finalFilePath)
+
+		cfg := config.LoadConfig()
+		if folderPath != cfg.UploadDir {
+			helpers.RefreshShareZip(folderPath, jm)
+		}
+	})
+
+	relPath, _ := filepath.Rel(folderPath, finalFilePath)
+	json.NewEncoder(w).Encode(UploadCheckResponse{
+		Present: true,
+		Path:    filepath.ToSlash(relPath),
+		Method:  method,
+	})
+}
+
+// pickExistingFile returns the first candidate the caller can read that is
+// still on disk unchanged since it was indexed.
+func pickExistingFile(claims jwt.MapClaims, candidates []models.FileEntry) (string, bool) {
+	for _, candidate := range candidates {
+		if canAccess, _ := auth.HasAccess(claims, candidate.FolderId, "r"); !canAccess {
+			continue
+		}
+		root, _, err := files.ResolveFolder(candidate.FolderId)
+		if err != nil {
+			continue
+		}
+		path, err := files.SafeJoin(root, candidate.Path)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || info.Size() != candidate.Size || !info.ModTime().Truncate(time.Microsecond).Equal(candidate.ModTime) {
+			continue
+		}
+		return path, true
+	}
+	return "", false
+}
+
+// linkExistingFile adds sourcePath's content at finalFilePath, or at a
+// `file (1)` style name if it is taken. Returns the path used.
+func linkExistingFile(sourcePath string, finalFilePath string) (string, string, error) {
+	for {
+		finalFilePath = helpers.GetUniqueFileName(finalFilePath)
+		method, err := files.CloneFile(sourcePath, finalFilePath)
+		if errors.Is(err, files.ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return "", "", err
+		}
+		syncDir(filepath.Dir(finalFilePath))
+		return finalFilePath, method, nil
+	}
+}
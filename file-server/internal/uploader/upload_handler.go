@@ -4,16 +4,19 @@ import (
 	// "bufio"
 	"crypto/md5"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
+	"time"
 	
 	"github.com/google/uuid"
 	"github.com/golang-jwt/jwt/v5"
@@ -21,46 +24,42 @@ import (
 	"file-server/config"
 	"file-server/internal/job"
 	"file-server/internal/auth"
+	"file-server/internal/files"
 	"file-server/internal/helpers"
 	
 )
 
+const (
+	chunkMetaFile    = "meta.json"   // Upload metadata, written alongside the chunks
+	assemblyTempFile = ".assembling" // Assembled file before it is verified and committed
+
+	assemblyLockRetry   = 100 * time.Millisecond
+	assemblyLockTimeout = 2 * time.Minute // Longest wait for a name held by another commit, rename or move
+)
+
 type ChunkMeta struct {
-	FileId        string
-	FileName      string
-	FileExtension string
-	MD5Hash       string
-	ChunkIndex    int
-	TotalChunks   int
+	FileId        string `json:"file_id"`
+	FileName      string `json:"file_name"`
+	FileExtension string `json:"file_extension"`
+	MD5Hash       string `json:"md5_hash"`
+	ChunkIndex    int    `json:"-"`
+	TotalChunks   int    `json:"total_chunks"`
+	Path          string `json:"path,omitempty"` // Sub folder to commit the file to, created if missing
+	ChunkSize     int64  `json:"chunk_size,omitempty"` // Set by /upload-init, every chunk but the last is this size
+	Size          int64  `json:"size,omitempty"`       // Set by /upload-init, size of the assembled file
 }
 
 type Chunk struct {
 	File multipart.File
-}
-
-func getUniqueFileName(path string) string {
-	counter := 1
-	dir := filepath.Dir(path)
-	ext := filepath.Ext(path)
-	baseName := strings.TrimSuffix(filepath.Base(path), ext)
-
-	for {
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			return path
-		}
-
-		newName := fmt.Sprintf("%s (%d)%s", baseName, counter, ext)
-		path = filepath.Join(dir, newName)
-		counter++
-	}
+	Size int64
 }
 
 func ParseFormFileId(w http.ResponseWriter, r *http.Request) (string, error) {
-	const MAX_MBYTES = 5
+	maxChunkSize := config.LoadConfig().UploadChunkSize
 
-	r.Body = http.MaxBytesReader(w, r.Body, (MAX_MBYTES<<20)+1024)
+	r.Body = http.MaxBytesReader(w, r.Body, maxChunkSize+maxFormOverhead)
 
-	if err := r.ParseMultipartForm(MAX_MBYTES << 20); err != nil {
+	if err := r.ParseMultipartForm(min(maxChunkSize, maxFormMemory)); err != nil {
 		return "", fmt.Errorf("unable to parse form: %w", err)
 	}
 
@@ -70,12 +69,16 @@ func ParseFormFileId(w http.ResponseWriter, r *http.Request) (string, error) {
 	return r.FormValue("fileId"), nil
 }
 
+// ParseForm parses a chunk request of at most the configured chunk size.
 func ParseForm(w http.ResponseWriter, r *http.Request) (ChunkMeta, Chunk, error) {
-	const MAX_MBYTES = 5
+	return parseChunkForm(w, r, config.LoadConfig().UploadChunkSize)
+}
 
-	r.Body = http.MaxBytesReader(w, r.Body, (MAX_MBYTES<<20)+1024)
+// parseChunkForm parses a chunk request, refusing chunks over maxChunkSize bytes.
+func parseChunkForm(w http.ResponseWriter, r *http.Request, maxChunkSize int64) (ChunkMeta, Chunk, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxChunkSize+maxFormOverhead)
 
-	if err := r.ParseMultipartForm(MAX_MBYTES << 20); err != nil {
+	if err := r.ParseMultipartForm(min(maxChunkSize, maxFormMemory)); err != nil {
 		return ChunkMeta{}, Chunk{}, fmt.Errorf("unable to parse form: %w", err)
 	}
 
@@ -107,6 +110,9 @@ func ParseForm(w http.ResponseWriter, r *http.Request) (ChunkMeta, Chunk, error)
 	if files[0].Size == 0 {
 		return ChunkMeta{}, Chunk{}, fmt.Errorf("chunk file is empty")
 	}
+	if files[0].Size > maxChunkSize {
+		return ChunkMeta{}, Chunk{}, fmt.Errorf("chunk file exceeds %d bytes", maxChunkSize)
+	}
 
 	chunkIndex, err := strconv.Atoi(r.FormValue("chunkIndex"))
 	if err != nil {
@@ -136,14 +142,11 @@ func ParseForm(w http.ResponseWriter, r *http.Request) (ChunkMeta, Chunk, error)
 		TotalChunks:   totalChunks,
 	}
 
-	fileNameRegex := regexp.MustCompile(`^[a-zA-Z0-9._ -\(\)\-]+$`)
-	if !fileNameRegex.MatchString(meta.FileName) {
-		return ChunkMeta{}, Chunk{}, fmt.Errorf("invalid file name format: %s", meta.FileName)
+	if err := helpers.ValidateFileName(meta.FileName, meta.FileExtension); err != nil {
+		return ChunkMeta{}, Chunk{}, err
 	}
-
-	fileExtensionRegex := regexp.MustCompile(`^\.(jpe?g|png|pdf|docx|doc|xlsx|xls|pptx|ppt|txt|csv|rtf|odt|ods|odp|heic|webp|gif|bmp|tiff?|mp3|wav|m4a|aac|flac|ogg|mp4|m4v|mov|mkv|avi|flv|wmv|webm|zip|rar|7z|tar|gz|iso|epub|azw3|mobi|ics|vcf|psd|ai|svg|html|css|js|json|xml)$`)
-	if !fileExtensionRegex.MatchString(strings.ToLower(meta.FileExtension)) {
-		return ChunkMeta{}, Chunk{}, fmt.Errorf("invalid file extension: %s", meta.FileExtension)
+	if meta.Path, err = cleanUploadPath(r.FormValue("path")); err != nil {
+		return ChunkMeta{}, Chunk{}, err
 	}
 
 	if _, err := uuid.Parse(meta.FileId); err != nil {
@@ -152,20 +155,53 @@ func ParseForm(w http.ResponseWriter, r *http.Request) (ChunkMeta, Chunk, error)
 
 	chunk := Chunk{
 		File: file,
+		Size: files[0].Size,
 	}
 
 	return meta, chunk, nil
 }
 
 func ChunkAssemble(meta ChunkMeta, jm *job.JobManager, absolutePath string) {
-	cfg := config.LoadConfig()
-
 	defer jm.ReleaseJob(meta.FileId)
 
+	event := job.Event{
+		FolderId: folderIdOf(absolutePath),
+		UploadId: meta.FileId,
+		File:     path.Join(meta.Path, meta.FileName+meta.FileExtension),
+	}
+	event.Type = job.EventAssemblyStarted
+	jm.Publish(event)
+
+	finalFilePath, err := assembleChunks(meta, absolutePath, func(tempFilePath string, _ string) (string, error) {
+		return commitUpload(jm, absolutePath, meta.Path, meta.FileName+meta.FileExtension, tempFilePath)
+	})
+	if err != nil {
+		log.Printf("[FILE-SERVER] Error assembling file %s: %v", meta.FileId, err)
+		publishAssemblyFailed(jm, event, err)
+		return
+	}
+
+	log.Printf("[FILE-SERVER] Successfully assembled file %s", finalFilePath)
+
+	if relPath, err := filepath.Rel(absolutePath, finalFilePath); err == nil {
+		event.File = filepath.ToSlash(relPath)
+	}
+	event.Type = job.EventAssemblyFinished
+	jm.Publish(event)
+	publishUploaded(jm, absolutePath, meta.FileId, finalFilePath)
+}
+
+// assembleChunks concatenates the chunks of an upload into a temporary file inside
+// the chunk directory, verifies it and hands it to commit along with the path it
+// is named after, commit moves it to its final location and returns that.
+// A crash at any point leaves either the chunks or a verified temporary file behind,
+// never a truncated file under the final name.
+func assembleChunks(meta ChunkMeta, absolutePath string, commit func(tempFilePath string, namePath string) (string, error)) (string, error) {
+	cfg := config.LoadConfig()
+
 	chunksDir := filepath.Join(absolutePath, cfg.ChunksDir, meta.FileId)
 	if _, err := os.Stat(chunksDir); os.IsNotExist(err) {
-		log.Printf("[FILE-SERVER] Chunk directory %s does not exist for file ID: %s", chunksDir, meta.FileId)
-		return
+		return "", fmt.Errorf("chunk directory %s does not exist", chunksDir)
 	}
 
 	defer func() {
@@ -174,51 +210,232 @@ func ChunkAssemble(meta ChunkMeta, jm *job.JobManager, absolutePath string) {
 		}
 	}()
 
-	finalFilePath := filepath.Join(absolutePath, meta.FileName+meta.FileExtension)
+	tempFilePath := filepath.Join(chunksDir, assemblyTempFile)
+	if err := writeAssembly(meta, chunksDir, tempFilePath); err != nil {
+		os.Remove(tempFilePath)
+		return "", err
+	}
+
+	// The verified temp file is now the only copy needed, drop the chunks so that
+	// recovery knows to commit the temp file rather than assemble again
+	if err := removeChunkFiles(chunksDir); err != nil {
+		return "", err
+	}
 
-	finalFilePath = getUniqueFileName(finalFilePath) // If file exists then save as `file (1)`
+	dirPath, err := targetDir(absolutePath, meta.Path)
+	if err != nil {
+		return "", err
+	}
+	return commit(tempFilePath, filepath.Join(dirPath, meta.FileName+meta.FileExtension))
+}
 
-	finalFile, err := os.Create(finalFilePath)
+// cleanUploadPath checks the optional sub folder of an upload, each level
+// must be a valid folder name.
+func cleanUploadPath(uploadPath string) (string, error) {
+	uploadPath = strings.Trim(filepath.ToSlash(uploadPath), "/")
+	if uploadPath == "" {
+		return "", nil
+	}
+	for _, folderName := range strings.Split(uploadPath, "/") {
+		if err := helpers.ValidateFolderName(folderName); err != nil {
+			return "", err
+		}
+	}
+	return uploadPath, nil
+}
+
+// targetDir returns the directory an upload is committed to, the folder
+// itself or the sub folder given with the upload, creating it if needed.
+func targetDir(absolutePath string, uploadPath string) (string, error) {
+	if uploadPath == "" {
+		return absolutePath, nil
+	}
+	dirPath, err := files.SafeJoin(absolutePath, uploadPath)
 	if err != nil {
-		log.Printf("[FILE-SERVER] Error creating final file %s: %v", finalFilePath, err)
-		return
+		return "", err
+	}
+	if err := os.MkdirAll(dirPath, os.ModePerm); err != nil {
+		return "", fmt.Errorf("error creating folder %s: %w", dirPath, err)
+	}
+	return dirPath, nil
+}
+
+var errMD5Mismatch = errors.New("MD5 mismatch")
+
+// writeAssembly writes all chunks into tempFilePath, flushes it to disk and
+// verifies its MD5 against the one announced by the client.
+func writeAssembly(meta ChunkMeta, chunksDir string, tempFilePath string) error {
+	tempFile, err := os.Create(tempFilePath)
+	if err != nil {
+		return fmt.Errorf("error creating temp file %s: %w", tempFilePath, err)
 	}
-	defer finalFile.Close()
+	defer tempFile.Close()
 
 	hasher := md5.New()
+	multiWriter := io.MultiWriter(tempFile, hasher)
 
 	for i := 0; i < meta.TotalChunks; i++ {
 		chunkPath := filepath.Join(chunksDir, fmt.Sprintf("chunk_%d", i))
 		chunkFile, err := os.Open(chunkPath)
 		if err != nil {
-			log.Printf("[FILE-SERVER] Error while opening chunk %s : %q", chunkPath, err)
-			return
+			return fmt.Errorf("error while opening chunk %s: %w", chunkPath, err)
 		}
 
-		multiWriter := io.MultiWriter(finalFile, hasher)
 		if _, err := io.Copy(multiWriter, chunkFile); err != nil {
 			chunkFile.Close()
-			log.Printf("[FILE-SERVER] Error copying chunk %s: %v", chunkPath, err)
-			return
+			return fmt.Errorf("error copying chunk %s: %w", chunkPath, err)
 		}
 		chunkFile.Close()
 	}
 
+	if err := tempFile.Sync(); err != nil {
+		return fmt.Errorf("error syncing temp file %s: %w", tempFilePath, err)
+	}
+
 	computedHash := hex.EncodeToString(hasher.Sum(nil))
 	expectedHash := strings.ToLower(strings.TrimSpace(meta.MD5Hash))
 
-	if strings.ToLower(computedHash) != expectedHash {
-		finalFile.Close() // File has to be closed in order to be removed
+	if computedHash != expectedHash {
+		return fmt.Errorf("%w. Computed: %s, Expected: %s", errMD5Mismatch, computedHash, expectedHash)
+	}
 
-		log.Printf("[FILE-SERVER] MD5 mismatch for %s. Computed: %s, Expected: %s", meta.FileId, computedHash, expectedHash)
-		if err := os.Remove(finalFilePath); err != nil {
-			log.Printf("[FILE-SERVER] Error while deleting final file path %s: %q", finalFilePath, err)
+	return tempFile.Close()
+}
+
+// verifyFile checks that an already assembled file matches the expected MD5 hash.
+func verifyFile(filePath string, md5Hash string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hasher := md5.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return err
+	}
+
+	computedHash := hex.EncodeToString(hasher.Sum(nil))
+	expectedHash := strings.ToLower(strings.TrimSpace(md5Hash))
+	if computedHash != expectedHash {
+		return fmt.Errorf("%w. Computed: %s, Expected: %s", errMD5Mismatch, computedHash, expectedHash)
+	}
+	return nil
+}
+
+func countChunkFiles(chunksDir string) (int, error) {
+	entries, err := os.ReadDir(chunksDir)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "chunk_") {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func removeChunkFiles(chunksDir string) error {
+	entries, err := os.ReadDir(chunksDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), "chunk_") {
+			continue
+		}
+		if err := os.Remove(filepath.Join(chunksDir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CommitFile moves a fully written temp file to finalFilePath, picking `file (1)`
+// style names instead of overwriting existing files. Returns the path used.
+func CommitFile(tempFilePath string, finalFilePath string) (string, error) {
+	for {
+		finalFilePath = helpers.GetUniqueFileName(finalFilePath) // If file exists then save as `file (1)`
+
+		// Link fails if the name got taken since the check above, rename would silently overwrite it
+		err := os.Link(tempFilePath, finalFilePath)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			if err := os.Rename(tempFilePath, finalFilePath); err != nil {
+				return "", fmt.Errorf("error moving %s to %s: %w", tempFilePath, finalFilePath, err)
+			}
+		} else if err := os.Remove(tempFilePath); err != nil {
+			log.Printf("[FILE-SERVER] Error removing temp file %s: %v", tempFilePath, err)
 		}
+		break
+	}
 
+	syncDir(filepath.Dir(finalFilePath))
+	return finalFilePath, nil
+}
+
+// lockTarget holds the name a file committed as namePath ends up with: namePath,
+// or the `file (1)` style name CommitFile picks when it is taken. The caller
+// releases the returned path.
+func lockTarget(jm *job.JobManager, namePath string) (string, error) {
+	for {
+		targetPath := helpers.GetUniqueFileName(namePath)
+		if err := lockName(jm, targetPath); err != nil {
+			return "", err
+		}
+		// The holder may have written the name before releasing it
+		if _, err := os.Lstat(targetPath); os.IsNotExist(err) {
+			return targetPath, nil
+		}
+		jm.ReleaseJob(targetPath)
+	}
+}
+
+// lockName holds namePath, waiting for another commit, rename or move
+// holding it for up to assemblyLockTimeout.
+func lockName(jm *job.JobManager, namePath string) error {
+	deadline := time.Now().Add(assemblyLockTimeout)
+	for !jm.AcquireJob(namePath) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s to be released", namePath)
+		}
+		time.Sleep(assemblyLockRetry)
+	}
+	return nil
+}
+
+// syncDir flushes a directory entry change (create, rename) to disk.
+func syncDir(dirPath string) {
+	dir, err := os.Open(dirPath)
+	if err != nil {
 		return
 	}
+	defer dir.Close()
+	_ = dir.Sync()
+}
 
-	log.Printf("[FILE-SERVER] Successfully assembled file %s", finalFilePath)
+// writeChunkMeta persists the upload metadata next to its chunks the first time a
+// chunk arrives, so an interrupted upload can be assembled again after a restart.
+func writeChunkMeta(chunksDir string, meta ChunkMeta) error {
+	metaPath := filepath.Join(chunksDir, chunkMetaFile)
+	if _, err := os.Stat(metaPath); err == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	tempPath := metaPath + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, metaPath)
 }
 
 func UploadHandler(w http.ResponseWriter, r *http.Request, jm *job.JobManager, folderPath string) {
@@ -239,10 +456,20 @@ func UploadHandler(w http.ResponseWriter, r *http.Request, jm *job.JobManager, f
 		http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
 		return
 	}
+
+	userId, _ := claims["user_id"].(string)
+	release, ok := AcquireUploadSlot(r, folderPath, auth.ClientId(claims))
+	if !ok {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Too many chunks in flight, retry later", http.StatusTooManyRequests)
+		return
+	}
+	defer release()
 	
 	cfg := config.LoadConfig()
 
-	meta, chunk, err := ParseForm(w, r)
+	// The session's chunk size is only known once the form is parsed
+	meta, chunk, err := parseChunkForm(w, r, largestChunkSize())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -255,54 +482,57 @@ func UploadHandler(w http.ResponseWriter, r *http.Request, jm *job.JobManager, f
 		return
 	}
 
-	chunkFilePath := filepath.Join(chunksDir, fmt.Sprintf("chunk_%d", meta.ChunkIndex))
-	out, err := os.Create(chunkFilePath)
-	if err != nil {
-		http.Error(w, "Unable to create chunk file", http.StatusInternalServerError)
+	if err := writeChunkMeta(chunksDir, meta); err != nil {
+		http.Error(w, "Error saving upload metadata", http.StatusInternalServerError)
 		return
 	}
-	defer out.Close()
 
-	_, err = io.Copy(out, chunk.File)
+	session, err := sessions.open(chunksDir, meta)
 	if err != nil {
-		http.Error(w, "Error saving chunk", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	files, err := os.ReadDir(chunksDir)
-	if err != nil {
-		http.Error(w, "Error reading chunk directory", http.StatusInternalServerError)
+	if err := checkChunkSize(session.meta, meta.ChunkIndex, chunk.Size, chunkSizeFor(userId)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Uploads started without /upload-init declare no size, what they sent so far is capped instead
+	if session.meta.ChunkSize == 0 && cfg.UploadMaxFileSize > 0 &&
+		sessions.receivedBytes(chunksDir, meta.ChunkIndex)+chunk.Size > cfg.UploadMaxFileSize {
+		http.Error(w, fmt.Sprintf("File exceeds the maximum size of %d bytes", cfg.UploadMaxFileSize), http.StatusRequestEntityTooLarge)
 		return
 	}
 
-	if len(files) == meta.TotalChunks {
+	// A chunk sent again after the last one arrived is already part of the file
+	if !sessions.isAssembling(chunksDir) {
+		if err := writeChunk(chunksDir, meta.ChunkIndex, chunk.File); err != nil {
+			http.Error(w, "Error saving chunk", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	received, complete := sessions.chunkWritten(chunksDir, meta.ChunkIndex, chunk.Size)
+	jm.Publish(job.Event{
+		Type:     job.EventChunkReceived,
+		FolderId: folderIdOf(folderPath),
+		UploadId: meta.FileId,
+		File:     path.Join(session.meta.Path, session.meta.FileName+session.meta.FileExtension),
+		Received: received,
+		Total:    session.meta.TotalChunks,
+	})
+	if complete {
 		if (jm.AcquireJob(meta.FileId)) {
-			go func (){
-				ChunkAssemble(meta, jm, folderPath)
+			assembleMeta := session.meta
+			jm.Go(func (){
+				defer sessions.close(chunksDir)
+				ChunkAssemble(assembleMeta, jm, folderPath)
 
 				if folderPath != cfg.UploadDir {
-					entries, err := os.ReadDir(folderPath)
-					if err != nil {
-						log.Printf("[FILE-SERVER] Error while reading directory : %v", err)
-					}
-					files := make([]string, len(entries))
-					for index, entry := range entries {
-						if strings.HasSuffix(entry.Name(), ".zip") {
-							continue
-						}
-						files[index] = entry.Name()
-					}
-
-					folderId := filepath.Base(folderPath)
-					zipFileName := fmt.Sprintf("%s.zip", folderId)
-					err = helpers.CreateZip(folderPath, zipFileName, files, jm)
-					if err != nil {
-						log.Printf("[FILE-SERVER] Received error while creating zip file : %v", err)
-					} else {
-						log.Printf("[FILE-SERVER] Successfully created zip file : %s", zipFileName)
-					}
+					helpers.RefreshShareZip(folderPath, jm)
 				}
-			}()
+			})
+		} else {
+			sessions.reopen(chunksDir)
 		}
 	}
 
@@ -0,0 +1,198 @@
+package uploader
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"file-server/config"
+	"file-server/internal/auth"
+	"file-server/internal/files"
+	"file-server/internal/helpers"
+)
+
+const (
+	maxFormOverhead = 4 << 10  // Multipart fields and boundaries sent along with a chunk
+	maxFormMemory   = 32 << 20 // Chunks larger than this are buffered on disk while parsed
+)
+
+type UploadInitDetails struct {
+	FolderId      string `json:"folder_id"`
+	UploadId      string `json:"upload_id,omitempty"` // Resumes this upload when given, a new one is started otherwise
+	FileName      string `json:"file_name"`
+	FileExtension string `json:"file_extension"`
+	MD5Hash       string `json:"md5_hash"`
+	Size          int64  `json:"size"`
+	Path          string `json:"path,omitempty"` // Sub folder to commit the file to, as with uploads
+}
+
+type UploadInitResponse struct {
+	UploadId    string `json:"upload_id"` // Sent as fileId with every chunk
+	ChunkSize   int64  `json:"chunk_size"`
+	TotalChunks int    `json:"total_chunks"`
+	MaxFileSize int64  `json:"max_file_size,omitempty"` // Zero for no limit
+}
+
+// UploadInitHandler starts an upload session. The server picks the chunk size
+// and every chunk is then checked against it, the last one holding the rest.
+func UploadInitHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claimsRaw := r.Context().Value(auth.ClaimsContextKey)
+	claims, ok := claimsRaw.(jwt.MapClaims)
+	if !ok {
+		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		return
+	}
+
+	var details UploadInitDetails
+	if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+		http.Error(w, "Unable to parse upload parameters", http.StatusBadRequest)
+		return
+	}
+	if err := helpers.ValidateFileName(details.FileName, details.FileExtension); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	uploadPath, err := cleanUploadPath(details.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	details.MD5Hash = strings.ToLower(strings.TrimSpace(details.MD5Hash))
+	if len(details.MD5Hash) != 32 || details.Size <= 0 {
+		http.Error(w, "Invalid md5_hash or size", http.StatusBadRequest)
+		return
+	}
+	if details.UploadId == "" {
+		details.UploadId = uuid.New().String()
+	} else if _, err := uuid.Parse(details.UploadId); err != nil {
+		http.Error(w, "Invalid upload_id", http.StatusBadRequest)
+		return
+	}
+
+	folderPath, accessKey, err := files.ResolveFolder(details.FolderId)
+	if err != nil {
+		http.Error(w, "Folder not found", http.StatusNotFound)
+		return
+	}
+	canAccess, err := auth.HasAccess(claims, accessKey, "w")
+	if err != nil || !canAccess {
+		http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+		return
+	}
+
+	cfg := config.LoadConfig()
+	if cfg.UploadMaxFileSize > 0 && details.Size > cfg.UploadMaxFileSize {
+		http.Error(w, fmt.Sprintf("File exceeds the maximum size of %d bytes", cfg.UploadMaxFileSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	userId, _ := claims["user_id"].(string)
+	chunkSize := chunkSizeFor(userId)
+	meta := ChunkMeta{
+		FileId:        details.UploadId,
+		FileName:      details.FileName,
+		FileExtension: details.FileExtension,
+		MD5Hash:       details.MD5Hash,
+		TotalChunks:   int((details.Size + chunkSize - 1) / chunkSize),
+		Path:          uploadPath,
+		ChunkSize:     chunkSize,
+		Size:          details.Size,
+	}
+
+	chunksDir := filepath.Join(folderPath, cfg.ChunksDir, meta.FileId)
+	if stored, err := readChunkMeta(chunksDir); err == nil {
+		// Resuming keeps the chunk size the upload started with
+		if stored.MD5Hash != meta.MD5Hash || stored.Size != meta.Size || stored.ChunkSize == 0 {
+			http.Error(w, "Upload id already used by another upload", http.StatusConflict)
+			return
+		}
+		meta = stored
+	} else {
+		if err := os.MkdirAll(chunksDir, os.ModePerm); err != nil {
+			http.Error(w, "Error creating directory", http.StatusInternalServerError)
+			return
+		}
+		if err := writeChunkMeta(chunksDir, meta); err != nil {
+			http.Error(w, "Error saving upload metadata", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("[FILE-SERVER] Started upload %s of %s%s in %d chunks", meta.FileId, meta.FileName, meta.FileExtension, meta.TotalChunks)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UploadInitResponse{
+		UploadId:    meta.FileId,
+		ChunkSize:   meta.ChunkSize,
+		TotalChunks: meta.TotalChunks,
+		MaxFileSize: cfg.UploadMaxFileSize,
+	})
+}
+
+// chunkSizeFor returns the chunk size of a user, from UploadChunkSizes when
+// listed there and UploadChunkSize otherwise.
+func chunkSizeFor(userId string) int64 {
+	cfg := config.LoadConfig()
+	for _, entry := range strings.Split(cfg.UploadChunkSizes, ",") {
+		user, size, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || user != userId {
+			continue
+		}
+		chunkSize, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || chunkSize <= 0 {
+			log.Printf("[FILE-SERVER] Invalid chunk size %q for %s in UPLOAD_CHUNK_SIZES", size, user)
+			break
+		}
+		return chunkSize
+	}
+	return cfg.UploadChunkSize
+}
+
+// largestChunkSize returns the largest chunk size any user may send, which
+// bounds a chunk request before its upload session is known.
+func largestChunkSize() int64 {
+	cfg := config.LoadConfig()
+	largest := cfg.UploadChunkSize
+	for _, entry := range strings.Split(cfg.UploadChunkSizes, ",") {
+		_, size, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		if chunkSize, err := strconv.ParseInt(size, 10, 64); err == nil && chunkSize > largest {
+			largest = chunkSize
+		}
+	}
+	return largest
+}
+
+// checkChunkSize verifies a chunk against the chunk size of its upload
+// session. Uploads started without /upload-init have none, their chunks are
+// held to maxChunkSize instead.
+func checkChunkSize(meta ChunkMeta, index int, size int64, maxChunkSize int64) error {
+	if meta.ChunkSize == 0 {
+		if size > maxChunkSize {
+			return fmt.Errorf("chunk %d is %d bytes, at most %d allowed", index, size, maxChunkSize)
+		}
+		return nil
+	}
+	expected := meta.ChunkSize
+	if index == meta.TotalChunks-1 {
+		expected = meta.Size - int64(meta.TotalChunks-1)*meta.ChunkSize
+	}
+	if size != expected {
+		return fmt.Errorf("chunk %d is %d bytes, expected %d", index, size, expected)
+	}
+	return nil
+}
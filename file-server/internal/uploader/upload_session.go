@@ -0,0 +1,272 @@
+package uploader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"file-server/config"
+	"file-server/internal/job"
+	"file-server/internal/throttle"
+)
+
+// uploadSession tracks which chunks of an upload have been written in full.
+// Chunks of one upload arrive in parallel, so a directory listing can show
+// chunks still being written; completion is decided here instead.
+type uploadSession struct {
+	meta       ChunkMeta
+	received   map[int]int64 // Size of each chunk written
+	assembling bool
+	lastActive time.Time
+}
+
+type uploadSessions struct {
+	mu    sync.Mutex
+	byDir map[string]*uploadSession // By chunk directory
+}
+
+var (
+	sessions = &uploadSessions{byDir: make(map[string]*uploadSession)}
+
+	limitsMu        sync.RWMutex
+	uploadLimits    *throttle.Limits
+	uploadBandwidth *throttle.Bandwidth
+)
+
+// SetUploadLimits sets the per user cap on chunks in flight, nil for none.
+func SetUploadLimits(limits *throttle.Limits) {
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+	uploadLimits = limits
+}
+
+// SetUploadBandwidth sets the buckets upload bodies are read through, nil
+// for no limit.
+func SetUploadBandwidth(bandwidth *throttle.Bandwidth) {
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+	uploadBandwidth = bandwidth
+}
+
+// AcquireUploadSlot takes one of the user's in-flight chunk slots and slows
+// the request body down to the upload rates of folderPath and the user. ok
+// is false when the user already has as many chunks in flight as allowed.
+// Every request carrying upload data goes through it, WebDAV PUTs included,
+// with auth.ClientId as the user of token requests.
+func AcquireUploadSlot(r *http.Request, folderPath string, user string) (release func(), ok bool) {
+	limitsMu.RLock()
+	limits, bandwidth := uploadLimits, uploadBandwidth
+	limitsMu.RUnlock()
+
+	release = func() {}
+	if limits != nil {
+		if release, ok = limits.Acquire(user); !ok {
+			return nil, false
+		}
+	}
+	if bandwidth != nil {
+		r.Body = bandwidth.Reader(r.Context(), shareOf(folderPath), user, r.Body)
+	}
+	return release, true
+}
+
+// shareOf returns the folder id of the share folderPath is, "" for the
+// library.
+func shareOf(folderPath string) string {
+	if filepath.Clean(folderPath) == filepath.Clean(config.LoadConfig().UploadDir) {
+		return ""
+	}
+	return filepath.Base(folderPath)
+}
+
+// folderIdOf returns the folder id events about folderPath are published
+// under, "/" for the library.
+func folderIdOf(folderPath string) string {
+	if share := shareOf(folderPath); share != "" {
+		return share
+	}
+	return "/"
+}
+
+// publishUploaded tells the subscribers of folderPath that the upload
+// uploadId was committed to it as filePath.
+func publishUploaded(jm *job.JobManager, folderPath string, uploadId string, filePath string) {
+	relPath, err := filepath.Rel(folderPath, filePath)
+	if err != nil {
+		return
+	}
+	event := job.Event{
+		Type:     job.EventUploadCompleted,
+		FolderId: folderIdOf(folderPath),
+		UploadId: uploadId,
+		File:     filepath.ToSlash(relPath),
+	}
+	jm.Publish(event)
+	event.Type = job.EventFileAdded
+	jm.Publish(event)
+}
+
+// publishAssemblyFailed tells the subscribers of an upload that it could not
+// be committed, and whether it was for not matching its checksum.
+func publishAssemblyFailed(jm *job.JobManager, event job.Event, err error) {
+	event.Type, event.Error = job.EventAssemblyFailed, err.Error()
+	jm.Publish(event)
+	if errors.Is(err, errMD5Mismatch) {
+		event.Type = job.EventChecksumFailed
+		jm.Publish(event)
+	}
+}
+
+// open returns the session of the upload in chunksDir, checking that meta
+// belongs to it. Without one, e.g. after a restart, the session starts from
+// the chunks already on disk.
+func (s *uploadSessions) open(chunksDir string, meta ChunkMeta) (*uploadSession, error) {
+	cfg := config.LoadConfig()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for dir, session := range s.byDir {
+		if !session.assembling && time.Since(session.lastActive) > cfg.ChunkTTL {
+			delete(s.byDir, dir)
+		}
+	}
+
+	session, ok := s.byDir[chunksDir]
+	if !ok {
+		session = &uploadSession{meta: meta, received: make(map[int]int64)}
+		if stored, err := readChunkMeta(chunksDir); err == nil {
+			session.meta = stored
+		}
+		for _, index := range writtenChunks(chunksDir) {
+			if info, err := os.Stat(filepath.Join(chunksDir, fmt.Sprintf("chunk_%d", index))); err == nil {
+				session.received[index] = info.Size()
+			}
+		}
+		s.byDir[chunksDir] = session
+	}
+	session.lastActive = time.Now()
+
+	if meta.TotalChunks != session.meta.TotalChunks || meta.MD5Hash != session.meta.MD5Hash {
+		return nil, fmt.Errorf("chunk does not match upload %s", meta.FileId)
+	}
+	if meta.ChunkIndex >= session.meta.TotalChunks {
+		return nil, fmt.Errorf("invalid chunk index: %d", meta.ChunkIndex)
+	}
+	return session, nil
+}
+
+// isAssembling tells whether the upload already has all its chunks, a chunk
+// sent again is then not written.
+func (s *uploadSessions) isAssembling(chunksDir string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.byDir[chunksDir]
+	return ok && session.assembling
+}
+
+// receivedBytes returns the size of the chunks of the upload written so far,
+// leaving out chunk except, which a chunk sent again replaces.
+func (s *uploadSessions) receivedBytes(chunksDir string, except int) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.byDir[chunksDir]
+	if !ok {
+		return 0
+	}
+	var total int64
+	for index, size := range session.received {
+		if index != except {
+			total += size
+		}
+	}
+	return total
+}
+
+// chunkWritten records a chunk of size bytes written in full and returns how
+// many of the chunks are in. complete is true, once, when it was the last one
+// missing; the caller then assembles the upload.
+func (s *uploadSessions) chunkWritten(chunksDir string, index int, size int64) (received int, complete bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.byDir[chunksDir]
+	if !ok {
+		return 0, false
+	}
+	session.received[index] = size
+	session.lastActive = time.Now()
+	received = len(session.received)
+	if session.assembling || received < session.meta.TotalChunks {
+		return received, false
+	}
+	session.assembling = true
+	return received, true
+}
+
+// reopen lets chunks complete the upload again, after its assembly could
+// not be started.
+func (s *uploadSessions) reopen(chunksDir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.byDir[chunksDir]; ok {
+		session.assembling = false
+	}
+}
+
+func (s *uploadSessions) close(chunksDir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.byDir, chunksDir)
+}
+
+// writtenChunks lists the indexes of the chunks in chunksDir.
+func writtenChunks(chunksDir string) []int {
+	entries, err := os.ReadDir(chunksDir)
+	if err != nil {
+		return nil
+	}
+	indexes := []int{}
+	for _, entry := range entries {
+		suffix, ok := strings.CutPrefix(entry.Name(), "chunk_")
+		if !ok {
+			continue
+		}
+		if index, err := strconv.Atoi(suffix); err == nil {
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes
+}
+
+// writeChunk writes a chunk under a temporary name and renames it into place
+// once complete, so `chunk_N` files are always whole. Leftover temporary
+// files end in .tmp and are removed by recovery.
+func writeChunk(chunksDir string, index int, content io.Reader) error {
+	tempFile, err := os.CreateTemp(chunksDir, fmt.Sprintf(".chunk_%d-*.tmp", index))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tempFile, content); err != nil {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		os.Remove(tempFile.Name())
+		return err
+	}
+	if err := os.Rename(tempFile.Name(), filepath.Join(chunksDir, fmt.Sprintf("chunk_%d", index))); err != nil {
+		os.Remove(tempFile.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package uploader
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"file-server/config"
+	"file-server/internal/auth"
+	"file-server/internal/files"
+)
+
+type UploadStatusResponse struct {
+	FileId      string `json:"file_id"`
+	TotalChunks int    `json:"total_chunks,omitempty"` // Zero when no chunk has been received
+	Received    []int  `json:"received"`               // Indexes of the chunks the server has
+}
+
+// UploadStatusHandler reports which chunks of an upload the server already
+// has, so an interrupted upload can be resumed by sending only the others.
+func UploadStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fileId := r.URL.Query().Get("file_id")
+	if _, err := uuid.Parse(fileId); err != nil {
+		http.Error(w, "Invalid file_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	claimsRaw := r.Context().Value(auth.ClaimsContextKey)
+	claims, ok := claimsRaw.(jwt.MapClaims)
+	if !ok {
+		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		return
+	}
+
+	folderPath, accessKey, err := files.ResolveFolder(r.URL.Query().Get("folder_id"))
+	if err != nil {
+		http.Error(w, "Folder not found", http.StatusNotFound)
+		return
+	}
+	canAccess, err := auth.HasAccess(claims, accessKey, "w")
+	if err != nil || !canAccess {
+		http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+		return
+	}
+
+	cfg := config.LoadConfig()
+	chunksDir := filepath.Join(folderPath, cfg.ChunksDir, fileId)
+
+	response := UploadStatusResponse{FileId: fileId, Received: []int{}}
+	if meta, err := readChunkMeta(chunksDir); err == nil {
+		response.TotalChunks = meta.TotalChunks
+	}
+	entries, err := os.ReadDir(chunksDir)
+	if err != nil && !os.IsNotExist(err) {
+		http.Error(w, "Error reading chunk directory", http.StatusInternalServerError)
+		return
+	}
+	for _, entry := range entries {
+		index, err := strconv.Atoi(strings.TrimPrefix(entry.Name(), "chunk_"))
+		if err != nil || !strings.HasPrefix(entry.Name(), "chunk_") {
+			continue
+		}
+		response.Received = append(response.Received, index)
+	}
+	sort.Ints(response.Received)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
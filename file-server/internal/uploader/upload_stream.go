@@ -0,0 +1,290 @@
+package uploader
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"file-server/config"
+	"file-server/internal/auth"
+	"file-server/internal/files"
+	"file-server/internal/helpers"
+	"file-server/internal/job"
+)
+
+const streamTempFile = "stream.tmp" // Body received so far, removed by recovery if left behind
+
+type UploadStreamResponse struct {
+	Path    string `json:"path"` // Where the file was committed, relative to the folder
+	Size    int64  `json:"size"`
+	MD5Hash string `json:"md5_hash"`
+}
+
+// UploadStreamHandler takes a whole file as the raw body of a PUT, streaming
+// it to disk without chunking. The body is checked against Content-Digest
+// (sha-256, sha-512 or md5) or Content-MD5 when given, then committed like an
+// assembled upload. Query parameters: folder_id ("/" by default), file_name
+// and optionally path.
+func UploadStreamHandler(w http.ResponseWriter, r *http.Request, jm *job.JobManager) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claimsRaw := r.Context().Value(auth.ClaimsContextKey)
+	claims, ok := claimsRaw.(jwt.MapClaims)
+	if !ok {
+		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	fileName := query.Get("file_name")
+	extension := path.Ext(fileName)
+	if err := helpers.ValidateFileName(strings.TrimSuffix(fileName, extension), extension); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	uploadPath, err := cleanUploadPath(query.Get("path"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	digests, err := parseDigests(r.Header)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	folderPath, accessKey, err := files.ResolveFolder(query.Get("folder_id"))
+	if err != nil {
+		http.Error(w, "Folder not found", http.StatusNotFound)
+		return
+	}
+	canAccess, err := auth.HasAccess(claims, accessKey, "w")
+	if err != nil || !canAccess {
+		http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+		return
+	}
+
+	cfg := config.LoadConfig()
+	if cfg.UploadMaxFileSize > 0 {
+		if r.ContentLength > cfg.UploadMaxFileSize {
+			http.Error(w, fmt.Sprintf("File exceeds the maximum size of %d bytes", cfg.UploadMaxFileSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, cfg.UploadMaxFileSize)
+	}
+
+	release, ok := AcquireUploadSlot(r, folderPath, auth.ClientId(claims))
+	if !ok {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Too many uploads in flight, retry later", http.StatusTooManyRequests)
+		return
+	}
+	defer release()
+
+	// Staged like chunks so that recovery and the janitor clean up after a crash
+	uploadId := uuid.New().String()
+	uploadDir := filepath.Join(folderPath, cfg.ChunksDir, uploadId)
+	if !jm.AcquireJob(uploadId) {
+		http.Error(w, "Upload currently processing", http.StatusConflict)
+		return
+	}
+	defer jm.ReleaseJob(uploadId)
+	if err := os.MkdirAll(uploadDir, os.ModePerm); err != nil {
+		http.Error(w, "Error creating directory", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(uploadDir)
+
+	tempFilePath := filepath.Join(uploadDir, streamTempFile)
+	size, md5Hash, err := receiveStream(tempFilePath, r.Body, digests)
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		http.Error(w, fmt.Sprintf("File exceeds the maximum size of %d bytes", cfg.UploadMaxFileSize), http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, errDigestMismatch):
+		jm.Publish(job.Event{
+			Type:     job.EventChecksumFailed,
+			FolderId: folderIdOf(folderPath),
+			UploadId: uploadId,
+			File:     path.Join(uploadPath, fileName),
+			Error:    err.Error(),
+		})
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("[FILE-SERVER] Error receiving streamed upload %s: %v", fileName, err)
+		http.Error(w, "Error saving upload", http.StatusInternalServerError)
+		return
+	}
+	if size == 0 {
+		http.Error(w, "File is empty", http.StatusBadRequest)
+		return
+	}
+
+	finalFilePath, err := commitUpload(jm, folderPath, uploadPath, fileName, tempFilePath)
+	if err != nil {
+		log.Printf("[FILE-SERVER] Error committing streamed upload %s: %v", fileName, err)
+		http.Error(w, "Error saving upload", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[FILE-SERVER] Successfully received file %s", finalFilePath)
+	publishUploaded(jm, folderPath, uploadId, finalFilePath)
+
+	if folderPath != cfg.UploadDir {
+		jm.Go(func() {
+			helpers.RefreshShareZip(folderPath, jm)
+		})
+	}
+
+	relPath, _ := filepath.Rel(folderPath, finalFilePath)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(UploadStreamResponse{
+		Path:    filepath.ToSlash(relPath),
+		Size:    size,
+		MD5Hash: md5Hash,
+	})
+}
+
+var errDigestMismatch = errors.New("digest mismatch")
+
+// digest is a hash the body must match, from Content-Digest or Content-MD5.
+type digest struct {
+	algorithm string
+	hash      hash.Hash
+	expected  []byte
+}
+
+// parseDigests reads the digests a request body is announced with. Content-Digest
+// algorithms other than sha-256, sha-512 and md5 are ignored, as RFC 9530 allows.
+func parseDigests(header http.Header) ([]digest, error) {
+	digests := []digest{}
+	if contentMD5 := header.Get("Content-MD5"); contentMD5 != "" {
+		expected, err := base64.StdEncoding.DecodeString(contentMD5)
+		if err != nil || len(expected) != md5.Size {
+			return nil, fmt.Errorf("invalid Content-MD5")
+		}
+		digests = append(digests, digest{algorithm: "md5", hash: md5.New(), expected: expected})
+	}
+
+	for _, member := range strings.Split(header.Get("Content-Digest"), ",") {
+		algorithm, value, ok := strings.Cut(strings.TrimSpace(member), "=")
+		if !ok {
+			continue
+		}
+		var newHash func() hash.Hash
+		switch strings.ToLower(algorithm) {
+		case "sha-256":
+			newHash = sha256.New
+		case "sha-512":
+			newHash = sha512.New
+		case "md5":
+			newHash = md5.New
+		default:
+			continue
+		}
+		// Byte sequences are base64 between colons
+		if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+			return nil, fmt.Errorf("invalid Content-Digest value for %s", algorithm)
+		}
+		expected, err := base64.StdEncoding.DecodeString(value[1 : len(value)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid Content-Digest value for %s", algorithm)
+		}
+		digests = append(digests, digest{algorithm: strings.ToLower(algorithm), hash: newHash(), expected: expected})
+	}
+	return digests, nil
+}
+
+// receiveStream writes body to tempFilePath, flushed to disk, and checks it
+// against digests. Returns its size and MD5.
+func receiveStream(tempFilePath string, body io.Reader, digests []digest) (int64, string, error) {
+	tempFile, err := os.Create(tempFilePath)
+	if err != nil {
+		return 0, "", err
+	}
+	defer tempFile.Close()
+
+	hasher := md5.New()
+	writers := []io.Writer{tempFile, hasher}
+	for _, d := range digests {
+		writers = append(writers, d.hash)
+	}
+	size, err := io.Copy(io.MultiWriter(writers...), body)
+	if err != nil {
+		return 0, "", err
+	}
+	if err := tempFile.Sync(); err != nil {
+		return 0, "", err
+	}
+
+	for _, d := range digests {
+		if !bytes.Equal(d.hash.Sum(nil), d.expected) {
+			return 0, "", fmt.Errorf("%w: %s of the body does not match", errDigestMismatch, d.algorithm)
+		}
+	}
+	return size, hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// commitUpload commits a complete, verified temp file as fileName in the sub
+// folder uploadPath of folderPath: under a unique name, holding the name
+// meanwhile, then through the assembly hooks. Chunked, streamed and tus uploads
+// all end here.
+// Returns the path the file ended up at.
+func commitUpload(jm *job.JobManager, folderPath string, uploadPath string, fileName string, tempFilePath string) (string, error) {
+	dirPath, err := targetDir(folderPath, uploadPath)
+	if err != nil {
+		return "", err
+	}
+
+	// Hold the name the file is committed to so that renames or moves onto it wait for the hooks
+	targetPath, err := lockTarget(jm, filepath.Join(dirPath, fileName))
+	if err != nil {
+		return "", err
+	}
+	defer jm.ReleaseJob(targetPath)
+
+	finalFilePath, err := CommitFile(tempFilePath, targetPath)
+	if err != nil {
+		return "", err
+	}
+	return runAssemblyHooks(folderPath, finalFilePath), nil
+}
+
+// ReplaceUpload commits a complete temp file as filePath, inside folderPath,
+// replacing the file there if any, then runs it through the assembly hooks
+// like any other upload. It is for clients writing files in place, such as
+// WebDAV. Returns the path the file ended up at.
+func ReplaceUpload(jm *job.JobManager, folderPath string, filePath string, tempFilePath string) (string, error) {
+	if err := lockName(jm, filePath); err != nil {
+		return "", err
+	}
+	defer jm.ReleaseJob(filePath)
+
+	if err := os.Rename(tempFilePath, filePath); err != nil {
+		return "", err
+	}
+	syncDir(filepath.Dir(filePath))
+	return runAssemblyHooks(folderPath, filePath), nil
+}
@@ -6,6 +6,9 @@ import (
 	"crypto/md5"
     "encoding/hex"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"mime/multipart"
 	"net/http"
@@ -13,15 +16,18 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/google/uuid"
 	"github.com/golang-jwt/jwt/v5"
 
 	"file-server/config"
 	"file-server/internal/job"
 	"file-server/internal/auth"
+	"file-server/internal/throttle"
 )
 
 type FormFields struct {
@@ -32,6 +38,7 @@ type FormFields struct {
 	chunkIndex 		string
 	totalChunks 	string
 	chunkContent 	[]byte
+	path 			string
 }
 
 // --------------------------------------
@@ -71,6 +78,11 @@ func createMultipartForm(formFields FormFields) (*http.Request, error) {
 			return nil, err
 		}
 	}
+	if formFields.path != "" {
+		if err := writer.WriteField("path", formFields.path); err != nil {
+			return nil, err
+		}
+	}
 	if len(formFields.chunkContent) > 0 {
 		part, err := writer.CreateFormFile("chunk", formFields.fileName)
 		if err != nil {
@@ -415,6 +427,336 @@ func TestChunkAssemble(t *testing.T) {
 		}
 	})
 }
+func TestChunkAssembleLeavesNoPartialFile(t *testing.T) {
+	cfg := config.LoadConfig()
+	id := uuid.New().String()
+
+	if _, err := createRandomChunks(id, 2, cfg.UploadDir); err != nil {
+		t.Fatal(err)
+	}
+	meta := ChunkMeta{
+		FileId: 		id,
+		FileName:      	"partialName",
+		FileExtension: 	".txt",
+		MD5Hash:       	"deadbeef",
+		TotalChunks:  	2,
+	}
+	if _, err := assembleChunks(meta, cfg.UploadDir, CommitFile); err == nil {
+		t.Error("Expected MD5 mismatch error, received nil")
+	}
+
+	entries, err := os.ReadDir(cfg.UploadDir)
+	if err != nil {
+		t.Fatalf("Received unexpected error when reading upload dir: %v", err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "partialName") || entry.Name() == assemblyTempFile {
+			t.Errorf("Found leftover file %s after failed assembly", entry.Name())
+		}
+	}
+}
+
+func TestLockTarget(t *testing.T) {
+	root := uuid.New().String()
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		t.Fatalf("Received unexpected error when creating folder: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	jm := job.NewJobManager(30 * time.Minute)
+	defer jm.Close()
+
+	t.Run("Taken_Name", func(t *testing.T) {
+		namePath := filepath.Join(root, "taken.txt")
+		if err := os.WriteFile(namePath, []byte("existing"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		targetPath, err := lockTarget(jm, namePath)
+		if err != nil {
+			t.Fatalf("Received unexpected error: %v", err)
+		}
+		defer jm.ReleaseJob(targetPath)
+
+		if targetPath != filepath.Join(root, "taken (1).txt") {
+			t.Errorf("Expected the name CommitFile would pick, received %s", targetPath)
+		}
+		if jm.AcquireJob(targetPath) {
+			t.Error("Expected the resolved name to be held")
+		}
+	})
+
+	t.Run("Held_Name", func(t *testing.T) {
+		// Another commit holds the name and writes it before releasing it
+		namePath := filepath.Join(root, "held.txt")
+		jm.AcquireJob(namePath)
+		go func() {
+			time.Sleep(3 * assemblyLockRetry)
+			os.WriteFile(namePath, []byte("committed"), 0644)
+			jm.ReleaseJob(namePath)
+		}()
+
+		targetPath, err := lockTarget(jm, namePath)
+		if err != nil {
+			t.Fatalf("Received unexpected error: %v", err)
+		}
+		defer jm.ReleaseJob(targetPath)
+
+		if targetPath != filepath.Join(root, "held (1).txt") {
+			t.Errorf("Expected the name after the other commit, received %s", targetPath)
+		}
+	})
+}
+
+// --------------------------------------
+// 		  Startup Recovery Tests
+// --------------------------------------
+func TestRecoverUploads(t *testing.T) {
+	cfg := config.LoadConfig()
+	root := uuid.New().String()
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		t.Fatalf("Received unexpected error when creating folder: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	jm := job.NewJobManager(30 * time.Minute)
+	defer jm.Close()
+
+	t.Run("Recover_Complete_Chunk_Set", func(t *testing.T) {
+		id := uuid.New().String()
+		hash, err := createRandomChunks(id, 3, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunksDir := filepath.Join(root, cfg.ChunksDir, id)
+		meta := ChunkMeta{FileId: id, FileName: "recovered", FileExtension: ".txt", MD5Hash: hash, TotalChunks: 3}
+		if err := writeChunkMeta(chunksDir, meta); err != nil {
+			t.Fatalf("Received unexpected error when writing chunk meta: %v", err)
+		}
+		// Simulate a crash midway through a previous assembly
+		if err := os.WriteFile(filepath.Join(chunksDir, assemblyTempFile), []byte("truncated"), 0644); err != nil {
+			t.Fatalf("Received unexpected error when writing temp file: %v", err)
+		}
+
+		RecoverUploads(jm, []string{root}, time.Hour)
+
+		if !pathExists(filepath.Join(root, "recovered.txt")) {
+			t.Error("Expected complete upload to be assembled during recovery")
+		}
+		if pathExists(chunksDir) {
+			t.Error("Expected chunk directory to be removed after recovery")
+		}
+	})
+
+	t.Run("Recover_Complete_Tus_Upload", func(t *testing.T) {
+		for _, complete := range []bool{true, false} {
+			id := uuid.New().String()
+			uploadDir := filepath.Join(root, cfg.ChunksDir, id)
+			if err := os.MkdirAll(uploadDir, os.ModePerm); err != nil {
+				t.Fatalf("Received unexpected error when creating folder: %v", err)
+			}
+			upload := tusUpload{Id: id, FolderId: "/", FileName: "recoveredTus" + id[:8], FileExtension: ".txt", Length: 10}
+			if err := writeTusUpload(uploadDir, upload); err != nil {
+				t.Fatalf("Received unexpected error when writing upload: %v", err)
+			}
+			data := []byte("someTusData")[:5]
+			if complete {
+				data = []byte("someTusDat")
+			}
+			if err := os.WriteFile(filepath.Join(uploadDir, tusDataFile), data, 0644); err != nil {
+				t.Fatalf("Received unexpected error when writing data: %v", err)
+			}
+
+			RecoverUploads(jm, []string{root}, time.Hour)
+
+			if pathExists(filepath.Join(root, upload.FileName+".txt")) != complete {
+				t.Errorf("Expected a complete tus upload to be committed and an incomplete one left, complete: %t", complete)
+			}
+			if pathExists(uploadDir) == complete {
+				t.Errorf("Expected the upload directory to be kept only while incomplete, complete: %t", complete)
+			}
+		}
+	})
+
+	t.Run("Recover_Runs_Assembly_Hooks", func(t *testing.T) {
+		defer SetAssemblyHooks()
+		hooked := []string{}
+		SetAssemblyHooks(func(folderPath string, filePath string) (string, error) {
+			hooked = append(hooked, filePath)
+			return filePath, nil
+		})
+
+		id := uuid.New().String()
+		uploadDir := filepath.Join(root, cfg.ChunksDir, id)
+		if err := os.MkdirAll(uploadDir, os.ModePerm); err != nil {
+			t.Fatalf("Received unexpected error when creating folder: %v", err)
+		}
+		upload := tusUpload{Id: id, FolderId: "/", FileName: "hookedTus", FileExtension: ".txt", Length: 10}
+		if err := writeTusUpload(uploadDir, upload); err != nil {
+			t.Fatalf("Received unexpected error when writing upload: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(uploadDir, tusDataFile), []byte("someTusDat"), 0644); err != nil {
+			t.Fatalf("Received unexpected error when writing data: %v", err)
+		}
+
+		RecoverUploads(jm, []string{root}, time.Hour)
+
+		if len(hooked) != 1 || hooked[0] != filepath.Join(root, "hookedTus.txt") {
+			t.Errorf("Expected the recovered file to go through the hooks, got %v", hooked)
+		}
+	})
+
+	t.Run("Recover_Verified_Temp_File", func(t *testing.T) {
+		id := uuid.New().String()
+		hash, err := createRandomChunks(id, 2, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunksDir := filepath.Join(root, cfg.ChunksDir, id)
+		meta := ChunkMeta{FileId: id, FileName: "committed", FileExtension: ".txt", MD5Hash: hash, TotalChunks: 2}
+		if err := writeChunkMeta(chunksDir, meta); err != nil {
+			t.Fatalf("Received unexpected error when writing chunk meta: %v", err)
+		}
+		// Simulate a crash after verification, once chunks were dropped but before the rename
+		if err := writeAssembly(meta, chunksDir, filepath.Join(chunksDir, assemblyTempFile)); err != nil {
+			t.Fatalf("Received unexpected error when assembling: %v", err)
+		}
+		if err := removeChunkFiles(chunksDir); err != nil {
+			t.Fatalf("Received unexpected error when removing chunks: %v", err)
+		}
+
+		RecoverUploads(jm, []string{root}, time.Hour)
+
+		if !pathExists(filepath.Join(root, "committed.txt")) {
+			t.Error("Expected verified temp file to be committed during recovery")
+		}
+		if pathExists(chunksDir) {
+			t.Error("Expected chunk directory to be removed after recovery")
+		}
+	})
+
+	t.Run("Recover_Stale_Partial_Chunk_Set", func(t *testing.T) {
+		staleId := uuid.New().String()
+		freshId := uuid.New().String()
+		for _, id := range []string{staleId, freshId} {
+			if _, err := createRandomChunks(id, 1, root); err != nil {
+				t.Fatal(err)
+			}
+			meta := ChunkMeta{FileId: id, FileName: "partial", FileExtension: ".txt", MD5Hash: "deadbeef", TotalChunks: 3}
+			if err := writeChunkMeta(filepath.Join(root, cfg.ChunksDir, id), meta); err != nil {
+				t.Fatalf("Received unexpected error when writing chunk meta: %v", err)
+			}
+		}
+
+		staleDir := filepath.Join(root, cfg.ChunksDir, staleId)
+		old := time.Now().Add(-2 * time.Hour)
+		for _, name := range []string{"", "chunk_0", chunkMetaFile} {
+			if err := os.Chtimes(filepath.Join(staleDir, name), old, old); err != nil {
+				t.Fatalf("Received unexpected error when aging chunk directory: %v", err)
+			}
+		}
+
+		RecoverUploads(jm, []string{root}, time.Hour)
+
+		if pathExists(staleDir) {
+			t.Error("Expected stale partial chunk directory to be removed")
+		}
+		if !pathExists(filepath.Join(root, cfg.ChunksDir, freshId)) {
+			t.Error("Expected recent partial chunk directory to be kept")
+		}
+		if pathExists(filepath.Join(root, "partial.txt")) {
+			t.Error("Partial upload should not be assembled")
+		}
+	})
+}
+
+// --------------------------------------
+// 		  Chunk Janitor Tests
+// --------------------------------------
+func TestCollectStaleChunks(t *testing.T) {
+	cfg := config.LoadConfig()
+	root := uuid.New().String()
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		t.Fatalf("Received unexpected error when creating folder: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	staleId := uuid.New().String()
+	busyId := uuid.New().String()
+	freshId := uuid.New().String()
+	old := time.Now().Add(-2 * time.Hour)
+	for _, id := range []string{staleId, busyId, freshId} {
+		if _, err := createRandomChunks(id, 1, root); err != nil {
+			t.Fatal(err)
+		}
+		if id == freshId {
+			continue
+		}
+		chunksDir := filepath.Join(root, cfg.ChunksDir, id)
+		for _, name := range []string{"", "chunk_0"} {
+			if err := os.Chtimes(filepath.Join(chunksDir, name), old, old); err != nil {
+				t.Fatalf("Received unexpected error when aging chunk directory: %v", err)
+			}
+		}
+	}
+
+	chunkSets := ListChunkSets([]string{root}, time.Hour)
+	if len(chunkSets) != 3 {
+		t.Fatalf("Expected 3 pending chunk sets, received %d", len(chunkSets))
+	}
+	for _, chunkSet := range chunkSets {
+		if chunkSet.Stale != (chunkSet.FileId != freshId) {
+			t.Errorf("Unexpected stale flag %v for chunk set %s", chunkSet.Stale, chunkSet.FileId)
+		}
+	}
+
+	jm := job.NewJobManager(30 * time.Minute)
+	defer jm.Close()
+	jm.AcquireJob(busyId) // Pretend this upload is being assembled
+
+	report := CollectStaleChunks(jm, []string{root}, time.Hour)
+
+	if len(report.Removed) != 1 || report.Removed[0].FileId != staleId {
+		t.Errorf("Expected only the stale chunk set to be removed, received %+v", report.Removed)
+	}
+	if report.ReclaimedBytes != 5*1024*1024 {
+		t.Errorf("Expected %d reclaimed bytes, received %d", 5*1024*1024, report.ReclaimedBytes)
+	}
+	if pathExists(filepath.Join(root, cfg.ChunksDir, staleId)) {
+		t.Error("Expected stale chunk directory to be removed")
+	}
+	if !pathExists(filepath.Join(root, cfg.ChunksDir, busyId)) {
+		t.Error("Expected chunk directory held by a job to be kept")
+	}
+	if !pathExists(filepath.Join(root, cfg.ChunksDir, freshId)) {
+		t.Error("Expected recent chunk directory to be kept")
+	}
+}
+
+func TestChunkJanitorHandlersAuth(t *testing.T) {
+	claims := jwt.MapClaims{
+		"user_id":   "someRandomUser",
+		"folder_id": uuid.New().String(),
+		"access":    "rw",
+		"exp":       time.Now().Add(5 * time.Hour).Unix(),
+	}
+	ctx := context.WithValue(context.Background(), auth.ClaimsContextKey, claims)
+	jm := job.NewJobManager(30 * time.Minute)
+	defer jm.Close()
+
+	rr := httptest.NewRecorder()
+	PendingChunksHandler(rr, httptest.NewRequest(http.MethodGet, "/admin/chunks", nil).WithContext(ctx))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 Forbidden; got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	CleanupChunksHandler(rr, httptest.NewRequest(http.MethodPost, "/admin/chunks-cleanup", nil).WithContext(ctx), jm)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 Forbidden; got %d", rr.Code)
+	}
+}
+
 // --------------------------------------
 // 		  Authorization Tests
 // --------------------------------------
@@ -572,4 +914,1119 @@ func TestUploadHandlerSuccess(t *testing.T) {
 	if err := os.RemoveAll(folder); err != nil {
 		t.Fatalf("Received unexpected error when removing folder %s: %v", folder, err)
 	}
-}
\ No newline at end of file
+}
+func TestAssemblyHooksRunInOrder(t *testing.T) {
+	defer SetAssemblyHooks()
+
+	calls := []string{}
+	SetAssemblyHooks(
+		func(folderPath string, filePath string) (string, error) {
+			calls = append(calls, "first:"+filePath)
+			return "moved.txt", nil
+		},
+		func(folderPath string, filePath string) (string, error) {
+			calls = append(calls, "second:"+filePath)
+			return "", fmt.Errorf("someError")
+		},
+		func(folderPath string, filePath string) (string, error) {
+			calls = append(calls, "third:"+filePath)
+			return filePath, nil
+		},
+	)
+
+	finalPath := runAssemblyHooks("someFolder", "original.txt")
+	expected := []string{"first:original.txt", "second:moved.txt", "third:moved.txt"}
+	if strings.Join(calls, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected hooks to run as %v, got %v", expected, calls)
+	}
+	if finalPath != "moved.txt" {
+		t.Errorf("Expected final path moved.txt, got %s", finalPath)
+	}
+}
+
+func TestUploadCheckHandler(t *testing.T) {
+	cfg := config.LoadConfig()
+	folderId := uuid.New().String()
+	folderPath := filepath.Join(cfg.SharingDir, folderId)
+	if err := os.MkdirAll(folderPath, os.ModePerm); err != nil {
+		t.Fatalf("Received unexpected error when creating folder: %v", err)
+	}
+	defer os.RemoveAll(folderPath)
+
+	content := []byte("somePhotoContent")
+	existingPath := filepath.Join(cfg.UploadDir, "existing.jpg")
+	if err := os.WriteFile(existingPath, content, 0644); err != nil {
+		t.Fatalf("Received unexpected error when writing file: %v", err)
+	}
+	defer os.Remove(existingPath)
+	info, _ := os.Stat(existingPath)
+	hash := md5.Sum(content)
+	md5Hash := hex.EncodeToString(hash[:])
+
+	jm := job.NewJobManager(30 * time.Minute)
+	defer jm.Close()
+
+	createReq := func(claimFolderId string) *http.Request {
+		claims := jwt.MapClaims{
+			"user_id":   "someRandomUser",
+			"folder_id": claimFolderId,
+			"access":    "rw",
+			"exp":       time.Now().Add(30 * time.Minute).Unix(),
+		}
+		body, _ := json.Marshal(UploadCheckDetails{
+			FolderId:      folderId,
+			FileName:      "newPhoto",
+			FileExtension: ".jpg",
+			MD5Hash:       md5Hash,
+			Size:          int64(len(content)),
+		})
+		req := httptest.NewRequest(http.MethodPost, "/upload-check", bytes.NewBuffer(body))
+		return req.WithContext(context.WithValue(context.Background(), auth.ClaimsContextKey, claims))
+	}
+	expectLookup := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(`FROM file_index\s+WHERE content_hash = \$1 AND size = \$2`).
+			WithArgs(md5Hash, int64(len(content))).
+			WillReturnRows(sqlmock.NewRows([]string{"folder_id", "path", "name", "extension", "size", "mod_time", "content_hash", "captured_at", "indexed_at"}).
+				AddRow("/", "existing.jpg", "existing.jpg", ".jpg", info.Size(), info.ModTime().UTC().Truncate(time.Microsecond), md5Hash, nil, time.Now()))
+	}
+
+	t.Run("Check_Content_Not_Readable", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		expectLookup(mock)
+
+		// The share user can't read the library, so its content must not be used
+		rr := httptest.NewRecorder()
+		UploadCheckHandler(rr, createReq(folderId), db, jm)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 OK, got: %d %s", rr.Code, rr.Body.String())
+		}
+		var response UploadCheckResponse
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		if response.Present {
+			t.Error("Expected content outside the caller's folders to be ignored")
+		}
+		if pathExists(filepath.Join(folderPath, "newPhoto.jpg")) {
+			t.Error("Expected no file to be added")
+		}
+	})
+
+	t.Run("Check_Present", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		expectLookup(mock)
+
+		rr := httptest.NewRecorder()
+		UploadCheckHandler(rr, createReq("/"), db, jm)
+
+		var response UploadCheckResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Received unexpected error when parsing response body: %v", err)
+		}
+		if !response.Present || response.Path != "newPhoto.jpg" {
+			t.Fatalf("Expected existing content to be linked, got: %+v", response)
+		}
+		linked, err := os.ReadFile(filepath.Join(folderPath, "newPhoto.jpg"))
+		if err != nil || !bytes.Equal(linked, content) {
+			t.Errorf("Expected linked file content, got %q %v", linked, err)
+		}
+	})
+
+	t.Run("Check_Not_Writable", func(t *testing.T) {
+		db, _, _ := sqlmock.New()
+		defer db.Close()
+
+		rr := httptest.NewRecorder()
+		UploadCheckHandler(rr, createReq(uuid.New().String()), db, jm)
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 Forbidden, got: %d", rr.Code)
+		}
+	})
+
+	jm.Wait(context.Background())
+}
+
+func TestUploadHandlerIntoSubFolder(t *testing.T) {
+	cfg := config.LoadConfig()
+	claims := jwt.MapClaims{
+		"user_id":   "someRandomUser",
+		"folder_id": "/",
+		"access":    "w",
+		"exp":       time.Now().Add(5 * time.Hour).Unix(),
+	}
+	ctx := context.WithValue(context.Background(), auth.ClaimsContextKey, claims)
+	jm := job.NewJobManager(30 * time.Minute)
+	defer jm.Close()
+
+	t.Run("Upload_Path_Escaping_Folder", func(t *testing.T) {
+		for _, uploadPath := range []string{"../outside", "albums/../../outside", ".hidden/photos"} {
+			req, err := createMultipartForm(FormFields{
+				fileId:        uuid.New().String(),
+				fileName:      "someFileName",
+				fileExtension: ".txt",
+				md5Hash:       "6d0bb00954ceb7fbee436bb55a8397a9",
+				chunkIndex:    "0",
+				totalChunks:   "1",
+				chunkContent:  make([]byte, 100),
+				path:          uploadPath,
+			})
+			if err != nil {
+				t.Fatalf("Received unexpected error when creating multipart form %v", err)
+			}
+			rr := httptest.NewRecorder()
+			UploadHandler(rr, req.WithContext(ctx), jm, cfg.UploadDir)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400 Bad Request for path %q, got: %d", uploadPath, rr.Code)
+			}
+		}
+	})
+
+	t.Run("Upload_Path_Success", func(t *testing.T) {
+		subFolder := filepath.Join(cfg.UploadDir, "someAlbum")
+		defer os.RemoveAll(subFolder)
+
+		req, err := createMultipartForm(FormFields{
+			fileId:        uuid.New().String(),
+			fileName:      "someFileName",
+			fileExtension: ".txt",
+			md5Hash:       "6d0bb00954ceb7fbee436bb55a8397a9",
+			chunkIndex:    "0",
+			totalChunks:   "1",
+			chunkContent:  make([]byte, 100),
+			path:          "/someAlbum/2024/",
+		})
+		if err != nil {
+			t.Fatalf("Received unexpected error when creating multipart form %v", err)
+		}
+		rr := httptest.NewRecorder()
+		UploadHandler(rr, req.WithContext(ctx), jm, cfg.UploadDir)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 OK, got: %d %s", rr.Code, rr.Body.String())
+		}
+		jm.Wait(context.Background())
+
+		fullFilePath := filepath.Join(subFolder, "2024", "someFileName.txt")
+		if !pathExists(fullFilePath) {
+			t.Errorf("Final file wasn't created in: %s ", fullFilePath)
+		}
+	})
+}
+
+func TestUploadStatusHandler(t *testing.T) {
+	cfg := config.LoadConfig()
+	fileId := uuid.New().String()
+	chunksDir := filepath.Join(cfg.UploadDir, cfg.ChunksDir, fileId)
+	if err := os.MkdirAll(chunksDir, os.ModePerm); err != nil {
+		t.Fatalf("Received unexpected error when creating chunks directory: %v", err)
+	}
+	defer os.RemoveAll(chunksDir)
+
+	if err := writeChunkMeta(chunksDir, ChunkMeta{FileId: fileId, FileName: "someFileName", FileExtension: ".txt", TotalChunks: 3}); err != nil {
+		t.Fatalf("Received unexpected error when writing chunk metadata: %v", err)
+	}
+	for _, name := range []string{"chunk_2", "chunk_0"} {
+		if err := os.WriteFile(filepath.Join(chunksDir, name), []byte("someChunk"), 0644); err != nil {
+			t.Fatalf("Received unexpected error when writing chunk: %v", err)
+		}
+	}
+
+	createReq := func(claimFolderId string, fileId string) *http.Request {
+		claims := jwt.MapClaims{
+			"user_id":   "someRandomUser",
+			"folder_id": claimFolderId,
+			"access":    "rw",
+			"exp":       time.Now().Add(30 * time.Minute).Unix(),
+		}
+		req := httptest.NewRequest(http.MethodGet, "/upload-status?folder_id=/&file_id="+fileId, nil)
+		return req.WithContext(context.WithValue(context.Background(), auth.ClaimsContextKey, claims))
+	}
+
+	t.Run("Status_Invalid_File_Id", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		UploadStatusHandler(rr, createReq("/", "../someFile"))
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 Bad Request, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Status_Not_Writable", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		UploadStatusHandler(rr, createReq(uuid.New().String(), fileId))
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 Forbidden, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Status_Received_Chunks", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		UploadStatusHandler(rr, createReq("/", fileId))
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 OK, got: %d %s", rr.Code, rr.Body.String())
+		}
+		var response UploadStatusResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Received unexpected error when parsing response body: %v", err)
+		}
+		if response.TotalChunks != 3 || fmt.Sprint(response.Received) != "[0 2]" {
+			t.Errorf("Expected 3 chunks with 0 and 2 received, got: %+v", response)
+		}
+	})
+
+	t.Run("Status_Unknown_Upload", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		UploadStatusHandler(rr, createReq("/", uuid.New().String()))
+
+		var response UploadStatusResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Received unexpected error when parsing response body: %v", err)
+		}
+		if response.TotalChunks != 0 || len(response.Received) != 0 {
+			t.Errorf("Expected no chunks for an unknown upload, got: %+v", response)
+		}
+	})
+}
+
+func TestParallelChunkUploads(t *testing.T) {
+	cfg := config.LoadConfig()
+	claims := jwt.MapClaims{
+		"user_id":   "someRandomUser",
+		"folder_id": "/",
+		"access":    "w",
+		"exp":       time.Now().Add(5 * time.Hour).Unix(),
+	}
+	ctx := context.WithValue(context.Background(), auth.ClaimsContextKey, claims)
+	jm := job.NewJobManager(30 * time.Minute)
+	defer jm.Close()
+
+	const totalChunks = 8
+	chunks := make([][]byte, totalChunks)
+	hash := md5.New()
+	for i := range chunks {
+		chunks[i] = make([]byte, 64<<10)
+		rand.Read(chunks[i])
+		hash.Write(chunks[i])
+	}
+	md5Hash := hex.EncodeToString(hash.Sum(nil))
+	fileId := uuid.New().String()
+	defer os.Remove(filepath.Join(cfg.UploadDir, "someParallelFile.txt"))
+
+	var wg sync.WaitGroup
+	codes := make(chan int, totalChunks)
+	for i := 0; i < totalChunks; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			req, err := createMultipartForm(FormFields{
+				fileId:        fileId,
+				fileName:      "someParallelFile",
+				fileExtension: ".txt",
+				md5Hash:       md5Hash,
+				chunkIndex:    fmt.Sprint(index),
+				totalChunks:   fmt.Sprint(totalChunks),
+				chunkContent:  chunks[index],
+			})
+			if err != nil {
+				t.Errorf("Received unexpected error when creating multipart form %v", err)
+				return
+			}
+			rr := httptest.NewRecorder()
+			UploadHandler(rr, req.WithContext(ctx), jm, cfg.UploadDir)
+			codes <- rr.Code
+		}(i)
+	}
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("Expected status 200 OK for every chunk, got: %d", code)
+		}
+	}
+	jm.Wait(context.Background())
+
+	assembled, err := os.ReadFile(filepath.Join(cfg.UploadDir, "someParallelFile.txt"))
+	if err != nil {
+		t.Fatalf("Expected the file to be assembled: %v", err)
+	}
+	if !bytes.Equal(assembled, bytes.Join(chunks, nil)) {
+		t.Error("Expected the assembled file to hold the chunks in order")
+	}
+	if pathExists(filepath.Join(cfg.UploadDir, "someParallelFile (1).txt")) {
+		t.Error("Expected the upload to be assembled once")
+	}
+	if pathExists(filepath.Join(cfg.UploadDir, cfg.ChunksDir, fileId)) {
+		t.Error("Expected the chunk directory to be removed")
+	}
+}
+
+func TestUploadChunkMismatch(t *testing.T) {
+	cfg := config.LoadConfig()
+	claims := jwt.MapClaims{
+		"user_id":   "someRandomUser",
+		"folder_id": "/",
+		"access":    "w",
+		"exp":       time.Now().Add(5 * time.Hour).Unix(),
+	}
+	ctx := context.WithValue(context.Background(), auth.ClaimsContextKey, claims)
+	jm := job.NewJobManager(30 * time.Minute)
+	defer jm.Close()
+
+	fileId := uuid.New().String()
+	defer os.RemoveAll(filepath.Join(cfg.UploadDir, cfg.ChunksDir, fileId))
+
+	for i, totalChunks := range []string{"3", "2"} {
+		req, err := createMultipartForm(FormFields{
+			fileId:        fileId,
+			fileName:      "someFileName",
+			fileExtension: ".txt",
+			md5Hash:       "6d0bb00954ceb7fbee436bb55a8397a9",
+			chunkIndex:    "0",
+			totalChunks:   totalChunks,
+			chunkContent:  make([]byte, 100),
+		})
+		if err != nil {
+			t.Fatalf("Received unexpected error when creating multipart form %v", err)
+		}
+		rr := httptest.NewRecorder()
+		UploadHandler(rr, req.WithContext(ctx), jm, cfg.UploadDir)
+
+		expected := []int{http.StatusOK, http.StatusBadRequest}[i]
+		if rr.Code != expected {
+			t.Errorf("Expected status %d for %s chunks, got: %d", expected, totalChunks, rr.Code)
+		}
+	}
+}
+
+func TestUploadLimits(t *testing.T) {
+	cfg := config.LoadConfig()
+	limits := throttle.NewLimits(1)
+	SetUploadLimits(limits)
+	defer SetUploadLimits(nil)
+
+	claims := jwt.MapClaims{
+		"user_id":   "someRandomUser",
+		"folder_id": "/",
+		"access":    "w",
+		"exp":       time.Now().Add(5 * time.Hour).Unix(),
+	}
+	ctx := context.WithValue(context.Background(), auth.ClaimsContextKey, claims)
+	jm := job.NewJobManager(30 * time.Minute)
+	defer jm.Close()
+
+	send := func(ctx context.Context, folderPath string) *httptest.ResponseRecorder {
+		req, err := createMultipartForm(FormFields{
+			fileId:        uuid.New().String(),
+			fileName:      "someLimitedFile",
+			fileExtension: ".txt",
+			md5Hash:       "6d0bb00954ceb7fbee436bb55a8397a9",
+			chunkIndex:    "0",
+			totalChunks:   "1",
+			chunkContent:  make([]byte, 100),
+		})
+		if err != nil {
+			t.Fatalf("Received unexpected error when creating multipart form %v", err)
+		}
+		rr := httptest.NewRecorder()
+		UploadHandler(rr, req.WithContext(ctx), jm, folderPath)
+		return rr
+	}
+
+	// The user's only slot is taken by a chunk still being received
+	release, _ := limits.Acquire("someRandomUser")
+	rr := send(ctx, cfg.UploadDir)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429 Too Many Requests, got: %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header")
+	}
+
+	release()
+	if rr := send(ctx, cfg.UploadDir); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 OK once the slot is free, got: %d", rr.Code)
+	}
+	jm.Wait(context.Background())
+	os.Remove(filepath.Join(cfg.UploadDir, "someLimitedFile.txt"))
+
+	// Visitors of a share count against the share, not its folder name
+	shareId := uuid.New().String()
+	shareClaims := jwt.MapClaims{
+		"user_id":   "someRandomUser",
+		"folder_id": shareId,
+		"access":    "w",
+		"kind":      auth.TokenKindShare,
+		"exp":       time.Now().Add(5 * time.Hour).Unix(),
+	}
+	shareCtx := context.WithValue(context.Background(), auth.ClaimsContextKey, shareClaims)
+	release, _ = limits.Acquire(shareId)
+	defer release()
+	if rr := send(shareCtx, filepath.Join(cfg.SharingDir, shareId)); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429 Too Many Requests while the share's slot is taken, got: %d", rr.Code)
+	}
+}
+
+func TestUploadInitHandler(t *testing.T) {
+	t.Setenv("UPLOAD_CHUNK_SIZE", "1000")
+	t.Setenv("UPLOAD_CHUNK_SIZES", "someOtherUser=abc, someBigUser=4000")
+	t.Setenv("UPLOAD_MAX_FILE_SIZE", "10000")
+	cfg := config.LoadConfig()
+
+	content := make([]byte, 2500)
+	rand.Read(content)
+	hash := md5.Sum(content)
+	md5Hash := hex.EncodeToString(hash[:])
+
+	claimsFor := func(userId string, folderId string) context.Context {
+		claims := jwt.MapClaims{
+			"user_id":   userId,
+			"folder_id": folderId,
+			"access":    "rw",
+			"exp":       time.Now().Add(30 * time.Minute).Unix(),
+		}
+		return context.WithValue(context.Background(), auth.ClaimsContextKey, claims)
+	}
+	initUpload := func(ctx context.Context, details UploadInitDetails) (*httptest.ResponseRecorder, UploadInitResponse) {
+		body, _ := json.Marshal(details)
+		req := httptest.NewRequest(http.MethodPost, "/upload-init", bytes.NewBuffer(body)).WithContext(ctx)
+		rr := httptest.NewRecorder()
+		UploadInitHandler(rr, req)
+
+		var response UploadInitResponse
+		if rr.Code == http.StatusOK {
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatalf("Received unexpected error when decoding response: %v", err)
+			}
+		}
+		return rr, response
+	}
+	details := UploadInitDetails{
+		FolderId:      "/",
+		FileName:      "someInitFile",
+		FileExtension: ".txt",
+		MD5Hash:       md5Hash,
+		Size:          int64(len(content)),
+		Path:          "someInitFolder",
+	}
+	defer os.RemoveAll(filepath.Join(cfg.UploadDir, "someInitFolder"))
+
+	t.Run("Invalid_Parameters", func(t *testing.T) {
+		invalid := details
+		invalid.MD5Hash = "notAHash"
+		if rr, _ := initUpload(claimsFor("someRandomUser", "/"), invalid); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 Bad Request for an invalid hash, got: %d", rr.Code)
+		}
+		invalid = details
+		invalid.UploadId = "notAUUID"
+		if rr, _ := initUpload(claimsFor("someRandomUser", "/"), invalid); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 Bad Request for an invalid upload id, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Forbidden", func(t *testing.T) {
+		if rr, _ := initUpload(claimsFor("someRandomUser", uuid.New().String()), details); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 Forbidden, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("File_Too_Large", func(t *testing.T) {
+		large := details
+		large.Size = 10001
+		if rr, _ := initUpload(claimsFor("someRandomUser", "/"), large); rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected status 413 Request Entity Too Large, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Chunk_Size_Per_User", func(t *testing.T) {
+		for userId, expected := range map[string]int64{"someBigUser": 4000, "someOtherUser": 1000} {
+			rr, response := initUpload(claimsFor(userId, "/"), details)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected status 200 OK, got: %d", rr.Code)
+			}
+			os.RemoveAll(filepath.Join(cfg.UploadDir, cfg.ChunksDir, response.UploadId))
+			if response.ChunkSize != expected {
+				t.Errorf("Expected chunk size %d for %s, got: %d", expected, userId, response.ChunkSize)
+			}
+		}
+	})
+
+	t.Run("Upload_Validated_Against_Session", func(t *testing.T) {
+		ctx := claimsFor("someRandomUser", "/")
+		withId := details
+		withId.UploadId = uuid.New().String()
+		rr, response := initUpload(ctx, withId)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 OK, got: %d", rr.Code)
+		}
+		defer os.RemoveAll(filepath.Join(cfg.UploadDir, cfg.ChunksDir, response.UploadId))
+		if response.UploadId != withId.UploadId || response.ChunkSize != 1000 || response.TotalChunks != 3 || response.MaxFileSize != 10000 {
+			t.Fatalf("Unexpected upload session %+v", response)
+		}
+
+		// Starting again resumes the same upload, another file can't take its id
+		if rr, resumed := initUpload(ctx, withId); rr.Code != http.StatusOK || resumed != response {
+			t.Errorf("Expected the upload to be resumed, got %d %+v", rr.Code, resumed)
+		}
+		other := withId
+		other.MD5Hash = strings.Repeat("0", 32)
+		if rr, _ := initUpload(ctx, other); rr.Code != http.StatusConflict {
+			t.Errorf("Expected status 409 Conflict, got: %d", rr.Code)
+		}
+
+		jm := job.NewJobManager(30 * time.Minute)
+		defer jm.Close()
+		sendChunk := func(index int, chunk []byte) int {
+			req, err := createMultipartForm(FormFields{
+				fileId:        response.UploadId,
+				fileName:      details.FileName,
+				fileExtension: details.FileExtension,
+				md5Hash:       md5Hash,
+				chunkIndex:    fmt.Sprint(index),
+				totalChunks:   fmt.Sprint(response.TotalChunks),
+				chunkContent:  chunk,
+				path:          details.Path,
+			})
+			if err != nil {
+				t.Fatalf("Received unexpected error when creating multipart form %v", err)
+			}
+			rr := httptest.NewRecorder()
+			UploadHandler(rr, req.WithContext(ctx), jm, cfg.UploadDir)
+			return rr.Code
+		}
+
+		if code := sendChunk(0, content[:999]); code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 Bad Request for a short chunk, got: %d", code)
+		}
+		if code := sendChunk(0, content[:1001]); code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 Bad Request for a chunk over the chunk size, got: %d", code)
+		}
+		if code := sendChunk(2, content[2000:2400]); code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 Bad Request for a short last chunk, got: %d", code)
+		}
+		for index := 0; index < response.TotalChunks; index++ {
+			if code := sendChunk(index, content[index*1000:min((index+1)*1000, len(content))]); code != http.StatusOK {
+				t.Errorf("Expected status 200 OK for chunk %d, got: %d", index, code)
+			}
+		}
+		jm.Wait(context.Background())
+
+		assembled, err := os.ReadFile(filepath.Join(cfg.UploadDir, "someInitFolder", "someInitFile.txt"))
+		if err != nil {
+			t.Fatalf("Expected the file to be assembled: %v", err)
+		}
+		if !bytes.Equal(assembled, content) {
+			t.Error("Expected the assembled file to match the upload")
+		}
+	})
+
+	t.Run("Legacy_Upload_Checked", func(t *testing.T) {
+		jm := job.NewJobManager(30 * time.Minute)
+		defer jm.Close()
+		sendChunk := func(userId string, fileId string, index int, totalChunks int, chunk []byte, md5Hash string) int {
+			req, err := createMultipartForm(FormFields{
+				fileId:        fileId,
+				fileName:      "someLegacyFile",
+				fileExtension: ".txt",
+				md5Hash:       md5Hash,
+				chunkIndex:    fmt.Sprint(index),
+				totalChunks:   fmt.Sprint(totalChunks),
+				chunkContent:  chunk,
+				path:          details.Path,
+			})
+			if err != nil {
+				t.Fatalf("Received unexpected error when creating multipart form %v", err)
+			}
+			rr := httptest.NewRecorder()
+			UploadHandler(rr, req.WithContext(claimsFor(userId, "/")), jm, cfg.UploadDir)
+			return rr.Code
+		}
+
+		fileId := uuid.New().String()
+		defer os.RemoveAll(filepath.Join(cfg.UploadDir, cfg.ChunksDir, fileId))
+		if code := sendChunk("someRandomUser", fileId, 0, 3, make([]byte, 1001), md5Hash); code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 Bad Request for a chunk over the user's chunk size, got: %d", code)
+		}
+
+		// 4000 + 4000 + 1000 bytes fit the 10000 bytes allowed, though three full chunks wouldn't
+		content := make([]byte, 9000)
+		rand.Read(content)
+		hash := md5.Sum(content)
+		fileId = uuid.New().String()
+		for index, chunk := range [][]byte{content[:4000], content[4000:8000], content[8000:]} {
+			if code := sendChunk("someBigUser", fileId, index, 3, chunk, hex.EncodeToString(hash[:])); code != http.StatusOK {
+				t.Errorf("Expected status 200 OK for chunk %d of a file within the maximum size, got: %d", index, code)
+			}
+		}
+		jm.Wait(context.Background())
+		if !pathExists(filepath.Join(cfg.UploadDir, details.Path, "someLegacyFile.txt")) {
+			t.Error("Expected the file within the maximum size to be assembled")
+		}
+
+		fileId = uuid.New().String()
+		defer os.RemoveAll(filepath.Join(cfg.UploadDir, cfg.ChunksDir, fileId))
+		for index, expected := range []int{http.StatusOK, http.StatusOK, http.StatusRequestEntityTooLarge} {
+			if code := sendChunk("someBigUser", fileId, index, 3, make([]byte, []int{4000, 4000, 2001}[index]), md5Hash); code != expected {
+				t.Errorf("Expected status %d for chunk %d of a file over the maximum size, got: %d", expected, index, code)
+			}
+		}
+		// A chunk sent again replaces the one already in
+		if code := sendChunk("someBigUser", fileId, 1, 3, make([]byte, 4000), md5Hash); code != http.StatusOK {
+			t.Errorf("Expected status 200 OK for a chunk sent again, got: %d", code)
+		}
+	})
+}
+
+func TestTusHandler(t *testing.T) {
+	cfg := config.LoadConfig()
+	jm := job.NewJobManager(30 * time.Minute)
+	defer jm.Close()
+
+	content := make([]byte, 3000)
+	rand.Read(content)
+	hash := md5.Sum(content)
+	md5Hash := hex.EncodeToString(hash[:])
+
+	encode := func(value string) string {
+		return base64.StdEncoding.EncodeToString([]byte(value))
+	}
+	send := func(folderId string, method string, target string, headers map[string]string, body []byte) *httptest.ResponseRecorder {
+		claims := jwt.MapClaims{
+			"user_id":   "someRandomUser",
+			"folder_id": folderId,
+			"access":    "rw",
+			"exp":       time.Now().Add(30 * time.Minute).Unix(),
+		}
+		req := httptest.NewRequest(method, target, bytes.NewReader(body))
+		req.Header.Set("Tus-Resumable", "1.0.0")
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		rr := httptest.NewRecorder()
+		TusHandler(rr, req.WithContext(context.WithValue(context.Background(), auth.ClaimsContextKey, claims)), jm)
+		return rr
+	}
+	create := func(folderId string, metadata string) string {
+		rr := send(folderId, http.MethodPost, TusPrefix+"/", map[string]string{
+			"Upload-Length":   fmt.Sprint(len(content)),
+			"Upload-Metadata": metadata,
+		}, nil)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201 Created, got: %d %s", rr.Code, rr.Body.String())
+		}
+		return rr.Header().Get("Location")
+	}
+	patch := func(folderId string, location string, offset int, chunk []byte, checksum string) *httptest.ResponseRecorder {
+		headers := map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": fmt.Sprint(offset),
+		}
+		if checksum != "" {
+			headers["Upload-Checksum"] = checksum
+		}
+		return send(folderId, http.MethodPatch, location, headers, chunk)
+	}
+
+	t.Run("Options", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		TusOptionsHandler(rr, httptest.NewRequest(http.MethodOptions, TusPrefix+"/", nil))
+		if rr.Code != http.StatusNoContent {
+			t.Errorf("Expected status 204 No Content, got: %d", rr.Code)
+		}
+		if rr.Header().Get("Tus-Version") != "1.0.0" || rr.Header().Get("Tus-Extension") != "creation,termination,checksum,expiration" {
+			t.Errorf("Unexpected discovery headers %v", rr.Header())
+		}
+	})
+
+	t.Run("Unsupported_Version", func(t *testing.T) {
+		rr := send("/", http.MethodPost, TusPrefix+"/", map[string]string{"Tus-Resumable": "0.2.2"}, nil)
+		if rr.Code != http.StatusPreconditionFailed {
+			t.Errorf("Expected status 412 Precondition Failed, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Creation_Invalid", func(t *testing.T) {
+		t.Setenv("UPLOAD_MAX_FILE_SIZE", "10000")
+		testCases := []struct {
+			name     string
+			folderId string
+			headers  map[string]string
+			expected int
+		}{
+			{"Missing_Length", "/", map[string]string{"Upload-Metadata": "filename " + encode("someFile.txt")}, http.StatusBadRequest},
+			{"Missing_File_Name", "/", map[string]string{"Upload-Length": "10"}, http.StatusBadRequest},
+			{"Unsafe_Extension", "/", map[string]string{"Upload-Length": "10", "Upload-Metadata": "filename " + encode("someFile.exe")}, http.StatusBadRequest},
+			{"Invalid_Metadata", "/", map[string]string{"Upload-Length": "10", "Upload-Metadata": "filename notBase64!"}, http.StatusBadRequest},
+			{"Too_Large", "/", map[string]string{"Upload-Length": "10001", "Upload-Metadata": "filename " + encode("someFile.txt")}, http.StatusRequestEntityTooLarge},
+			{"Forbidden", uuid.New().String(), map[string]string{"Upload-Length": "10", "Upload-Metadata": "filename " + encode("someFile.txt")}, http.StatusForbidden},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				if rr := send(tc.folderId, http.MethodPost, TusPrefix+"/", tc.headers, nil); rr.Code != tc.expected {
+					t.Errorf("Expected status %d, got: %d", tc.expected, rr.Code)
+				}
+			})
+		}
+	})
+
+	t.Run("Upload_To_Library", func(t *testing.T) {
+		defer os.RemoveAll(filepath.Join(cfg.UploadDir, "someTusFolder"))
+		location := create("/", strings.Join([]string{
+			"filename " + encode("someTusFile.txt"),
+			"path " + encode("someTusFolder"),
+			"md5 " + encode(md5Hash),
+			"is_confidential",
+		}, ","))
+		if !strings.HasPrefix(location, TusPrefix+"/") || strings.Count(location, "/") != 2 {
+			t.Fatalf("Unexpected location %q", location)
+		}
+
+		rr := send("/", http.MethodHead, location, nil, nil)
+		if rr.Code != http.StatusOK || rr.Header().Get("Upload-Offset") != "0" || rr.Header().Get("Upload-Length") != "3000" {
+			t.Fatalf("Unexpected HEAD response %d %v", rr.Code, rr.Header())
+		}
+		if rr.Header().Get("Upload-Expires") == "" || rr.Header().Get("Cache-Control") != "no-store" {
+			t.Errorf("Expected expiry and no caching on HEAD, got %v", rr.Header())
+		}
+
+		rr = send("/", http.MethodPatch, location, map[string]string{"Upload-Offset": "0"}, content[:1000])
+		if rr.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("Expected status 415 Unsupported Media Type, got: %d", rr.Code)
+		}
+		if rr := patch("/", location, 1000, content[1000:2000], ""); rr.Code != http.StatusConflict {
+			t.Errorf("Expected status 409 Conflict for a wrong offset, got: %d", rr.Code)
+		}
+		if rr := patch("/", location, 0, content[:1000], "crc32 AAAA"); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 Bad Request for an unsupported checksum, got: %d", rr.Code)
+		}
+		wrongSum := sha256.Sum256(content[:999])
+		if rr := patch("/", location, 0, content[:1000], "sha256 "+base64.StdEncoding.EncodeToString(wrongSum[:])); rr.Code != 460 {
+			t.Errorf("Expected status 460 Checksum Mismatch, got: %d", rr.Code)
+		}
+		if rr := send("/", http.MethodHead, location, nil, nil); rr.Header().Get("Upload-Offset") != "0" {
+			t.Errorf("Expected mismatching data to be dropped, offset is %s", rr.Header().Get("Upload-Offset"))
+		}
+
+		sum := md5.Sum(content[:1000])
+		rr = patch("/", location, 0, content[:1000], "md5 "+base64.StdEncoding.EncodeToString(sum[:]))
+		if rr.Code != http.StatusNoContent || rr.Header().Get("Upload-Offset") != "1000" {
+			t.Fatalf("Unexpected PATCH response %d %v", rr.Code, rr.Header())
+		}
+		if rr := patch("/", location, 1000, content[1000:], ""); rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204 No Content, got: %d", rr.Code)
+		}
+		jm.Wait(context.Background())
+
+		uploaded, err := os.ReadFile(filepath.Join(cfg.UploadDir, "someTusFolder", "someTusFile.txt"))
+		if err != nil {
+			t.Fatalf("Expected the upload to be committed: %v", err)
+		}
+		if !bytes.Equal(uploaded, content) {
+			t.Error("Expected the committed file to match the upload")
+		}
+		if rr := send("/", http.MethodHead, location, nil, nil); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 Not Found once committed, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Upload_To_Share", func(t *testing.T) {
+		folderId := uuid.New().String()
+		if err := os.MkdirAll(filepath.Join(cfg.SharingDir, folderId), os.ModePerm); err != nil {
+			t.Fatalf("Received unexpected error when creating folder: %v", err)
+		}
+		defer os.RemoveAll(filepath.Join(cfg.SharingDir, folderId))
+
+		location := create(folderId, "folder_id "+encode(folderId)+",name "+encode("someSharedFile.txt"))
+		if location != TusPrefix+"/"+folderId+"/"+filepath.Base(location) {
+			t.Fatalf("Expected the location to name the share folder, got %q", location)
+		}
+		if rr := send(uuid.New().String(), http.MethodHead, location, nil, nil); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 Forbidden for another share, got: %d", rr.Code)
+		}
+		if rr := patch(folderId, location, 0, append(content, 'x'), ""); rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected status 413 Request Entity Too Large past Upload-Length, got: %d", rr.Code)
+		}
+		if rr := patch(folderId, location, 0, content, ""); rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204 No Content, got: %d", rr.Code)
+		}
+		jm.Wait(context.Background())
+
+		uploaded, err := os.ReadFile(filepath.Join(cfg.SharingDir, folderId, "someSharedFile.txt"))
+		if err != nil || !bytes.Equal(uploaded, content) {
+			t.Errorf("Expected the upload to be committed to the share: %v", err)
+		}
+	})
+
+	t.Run("Termination", func(t *testing.T) {
+		location := create("/", "filename "+encode("someTerminated.txt"))
+		if rr := patch("/", location, 0, content[:1000], ""); rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204 No Content, got: %d", rr.Code)
+		}
+		if rr := send("/", http.MethodDelete, location, nil, nil); rr.Code != http.StatusNoContent {
+			t.Errorf("Expected status 204 No Content, got: %d", rr.Code)
+		}
+		if rr := send("/", http.MethodHead, location, nil, nil); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 Not Found once terminated, got: %d", rr.Code)
+		}
+		if pathExists(filepath.Join(cfg.UploadDir, cfg.ChunksDir, filepath.Base(location))) {
+			t.Error("Expected the upload directory to be removed")
+		}
+	})
+
+	t.Run("Expiration", func(t *testing.T) {
+		location := create("/", "filename "+encode("someExpired.txt"))
+		defer os.RemoveAll(filepath.Join(cfg.UploadDir, cfg.ChunksDir, filepath.Base(location)))
+
+		t.Setenv("CHUNK_TTL", "1ms")
+		time.Sleep(10 * time.Millisecond)
+		if rr := send("/", http.MethodHead, location, nil, nil); rr.Code != http.StatusGone {
+			t.Errorf("Expected status 410 Gone, got: %d", rr.Code)
+		}
+		if rr := patch("/", location, 0, content, ""); rr.Code != http.StatusGone {
+			t.Errorf("Expected status 410 Gone, got: %d", rr.Code)
+		}
+	})
+}
+
+func TestUploadStreamHandler(t *testing.T) {
+	t.Setenv("UPLOAD_MAX_FILE_SIZE", "5000")
+	cfg := config.LoadConfig()
+	jm := job.NewJobManager(30 * time.Minute)
+	defer jm.Close()
+
+	content := make([]byte, 4000)
+	rand.Read(content)
+	md5Sum := md5.Sum(content)
+	sha256Sum := sha256.Sum256(content)
+
+	send := func(claimFolderId string, method string, query string, headers map[string]string, body []byte) *httptest.ResponseRecorder {
+		claims := jwt.MapClaims{
+			"user_id":   "someRandomUser",
+			"folder_id": claimFolderId,
+			"access":    "rw",
+			"exp":       time.Now().Add(30 * time.Minute).Unix(),
+		}
+		req := httptest.NewRequest(method, "/upload-stream?"+query, bytes.NewReader(body))
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		rr := httptest.NewRecorder()
+		UploadStreamHandler(rr, req.WithContext(context.WithValue(context.Background(), auth.ClaimsContextKey, claims)), jm)
+		return rr
+	}
+	defer os.RemoveAll(filepath.Join(cfg.UploadDir, "someStreamFolder"))
+
+	t.Run("Invalid_Requests", func(t *testing.T) {
+		testCases := []struct {
+			name          string
+			method        string
+			claimFolderId string
+			query         string
+			headers       map[string]string
+			body          []byte
+			expected      int
+		}{
+			{"Wrong_Method", http.MethodPost, "/", "file_name=someStream.txt", nil, content, http.StatusMethodNotAllowed},
+			{"Missing_File_Name", http.MethodPut, "/", "", nil, content, http.StatusBadRequest},
+			{"Invalid_Path", http.MethodPut, "/", "file_name=someStream.txt&path=../..", nil, content, http.StatusBadRequest},
+			{"Invalid_Content_MD5", http.MethodPut, "/", "file_name=someStream.txt", map[string]string{"Content-MD5": "notBase64!"}, content, http.StatusBadRequest},
+			{"Forbidden", http.MethodPut, uuid.New().String(), "file_name=someStream.txt", nil, content, http.StatusForbidden},
+			{"Too_Large", http.MethodPut, "/", "file_name=someStream.txt", nil, make([]byte, 5001), http.StatusRequestEntityTooLarge},
+			{"Empty", http.MethodPut, "/", "file_name=someStream.txt", nil, nil, http.StatusBadRequest},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				if rr := send(tc.claimFolderId, tc.method, tc.query, tc.headers, tc.body); rr.Code != tc.expected {
+					t.Errorf("Expected status %d, got: %d", tc.expected, rr.Code)
+				}
+			})
+		}
+		if pathExists(filepath.Join(cfg.UploadDir, "someStream.txt")) {
+			t.Error("Expected refused uploads not to be committed")
+		}
+	})
+
+	t.Run("Digest_Mismatch", func(t *testing.T) {
+		wrongSum := md5.Sum(content[1:])
+		headers := map[string]string{"Content-MD5": base64.StdEncoding.EncodeToString(wrongSum[:])}
+		if rr := send("/", http.MethodPut, "file_name=someStream.txt&path=someStreamFolder", headers, content); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 Bad Request, got: %d", rr.Code)
+		}
+		headers = map[string]string{"Content-Digest": "sha-256=:" + base64.StdEncoding.EncodeToString(wrongSum[:]) + ":"}
+		if rr := send("/", http.MethodPut, "file_name=someStream.txt&path=someStreamFolder", headers, content); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 Bad Request, got: %d", rr.Code)
+		}
+		if pathExists(filepath.Join(cfg.UploadDir, "someStreamFolder", "someStream.txt")) {
+			t.Error("Expected a mismatching upload not to be committed")
+		}
+	})
+
+	t.Run("Stream_To_Library", func(t *testing.T) {
+		headers := []map[string]string{
+			{"Content-MD5": base64.StdEncoding.EncodeToString(md5Sum[:])},
+			{"Content-Digest": "unixsum=:AAAA:, sha-256=:" + base64.StdEncoding.EncodeToString(sha256Sum[:]) + ":"},
+		}
+		for i, expectedPath := range []string{"someStreamFolder/someStream.txt", "someStreamFolder/someStream (1).txt"} {
+			rr := send("/", http.MethodPut, "file_name=someStream.txt&path=someStreamFolder", headers[i], content)
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("Expected status 201 Created, got: %d %s", rr.Code, rr.Body.String())
+			}
+			var response UploadStreamResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatalf("Received unexpected error when decoding response: %v", err)
+			}
+			if response.Path != expectedPath || response.Size != int64(len(content)) || response.MD5Hash != hex.EncodeToString(md5Sum[:]) {
+				t.Errorf("Unexpected response %+v", response)
+			}
+			uploaded, err := os.ReadFile(filepath.Join(cfg.UploadDir, expectedPath))
+			if err != nil || !bytes.Equal(uploaded, content) {
+				t.Errorf("Expected the body to be committed to %s: %v", expectedPath, err)
+			}
+		}
+		entries, _ := os.ReadDir(filepath.Join(cfg.UploadDir, cfg.ChunksDir))
+		for _, entry := range entries {
+			if pathExists(filepath.Join(cfg.UploadDir, cfg.ChunksDir, entry.Name(), streamTempFile)) {
+				t.Errorf("Expected no staged body to be left behind in %s", entry.Name())
+			}
+		}
+	})
+
+	t.Run("Stream_To_Share", func(t *testing.T) {
+		folderId := uuid.New().String()
+		if err := os.MkdirAll(filepath.Join(cfg.SharingDir, folderId), os.ModePerm); err != nil {
+			t.Fatalf("Received unexpected error when creating folder: %v", err)
+		}
+		defer os.RemoveAll(filepath.Join(cfg.SharingDir, folderId))
+
+		if rr := send(folderId, http.MethodPut, "folder_id="+folderId+"&file_name=someSharedStream.txt", nil, content); rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201 Created, got: %d", rr.Code)
+		}
+		jm.Wait(context.Background())
+		if !pathExists(filepath.Join(cfg.SharingDir, folderId, "someSharedStream.txt")) {
+			t.Error("Expected the body to be committed to the share")
+		}
+		if !pathExists(filepath.Join(cfg.SharingDir, folderId, folderId+".zip")) {
+			t.Error("Expected the share zip to be refreshed")
+		}
+	})
+}
+
+func TestUploadEvents(t *testing.T) {
+	cfg := config.LoadConfig()
+	claims := jwt.MapClaims{
+		"user_id":   "someRandomUser",
+		"folder_id": "/",
+		"access":    "w",
+		"exp":       time.Now().Add(5 * time.Hour).Unix(),
+	}
+	ctx := context.WithValue(context.Background(), auth.ClaimsContextKey, claims)
+	jm := job.NewJobManager(30 * time.Minute)
+	defer jm.Close()
+
+	events, unsubscribe := jm.Subscribe("/")
+	defer unsubscribe()
+	shareEvents, unsubscribeShare := jm.Subscribe("someShareId")
+	defer unsubscribeShare()
+
+	chunks := [][]byte{[]byte("someFirstChunk"), []byte("someSecondChunk")}
+	hash := md5.Sum(bytes.Join(chunks, nil))
+	fileId := uuid.New().String()
+	defer os.RemoveAll(filepath.Join(cfg.UploadDir, "someEventFolder"))
+
+	for index, chunk := range chunks {
+		req, err := createMultipartForm(FormFields{
+			fileId:        fileId,
+			fileName:      "someEventFile",
+			fileExtension: ".txt",
+			md5Hash:       hex.EncodeToString(hash[:]),
+			chunkIndex:    fmt.Sprint(index),
+			totalChunks:   fmt.Sprint(len(chunks)),
+			chunkContent:  chunk,
+			path:          "someEventFolder",
+		})
+		if err != nil {
+			t.Fatalf("Received unexpected error when creating multipart form %v", err)
+		}
+		rr := httptest.NewRecorder()
+		UploadHandler(rr, req.WithContext(ctx), jm, cfg.UploadDir)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 OK, got: %d", rr.Code)
+		}
+	}
+	jm.Wait(context.Background())
+
+	expected := []job.Event{
+		{Type: job.EventChunkReceived, Received: 1, Total: 2},
+		{Type: job.EventChunkReceived, Received: 2, Total: 2},
+		{Type: job.EventAssemblyStarted},
+		{Type: job.EventAssemblyFinished},
+		{Type: job.EventUploadCompleted},
+		{Type: job.EventFileAdded},
+	}
+	for _, want := range expected {
+		select {
+		case event := <-events:
+			if event.Type != want.Type || event.Received != want.Received || event.Total != want.Total {
+				t.Errorf("Expected %s %d/%d, got %+v", want.Type, want.Received, want.Total, event)
+			}
+			if event.FolderId != "/" || event.File != "someEventFolder/someEventFile.txt" {
+				t.Errorf("Expected the event to be about the uploaded file, got %+v", event)
+			}
+		default:
+			t.Fatalf("Expected a %s event", want.Type)
+		}
+	}
+	select {
+	case event := <-shareEvents:
+		t.Errorf("Expected share subscribers not to see library uploads, got %+v", event)
+	default:
+	}
+}
+
+func TestUploadChecksumFailedEvent(t *testing.T) {
+	cfg := config.LoadConfig()
+	claims := jwt.MapClaims{
+		"user_id":   "someRandomUser",
+		"folder_id": "/",
+		"access":    "w",
+		"exp":       time.Now().Add(5 * time.Hour).Unix(),
+	}
+	ctx := context.WithValue(context.Background(), auth.ClaimsContextKey, claims)
+	jm := job.NewJobManager(30 * time.Minute)
+	defer jm.Close()
+
+	req, err := createMultipartForm(FormFields{
+		fileId:        uuid.New().String(),
+		fileName:      "someCorruptFile",
+		fileExtension: ".txt",
+		md5Hash:       "6d0bb00954ceb7fbee436bb55a8397a9",
+		chunkIndex:    "0",
+		totalChunks:   "1",
+		chunkContent:  []byte("someContentNotMatchingTheHash"),
+	})
+	if err != nil {
+		t.Fatalf("Received unexpected error when creating multipart form %v", err)
+	}
+
+	events, unsubscribe := jm.Subscribe("/")
+	defer unsubscribe()
+	rr := httptest.NewRecorder()
+	UploadHandler(rr, req.WithContext(ctx), jm, cfg.UploadDir)
+	jm.Wait(context.Background())
+
+	types := []string{}
+	for len(events) > 0 {
+		types = append(types, (<-events).Type)
+	}
+	expected := []string{job.EventChunkReceived, job.EventAssemblyStarted, job.EventAssemblyFailed, job.EventChecksumFailed}
+	if strings.Join(types, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected events %v, got %v", expected, types)
+	}
+	if pathExists(filepath.Join(cfg.UploadDir, "someCorruptFile.txt")) {
+		t.Error("Expected the corrupt upload not to be committed")
+	}
+}
@@ -0,0 +1,244 @@
+// Package webhooks posts file and share events to URLs configured by the
+// admin, signed with HMAC-SHA256, retrying with backoff and logging every
+// delivery in the database.
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"file-server/internal/job"
+	"file-server/internal/models"
+	"file-server/internal/repositories"
+)
+
+const (
+	deliveryTimeout = 10 * time.Second
+	maxRetryDelay   = time.Hour
+)
+
+// Events are the events webhooks can be sent, the others are for the UI only.
+var Events = []string{
+	job.EventUploadCompleted,
+	job.EventChecksumFailed,
+	job.EventShareCreated,
+	job.EventShareAccessed,
+	job.EventShareExpired,
+	job.EventFileDownloaded,
+}
+
+// Payload is the body posted to a webhook. It is signed as sent, in the
+// X-HomeShare-Signature header as "sha256=" followed by Sign of the body.
+type Payload struct {
+	DeliveryId string    `json:"delivery_id"` // Same for every attempt, to drop duplicates
+	Event      string    `json:"event"`
+	Data       job.Event `json:"data"`
+}
+
+// Sign returns the hex HMAC-SHA256 of body with secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher sends the events published through a JobManager to the webhooks
+// listening for them.
+type Dispatcher struct {
+	db          *sql.DB
+	jm          *job.JobManager
+	client      *http.Client
+	maxAttempts int
+	retryDelay  time.Duration // Doubled after every failed attempt
+	ctx         context.Context
+	cancel      context.CancelFunc
+}
+
+func NewDispatcher(db *sql.DB, jm *job.JobManager, maxAttempts int, retryDelay time.Duration) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		db: db,
+		jm: jm,
+		client: &http.Client{
+			Timeout: deliveryTimeout,
+			// A redirect is a failed delivery, the receiver's URL has to be fixed
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		maxAttempts: maxAttempts,
+		retryDelay:  retryDelay,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+// Start listens for events and resumes the deliveries the last run left
+// pending. Deliveries run through the JobManager, so shutdown waits for them
+// to record how they went.
+func (d *Dispatcher) Start() {
+	d.jm.Listen(func(event job.Event) {
+		if !slices.Contains(Events, event.Type) {
+			return
+		}
+		d.jm.Go(func() {
+			d.dispatch(event)
+		})
+	})
+	d.jm.Go(d.resume)
+}
+
+// Stop ends the deliveries under way, those not delivered yet stay pending
+// until the next Start.
+func (d *Dispatcher) Stop() {
+	d.cancel()
+}
+
+// dispatch logs a delivery of event for every webhook it is for, and sends them.
+func (d *Dispatcher) dispatch(event job.Event) {
+	if d.ctx.Err() != nil {
+		return
+	}
+	webhooks, err := repositories.ListWebhooks(d.db)
+	if err != nil {
+		log.Printf("[FILE-SERVER] Error listing webhooks for event %s: %v", event.Type, err)
+		return
+	}
+
+	for _, webhook := range webhooks {
+		if len(webhook.Events) > 0 && !slices.Contains(webhook.Events, event.Type) {
+			continue
+		}
+
+		deliveryId := uuid.New().String()
+		payload, err := json.Marshal(Payload{DeliveryId: deliveryId, Event: event.Type, Data: event})
+		if err != nil {
+			log.Printf("[FILE-SERVER] Error encoding event %s: %v", event.Type, err)
+			return
+		}
+		delivery, err := repositories.CreateWebhookDelivery(d.db, deliveryId, webhook.Id, event.Type, string(payload))
+		if err != nil {
+			log.Printf("[FILE-SERVER] Error logging webhook delivery to %s: %v", webhook.Url, err)
+			continue
+		}
+
+		d.jm.Go(func() {
+			d.deliver(webhook, delivery)
+		})
+	}
+}
+
+// resume sends the deliveries left pending, e.g. by a restart during their retries.
+func (d *Dispatcher) resume() {
+	deliveries, err := repositories.ListPendingWebhookDeliveries(d.db)
+	if err != nil {
+		log.Printf("[FILE-SERVER] Error listing pending webhook deliveries: %v", err)
+		return
+	}
+	if len(deliveries) == 0 {
+		return
+	}
+	webhooks, err := repositories.ListWebhooks(d.db)
+	if err != nil {
+		log.Printf("[FILE-SERVER] Error listing webhooks: %v", err)
+		return
+	}
+
+	byId := make(map[string]models.Webhook, len(webhooks))
+	for _, webhook := range webhooks {
+		byId[webhook.Id] = webhook
+	}
+	for _, delivery := range deliveries {
+		webhook, ok := byId[delivery.WebhookId]
+		if !ok {
+			continue
+		}
+		d.jm.Go(func() {
+			d.deliver(webhook, delivery)
+		})
+	}
+}
+
+// deliver attempts delivery until the receiver takes it or attempts run out,
+// recording every attempt.
+func (d *Dispatcher) deliver(webhook models.Webhook, delivery models.WebhookDelivery) {
+	for delivery.Status == models.DeliveryPending {
+		if delivery.NextAttemptAt != nil {
+			select {
+			case <-time.After(time.Until(*delivery.NextAttemptAt)):
+			case <-d.ctx.Done():
+				return
+			}
+		}
+
+		delivery.Attempts++
+		responseCode, err := d.send(webhook, delivery)
+		if err != nil && d.ctx.Err() != nil {
+			return // Cut short by shutdown, doesn't count
+		}
+		delivery.ResponseCode, delivery.Error, delivery.NextAttemptAt = responseCode, "", nil
+		switch {
+		case err == nil:
+			delivery.Status = models.DeliveryDelivered
+		case delivery.Attempts >= d.maxAttempts:
+			delivery.Status, delivery.Error = models.DeliveryFailed, err.Error()
+			log.Printf("[FILE-SERVER] Giving up on webhook delivery %s to %s after %d attempts: %v", delivery.Id, webhook.Url, delivery.Attempts, err)
+		default:
+			delivery.Error = err.Error()
+			nextAttemptAt := time.Now().Add(d.backoff(delivery.Attempts)).UTC()
+			delivery.NextAttemptAt = &nextAttemptAt
+		}
+
+		if err := repositories.UpdateWebhookDelivery(d.db, delivery); err != nil {
+			log.Printf("[FILE-SERVER] Error recording webhook delivery %s: %v", delivery.Id, err)
+		}
+	}
+}
+
+// backoff returns the wait after the given number of failed attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.retryDelay << (attempts - 1)
+	if delay <= 0 || delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
+}
+
+// send posts the payload of delivery to webhook, any 2xx is a success.
+// Returns the response code, 0 when there was no response.
+func (d *Dispatcher) send(webhook models.Webhook, delivery models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, webhook.Url, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "HomeShare-Webhook")
+	req.Header.Set("X-HomeShare-Event", delivery.Event)
+	req.Header.Set("X-HomeShare-Delivery", delivery.Id)
+	req.Header.Set("X-HomeShare-Signature", "sha256="+Sign(webhook.Secret, []byte(delivery.Payload)))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,191 @@
+package webhooks
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"file-server/internal/auth"
+	"file-server/internal/repositories"
+)
+
+const (
+	defaultDeliveriesLimit = 50
+	maxDeliveriesLimit     = 500
+)
+
+type WebhookDetails struct {
+	Url    string   `json:"url"`
+	Events []string `json:"events"`           // Any of Events, all of them when empty
+	Secret string   `json:"secret,omitempty"` // Generated when empty
+}
+
+type WebhookDeleteDetails struct {
+	Id string `json:"id"`
+}
+
+type WebhookResponse struct {
+	Id        string    `json:"id"`
+	Url       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret"` // Only ever returned here
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhooksHandler lists (GET) or creates (POST) the webhooks events are sent to.
+func WebhooksHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAdmin(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodGet {
+		webhooks, err := repositories.ListWebhooks(db)
+		if err != nil {
+			http.Error(w, "Error while listing webhooks", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(webhooks)
+		return
+	}
+
+	var details WebhookDetails
+	if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+		http.Error(w, "Unable to parse webhook parameters", http.StatusBadRequest)
+		return
+	}
+	if err := validateUrl(details.Url); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if details.Events == nil {
+		details.Events = []string{}
+	}
+	for _, event := range details.Events {
+		if !slices.Contains(Events, event) {
+			http.Error(w, fmt.Sprintf("Unknown event %q, expected one of %s", event, strings.Join(Events, ", ")), http.StatusBadRequest)
+			return
+		}
+	}
+	if details.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			http.Error(w, "Error while generating webhook secret", http.StatusInternalServerError)
+			return
+		}
+		details.Secret = hex.EncodeToString(secret)
+	}
+
+	webhook, err := repositories.CreateWebhook(db, uuid.New().String(), details.Url, details.Events, details.Secret)
+	if err != nil {
+		http.Error(w, "Error while creating webhook", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(WebhookResponse{
+		Id:        webhook.Id,
+		Url:       webhook.Url,
+		Events:    webhook.Events,
+		Secret:    webhook.Secret,
+		CreatedAt: webhook.CreatedAt,
+	})
+}
+
+// WebhookDeleteHandler removes a webhook and its delivery log.
+func WebhookDeleteHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAdmin(w, r) {
+		return
+	}
+
+	var details WebhookDeleteDetails
+	if err := json.NewDecoder(r.Body).Decode(&details); err != nil || details.Id == "" {
+		http.Error(w, "Missing id parameter", http.StatusBadRequest)
+		return
+	}
+	if err := repositories.DeleteWebhook(db, details.Id); err != nil {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WebhookDeliveriesHandler returns the latest deliveries of the webhook given
+// as webhook_id, newest first. limit is 50 by default.
+func WebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAdmin(w, r) {
+		return
+	}
+
+	webhookId := r.URL.Query().Get("webhook_id")
+	if webhookId == "" {
+		http.Error(w, "Missing webhook_id parameter", http.StatusBadRequest)
+		return
+	}
+	limit := defaultDeliveriesLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxDeliveriesLimit {
+			http.Error(w, fmt.Sprintf("Invalid limit, expected 1 to %d", maxDeliveriesLimit), http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	deliveries, err := repositories.ListWebhookDeliveries(db, webhookId, limit)
+	if err != nil {
+		http.Error(w, "Error while listing webhook deliveries", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// isAdmin checks that the caller has rw access to root, answering the
+// request otherwise.
+func isAdmin(w http.ResponseWriter, r *http.Request) bool {
+	claimsRaw := r.Context().Value(auth.ClaimsContextKey)
+	claims, ok := claimsRaw.(jwt.MapClaims)
+	if !ok {
+		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		return false
+	}
+
+	canAccess, err := auth.HasAccess(claims, "/", "rw")
+	if err != nil || !canAccess {
+		http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func validateUrl(rawUrl string) error {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid url, expected an http or https URL")
+	}
+	return nil
+}
@@ -0,0 +1,379 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+
+	"file-server/internal/auth"
+	"file-server/internal/job"
+	"file-server/internal/models"
+)
+
+// --------------------------------------
+// 			 Helper Functions
+// --------------------------------------
+
+// receiver is a local webhook endpoint answering with the given codes in turn.
+type receiver struct {
+	mu       sync.Mutex
+	codes    []int
+	requests []*http.Request
+	bodies   []string
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	code := rc.codes[min(len(rc.requests), len(rc.codes)-1)]
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, string(body))
+	w.WriteHeader(code)
+}
+
+func webhookRows(url string, events string) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "url", "events", "secret", "created_at"}).
+		AddRow("someWebhookId", url, events, "someSecret", time.Now())
+}
+
+func deliveryColumns() []string {
+	return []string{"id", "webhook_id", "event", "payload", "status", "attempts", "response_code", "error", "next_attempt_at", "created_at", "updated_at"}
+}
+
+// newDispatcher returns a dispatcher on a mock database, to be started once
+// the expectations are set.
+func newDispatcher(t *testing.T, maxAttempts int, pending *sqlmock.Rows) (sqlmock.Sqlmock, *job.JobManager, *Dispatcher) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Received unexpected error when creating mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	mock.MatchExpectationsInOrder(false)
+
+	if pending == nil {
+		pending = sqlmock.NewRows(deliveryColumns())
+	}
+	mock.ExpectQuery(`FROM webhook_deliveries\s+WHERE status = \$1`).WithArgs(models.DeliveryPending).WillReturnRows(pending)
+
+	jm := job.NewJobManager(time.Minute)
+	t.Cleanup(jm.Close)
+	return mock, jm, NewDispatcher(db, jm, maxAttempts, 10*time.Millisecond)
+}
+
+func waitDeliveries(t *testing.T, jm *job.JobManager) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := jm.Wait(ctx); err != nil {
+		t.Fatalf("Expected deliveries to finish: %v", err)
+	}
+}
+
+func adminRequest(method string, target string, body string, folderId string) *http.Request {
+	claims := jwt.MapClaims{
+		"user_id":   "someRandomUser",
+		"folder_id": folderId,
+		"access":    "rw",
+		"exp":       time.Now().Add(30 * time.Minute).Unix(),
+	}
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	return req.WithContext(context.WithValue(context.Background(), auth.ClaimsContextKey, claims))
+}
+
+// --------------------------------------
+// 				 Tests
+// --------------------------------------
+func TestDispatcher(t *testing.T) {
+	t.Run("Delivery_Signed_And_Retried", func(t *testing.T) {
+		rc := &receiver{codes: []int{http.StatusInternalServerError, http.StatusOK}}
+		ts := httptest.NewServer(rc)
+		defer ts.Close()
+
+		mock, jm, d := newDispatcher(t, 3, nil)
+		mock.ExpectQuery(`FROM webhooks`).WillReturnRows(webhookRows(ts.URL, "upload.completed,share.created"))
+		mock.ExpectQuery(`INSERT INTO webhook_deliveries`).
+			WithArgs(sqlmock.AnyArg(), "someWebhookId", job.EventShareCreated, sqlmock.AnyArg(), models.DeliveryPending).
+			WillReturnRows(sqlmock.NewRows([]string{"created_at", "updated_at"}).AddRow(time.Now(), time.Now()))
+		mock.ExpectExec(`UPDATE webhook_deliveries`).
+			WithArgs(sqlmock.AnyArg(), models.DeliveryPending, 1, http.StatusInternalServerError, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE webhook_deliveries`).
+			WithArgs(sqlmock.AnyArg(), models.DeliveryDelivered, 2, http.StatusOK, "", nil).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		d.Start()
+		jm.Publish(job.Event{Type: job.EventShareCreated, FolderId: "someFolderId"})
+		waitDeliveries(t, jm)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("Expected the delivery to be logged: %v", err)
+		}
+		if len(rc.requests) != 2 {
+			t.Fatalf("Expected a retry after the failure, got %d requests", len(rc.requests))
+		}
+		if rc.bodies[0] != rc.bodies[1] {
+			t.Error("Expected the retry to send the same payload")
+		}
+		req := rc.requests[1]
+		if req.Header.Get("X-HomeShare-Signature") != "sha256="+Sign("someSecret", []byte(rc.bodies[1])) {
+			t.Errorf("Expected the payload to be signed, got %s", req.Header.Get("X-HomeShare-Signature"))
+		}
+		var payload Payload
+		if err := json.Unmarshal([]byte(rc.bodies[1]), &payload); err != nil {
+			t.Fatalf("Received unexpected error when parsing payload: %v", err)
+		}
+		if payload.Event != job.EventShareCreated || payload.Data.FolderId != "someFolderId" || payload.DeliveryId != req.Header.Get("X-HomeShare-Delivery") {
+			t.Errorf("Expected the payload to describe the event, got %+v", payload)
+		}
+	})
+
+	t.Run("Delivery_Filtered_By_Event", func(t *testing.T) {
+		rc := &receiver{codes: []int{http.StatusOK}}
+		ts := httptest.NewServer(rc)
+		defer ts.Close()
+
+		mock, jm, d := newDispatcher(t, 3, nil)
+		mock.ExpectQuery(`FROM webhooks`).WillReturnRows(webhookRows(ts.URL, "share.created"))
+
+		d.Start()
+		jm.Publish(job.Event{Type: job.EventChunkReceived, FolderId: "/"}) // Not sent to webhooks at all
+		jm.Publish(job.Event{Type: job.EventFileDownloaded, FolderId: "someFolderId"})
+		waitDeliveries(t, jm)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("Expected webhooks to be looked up once: %v", err)
+		}
+		if len(rc.requests) != 0 {
+			t.Errorf("Expected no delivery for events the webhook doesn't listen to, got %d", len(rc.requests))
+		}
+	})
+
+	t.Run("Delivery_Given_Up", func(t *testing.T) {
+		rc := &receiver{codes: []int{http.StatusServiceUnavailable}}
+		ts := httptest.NewServer(rc)
+		defer ts.Close()
+
+		mock, jm, d := newDispatcher(t, 2, nil)
+		mock.ExpectQuery(`FROM webhooks`).WillReturnRows(webhookRows(ts.URL, ""))
+		mock.ExpectQuery(`INSERT INTO webhook_deliveries`).
+			WillReturnRows(sqlmock.NewRows([]string{"created_at", "updated_at"}).AddRow(time.Now(), time.Now()))
+		mock.ExpectExec(`UPDATE webhook_deliveries`).
+			WithArgs(sqlmock.AnyArg(), models.DeliveryPending, 1, http.StatusServiceUnavailable, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE webhook_deliveries`).
+			WithArgs(sqlmock.AnyArg(), models.DeliveryFailed, 2, http.StatusServiceUnavailable, "receiver answered 503 Service Unavailable", nil).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		d.Start()
+		jm.Publish(job.Event{Type: job.EventUploadCompleted, FolderId: "/", File: "someFile.txt"})
+		waitDeliveries(t, jm)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("Expected the delivery to be marked failed: %v", err)
+		}
+		if len(rc.requests) != 2 {
+			t.Errorf("Expected %d attempts, got %d", 2, len(rc.requests))
+		}
+	})
+
+	t.Run("Pending_Delivery_Resumed", func(t *testing.T) {
+		rc := &receiver{codes: []int{http.StatusNoContent}}
+		ts := httptest.NewServer(rc)
+		defer ts.Close()
+
+		nextAttemptAt := time.Now().Add(-time.Minute)
+		pending := sqlmock.NewRows(deliveryColumns()).
+			AddRow("someDeliveryId", "someWebhookId", job.EventShareExpired, `{"event":"share.expired"}`, models.DeliveryPending,
+				1, http.StatusBadGateway, "receiver answered 502 Bad Gateway", nextAttemptAt, time.Now(), time.Now())
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("Received unexpected error when creating mock database: %v", err)
+		}
+		defer db.Close()
+		mock.ExpectQuery(`FROM webhook_deliveries\s+WHERE status = \$1`).WillReturnRows(pending)
+		mock.ExpectQuery(`FROM webhooks`).WillReturnRows(webhookRows(ts.URL, ""))
+		mock.ExpectExec(`UPDATE webhook_deliveries`).
+			WithArgs("someDeliveryId", models.DeliveryDelivered, 2, http.StatusNoContent, "", nil).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		jm := job.NewJobManager(time.Minute)
+		defer jm.Close()
+		NewDispatcher(db, jm, 3, 10*time.Millisecond).Start()
+		waitDeliveries(t, jm)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("Expected the pending delivery to be sent: %v", err)
+		}
+		if len(rc.requests) != 1 || rc.bodies[0] != `{"event":"share.expired"}` {
+			t.Errorf("Expected the logged payload to be sent again, got %v", rc.bodies)
+		}
+	})
+
+	t.Run("Stop_Leaves_Delivery_Pending", func(t *testing.T) {
+		rc := &receiver{codes: []int{http.StatusInternalServerError}}
+		ts := httptest.NewServer(rc)
+		defer ts.Close()
+
+		mock, jm, d := newDispatcher(t, 3, nil)
+		d.retryDelay = time.Hour
+		mock.ExpectQuery(`FROM webhooks`).WillReturnRows(webhookRows(ts.URL, ""))
+		mock.ExpectQuery(`INSERT INTO webhook_deliveries`).
+			WillReturnRows(sqlmock.NewRows([]string{"created_at", "updated_at"}).AddRow(time.Now(), time.Now()))
+		recorded := make(chan struct{})
+		mock.ExpectExec(`UPDATE webhook_deliveries`).
+			WithArgs(sqlmock.AnyArg(), signalArg{value: models.DeliveryPending, matched: recorded}, 1, http.StatusInternalServerError, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		d.Start()
+		jm.Publish(job.Event{Type: job.EventShareAccessed, FolderId: "someFolderId"})
+		select {
+		case <-recorded:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the first attempt to be recorded")
+		}
+		d.Stop()
+		waitDeliveries(t, jm)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("Expected the first attempt to be logged: %v", err)
+		}
+	})
+}
+
+func TestWebhooksHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Received unexpected error when creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	t.Run("Webhooks_Not_Admin", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		WebhooksHandler(rr, adminRequest(http.MethodGet, "/webhooks", "", "someFolderId"), db)
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 Forbidden, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Webhooks_Invalid_Url", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		WebhooksHandler(rr, adminRequest(http.MethodPost, "/webhooks", `{"url":"ftp://someHost/hook"}`, "/"), db)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 Bad Request, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Webhooks_Unknown_Event", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		WebhooksHandler(rr, adminRequest(http.MethodPost, "/webhooks", `{"url":"http://someHost/hook","events":["chunk.received"]}`, "/"), db)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 Bad Request, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Webhooks_Create", func(t *testing.T) {
+		mock.ExpectQuery(`INSERT INTO webhooks`).
+			WithArgs(sqlmock.AnyArg(), "http://someHost/hook", "upload.completed,share.expired", secretArg{}).
+			WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+
+		rr := httptest.NewRecorder()
+		WebhooksHandler(rr, adminRequest(http.MethodPost, "/webhooks", `{"url":"http://someHost/hook","events":["upload.completed","share.expired"]}`, "/"), db)
+
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201 Created, got: %d", rr.Code)
+		}
+		var response WebhookResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Received unexpected error when parsing response body: %v", err)
+		}
+		if len(response.Secret) != 64 {
+			t.Errorf("Expected a secret to be generated, got %q", response.Secret)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("Webhooks_List_Hides_Secret", func(t *testing.T) {
+		mock.ExpectQuery(`FROM webhooks`).WillReturnRows(webhookRows("http://someHost/hook", ""))
+
+		rr := httptest.NewRecorder()
+		WebhooksHandler(rr, adminRequest(http.MethodGet, "/webhooks", "", "/"), db)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 OK, got: %d", rr.Code)
+		}
+		if strings.Contains(rr.Body.String(), "someSecret") {
+			t.Error("Expected secrets not to be listed")
+		}
+	})
+
+	t.Run("Webhook_Delete_Not_Found", func(t *testing.T) {
+		mock.ExpectExec(`DELETE FROM webhooks`).WithArgs("someWebhookId").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		rr := httptest.NewRecorder()
+		WebhookDeleteHandler(rr, adminRequest(http.MethodPost, "/webhook-delete", `{"id":"someWebhookId"}`, "/"), db)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 Not Found, got: %d", rr.Code)
+		}
+	})
+
+	t.Run("Webhook_Deliveries", func(t *testing.T) {
+		mock.ExpectQuery(`FROM webhook_deliveries\s+WHERE webhook_id = \$1`).WithArgs("someWebhookId", 10).
+			WillReturnRows(sqlmock.NewRows(deliveryColumns()).
+				AddRow("someDeliveryId", "someWebhookId", job.EventFileDownloaded, "{}", models.DeliveryDelivered, 1, 200, "", nil, time.Now(), time.Now()))
+
+		rr := httptest.NewRecorder()
+		WebhookDeliveriesHandler(rr, adminRequest(http.MethodGet, "/webhook-deliveries?webhook_id=someWebhookId&limit=10", "", "/"), db)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 OK, got: %d", rr.Code)
+		}
+		var deliveries []models.WebhookDelivery
+		if err := json.Unmarshal(rr.Body.Bytes(), &deliveries); err != nil || len(deliveries) != 1 || deliveries[0].Status != models.DeliveryDelivered {
+			t.Errorf("Expected the delivery log, got %s", rr.Body.String())
+		}
+	})
+}
+
+// secretArg matches a generated webhook secret.
+type secretArg struct{}
+
+func (secretArg) Match(v driver.Value) bool {
+	secret, ok := v.(string)
+	return ok && len(secret) == 64
+}
+
+// signalArg matches value, closing matched the first time it does.
+type signalArg struct {
+	value   string
+	matched chan struct{}
+}
+
+func (a signalArg) Match(v driver.Value) bool {
+	if v != a.value {
+		return false
+	}
+	select {
+	case <-a.matched:
+	default:
+		close(a.matched)
+	}
+	return true
+}